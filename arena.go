@@ -0,0 +1,86 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// arena.go implements block allocation of the objects created while parsing icons.
+
+package oksvg
+
+import (
+	"sync"
+
+	"github.com/srwiley/rasterx"
+)
+
+// Arena allocates the paths, styles and gradients of the icons parsed with it
+// in large blocks, so that an application keeping thousands of icons resident
+// has far fewer objects for the garbage collector to track. A block is freed
+// only when none of the icons allocated from it are reachable, so an Arena
+// suits icons that share a lifetime, such as the icon set of a service. An
+// Arena may be shared by goroutines parsing icons concurrently.
+type Arena struct {
+	// BlockSize is the number of path elements in each block of path data.
+	// Zero means 4096. Paths longer than a quarter of a block are allocated
+	// on their own.
+	BlockSize int
+
+	mu    sync.Mutex
+	path  rasterx.Path
+	paths []SvgPath
+	grads []rasterx.Gradient
+}
+
+const (
+	arenaPathsBlock = 256 // SvgPaths per block
+	arenaGradsBlock = 64  // Gradients per block
+)
+
+// copyPath returns a copy of p, taken from the arena if a is not nil. The copy
+// has no spare capacity, so appending to it never writes into the arena.
+func (a *Arena) copyPath(p rasterx.Path) rasterx.Path {
+	n := 4096
+	if a != nil && a.BlockSize > 0 {
+		n = a.BlockSize
+	}
+	if a == nil || len(p) > n/4 {
+		pathCopy := make(rasterx.Path, len(p))
+		copy(pathCopy, p)
+		return pathCopy
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(p) > cap(a.path)-len(a.path) {
+		a.path = make(rasterx.Path, 0, n)
+	}
+	start := len(a.path)
+	a.path = append(a.path, p...)
+	return a.path[start:len(a.path):len(a.path)]
+}
+
+// copyPaths returns a copy of paths, taken from the arena if a is not nil.
+func (a *Arena) copyPaths(paths []SvgPath) []SvgPath {
+	if a == nil || len(paths) > arenaPathsBlock/4 {
+		return append([]SvgPath(nil), paths...)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(paths) > cap(a.paths)-len(a.paths) {
+		a.paths = make([]SvgPath, 0, arenaPathsBlock)
+	}
+	start := len(a.paths)
+	a.paths = append(a.paths, paths...)
+	return a.paths[start:len(a.paths):len(a.paths)]
+}
+
+// newGradient returns a pointer to a copy of g, taken from the arena if a is
+// not nil.
+func (a *Arena) newGradient(g rasterx.Gradient) *rasterx.Gradient {
+	if a == nil {
+		return &g
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.grads) == cap(a.grads) {
+		a.grads = make([]rasterx.Gradient, 0, arenaGradsBlock)
+	}
+	a.grads = append(a.grads, g)
+	return &a.grads[len(a.grads)-1]
+}
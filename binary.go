@@ -0,0 +1,508 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// binary.go implements a compact binary encoding of compiled icons.
+
+package oksvg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	binaryMagic   = "OKSVG"
+	binaryVersion = 4
+	maxBinaryLen  = 1 << 26 // limit on decoded lengths, guarding against corrupt input
+)
+
+var (
+	errBinaryFormat  = errors.New("not an oksvg binary icon")
+	errBinaryVersion = errors.New("unsupported oksvg binary icon version")
+	errCustomFunc    = errors.New("cannot encode a gap or cap function not defined by rasterx")
+)
+
+// Paint kinds of the binary encoding
+const (
+	paintNone byte = iota
+	paintColor
+	paintGradient
+	paintImage
+	paintPattern
+)
+
+// gapFuncs and capFuncs list the functions a style can refer to; their index is
+// encoded and 0 stands for nil.
+var (
+	gapFuncs = []rasterx.GapFunc{nil, rasterx.FlatGap, rasterx.RoundGap, rasterx.CubicGap, rasterx.QuadraticGap}
+	capFuncs = []rasterx.CapFunc{nil, rasterx.ButtCap, rasterx.RoundCap, rasterx.SquareCap,
+		rasterx.CubicCap, rasterx.QuadraticCap}
+)
+
+// EncodeBinary writes the compiled icon to w in a compact binary format that
+// DecodeBinary reads back much faster than the SVG can be parsed, so that it can
+// serve as a cache of compiled icons. The view box and its preserveAspectRatio,
+// physical size, transform, titles, descriptions and paths, with their styles,
+// gradients, patterns, clip paths, masks and source positions, are kept. As with ParseOptions.LowMemory,
+// the definitions, gradients by id and texts by element id are not.
+func (s *SvgIcon) EncodeBinary(w io.Writer) error {
+	e := &binaryEncoder{w: bufio.NewWriter(w)}
+	e.w.WriteString(binaryMagic)
+	e.w.WriteByte(binaryVersion)
+	e.floats(s.ViewBox.X, s.ViewBox.Y, s.ViewBox.W, s.ViewBox.H)
+	e.floats(s.physW, s.physH)
+	e.matrix(s.Transform)
+	e.bool(s.IsolateOpacity)
+	e.strings([]string{s.PreserveAspectRatio})
+	e.strings(s.Titles)
+	e.strings(s.Descriptions)
+	e.uvarint(uint64(len(s.SVGPaths)))
+	for i := range s.SVGPaths {
+		e.path(&s.SVGPaths[i])
+	}
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// DecodeBinary reads an icon written by EncodeBinary.
+func DecodeBinary(r io.Reader) (*SvgIcon, error) {
+	d := &binaryDecoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic[:len(binaryMagic)]) != binaryMagic {
+		return nil, errBinaryFormat
+	}
+	if magic[len(binaryMagic)] != binaryVersion {
+		return nil, errBinaryVersion
+	}
+	icon := &SvgIcon{}
+	icon.ViewBox = ViewBox{d.float(), d.float(), d.float(), d.float()}
+	icon.physW, icon.physH = d.float(), d.float()
+	icon.Transform = d.matrix()
+	icon.IsolateOpacity = d.bool()
+	if par := d.strings(); len(par) == 1 {
+		icon.PreserveAspectRatio = par[0]
+	}
+	icon.Titles = d.strings()
+	icon.Descriptions = d.strings()
+	n := d.len()
+	if d.err != nil {
+		return nil, d.err
+	}
+	icon.SVGPaths = d.paths(n)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return icon, nil
+}
+
+type binaryEncoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (e *binaryEncoder) uvarint(v uint64) {
+	e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *binaryEncoder) varint(v int64) {
+	e.w.Write(e.buf[:binary.PutVarint(e.buf[:], v)])
+}
+
+func (e *binaryEncoder) floats(vs ...float64) {
+	for _, v := range vs {
+		binary.LittleEndian.PutUint64(e.buf[:], math.Float64bits(v))
+		e.w.Write(e.buf[:8])
+	}
+}
+
+func (e *binaryEncoder) bool(b bool) {
+	if b {
+		e.w.WriteByte(1)
+	} else {
+		e.w.WriteByte(0)
+	}
+}
+
+func (e *binaryEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.w.Write(b)
+}
+
+func (e *binaryEncoder) strings(ss []string) {
+	e.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		e.bytes([]byte(s))
+	}
+}
+
+func (e *binaryEncoder) matrix(m rasterx.Matrix2D) {
+	e.floats(m.A, m.B, m.C, m.D, m.E, m.F)
+}
+
+func (e *binaryEncoder) color(c color.Color) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	e.w.Write([]byte{n.R, n.G, n.B, n.A})
+}
+
+func (e *binaryEncoder) path(svgp *SvgPath) {
+	e.uvarint(uint64(len(svgp.Path)))
+	for _, v := range svgp.Path {
+		e.varint(int64(v))
+	}
+	e.varint(svgp.Source.Offset)
+	e.varint(int64(svgp.Source.Line))
+	e.varint(int64(svgp.Source.Column))
+	e.style(&svgp.PathStyle)
+}
+
+func (e *binaryEncoder) style(s *PathStyle) {
+	e.floats(s.FillOpacity, s.LineOpacity, s.LineWidth, s.DashOffset, s.MiterLimit, s.opacity, s.fontSize)
+	e.uvarint(uint64(len(s.Dash)))
+	e.floats(s.Dash...)
+	e.bool(s.UseNonZeroWinding)
+	e.bool(s.ContinueDash)
+	e.paint(s.fillerColor)
+	e.paint(s.linerColor)
+	e.gap(s.LineGap)
+	e.capFunc(s.LeadLineCap)
+	e.capFunc(s.LineCap)
+	e.w.WriteByte(byte(s.LineJoin))
+	e.matrix(s.mAdder.M)
+	e.uvarint(uint64(len(s.clips)))
+	for _, cp := range s.clips {
+		e.bool(cp.bbox)
+		e.bool(cp.luminance)
+		e.uvarint(uint64(len(cp.paths)))
+		for i := range cp.paths {
+			e.path(&cp.paths[i])
+		}
+	}
+}
+
+// gap and capFunc write the index of f in gapFuncs or capFuncs.
+func (e *binaryEncoder) gap(f rasterx.GapFunc) {
+	for i, g := range gapFuncs {
+		if f == nil && i == 0 || i > 0 && sameFunc(f, g) {
+			e.w.WriteByte(byte(i))
+			return
+		}
+	}
+	e.fail(errCustomFunc)
+}
+
+func (e *binaryEncoder) capFunc(f rasterx.CapFunc) {
+	for i, g := range capFuncs {
+		if f == nil && i == 0 || i > 0 && sameFunc(f, g) {
+			e.w.WriteByte(byte(i))
+			return
+		}
+	}
+	e.fail(errCustomFunc)
+}
+
+// sameFunc reports whether the functions f and g, which cannot be compared
+// with ==, have the same code pointer.
+func sameFunc(f, g interface{}) bool {
+	return fmt.Sprintf("%p", f) == fmt.Sprintf("%p", g)
+}
+
+func (e *binaryEncoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *binaryEncoder) paint(paint interface{}) {
+	switch p := paint.(type) {
+	case nil:
+		e.w.WriteByte(paintNone)
+	case rasterx.Gradient:
+		e.w.WriteByte(paintGradient)
+		e.floats(p.Points[:]...)
+		e.uvarint(uint64(len(p.Stops)))
+		for _, s := range p.Stops {
+			e.paint(s.StopColor) // nil until localized
+			e.floats(s.Offset, s.Opacity)
+		}
+		e.floats(p.Bounds.X, p.Bounds.Y, p.Bounds.W, p.Bounds.H)
+		e.matrix(p.Matrix)
+		e.w.Write([]byte{byte(p.Spread), byte(p.Units)})
+		e.bool(p.IsRadial)
+	case imagePaint:
+		e.w.WriteByte(paintImage)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, p.img); err != nil {
+			e.fail(err)
+		}
+		e.bytes(buf.Bytes())
+		e.floats(p.rect.X, p.rect.Y, p.rect.W, p.rect.H)
+		e.w.WriteByte(byte(p.sampling))
+	case *Pattern:
+		e.w.WriteByte(paintPattern)
+		e.floats(p.X, p.Y, p.W, p.H, p.ViewBox.X, p.ViewBox.Y, p.ViewBox.W, p.ViewBox.H, p.coordScale)
+		e.w.Write([]byte{byte(p.Units), byte(p.ContentUnits)})
+		e.matrix(p.Matrix)
+		e.strings([]string{p.align})
+		e.bool(p.slice)
+		e.uvarint(uint64(len(p.Paths)))
+		for i := range p.Paths {
+			e.path(&p.Paths[i])
+		}
+	case color.Color:
+		e.w.WriteByte(paintColor)
+		e.color(p)
+	default:
+		e.fail(fmt.Errorf("cannot encode paint of type %T", paint))
+	}
+}
+
+// binaryDecoder reads the values written by binaryEncoder. After the first
+// error, it returns zero values and keeps the error. Slices grow as their
+// elements are read, rather than being allocated for the length read before
+// them, so a corrupt length fails at the end of the input instead of
+// allocating memory for elements that are not there.
+type binaryDecoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *binaryDecoder) fail(err error) {
+	if d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	return v
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return v
+}
+
+// len reads a length, failing if it is implausibly large.
+func (d *binaryDecoder) len() int {
+	n := d.uvarint()
+	if n > maxBinaryLen {
+		d.fail(errBinaryFormat)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *binaryDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	d.fail(err)
+	return b
+}
+
+// enum reads a byte that is at most max, failing if it is larger.
+func (d *binaryDecoder) enum(max byte) byte {
+	b := d.byte()
+	if b > max {
+		d.fail(errBinaryFormat)
+	}
+	return b
+}
+
+func (d *binaryDecoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *binaryDecoder) float() float64 {
+	var b [8]byte
+	if d.err != nil {
+		return 0
+	}
+	_, err := io.ReadFull(d.r, b[:])
+	d.fail(err)
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (d *binaryDecoder) bytes() []byte {
+	n := d.len()
+	if d.err != nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, d.r, int64(n)); err != nil {
+		d.fail(err)
+		return nil
+	}
+	return b.Bytes()
+}
+
+func (d *binaryDecoder) strings() []string {
+	n := d.len()
+	var ss []string
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, string(d.bytes()))
+	}
+	return ss
+}
+
+func (d *binaryDecoder) matrix() rasterx.Matrix2D {
+	return rasterx.Matrix2D{A: d.float(), B: d.float(), C: d.float(), D: d.float(), E: d.float(), F: d.float()}
+}
+
+func (d *binaryDecoder) color() color.NRGBA {
+	return color.NRGBA{d.byte(), d.byte(), d.byte(), d.byte()}
+}
+
+// paths reads n paths.
+func (d *binaryDecoder) paths(n int) []SvgPath {
+	var paths []SvgPath
+	for i := 0; i < n && d.err == nil; i++ {
+		paths = append(paths, SvgPath{})
+		d.path(&paths[i])
+	}
+	return paths
+}
+
+func (d *binaryDecoder) path(svgp *SvgPath) {
+	n := d.len()
+	for i := 0; i < n && d.err == nil; i++ {
+		svgp.Path = append(svgp.Path, fixed.Int26_6(d.varint()))
+	}
+	if d.err == nil && !validPath(svgp.Path) {
+		d.fail(errBinaryFormat)
+	}
+	svgp.Source = SourcePos{Offset: d.varint(), Line: int(d.varint()), Column: int(d.varint())}
+	d.style(&svgp.PathStyle)
+}
+
+// validPath reports whether path is a sequence of commands, each followed by
+// all of its points, so that drawing it cannot fail.
+func validPath(path rasterx.Path) bool {
+	for i := 0; i < len(path); {
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			i += 3
+		case rasterx.PathQuadTo:
+			i += 5
+		case rasterx.PathCubicTo:
+			i += 7
+		case rasterx.PathClose:
+			i++
+		default:
+			return false
+		}
+		if i > len(path) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *binaryDecoder) style(s *PathStyle) {
+	s.FillOpacity, s.LineOpacity, s.LineWidth = d.float(), d.float(), d.float()
+	s.DashOffset, s.MiterLimit, s.opacity, s.fontSize = d.float(), d.float(), d.float(), d.float()
+	n := d.len()
+	for i := 0; i < n && d.err == nil; i++ {
+		s.Dash = append(s.Dash, d.float())
+	}
+	s.UseNonZeroWinding = d.bool()
+	s.ContinueDash = d.bool()
+	s.fillerColor = d.paint()
+	s.linerColor = d.paint()
+	if i := int(d.byte()); i < len(gapFuncs) {
+		s.LineGap = gapFuncs[i]
+	} else {
+		d.fail(errBinaryFormat)
+	}
+	for _, f := range []*rasterx.CapFunc{&s.LeadLineCap, &s.LineCap} {
+		if i := int(d.byte()); i < len(capFuncs) {
+			*f = capFuncs[i]
+		} else {
+			d.fail(errBinaryFormat)
+		}
+	}
+	s.LineJoin = rasterx.JoinMode(d.enum(byte(rasterx.Round)))
+	s.mAdder.M = d.matrix()
+	n = d.len()
+	for i := 0; i < n && d.err == nil; i++ {
+		cp := &clipPath{bbox: d.bool(), luminance: d.bool()}
+		cp.paths = d.paths(d.len())
+		s.clips = append(s.clips, cp)
+	}
+}
+
+func (d *binaryDecoder) paint() interface{} {
+	switch d.byte() {
+	case paintNone:
+		return nil
+	case paintColor:
+		return d.color()
+	case paintGradient:
+		var g rasterx.Gradient
+		for i := range g.Points {
+			g.Points[i] = d.float()
+		}
+		n := d.len()
+		for i := 0; i < n && d.err == nil; i++ {
+			clr, _ := d.paint().(color.Color)
+			g.Stops = append(g.Stops, rasterx.GradStop{StopColor: clr, Offset: d.float(), Opacity: d.float()})
+		}
+		g.Bounds.X, g.Bounds.Y, g.Bounds.W, g.Bounds.H = d.float(), d.float(), d.float(), d.float()
+		g.Matrix = d.matrix()
+		g.Spread = rasterx.SpreadMethod(d.enum(byte(rasterx.RepeatSpread)))
+		g.Units = rasterx.GradientUnits(d.enum(byte(rasterx.UserSpaceOnUse)))
+		g.IsRadial = d.bool()
+		return g
+	case paintImage:
+		data := d.bytes()
+		if d.err != nil {
+			return nil
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			d.fail(err)
+			return nil
+		}
+		return imagePaint{img, ViewBox{d.float(), d.float(), d.float(), d.float()}, ImageSampling(d.enum(byte(CatmullRomSampling)))}
+	case paintPattern:
+		p := &Pattern{X: d.float(), Y: d.float(), W: d.float(), H: d.float(),
+			ViewBox: ViewBox{d.float(), d.float(), d.float(), d.float()}, coordScale: d.float()}
+		p.Units = rasterx.GradientUnits(d.enum(byte(rasterx.UserSpaceOnUse)))
+		p.ContentUnits = rasterx.GradientUnits(d.enum(byte(rasterx.UserSpaceOnUse)))
+		p.Matrix = d.matrix()
+		if align := d.strings(); len(align) == 1 {
+			p.align = align[0]
+		} else {
+			d.fail(errBinaryFormat)
+		}
+		p.slice = d.bool()
+		p.Paths = d.paths(d.len())
+		return p
+	}
+	d.fail(errBinaryFormat)
+	return nil
+}
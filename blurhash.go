@@ -0,0 +1,135 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// blurhash.go implements BlurHash placeholders of icons, for web pages that
+// show a blurred preview until the rendering of an icon is loaded.
+
+package oksvg
+
+import (
+	"errors"
+	"image/color"
+	"math"
+)
+
+// blurHashSize is the width or height in pixels, whichever is larger, of the
+// rendering that BlurHash encodes, which is more than enough for nine
+// components.
+const blurHashSize = 32
+
+// base83 holds the digits of the base 83 encoding of BlurHash.
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+var errBlurHashComponents = errors.New("blurhash components must be between 1 and 9")
+
+// BlurHash renders the icon into a small image with the aspect ratio of its
+// ViewBox and returns its BlurHash, with xComponents across and yComponents
+// down, each from 1 to 9, for a placeholder that web pages can decode and show
+// while the full rendering loads. BlurHash has no transparency, so the icon is
+// drawn on white, unless opts give another background with WithBackground. The
+// other opts are those of Rasterize.
+func (s *SvgIcon) BlurHash(xComponents, yComponents int, opts ...RasterOption) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", errBlurHashComponents
+	}
+	vb := s.ViewBox
+	if !(vb.W > 0 && vb.H > 0) {
+		return "", errEmptyRaster
+	}
+	w, h := blurHashSize, blurHashSize
+	if vb.W > vb.H {
+		h = int(math.Max(1, math.Round(blurHashSize*vb.H/vb.W)))
+	} else {
+		w = int(math.Max(1, math.Round(blurHashSize*vb.W/vb.H)))
+	}
+	img, err := s.Rasterize(w, h, append([]RasterOption{WithBackground(color.White)}, opts...)...)
+	if err != nil {
+		return "", err
+	}
+
+	// The linear colors of the pixels, so that each is converted once
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			linear[y*w+x] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+		}
+	}
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * cy
+					for c, v := range linear[y*w+x] {
+						f[c] += basis * v
+					}
+				}
+			}
+			scale := 2 / float64(w*h)
+			if i == 0 && j == 0 {
+				scale = 1 / float64(w*h)
+			}
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	hash := encode83(nil, (xComponents-1)+(yComponents-1)*9, 1)
+	maxAC := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantized := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxAC = float64(quantized+1) / 166
+		hash = encode83(hash, quantized, 1)
+	} else {
+		hash = encode83(hash, 0, 1)
+	}
+	dc := factors[0]
+	hash = encode83(hash, linearToSrgb(dc[0])<<16|linearToSrgb(dc[1])<<8|linearToSrgb(dc[2]), 4)
+	for _, f := range factors[1:] {
+		v := 0
+		for _, c := range f {
+			// The signed square root spreads the levels over small values
+			q := math.Copysign(math.Sqrt(math.Abs(c/maxAC)), c)
+			v = v*19 + int(math.Max(0, math.Min(18, math.Floor(q*9+9.5))))
+		}
+		hash = encode83(hash, v, 2)
+	}
+	return string(hash), nil
+}
+
+// encode83 appends the value v as length base 83 digits to b.
+func encode83(b []byte, v, length int) []byte {
+	for i := length - 1; i >= 0; i-- {
+		d := v
+		for k := 0; k < i; k++ {
+			d /= 83
+		}
+		b = append(b, base83[d%83])
+	}
+	return b
+}
+
+// srgbToLinear returns the linear intensity of the sRGB value v.
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+// linearToSrgb returns the sRGB value of the linear intensity f.
+func linearToSrgb(f float64) int {
+	f = math.Max(0, math.Min(1, f))
+	if f <= 0.0031308 {
+		return int(f*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(f, 1/2.4)-0.055)*255 + 0.5)
+}
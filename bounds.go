@@ -0,0 +1,131 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// bounds.go implements bounding boxes of paths and icons computed from their
+// segments, for laying out icons, hit testing and cropping.
+
+package oksvg
+
+import (
+	"math"
+
+	"github.com/srwiley/rasterx"
+)
+
+// Bounds returns the smallest rectangle containing the path, in the user units
+// of the ViewBox, as its minimum and maximum x and y. It is computed from the
+// segments of the Path under the transforms of its element and ancestors,
+// including the extremes of its curves, without rasterizing. The stroke is not
+// included. An empty path has zero bounds.
+func (svgp *SvgPath) Bounds() (minX, minY, maxX, maxY float64) {
+	var b extremes
+	b.addPath(svgp.Path, svgp.mAdder.M)
+	return b.rect()
+}
+
+// Bounds returns the smallest rectangle containing the Bounds of all paths of
+// the icon, in the user units of the ViewBox, before the Transform of the icon.
+// An icon without paths has zero bounds.
+func (s *SvgIcon) Bounds() (minX, minY, maxX, maxY float64) {
+	var b extremes
+	for i := range s.SVGPaths {
+		b.addPath(s.SVGPaths[i].Path, s.SVGPaths[i].mAdder.M)
+	}
+	return b.rect()
+}
+
+// extremes accumulates the extent of points.
+type extremes struct {
+	minX, minY, maxX, maxY float64
+	set                    bool
+}
+
+func (b *extremes) add(x, y float64) {
+	if !b.set {
+		b.minX, b.minY, b.maxX, b.maxY, b.set = x, y, x, y, true
+		return
+	}
+	b.minX, b.minY = math.Min(b.minX, x), math.Min(b.minY, y)
+	b.maxX, b.maxY = math.Max(b.maxX, x), math.Max(b.maxY, y)
+}
+
+func (b *extremes) rect() (minX, minY, maxX, maxY float64) {
+	return b.minX, b.minY, b.maxX, b.maxY
+}
+
+// addPath adds the extent of path transformed by m. Curves transformed by an
+// affine matrix are the curves of their transformed control points, so the
+// extremes are found on those.
+func (b *extremes) addPath(path rasterx.Path, m rasterx.Matrix2D) {
+	var p [4][2]float64 // the current point and the points of the segment
+	for i := 0; i < len(path); {
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			n = 1
+		case rasterx.PathQuadTo:
+			n = 2
+		case rasterx.PathCubicTo:
+			n = 3
+		}
+		for j := 1; j <= n; j++ {
+			p[j][0], p[j][1] = m.Transform(float64(path[i+2*j-1])/64, float64(path[i+2*j])/64)
+		}
+		if n > 0 {
+			b.add(p[n][0], p[n][1])
+		}
+		for k := 0; k < 2 && n > 1; k++ {
+			var ts [2]float64
+			for _, t := range ts[:curveExtremes(p[:n+1], k, ts[:0])] {
+				x, y := bezierPoint(p[:n+1], 0, t), bezierPoint(p[:n+1], 1, t)
+				b.add(x, y)
+			}
+		}
+		if n > 0 {
+			p[0] = p[n]
+		}
+		i += 1 + 2*n
+	}
+}
+
+// curveExtremes appends to ts the parameters strictly between 0 and 1 at which
+// the quadratic or cubic Bézier curve with points p has an extreme along axis
+// k, and returns their number.
+func curveExtremes(p [][2]float64, k int, ts []float64) int {
+	// The roots of the derivative, a t² + b t + c
+	var a, b, c float64
+	if len(p) == 3 {
+		b, c = p[0][k]-2*p[1][k]+p[2][k], p[1][k]-p[0][k]
+	} else {
+		a = -p[0][k] + 3*p[1][k] - 3*p[2][k] + p[3][k]
+		b, c = 2*(p[0][k]-2*p[1][k]+p[2][k]), p[1][k]-p[0][k]
+	}
+	add := func(t float64) {
+		if t > 0 && t < 1 {
+			ts = append(ts, t)
+		}
+	}
+	switch {
+	case math.Abs(a) < 1e-12:
+		if b != 0 {
+			add(-c / b)
+		}
+	default:
+		d := b*b - 4*a*c
+		if d >= 0 {
+			sd := math.Sqrt(d)
+			add((-b + sd) / (2 * a))
+			add((-b - sd) / (2 * a))
+		}
+	}
+	return len(ts)
+}
+
+// bezierPoint returns the coordinate along axis k at t of the quadratic or
+// cubic Bézier curve with points p.
+func bezierPoint(p [][2]float64, k int, t float64) float64 {
+	u := 1 - t
+	if len(p) == 3 {
+		return u*u*p[0][k] + 2*u*t*p[1][k] + t*t*p[2][k]
+	}
+	return u*u*u*p[0][k] + 3*u*u*t*p[1][k] + 3*u*t*t*p[2][k] + t*t*t*p[3][k]
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// budget.go implements limits on the flattening of curves while drawing.
+
+package oksvg
+
+import (
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// TessellationBudget limits the number of line segments that curves are
+// flattened into while drawing, so that crafted SVG files with huge or
+// degenerate beziers cannot stall rendering. Curves that exceed the budget
+// are drawn with fewer segments rather than dropped.
+type TessellationBudget struct {
+	// MaxCurveSegments is the most line segments a single curve is flattened into.
+	// Zero means no limit.
+	MaxCurveSegments int
+	// MaxPathSegments is the most line segments the curves of a path are flattened
+	// into, counted separately for the fill and the stroke. Once it is spent the
+	// remaining curves of the path are drawn as straight lines. Zero means no limit.
+	MaxPathSegments int
+	// Tolerance, if not zero, is the greatest distance in pixels between a curve
+	// and the line segments it is flattened into. Curves are then split in halves
+	// until each part is that close to its chord, so that sharply bent parts get
+	// more segments than gentle ones and curves drawn at larger scales get more
+	// segments than the same curves drawn small. Arcs are drawn as cubic curves,
+	// so it applies to them too. If zero, curves are flattened by rasterx, which
+	// keeps them within a few hundredths of a pixel.
+	Tolerance float64
+}
+
+// DefaultTessellationBudget is the budget used when drawing an SvgPath, or an
+// SvgIcon without a Budget of its own. It allows curves far larger than any
+// reasonable target image to be drawn at full quality.
+var DefaultTessellationBudget = TessellationBudget{
+	MaxCurveSegments: 1024,
+	MaxPathSegments:  1 << 20,
+}
+
+// budget returns the tessellation budget for drawing the icon.
+func (s *SvgIcon) budget() TessellationBudget {
+	if s.Budget != nil {
+		return *s.Budget
+	}
+	return DefaultTessellationBudget
+}
+
+// budgetAdder passes path commands on to an Adder, replacing curves that would
+// exceed its TessellationBudget with coarser polylines.
+type budgetAdder struct {
+	rasterx.Adder
+	TessellationBudget
+	a, first fixed.Point26_6
+	spent    int
+}
+
+// Start starts a new curve at a
+func (b *budgetAdder) Start(a fixed.Point26_6) {
+	b.a, b.first = a, a
+	b.Adder.Start(a)
+}
+
+// Line adds a line segment to p
+func (b *budgetAdder) Line(p fixed.Point26_6) {
+	b.a = p
+	b.Adder.Line(p)
+}
+
+// Stop ends the current curve, closing it if closeLoop is true
+func (b *budgetAdder) Stop(closeLoop bool) {
+	if closeLoop {
+		b.a = b.first
+	}
+	b.Adder.Stop(closeLoop)
+}
+
+// QuadBezier adds a quadratic bezier with control point p ending at q
+func (b *budgetAdder) QuadBezier(p, q fixed.Point26_6) {
+	a := b.a
+	if b.Tolerance > 0 {
+		// The same curve as a cubic
+		c1 := fixed.Point26_6{X: a.X + (p.X-a.X)*2/3, Y: a.Y + (p.Y-a.Y)*2/3}
+		c2 := fixed.Point26_6{X: q.X + (p.X-q.X)*2/3, Y: q.Y + (p.Y-q.Y)*2/3}
+		b.subdivide(a, c1, c2, q)
+		return
+	}
+	n := curveSegments(devSquared(a, p, q))
+	if m, ok := b.allot(n); ok {
+		b.a = q
+		b.Adder.QuadBezier(p, q)
+	} else {
+		b.flatten(m, q, func(t float64) (float64, float64) {
+			mt := 1 - t
+			t1, t2, t3 := mt*mt, 2*mt*t, t*t
+			return float64(a.X)*t1 + float64(p.X)*t2 + float64(q.X)*t3,
+				float64(a.Y)*t1 + float64(p.Y)*t2 + float64(q.Y)*t3
+		})
+	}
+}
+
+// CubeBezier adds a cubic bezier with control points p and q ending at r
+func (b *budgetAdder) CubeBezier(p, q, r fixed.Point26_6) {
+	a := b.a
+	if b.Tolerance > 0 {
+		b.subdivide(a, p, q, r)
+		return
+	}
+	n := curveSegments(math.Max(devSquared(a, p, r), devSquared(a, q, r)))
+	if m, ok := b.allot(n); ok {
+		b.a = r
+		b.Adder.CubeBezier(p, q, r)
+	} else {
+		b.flatten(m, r, func(t float64) (float64, float64) {
+			mt := 1 - t
+			t1, t2, t3, t4 := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+			return float64(a.X)*t1 + float64(p.X)*t2 + float64(q.X)*t3 + float64(r.X)*t4,
+				float64(a.Y)*t1 + float64(p.Y)*t2 + float64(q.Y)*t3 + float64(r.Y)*t4
+		})
+	}
+}
+
+// allot takes up to n segments from the budget for a curve, returning the number
+// of segments taken and whether that is all n of them. At least one segment is
+// always taken, so that the curve still reaches its end point.
+func (b *budgetAdder) allot(n int) (int, bool) {
+	m := n
+	if b.MaxCurveSegments > 0 && m > b.MaxCurveSegments {
+		m = b.MaxCurveSegments
+	}
+	if b.MaxPathSegments > 0 && m > b.MaxPathSegments-b.spent {
+		m = b.MaxPathSegments - b.spent
+	}
+	if m < 1 {
+		m = 1
+	}
+	b.spent += m
+	return m, m == n
+}
+
+// flatten adds m line segments along the curve at, which maps t in [0, 1] to a point
+// in fixed point units, ending exactly at end
+func (b *budgetAdder) flatten(m int, end fixed.Point26_6, at func(t float64) (float64, float64)) {
+	for i := 1; i < m; i++ {
+		x, y := at(float64(i) / float64(m))
+		b.Adder.Line(fixed.Point26_6{X: fixed.Int26_6(x), Y: fixed.Int26_6(y)})
+	}
+	b.Line(end)
+}
+
+// maxSubdivision is the most times a curve is split in halves to bring it within
+// the Tolerance of a TessellationBudget, giving at most 1<<maxSubdivision segments.
+const maxSubdivision = 16
+
+// subdivide adds the cubic curve from a with control points p and q ending at r as
+// line segments within the Tolerance of the budget, if it allows that many, and
+// otherwise as the segments it allows.
+func (b *budgetAdder) subdivide(a, p, q, r fixed.Point26_6) {
+	tol := b.Tolerance * 64
+	pts := [4][2]float64{{float64(a.X), float64(a.Y)}, {float64(p.X), float64(p.Y)},
+		{float64(q.X), float64(q.Y)}, {float64(r.X), float64(r.Y)}}
+	depth := maxSubdivision
+	for b.MaxCurveSegments > 0 && depth > 0 && 1<<depth > b.MaxCurveSegments {
+		depth--
+	}
+	var ends [][2]float64
+	splitCubic(pts, 16*tol*tol, depth, &ends)
+	if m, ok := b.allot(len(ends)); !ok {
+		b.flatten(m, r, func(t float64) (float64, float64) {
+			mt := 1 - t
+			t1, t2, t3, t4 := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+			return pts[0][0]*t1 + pts[1][0]*t2 + pts[2][0]*t3 + pts[3][0]*t4,
+				pts[0][1]*t1 + pts[1][1]*t2 + pts[2][1]*t3 + pts[3][1]*t4
+		})
+		return
+	}
+	for _, e := range ends[:len(ends)-1] {
+		b.Adder.Line(fixed.Point26_6{X: fixed.Int26_6(math.Round(e[0])), Y: fixed.Int26_6(math.Round(e[1]))})
+	}
+	b.Line(r)
+}
+
+// splitCubic appends the end points of the line segments approximating the cubic
+// curve pts to ends, splitting it in halves until the flatness measure of each
+// part is within flat16, or depth runs out. The measure bounds sixteen times the
+// squared distance of a part from its chord.
+func splitCubic(pts [4][2]float64, flat16 float64, depth int, ends *[][2]float64) {
+	ux, uy := 3*pts[1][0]-2*pts[0][0]-pts[3][0], 3*pts[1][1]-2*pts[0][1]-pts[3][1]
+	vx, vy := 3*pts[2][0]-pts[0][0]-2*pts[3][0], 3*pts[2][1]-pts[0][1]-2*pts[3][1]
+	if depth == 0 || math.Max(ux*ux, vx*vx)+math.Max(uy*uy, vy*vy) <= flat16 {
+		*ends = append(*ends, pts[3])
+		return
+	}
+	// de Casteljau's split at the middle
+	var ab, bc, cd, abc, bcd, m [2]float64
+	for i := 0; i < 2; i++ {
+		ab[i] = (pts[0][i] + pts[1][i]) / 2
+		bc[i] = (pts[1][i] + pts[2][i]) / 2
+		cd[i] = (pts[2][i] + pts[3][i]) / 2
+		abc[i] = (ab[i] + bc[i]) / 2
+		bcd[i] = (bc[i] + cd[i]) / 2
+		m[i] = (abc[i] + bcd[i]) / 2
+	}
+	splitCubic([4][2]float64{pts[0], ab, abc, m}, flat16, depth-1, ends)
+	splitCubic([4][2]float64{m, bcd, cd, pts[3]}, flat16, depth-1, ends)
+}
+
+// devSquared measures how far the control point b pulls the curve from a to c
+// away from a straight line; it is the same measure rasterx uses to choose the
+// number of segments to flatten a curve into.
+func devSquared(a, b, c fixed.Point26_6) float64 {
+	devx := float64(a.X) - 2*float64(b.X) + float64(c.X)
+	devy := float64(a.Y) - 2*float64(b.Y) + float64(c.Y)
+	return devx*devx + devy*devy
+}
+
+// curveSegments returns the number of line segments rasterx flattens a curve with
+// deviation devsq into.
+func curveSegments(devsq float64) int {
+	if devsq < 0.333 {
+		return 1
+	}
+	return 1 + int(math.Sqrt(math.Sqrt(3*devsq)))
+}
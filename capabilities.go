@@ -0,0 +1,195 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// capabilities.go describes the parts of SVG that oksvg supports.
+
+package oksvg
+
+import "sort"
+
+// SupportLevel tells how fully a feature of SVG is supported.
+type SupportLevel int
+
+const (
+	// Unsupported features are ignored or reported as unknown elements.
+	Unsupported SupportLevel = iota
+	// Partial support is described by the Note of the Feature.
+	Partial
+	// Supported features render as the SVG specification describes.
+	Supported
+)
+
+// String returns the name of the level, for messages to users.
+func (l SupportLevel) String() string {
+	switch l {
+	case Supported:
+		return "supported"
+	case Partial:
+		return "partially supported"
+	}
+	return "unsupported"
+}
+
+// Feature is an element, property, filter primitive or unit of SVG.
+type Feature struct {
+	Name  string
+	Level SupportLevel
+	Note  string // the limits of partial support, or how a feature is supported
+}
+
+// CapabilitySet lists the features of SVG that oksvg knows of, each sorted by
+// name, so that applications can check documents before reading them or tell
+// their users what will not render.
+type CapabilitySet struct {
+	Elements   []Feature
+	Properties []Feature // presentation attributes and style properties
+	Filters    []Feature // filter primitive elements
+	Units      []Feature // units of lengths
+}
+
+// Capabilities returns the features of SVG that oksvg supports, and those it is
+// known not to. Elements with a handler registered with RegisterElementHandler
+// are included as supported.
+func Capabilities() CapabilitySet {
+	cs := CapabilitySet{
+		Properties: append([]Feature(nil), propertyFeatures...),
+		Filters:    append([]Feature(nil), filterFeatures...),
+		Units:      append([]Feature(nil), unitFeatures...),
+	}
+	seen := make(map[string]bool)
+	for _, f := range elementFeatures {
+		_, handled := drawFuncs[f.Name]
+		switch {
+		case handled && f.Level == Unsupported:
+			f.Level, f.Note = Supported, "registered handler"
+		case !handled && f.Level == Supported && !drawnByReference(f.Name):
+			f.Level, f.Note = Unsupported, "handler removed" // by RegisterElementHandler
+		}
+		cs.Elements = append(cs.Elements, f)
+		seen[f.Name] = true
+	}
+	for tag := range drawFuncs {
+		if !seen[tag] {
+			cs.Elements = append(cs.Elements, Feature{Name: tag, Level: Supported, Note: "registered handler"})
+		}
+	}
+	for _, fs := range [][]Feature{cs.Elements, cs.Properties, cs.Filters, cs.Units} {
+		sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	}
+	return cs
+}
+
+// elementFeatures are the elements oksvg knows of, with their support when no
+// handlers have been registered.
+var elementFeatures = []Feature{
+	{Name: "svg", Level: Supported, Note: "nested svg elements do not clip their content to their viewport"},
+	{Name: "g", Level: Supported},
+	{Name: "defs", Level: Supported},
+	{Name: "use", Level: Supported},
+	{Name: "symbol", Level: Supported, Note: "symbols do not clip their content to their viewport"},
+	{Name: "line", Level: Supported},
+	{Name: "rect", Level: Supported},
+	{Name: "circle", Level: Supported},
+	{Name: "ellipse", Level: Supported},
+	{Name: "polyline", Level: Supported},
+	{Name: "polygon", Level: Supported},
+	{Name: "path", Level: Supported, Note: "arcs are approximated with Bezier curves"},
+	{Name: "linearGradient", Level: Supported},
+	{Name: "radialGradient", Level: Supported},
+	{Name: "stop", Level: Supported},
+	{Name: "pattern", Level: Supported},
+	{Name: "clipPath", Level: Supported},
+	{Name: "mask", Level: Supported, Note: "luminance masks"},
+	{Name: "style", Level: Partial,
+		Note: "element, class and id selectors and their compounds; other selectors are skipped"},
+	{Name: "title", Level: Supported},
+	{Name: "desc", Level: Supported},
+	{Name: "foreignObject", Level: Partial, Note: "drawn by the ForeignObjectRenderer, if one is set"},
+	{Name: "text"},
+	{Name: "tspan"},
+	{Name: "textPath"},
+	{Name: "image", Level: Partial, Note: "PNG, JPEG and GIF data URIs, and other images returned by the ImageLoader, if one is set"},
+	{Name: "marker", Level: Partial, Note: "drawn with the style of the root svg element, and not clipped to its viewport"},
+	{Name: "pageSet", Level: Partial, Note: "its pages are drawn one at a time by Pages, and all at once by Draw"},
+	{Name: "page", Level: Partial, Note: "Inkscape pages and the pages of a pageSet, without master pages"},
+	{Name: "filter"},
+	{Name: "a"},
+	{Name: "switch"},
+	{Name: "animate"},
+	{Name: "animateTransform"},
+	{Name: "set"},
+}
+
+// propertyFeatures are the properties read by readStyleAttr, and others often
+// found in icons that are ignored.
+var propertyFeatures = []Feature{
+	{Name: "fill", Level: Supported},
+	{Name: "fill-opacity", Level: Supported},
+	{Name: "fill-rule", Level: Supported},
+	{Name: "stroke", Level: Supported},
+	{Name: "stroke-opacity", Level: Supported},
+	{Name: "stroke-width", Level: Supported},
+	{Name: "stroke-linecap", Level: Supported},
+	{Name: "stroke-linejoin", Level: Supported, Note: "also the miter-clip value of SVG 2 and the arc and arc-clip extensions of oksvg"},
+	{Name: "stroke-miterlimit", Level: Supported},
+	{Name: "stroke-dasharray", Level: Supported},
+	{Name: "stroke-dashoffset", Level: Supported},
+	{Name: "stroke-linegap", Level: Supported, Note: "an extension of oksvg"},
+	{Name: "stroke-leadlinecap", Level: Supported, Note: "an extension of oksvg"},
+	{Name: "opacity", Level: Supported, Note: "applied to the fill and stroke unless IsolateOpacity is set"},
+	{Name: "color", Level: Supported},
+	{Name: "font-size", Level: Supported},
+	{Name: "image-rendering", Level: Supported, Note: "pixelated and crisp-edges images use NearestSampling, others ParseOptions.ImageSampling"},
+	{Name: "clip-path", Level: Supported},
+	{Name: "mask", Level: Supported},
+	{Name: "transform", Level: Supported},
+	{Name: "clip-rule"},
+	{Name: "display"},
+	{Name: "visibility"},
+	{Name: "filter"},
+	{Name: "marker-start", Level: Supported},
+	{Name: "marker-mid", Level: Supported},
+	{Name: "marker-end", Level: Supported},
+	{Name: "paint-order"},
+	{Name: "vector-effect"},
+	{Name: "mix-blend-mode"},
+}
+
+// filterFeatures are the filter primitives, none of which are supported.
+var filterFeatures = []Feature{
+	{Name: "feBlend"},
+	{Name: "feColorMatrix"},
+	{Name: "feComponentTransfer"},
+	{Name: "feComposite"},
+	{Name: "feConvolveMatrix"},
+	{Name: "feDiffuseLighting"},
+	{Name: "feDisplacementMap"},
+	{Name: "feDropShadow"},
+	{Name: "feFlood"},
+	{Name: "feGaussianBlur"},
+	{Name: "feImage"},
+	{Name: "feMerge"},
+	{Name: "feMorphology"},
+	{Name: "feOffset"},
+	{Name: "feSpecularLighting"},
+	{Name: "feTile"},
+	{Name: "feTurbulence"},
+}
+
+// unitFeatures are the units of lengths, with "" for user units.
+var unitFeatures = []Feature{
+	{Name: "", Level: Supported, Note: "user units"},
+	{Name: "px", Level: Supported},
+	{Name: "em", Level: Supported},
+	{Name: "rem", Level: Supported},
+	{Name: "%", Level: Supported},
+	{Name: "pt", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "pc", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "in", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "cm", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "mm", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "Q", Level: Supported, Note: "converted at ParseOptions.DPI"},
+	{Name: "ex"},
+	{Name: "ch"},
+	{Name: "vw"},
+	{Name: "vh"},
+}
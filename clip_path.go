@@ -0,0 +1,170 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// clip_path.go implements clipping of paths by clipPath and mask elements.
+
+package oksvg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// clipPath is the content of a clipPath or mask element, in the user space of
+// the element it clips or, if bbox is true, in the objectBoundingBox units of
+// the clipped path. The paths of a clipPath are drawn as opaque fills, while a
+// mask, with luminance set, is drawn with its paints and the luminance of the
+// result is the coverage.
+type clipPath struct {
+	paths     []SvgPath
+	bbox      bool
+	luminance bool
+}
+
+// addClip adds the clipPath or mask, as tag is, referenced by the clip-path or
+// mask value v to the clips of style. The children of the element are read as a
+// use element would draw them, and their paths are kept in the clipPath rather
+// than added to the icon. While the document is read, an element not read yet,
+// or children using one, are read at its end.
+func (c *IconCursor) addClip(style *PathStyle, v, tag string) error {
+	if v == "none" {
+		return nil
+	}
+	cp := &clipPath{luminance: tag == "mask"}
+	found, missed, err := c.collect(func() (bool, error) { return c.readClip(cp, *style, v, tag) })
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.late.deferring && (!found || missed):
+		c.deferClip(cp, *style, v, tag)
+	case !found:
+		return c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: %s %s", errMissingRef, tag, v))
+	}
+	// The clips of style are shared with the styles it was copied from
+	style.clips = append(style.clips[:len(style.clips):len(style.clips)], cp)
+	return nil
+}
+
+// readClip reads the children of the clipPath or mask, as tag is, referenced by
+// the value v into cp, drawn on an element with the style base. It returns
+// false if v names no such element.
+func (c *IconCursor) readClip(cp *clipPath, base PathStyle, v, tag string) (bool, error) {
+	var id string
+	if strings.HasPrefix(v, "url(") && strings.HasSuffix(v, ")") {
+		id = strings.TrimSpace(v[4 : len(v)-1])
+	}
+	defs, ok := c.icon.Defs[strings.TrimPrefix(id, "#")]
+	if !ok || !strings.HasPrefix(id, "#") || defs[0].Tag != tag {
+		return false, nil
+	}
+	for _, u := range c.uses {
+		if u == id {
+			return true, fmt.Errorf("%s %s references itself", tag, v)
+		}
+	}
+	for _, attr := range defs[0].Attrs {
+		if attr.Name.Local == "clipPathUnits" || attr.Name.Local == "maskContentUnits" {
+			cp.bbox = attr.Value == "objectBoundingBox"
+		}
+	}
+	if cp.bbox {
+		base.mAdder.M = rasterx.Identity
+	}
+	n, depth := len(c.icon.SVGPaths), len(c.StyleStack)
+	c.StyleStack = append(c.StyleStack, base)
+	c.uses = append(c.uses, id)
+	err := c.instantiate(defs, 0, 0)
+	c.uses = c.uses[:len(c.uses)-1]
+	c.StyleStack = c.StyleStack[:depth]
+	cp.paths = append([]SvgPath(nil), c.icon.SVGPaths[n:]...)
+	c.icon.SVGPaths = c.icon.SVGPaths[:n]
+	return true, err
+}
+
+// drawTo fills the clip region white into r, or draws the mask content, transformed
+// by t and then offset. Paths in objectBoundingBox units are instead mapped to
+// bbox, in device coordinates, and then offset.
+func (cp *clipPath) drawTo(r *rasterx.Dasher, t, offset rasterx.Matrix2D, bbox ViewBox, tb TessellationBudget) {
+	if cp.bbox {
+		t = rasterx.Identity.Translate(bbox.X, bbox.Y).Scale(bbox.W, bbox.H)
+	}
+	t = offset.Mult(t)
+	for _, svgp := range cp.paths {
+		if !cp.luminance {
+			svgp.fillerColor, svgp.linerColor = color.White, nil
+			svgp.FillOpacity = 1
+		}
+		svgp.drawBudgeted(r, 1, t, svgp.StrokeStyle(), tb)
+	}
+}
+
+// drawClipped draws the SvgPath as drawBudgeted does into an offscreen layer,
+// and composites the layer through the intersection of its clip paths and masks.
+func (svgp *SvgPath) drawClipped(r *rasterx.Dasher, opacity float64, t rasterx.Matrix2D,
+	ss StrokeStyle, tb TessellationBudget) {
+	inner := *svgp
+	inner.clips = nil
+	var es extentScanner
+	er := rasterx.NewDasher(1, 1, &es)
+	inner.drawBudgeted(er, 1, t, ss, tb)
+	rect := es.drawn.Intersect(image.Rect(0, 0, math.MaxInt32, math.MaxInt32))
+	// objectBoundingBox units refer to the geometry of the fill, without the stroke
+	fill := inner
+	fill.fillerColor, fill.linerColor = color.Black, nil
+	fill.drawBudgeted(er, 1, t, ss, tb)
+	bbox := objectBounds(&es)
+	for _, cp := range svgp.clips {
+		es.drawn = image.Rectangle{}
+		cp.drawTo(er, t, rasterx.Identity, bbox, tb)
+		rect = rect.Intersect(es.drawn)
+	}
+	if sr, ok := scannerRect(r.Scanner); ok {
+		// Only the part of the layer that reaches the image is drawn
+		rect = rect.Intersect(sr)
+	}
+	if rect.Empty() {
+		return
+	}
+	w, h := rect.Dx(), rect.Dy()
+	toLayer := rasterx.Identity.Translate(-float64(rect.Min.X), -float64(rect.Min.Y))
+	layer := image.NewRGBA(image.Rect(0, 0, w, h))
+	inner.drawBudgeted(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, layer, layer.Bounds())),
+		opacity, toLayer.Mult(t), ss, tb)
+	masks := make([]*image.Alpha, len(svgp.clips))
+	for i, cp := range svgp.clips {
+		masks[i] = image.NewAlpha(layer.Bounds())
+		if !cp.luminance {
+			cp.drawTo(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, masks[i], layer.Bounds())),
+				t, toLayer, bbox, tb)
+			continue
+		}
+		content := image.NewRGBA(layer.Bounds())
+		cp.drawTo(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, content, layer.Bounds())),
+			t, toLayer, bbox, tb)
+		for j := 0; j < len(masks[i].Pix); j++ {
+			// The luminance of the premultiplied color includes its alpha
+			p := content.Pix[4*j : 4*j+4 : 4*j+4]
+			masks[i].Pix[j] = uint8(0.2125*float64(p[0]) + 0.7154*float64(p[1]) + 0.0721*float64(p[2]) + 0.5)
+		}
+	}
+
+	r.Clear()
+	rf := &r.Filler
+	rasterx.AddRect(float64(rect.Min.X), float64(rect.Min.Y), float64(rect.Max.X), float64(rect.Max.Y), 0, rf)
+	rf.SetColor(rasterx.ColorFunc(func(x, y int) color.Color {
+		x, y = x-rect.Min.X, y-rect.Min.Y
+		c := layer.RGBAAt(x, y)
+		a := 1.0
+		for _, m := range masks {
+			a *= float64(m.AlphaAt(x, y).A) / 0xFF
+		}
+		return color.RGBA{uint8(float64(c.R) * a), uint8(float64(c.G) * a),
+			uint8(float64(c.B) * a), uint8(float64(c.A) * a)}
+	}))
+	rf.Draw()
+}
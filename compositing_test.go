@@ -0,0 +1,159 @@
+// Copyright 2018 The oksvg Authors. All rights reserved.
+// created: 2018 by S.R.Wiley
+package oksvg_test
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/srwiley/oksvg"
+	. "github.com/srwiley/rasterx"
+)
+
+var updateRefs = flag.Bool("update", false, "rewrite the reference images of TestCompositing")
+
+// compositeCase is an icon combining nested groups with opacity, transforms,
+// clips and gradients. Its rendering is compared to testdata/ref/<name>.png,
+// and the probes document the color a browser renders at each point. Cases
+// whose probes need features the renderer lacks name the issue; their reference
+// images record the current rendering so that changes to it are noticed.
+type compositeCase struct {
+	name, body string
+	probes     []compositeProbe
+	issue      string
+}
+
+type compositeProbe struct {
+	p image.Point
+	c color.NRGBA
+}
+
+var compositeCases = []compositeCase{
+	{name: "nested opacity",
+		// Group opacities multiply: 0.5 * 0.5 of opaque red
+		body: `<g opacity="0.5"><g opacity="0.5"><rect x="5" y="5" width="30" height="30" fill="red"/></g></g>`,
+		probes: []compositeProbe{{image.Point{20, 20}, color.NRGBA{255, 0, 0, 64}},
+			{image.Point{2, 2}, color.NRGBA{}}}},
+	{name: "nested transforms",
+		// The inner scale applies first: the rect covers 10 to 30
+		body: `<g transform="translate(10,10)"><g transform="scale(2)"><rect width="10" height="10" fill="blue"/></g></g>`,
+		probes: []compositeProbe{{image.Point{11, 11}, color.NRGBA{0, 0, 255, 255}},
+			{image.Point{29, 29}, color.NRGBA{0, 0, 255, 255}}, {image.Point{31, 20}, color.NRGBA{}},
+			{image.Point{8, 20}, color.NRGBA{}}}},
+	{name: "gradient in rotated group",
+		// The objectBoundingBox gradient turns with the group: red at the top, blue at the bottom
+		body: `<defs><linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/>
+			</linearGradient></defs><g transform="rotate(90,20,20)"><rect x="0" y="0" width="40" height="40" fill="url(#g)"/></g>`,
+		probes: []compositeProbe{{image.Point{20, 0}, color.NRGBA{250, 0, 5, 255}},
+			{image.Point{20, 39}, color.NRGBA{5, 0, 250, 255}}},
+		issue: "objectBoundingBox gradients are mapped to the device bounding box, ignoring the rotation"},
+	{name: "gradient with group opacity",
+		body: `<defs><linearGradient id="g" x1="0" x2="40" gradientUnits="userSpaceOnUse"><stop offset="0" stop-color="red"/>
+			<stop offset="1" stop-color="blue"/></linearGradient></defs>
+			<g opacity="0.5"><rect width="40" height="40" fill="url(#g)"/></g>`,
+		probes: []compositeProbe{{image.Point{0, 20}, color.NRGBA{250, 0, 5, 128}},
+			{image.Point{39, 20}, color.NRGBA{5, 0, 250, 128}}}},
+	{name: "overlap in translucent group",
+		// A group with opacity is composited as a whole, so the overlap of the
+		// two opaque rects is no darker than the rest
+		body: `<g opacity="0.5"><rect x="5" y="5" width="20" height="20" fill="green"/>
+			<rect x="15" y="15" width="20" height="20" fill="green"/></g>`,
+		probes: []compositeProbe{{image.Point{10, 10}, color.NRGBA{0, 128, 0, 128}},
+			{image.Point{20, 20}, color.NRGBA{0, 128, 0, 128}}},
+		issue: "group opacity is applied to each path instead of the group as a whole"},
+	{name: "transformed opacity stroke",
+		body: `<g transform="translate(20,20) scale(2)" opacity="0.5"><g transform="rotate(45)">
+			<rect x="-5" y="-5" width="10" height="10" fill="none" stroke="black" stroke-width="2"/></g></g>`,
+		probes: []compositeProbe{{image.Point{20, 20}, color.NRGBA{}}, {image.Point{20, 6}, color.NRGBA{0, 0, 0, 128}}}},
+	{name: "clip in transformed group",
+		// The clip path is in the user space of the clipped element, so it scales with the group
+		body: `<defs><clipPath id="c"><rect width="10" height="10"/></clipPath></defs>
+			<g transform="scale(2)"><rect width="20" height="20" fill="red" clip-path="url(#c)"/></g>`,
+		probes: []compositeProbe{{image.Point{10, 10}, color.NRGBA{255, 0, 0, 255}}, {image.Point{30, 30}, color.NRGBA{}}}},
+	{name: "clip with opacity and gradient",
+		// The gradient is sampled at pixel centers, 20.5 of 40 at the probe
+		body: `<defs><clipPath id="c"><circle cx="20" cy="20" r="10"/></clipPath>
+			<linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient></defs>
+			<g opacity="0.5" clip-path="url(#c)"><g transform="translate(5,0)"><rect x="-5" width="40" height="40" fill="url(#g)"/></g></g>`,
+		probes: []compositeProbe{{image.Point{2, 2}, color.NRGBA{}}, {image.Point{20, 20}, color.NRGBA{124, 0, 131, 128}}}},
+}
+
+func TestCompositing(t *testing.T) {
+	const w, h = 40, 40
+	for _, cc := range compositeCases {
+		cc := cc
+		t.Run(cc.name, func(t *testing.T) {
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + cc.body + `</svg>`
+			icon, err := ReadIconStream(strings.NewReader(svg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := image.NewNRGBA(image.Rect(0, 0, w, h))
+			icon.Draw(NewDasher(w, h, NewScannerGV(w, h, img, img.Bounds())), 1)
+			compareRef(t, "testdata/ref/"+strings.ReplaceAll(cc.name, " ", "_")+".png", img)
+			if cc.issue != "" {
+				t.Skip(cc.issue)
+			}
+			for _, pr := range cc.probes {
+				if got := img.NRGBAAt(pr.p.X, pr.p.Y); !nearColor(got, pr.c, 3) {
+					t.Errorf("at %v got %v, want %v", pr.p, got, pr.c)
+				}
+			}
+		})
+	}
+}
+
+// nearColor reports whether the channels of a and b differ by at most tol.
+// The colors of fully transparent pixels do not matter.
+func nearColor(a, b color.NRGBA, tol int) bool {
+	if a.A == 0 && b.A == 0 {
+		return true
+	}
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d > tol || d < -tol {
+			return false
+		}
+	}
+	return true
+}
+
+// compareRef compares img to the reference image in file, or writes it there
+// if the -update flag is set.
+func compareRef(t *testing.T, file string, img *image.NRGBA) {
+	if *updateRefs {
+		f, err := os.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err = png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err, "(run the test with -update to create it)")
+	}
+	defer f.Close()
+	ref, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Bounds() != img.Bounds() {
+		t.Fatalf("reference is %v, rendering is %v", ref.Bounds(), img.Bounds())
+	}
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			want := color.NRGBAModel.Convert(ref.At(x, y)).(color.NRGBA)
+			if got := img.NRGBAAt(x, y); !nearColor(got, want, 2) {
+				t.Fatalf("differs from %s at %d,%d: got %v, want %v", file, x, y, got, want)
+			}
+		}
+	}
+}
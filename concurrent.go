@@ -0,0 +1,79 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// concurrent.go implements drawing the paths of an icon on several goroutines.
+
+package oksvg
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+
+	"github.com/srwiley/rasterx"
+)
+
+// DrawConcurrent draws the icon into dst as Draw does with a ScannerGV over dst,
+// with the Transform of the icon mapping to the coordinates of dst. The paths are
+// rasterized by n goroutines, each into a layer covering only the pixels of its
+// path, and the layers are composited over dst in document order, so the result
+// is that of Draw. If n is not positive, GOMAXPROCS goroutines are used.
+//
+// DrawConcurrent pays off for icons with many paths, such as maps, on multicore
+// machines. The icon must not be changed while it is drawn.
+func (s *SvgIcon) DrawConcurrent(n int, dst draw.Image, opacity float64) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	bounds := dst.Bounds()
+	if bounds.Empty() || len(s.SVGPaths) == 0 {
+		return
+	}
+	t := rasterx.Identity.Translate(-float64(bounds.Min.X), -float64(bounds.Min.Y)).Mult(s.Transform)
+	tb := s.budget()
+	layers := make([]chan cachedLayer, len(s.SVGPaths))
+	for i := range layers {
+		layers[i] = make(chan cachedLayer, 1)
+	}
+	// window bounds the number of layers held before they are composited
+	window := make(chan struct{}, 4*n)
+	next := make(chan int)
+	go func() {
+		for i := range s.SVGPaths {
+			window <- struct{}{}
+			next <- i
+		}
+		close(next)
+	}()
+	for w := 0; w < n; w++ {
+		go func() {
+			for i := range next {
+				svgp := s.SVGPaths[i] // drawing changes the matrix of the path
+				layers[i] <- s.pathLayer(&svgp, opacity, t, bounds.Size(), tb)
+			}
+		}()
+	}
+	for i := range layers {
+		if l := <-layers[i]; l.img != nil {
+			draw.Draw(dst, l.rect.Add(bounds.Min), l.img, image.Point{}, draw.Over)
+		}
+		<-window
+	}
+}
+
+// pathLayer draws svgp, transformed by t, into a layer holding the part of an
+// image of size that it covers. The img of the layer is nil if it covers nothing.
+func (s *SvgIcon) pathLayer(svgp *SvgPath, opacity float64, t rasterx.Matrix2D, size image.Point,
+	tb TessellationBudget) cachedLayer {
+	var es extentScanner
+	s.drawPath(svgp, rasterx.NewDasher(1, 1, &es), 1, t, tb, s.Quirks)
+	rect := es.drawn.Intersect(image.Rectangle{Max: size})
+	if rect.Empty() {
+		return cachedLayer{}
+	}
+	w, h := rect.Dx(), rect.Dy()
+	l := cachedLayer{rect: rect, img: image.NewRGBA(image.Rect(0, 0, w, h))}
+	toLayer := rasterx.Identity.Translate(-float64(rect.Min.X), -float64(rect.Min.Y))
+	s.drawPath(svgp, rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, l.img, l.img.Bounds())),
+		opacity, toLayer.Mult(t), tb, s.Quirks)
+	return l
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// dash_phase.go implements continuation of the dash pattern across subpaths.
+
+package oksvg
+
+import (
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// dashPhaseAdder passes path commands on to a Dasher, measuring their length so
+// that the dash pattern of each subpath starts where the previous subpath left it
+// rather than at the dash offset. Curves are measured along the same polylines
+// the Dasher flattens them into.
+type dashPhaseAdder struct {
+	r              *rasterx.Dasher
+	offset, length fixed.Int26_6 // dash offset of the path and length added so far
+	a, first       fixed.Point26_6
+}
+
+// newDashPhaseAdder returns a dashPhaseAdder for the stroke r has been set to.
+func newDashPhaseAdder(r *rasterx.Dasher) *dashPhaseAdder {
+	return &dashPhaseAdder{r: r, offset: r.DashOffset}
+}
+
+// Start starts a new subpath at a, continuing the dash pattern
+func (d *dashPhaseAdder) Start(a fixed.Point26_6) {
+	var period fixed.Int26_6
+	for _, v := range d.r.Dashes {
+		period += v
+	}
+	if period > 0 {
+		d.r.DashOffset = (d.offset + d.length) % period
+	}
+	d.a, d.first = a, a
+	d.r.Start(a)
+}
+
+// Line adds a line segment to b
+func (d *dashPhaseAdder) Line(b fixed.Point26_6) {
+	d.lineTo(b)
+	d.r.Line(b)
+}
+
+// QuadBezier adds a quadratic bezier with control point b ending at c
+func (d *dashPhaseAdder) QuadBezier(b, c fixed.Point26_6) {
+	if d.a == b || b == c {
+		d.lineTo(c)
+	} else {
+		rasterx.QuadTo(float32(d.a.X), float32(d.a.Y), float32(b.X), float32(b.Y),
+			float32(c.X), float32(c.Y), d.lineToF)
+	}
+	d.a = c
+	d.r.QuadBezier(b, c)
+}
+
+// CubeBezier adds a cubic bezier with control points b and c ending at e
+func (d *dashPhaseAdder) CubeBezier(b, c, e fixed.Point26_6) {
+	a := d.a
+	if (a == b && c == e) || (a == b && b == c) || (c == b && e == c) {
+		d.lineTo(e)
+	} else {
+		rasterx.CubeTo(float32(a.X), float32(a.Y), float32(b.X), float32(b.Y),
+			float32(c.X), float32(c.Y), float32(e.X), float32(e.Y), d.lineToF)
+	}
+	d.a = e
+	d.r.CubeBezier(b, c, e)
+}
+
+// Stop ends the current subpath, closing it if closeLoop is true
+func (d *dashPhaseAdder) Stop(closeLoop bool) {
+	if closeLoop {
+		d.lineTo(d.first)
+	}
+	d.r.Stop(closeLoop)
+}
+
+func (d *dashPhaseAdder) lineTo(b fixed.Point26_6) {
+	d.length += rasterx.Length(b.Sub(d.a))
+	d.a = b
+}
+
+func (d *dashPhaseAdder) lineToF(x, y float32) {
+	d.lineTo(fixed.Point26_6{X: fixed.Int26_6(x), Y: fixed.Int26_6(y)})
+}
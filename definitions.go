@@ -7,10 +7,203 @@ package oksvg
 
 import (
 	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"github.com/srwiley/rasterx"
 )
 
 // definition is used to store XML-tags of SVG source definitions data.
 type definition struct {
 	ID, Tag string
 	Attrs   []xml.Attr
+	pos     SourcePos // location of the element in the source
+}
+
+// Definition is a referenceable object defined in an SVG icon,
+// such as a gradient or the elements within a defs element.
+type Definition interface {
+	DefID() string
+}
+
+// GradientDef is the Definition of a linear or radial gradient.
+type GradientDef struct {
+	ID       string
+	Gradient *rasterx.Gradient
+	Source   SourcePos // location of the gradient element in the source
+}
+
+// DefID returns the id of the gradient.
+func (d GradientDef) DefID() string { return d.ID }
+
+// ElementDef is the Definition of elements within defs that
+// can be instantiated by a use element.
+type ElementDef struct {
+	ID     string
+	Tag    string     // Tag of the first defined element, e.g. "g" or "path"
+	Attrs  []xml.Attr // Attributes of the first defined element
+	Source SourcePos  // location of the first defined element in the source
+}
+
+// DefID returns the id of the defined elements.
+func (d ElementDef) DefID() string { return d.ID }
+
+// SymbolDef is the Definition of a symbol element, such as an icon of a
+// sprite sheet.
+type SymbolDef struct {
+	ID     string
+	Attrs  []xml.Attr // Attributes of the symbol element, e.g. its viewBox
+	Source SourcePos  // location of the symbol element in the source
+}
+
+// DefID returns the id of the symbol.
+func (d SymbolDef) DefID() string { return d.ID }
+
+// ClipDef is the Definition of a clipPath or mask element.
+type ClipDef struct {
+	ID     string
+	Mask   bool       // whether the element is a mask rather than a clipPath
+	Attrs  []xml.Attr // Attributes of the clipPath or mask element
+	Source SourcePos  // location of the element in the source
+}
+
+// DefID returns the id of the clip path or mask.
+func (d ClipDef) DefID() string { return d.ID }
+
+// PatternDef is the Definition of a pattern element.
+type PatternDef struct {
+	ID     string
+	Attrs  []xml.Attr // Attributes of the pattern element
+	Source SourcePos  // location of the pattern element in the source
+}
+
+// DefID returns the id of the pattern.
+func (d PatternDef) DefID() string { return d.ID }
+
+// LookupGradient returns the gradient with the given id.
+func (s *SvgIcon) LookupGradient(id string) (*rasterx.Gradient, bool) {
+	g, ok := s.Grads[id]
+	return g, ok
+}
+
+// LookupElement returns the elements defined within defs with the given id.
+func (s *SvgIcon) LookupElement(id string) (ElementDef, bool) {
+	defs, ok := s.Defs[id]
+	if !ok || len(defs) == 0 {
+		return ElementDef{}, false
+	}
+	return ElementDef{ID: id, Tag: defs[0].Tag, Attrs: defs[0].Attrs, Source: defs[0].pos}, true
+}
+
+// LookupSymbol returns the symbol element with the given id.
+func (s *SvgIcon) LookupSymbol(id string) (SymbolDef, bool) {
+	defs := s.Defs[id]
+	if len(defs) == 0 || defs[0].Tag != "symbol" {
+		return SymbolDef{}, false
+	}
+	return SymbolDef{ID: id, Attrs: defs[0].Attrs, Source: defs[0].pos}, true
+}
+
+// LookupClip returns the clipPath or mask element with the given id.
+func (s *SvgIcon) LookupClip(id string) (ClipDef, bool) {
+	defs := s.Defs[id]
+	if len(defs) == 0 || (defs[0].Tag != "clipPath" && defs[0].Tag != "mask") {
+		return ClipDef{}, false
+	}
+	return ClipDef{ID: id, Mask: defs[0].Tag == "mask", Attrs: defs[0].Attrs, Source: defs[0].pos}, true
+}
+
+// LookupPattern returns the pattern element with the given id.
+func (s *SvgIcon) LookupPattern(id string) (PatternDef, bool) {
+	defs := s.Defs[id]
+	if len(defs) == 0 || defs[0].Tag != "pattern" {
+		return PatternDef{}, false
+	}
+	return PatternDef{ID: id, Attrs: defs[0].Attrs, Source: defs[0].pos}, true
+}
+
+// LookupDefinition returns the Definition with the given id: a GradientDef,
+// SymbolDef, ClipDef or PatternDef, or an ElementDef for other elements.
+func (s *SvgIcon) LookupDefinition(id string) (Definition, bool) {
+	if g, ok := s.LookupGradient(id); ok {
+		return GradientDef{ID: id, Gradient: g, Source: s.gradSources[id]}, true
+	}
+	if d, ok := s.LookupSymbol(id); ok {
+		return d, true
+	}
+	if d, ok := s.LookupClip(id); ok {
+		return d, true
+	}
+	if d, ok := s.LookupPattern(id); ok {
+		return d, true
+	}
+	if e, ok := s.LookupElement(id); ok {
+		return e, true
+	}
+	return nil, false
+}
+
+// Definitions returns all Definitions of the icon sorted by id.
+func (s *SvgIcon) Definitions() []Definition {
+	ids := make([]string, 0, len(s.Grads)+len(s.Defs))
+	for id := range s.Grads {
+		ids = append(ids, id)
+	}
+	for id := range s.Defs {
+		if _, ok := s.Grads[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	list := make([]Definition, 0, len(ids))
+	for _, id := range ids {
+		if d, ok := s.LookupDefinition(id); ok {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// Symbols returns the ids of the symbol elements of the icon, sorted, such as
+// the icons of a sprite sheet. Icons read with ParseOptions.LowMemory have none.
+func (s *SvgIcon) Symbols() []string {
+	var ids []string
+	for id, defs := range s.Defs {
+		if len(defs) > 0 && defs[0].Tag == "symbol" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Symbol returns the symbol element with the id as an icon of its own, drawn as a
+// use element referencing it would draw it. If the symbol has a viewBox, the
+// ViewBox of the icon is the same size at the origin, otherwise it is that of s.
+// The icon shares the definitions and gradients of s.
+func (s *SvgIcon) Symbol(id string) (*SvgIcon, error) {
+	defs := s.Defs[id]
+	if len(defs) == 0 || defs[0].Tag != "symbol" {
+		return nil, fmt.Errorf("%w: symbol %s", errMissingRef, id)
+	}
+	icon := &SvgIcon{ViewBox: s.ViewBox, Defs: s.Defs, Grads: s.Grads, Transform: rasterx.Identity,
+		Budget: s.Budget, IsolateOpacity: s.IsolateOpacity, Quirks: s.Quirks,
+		styleRules: s.styleRules, gradSources: s.gradSources}
+	c := newIconCursor(icon, s.readOpts)
+	for _, attr := range defs[0].Attrs {
+		if attr.Name.Local != "viewBox" {
+			continue
+		}
+		if err := c.GetPoints(attr.Value); err != nil || len(c.points) != 4 {
+			return nil, errParamMismatch
+		}
+		if c.points[2] > 0 && c.points[3] > 0 {
+			icon.ViewBox = ViewBox{0, 0, c.points[2], c.points[3]}
+		}
+	}
+	c.coordScale = coordScaleFor(icon.ViewBox)
+	if err := c.instantiate(defs, icon.ViewBox.W, icon.ViewBox.H); err != nil {
+		return nil, err
+	}
+	return icon, nil
 }
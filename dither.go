@@ -0,0 +1,75 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// dither.go implements 1-bit output for printers and e-ink displays.
+
+package oksvg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/srwiley/rasterx"
+)
+
+// DitherMode selects how gray levels are reduced to black and white.
+type DitherMode int
+
+// DitherMode constants
+const (
+	DitherThreshold      DitherMode = iota // pixels darker than mid gray are black
+	DitherFloydSteinberg                   // error diffusion, best for photos and gradients
+	DitherBayer                            // 4x4 ordered dither, stable under small changes
+)
+
+// monochrome is the palette of 1-bit images: index 0 is white paper and
+// index 1 is black ink.
+var monochrome = color.Palette{color.White, color.Black}
+
+// bayer4 is the 4x4 Bayer threshold matrix.
+var bayer4 = [4][4]uint8{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// DrawMonochrome draws the icon, using its Transform, over a white background of
+// width w and height h and reduces the result to a 1-bit image with the mode.
+func DrawMonochrome(icon *SvgIcon, w, h int, mode DitherMode) *image.Paletted {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+	return Dither(img, mode)
+}
+
+// Dither reduces src to a 1-bit image with the mode. In the result, palette
+// index 0 is white and index 1 is black. Transparent parts of src are
+// treated as they appear over black, so src should normally be opaque.
+func Dither(src image.Image, mode DitherMode) *image.Paletted {
+	b := src.Bounds()
+	dst := image.NewPaletted(b, monochrome)
+	switch mode {
+	case DitherFloydSteinberg:
+		draw.FloydSteinberg.Draw(dst, b, src, b.Min)
+	case DitherBayer:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				g := color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y
+				t := bayer4[y&3][x&3]*16 + 8
+				if g < t {
+					dst.SetColorIndex(x, y, 1)
+				}
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y < 0x80 {
+					dst.SetColorIndex(x, y, 1)
+				}
+			}
+		}
+	}
+	return dst
+}
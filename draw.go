@@ -8,15 +8,33 @@ package oksvg
 import (
 	"encoding/xml"
 	"errors"
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/srwiley/rasterx"
 	"golang.org/x/image/math/fixed"
 )
 
+// ElemHandler handles an SVG element with the given attributes. It is called
+// after the style attributes of the element are pushed onto the StyleStack of
+// the IconCursor. Any path the handler adds to the PathCursor is added to the
+// icon with that style once the handler returns.
+type ElemHandler func(c *IconCursor, attrs []xml.Attr) error
+
 // svgFunc defines function interface to use as drawing implementation.
-type svgFunc func(c *IconCursor, attrs []xml.Attr) error
+type svgFunc = ElemHandler
+
+// RegisterElementHandler sets fn as the handler for SVG elements with the given tag,
+// replacing any previous handler including the built in ones. A nil fn removes the
+// handler so the element is reported as unprocessed. Handlers are not guarded against
+// concurrent use, so register them before reading icons, for example from an init func.
+func RegisterElementHandler(tag string, fn ElemHandler) {
+	if fn == nil {
+		delete(drawFuncs, tag)
+		return
+	}
+	drawFuncs[tag] = fn
+}
 
 var (
 	drawFuncs = map[string]svgFunc{
@@ -36,9 +54,15 @@ var (
 		"title":          titleF,
 		"linearGradient": linearGradientF,
 		"radialGradient": radialGradientF,
+		"image":          imageF,
+		"pageSet":        gF,
+		"page":           pageF,
 	}
 
 	svgF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+		if len(c.ids) != 1 {
+			return c.svgViewport(attrs, 0, 0)
+		}
 		c.icon.ViewBox.X = 0
 		c.icon.ViewBox.Y = 0
 		c.icon.ViewBox.W = 0
@@ -56,10 +80,22 @@ var (
 				c.icon.ViewBox.Y = c.points[1]
 				c.icon.ViewBox.W = c.points[2]
 				c.icon.ViewBox.H = c.points[3]
-			case "width":
-				width, err = parseFloat(attr.Value, 64)
-			case "height":
-				height, err = parseFloat(attr.Value, 64)
+			case "preserveAspectRatio":
+				if len(c.ids) == 1 { // the root svg element
+					c.icon.PreserveAspectRatio = strings.TrimSpace(attr.Value)
+				}
+			case "width", "height":
+				if strings.HasSuffix(attr.Value, "%") {
+					break // relative to a viewport the icon does not have
+				}
+				size, phys := &width, &c.icon.physW
+				if attr.Name.Local == "height" {
+					size, phys = &height, &c.icon.physH
+				}
+				*size, err = c.parseLength(attr.Value, 0)
+				if len(c.ids) == 1 { // the root svg element
+					*phys = physicalLength(attr.Value)
+				}
 			}
 			if err != nil {
 				return err
@@ -71,6 +107,7 @@ var (
 		if c.icon.ViewBox.H == 0 {
 			c.icon.ViewBox.H = height
 		}
+		c.coordScale = coordScaleFor(c.icon.ViewBox)
 		return nil
 	}
 	gF    svgFunc = func(*IconCursor, []xml.Attr) error { return nil } // g does nothing but push the style
@@ -80,27 +117,24 @@ var (
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "x":
-				x, err = parseFloat(attr.Value, 64)
+				x, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "y":
-				y, err = parseFloat(attr.Value, 64)
+				y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			case "width":
-				w, err = parseFloat(attr.Value, 64)
+				w, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "height":
-				h, err = parseFloat(attr.Value, 64)
+				h, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			case "rx":
-				rx, err = parseFloat(attr.Value, 64)
+				rx, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "ry":
-				ry, err = parseFloat(attr.Value, 64)
+				ry, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			}
 			if err != nil {
 				return err
 			}
 		}
-		if w == 0 || h == 0 {
-			return nil
-		}
-		rasterx.AddRoundRect(x, y, w+x, h+y, rx, ry, 0, rasterx.RoundGap, &c.Path)
-		return nil
+		c.RoundRect(c.scaled(x), c.scaled(y), c.scaled(w), c.scaled(h), c.scaled(rx), c.scaled(ry))
+		return c.checkRange()
 	}
 	circleF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
 		var cx, cy, rx, ry float64
@@ -108,16 +142,16 @@ var (
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "cx":
-				cx, err = parseFloat(attr.Value, 64)
+				cx, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "cy":
-				cy, err = parseFloat(attr.Value, 64)
+				cy, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			case "r":
-				rx, err = parseFloat(attr.Value, 64)
+				rx, err = c.parseLength(attr.Value, c.diagonal())
 				ry = rx
 			case "rx":
-				rx, err = parseFloat(attr.Value, 64)
+				rx, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "ry":
-				ry, err = parseFloat(attr.Value, 64)
+				ry, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			}
 			if err != nil {
 				return err
@@ -126,8 +160,8 @@ var (
 		if rx == 0 || ry == 0 { // not drawn, but not an error
 			return nil
 		}
-		c.EllipseAt(cx, cy, rx, ry)
-		return nil
+		c.EllipseAt(c.scaled(cx), c.scaled(cy), c.scaled(rx), c.scaled(ry))
+		return c.checkRange()
 	}
 	lineF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
 		var x1, x2, y1, y2 float64
@@ -135,24 +169,28 @@ var (
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "x1":
-				x1, err = parseFloat(attr.Value, 64)
+				x1, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "x2":
-				x2, err = parseFloat(attr.Value, 64)
+				x2, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "y1":
-				y1, err = parseFloat(attr.Value, 64)
+				y1, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			case "y2":
-				y2, err = parseFloat(attr.Value, 64)
+				y2, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			}
 			if err != nil {
 				return err
 			}
 		}
+		c.points = append(c.points[:0], c.scaled(x1), c.scaled(y1), c.scaled(x2), c.scaled(y2))
+		if err = c.checkRange(); err != nil {
+			return err
+		}
 		c.Path.Start(fixed.Point26_6{
-			X: fixed.Int26_6((x1) * 64),
-			Y: fixed.Int26_6((y1) * 64)})
+			X: fixed.Int26_6((c.points[0]) * 64),
+			Y: fixed.Int26_6((c.points[1]) * 64)})
 		c.Path.Line(fixed.Point26_6{
-			X: fixed.Int26_6((x2) * 64),
-			Y: fixed.Int26_6((y2) * 64)})
+			X: fixed.Int26_6((c.points[2]) * 64),
+			Y: fixed.Int26_6((c.points[3]) * 64)})
 		return nil
 	}
 	polylineF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
@@ -169,6 +207,12 @@ var (
 				return err
 			}
 		}
+		if c.coordScale != 0 {
+			c.scalePoints('L')
+		}
+		if err = c.checkRange(); err != nil {
+			return err
+		}
 		if len(c.points) > 4 {
 			c.Path.Start(fixed.Point26_6{
 				X: fixed.Int26_6((c.points[0]) * 64),
@@ -202,13 +246,21 @@ var (
 		return nil
 	}
 	descF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+		if c.lowMemory {
+			return nil
+		}
 		c.inDescText = true
 		c.icon.Descriptions = append(c.icon.Descriptions, "")
+		c.textID, c.textOwned = c.textOwner()
 		return nil
 	}
 	titleF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+		if c.lowMemory {
+			return nil
+		}
 		c.inTitleText = true
 		c.icon.Titles = append(c.icon.Titles, "")
+		c.textID, c.textOwned = c.textOwner()
 		return nil
 	}
 	defsF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
@@ -220,16 +272,20 @@ var (
 		return nil
 	}
 	linearGradientF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
-		var err error
+		ref, err := c.gradientRef(attrs)
+		if err != nil {
+			return err
+		}
 		c.inGrad = true
-		c.grad = &rasterx.Gradient{Points: [5]float64{0, 0, 1, 0, 0},
-			IsRadial: false, Bounds: c.icon.ViewBox, Matrix: rasterx.Identity}
+		c.grad = c.arena.newGradient(c.inheritGradient(rasterx.Gradient{Points: [5]float64{0, 0, 1, 0, 0},
+			IsRadial: false, Bounds: c.icon.ViewBox, Matrix: rasterx.Identity}, ref))
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "id":
 				id := attr.Value
 				if len(id) >= 0 {
 					c.icon.Grads[id] = c.grad
+					c.icon.gradSources = setSource(c.icon.gradSources, id, c.pos)
 				} else {
 					return errZeroLengthID
 				}
@@ -251,17 +307,23 @@ var (
 		return nil
 	}
 	radialGradientF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+		ref, err := c.gradientRef(attrs)
+		if err != nil {
+			return err
+		}
 		c.inGrad = true
-		c.grad = &rasterx.Gradient{Points: [5]float64{0.5, 0.5, 0.5, 0.5, 0.5},
-			IsRadial: true, Bounds: c.icon.ViewBox, Matrix: rasterx.Identity}
-		var setFx, setFy bool
-		var err error
+		c.grad = c.arena.newGradient(c.inheritGradient(rasterx.Gradient{Points: [5]float64{0.5, 0.5, 0.5, 0.5, 0.5},
+			IsRadial: true, Bounds: c.icon.ViewBox, Matrix: rasterx.Identity}, ref))
+		// A focus the referenced gradient sets is inherited, one at its center follows cx and cy
+		setFx := ref != nil && ref.IsRadial && ref.Points[2] != ref.Points[0]
+		setFy := ref != nil && ref.IsRadial && ref.Points[3] != ref.Points[1]
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "id":
 				id := attr.Value
 				if len(id) >= 0 {
 					c.icon.Grads[id] = c.grad
+					c.icon.gradSources = setSource(c.icon.gradSources, id, c.pos)
 				} else {
 					return errZeroLengthID
 				}
@@ -301,8 +363,11 @@ var (
 				case "offset":
 					stop.Offset, err = readFraction(attr.Value)
 				case "stop-color":
-					//todo: add current color inherit
-					stop.StopColor, err = ParseSVGColor(attr.Value)
+					if strings.EqualFold(strings.TrimSpace(attr.Value), "currentColor") {
+						stop.StopColor = c.StyleStack[len(c.StyleStack)-1].currentColor
+						break
+					}
+					stop.StopColor, err = c.readColor(attr.Value)
 				case "stop-opacity":
 					stop.Opacity, err = parseFloat(attr.Value, 64)
 				}
@@ -316,18 +381,22 @@ var (
 	}
 	useF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
 		var (
-			href string
-			x, y float64
-			err  error
+			href       string
+			x, y, w, h float64
+			err        error
 		)
 		for _, attr := range attrs {
 			switch attr.Name.Local {
 			case "href":
 				href = attr.Value
 			case "x":
-				x, err = parseFloat(attr.Value, 64)
+				x, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
 			case "y":
-				y, err = parseFloat(attr.Value, 64)
+				y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+			case "width":
+				w, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+			case "height":
+				h, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
 			}
 			if err != nil {
 				return err
@@ -342,48 +411,219 @@ var (
 		if !strings.HasPrefix(href, "#") {
 			return errors.New("only the ID CSS selector is supported")
 		}
-		defs, ok := c.icon.Defs[href[1:]]
-		if !ok {
-			return errors.New("href ID in use statement was not found in saved defs")
+		return c.drawUse(href, w, h)
+	}
+)
+
+// drawUse draws the element with the id in href for a use element of width w
+// and height h. An element not read yet is drawn at the end of the document.
+func (c *IconCursor) drawUse(href string, w, h float64) error {
+	defs, ok := c.icon.Defs[href[1:]]
+	if !ok && c.late.deferring {
+		c.deferDraw("error during processing svg element use", func() error { return c.drawUse(href, w, h) })
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: href %s in use statement is not in saved defs", errMissingRef, href)
+	}
+	for _, u := range c.uses {
+		if u == href {
+			return fmt.Errorf("use of %s references itself", href)
 		}
-		for _, def := range defs {
-			if def.Tag == "endg" {
-				// pop style
-				c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
-				continue
-			}
-			if err = c.PushStyle(def.Attrs); err != nil {
+	}
+	c.uses = append(c.uses, href)
+	depth := len(c.StyleStack)
+	defer func() { c.uses, c.StyleStack = c.uses[:len(c.uses)-1], c.StyleStack[:depth] }()
+	return c.instantiate(defs, w, h)
+}
+
+// instantiate draws the saved definitions of an element for a use element, or
+// for a clipPath, mask or pattern. Symbols map their viewBox to w by h, see symbolViewBox.
+func (c *IconCursor) instantiate(defs []definition, w, h float64) error {
+	for i := 0; i < len(defs); i++ {
+		def := defs[i]
+		if c.instances++; c.instances > c.maxInstances {
+			return fmt.Errorf("%w: more than %d", errTooManyInst, c.maxInstances)
+		}
+		if def.Tag == "defs" || (i > 0 && drawnByReference(def.Tag)) {
+			// Nested defs, symbols, clip paths, masks, patterns and markers are
+			// not drawn with the element they are in
+			i = endOfDef(defs, i)
+			continue
+		}
+		if def.Tag == "endg" {
+			// pop style
+			c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
+			continue
+		}
+		if err := c.pushStyle(def.Tag, def.Attrs); err != nil {
+			return err
+		}
+		switch def.Tag {
+		case "symbol":
+			if err := c.symbolViewBox(def.Attrs, w, h); err != nil {
 				return err
 			}
-			df, ok := drawFuncs[def.Tag]
-			if !ok {
-				errStr := "Cannot process svg element " + def.Tag
-				if c.ErrorMode == StrictErrorMode {
-					return errors.New(errStr)
-				} else if c.ErrorMode == WarnErrorMode {
-					log.Println(errStr)
-				}
-				return nil
+			continue // the style is popped at the matching endg
+		case "svg":
+			vw, vh := w, h
+			if i > 0 { // only the referenced svg takes the size of the use element
+				vw, vh = 0, 0
+			}
+			if err := c.svgViewport(def.Attrs, vw, vh); err != nil {
+				return err
 			}
-			if err := df(c, def.Attrs); err != nil {
+			continue
+		case "clipPath", "mask", "pattern", "marker":
+			continue
+		}
+		df, ok := drawFuncs[def.Tag]
+		if !ok {
+			return c.report(c.ErrorPolicy.UnknownElement, errors.New("Cannot process svg element "+def.Tag))
+		}
+		if err := df(c, def.Attrs); err != nil {
+			return err
+		}
+		//Did c.Path get added to during the drawFunction call iteration?
+		if len(c.Path) > 0 {
+			//The cursor parsed a path from the xml element
+			c.addPath(c.pathStyle())
+			err := c.drawMarkers(def.Tag)
+			c.Path = c.Path[:0]
+			if err != nil {
 				return err
 			}
-			//Did c.Path get added to during the drawFunction call iteration?
-			if len(c.Path) > 0 {
-				//The cursor parsed a path from the xml element
-				pathCopy := make(rasterx.Path, len(c.Path))
-				copy(pathCopy, c.Path)
-				c.icon.SVGPaths = append(c.icon.SVGPaths, SvgPath{c.StyleStack[len(c.StyleStack)-1], pathCopy})
-				c.Path = c.Path[:0]
+		}
+		if def.Tag != "g" {
+			// pop style
+			c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
+		}
+	}
+	return nil
+}
+
+// endOfDef returns the index in defs of the endg definition closing the
+// container element defs[i].
+func endOfDef(defs []definition, i int) int {
+	depth := 0
+	for ; i < len(defs); i++ {
+		switch {
+		case defs[i].Tag == "endg":
+			depth--
+		case closedByEndg(defs[i].Tag):
+			depth++
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	return i
+}
+
+// symbolViewBox maps the viewBox of a symbol with attrs, if it has one, to the
+// w by h viewport of the use element instantiating it. A zero w or h is taken
+// from the symbol or else is the size of the icon.
+func (c *IconCursor) symbolViewBox(attrs []xml.Attr, w, h float64) error {
+	var (
+		vb     ViewBox
+		align  = "xMidYMid"
+		slice  bool
+		sw, sh float64
+		err    error
+	)
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "viewBox":
+			if err = c.GetPoints(attr.Value); err == nil && len(c.points) != 4 {
+				err = errParamMismatch
+			}
+			if err == nil {
+				vb = ViewBox{c.points[0], c.points[1], c.points[2], c.points[3]}
 			}
-			if def.Tag != "g" {
-				// pop style
-				c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
+		case "preserveAspectRatio":
+			fields := strings.Fields(attr.Value)
+			if len(fields) > 0 {
+				align = fields[0]
 			}
+			slice = len(fields) > 1 && fields[1] == "slice"
+		case "width":
+			sw, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "height":
+			sh, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		}
+		if err != nil {
+			return err
 		}
+	}
+	if vb.W <= 0 || vb.H <= 0 {
 		return nil
 	}
-)
+	if w == 0 {
+		w = sw
+		if w == 0 {
+			w = c.icon.ViewBox.W
+		}
+	}
+	if h == 0 {
+		h = sh
+		if h == 0 {
+			h = c.icon.ViewBox.H
+		}
+	}
+	style := &c.StyleStack[len(c.StyleStack)-1]
+	style.mAdder.M = style.mAdder.M.Mult(viewBoxTransform(vb, align, slice, w, h))
+	return nil
+}
+
+// svgViewport maps the viewBox of a nested svg element with attrs to its
+// viewport at x, y, as symbolViewBox does for symbols. Unlike in browsers, the
+// content of the viewport is not clipped to it.
+func (c *IconCursor) svgViewport(attrs []xml.Attr, w, h float64) error {
+	var x, y float64
+	var err error
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "x":
+			x, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "y":
+			y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	style := &c.StyleStack[len(c.StyleStack)-1]
+	style.mAdder.M = style.mAdder.M.Translate(x, y)
+	return c.symbolViewBox(attrs, w, h)
+}
+
+// viewBoxTransform returns the transform mapping the viewBox vb onto a w by h
+// viewport with the preserveAspectRatio alignment align, covering the viewport
+// rather than fitting it if slice is true.
+func viewBoxTransform(vb ViewBox, align string, slice bool, w, h float64) rasterx.Matrix2D {
+	sx, sy := w/vb.W, h/vb.H
+	if align != "none" {
+		if (sx < sy) != slice {
+			sy = sx
+		} else {
+			sx = sy
+		}
+	}
+	tx, ty := -vb.X*sx, -vb.Y*sy
+	switch {
+	case strings.HasPrefix(align, "xMid"):
+		tx += (w - vb.W*sx) / 2
+	case strings.HasPrefix(align, "xMax"):
+		tx += w - vb.W*sx
+	}
+	switch {
+	case strings.HasSuffix(align, "YMid"):
+		ty += (h - vb.H*sy) / 2
+	case strings.HasSuffix(align, "YMax"):
+		ty += h - vb.H*sy
+	}
+	return rasterx.Matrix2D{A: sx, D: sy, E: tx, F: ty}
+}
 
 func init() {
 	// avoids cyclical static declaration
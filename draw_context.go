@@ -0,0 +1,71 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// draw_context.go implements reusable rasterization state for drawing icons.
+
+package oksvg
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"sync"
+
+	"github.com/srwiley/rasterx"
+)
+
+var errContextTooSmall = errors.New("destination exceeds draw context size")
+
+// DrawContext owns the scanner and dasher needed to rasterize icons, so that
+// any icon can be drawn repeatedly into destinations up to its maximum size
+// without allocating new rasterization buffers for each draw.
+// A DrawContext must not be used by more than one goroutine at a time.
+type DrawContext struct {
+	maxW, maxH int
+	scanner    *rasterx.ScannerGV
+	raster     *rasterx.Dasher
+}
+
+var drawContextPool sync.Pool
+
+// NewDrawContext returns a DrawContext for destinations of at most maxW by maxH pixels.
+func NewDrawContext(maxW, maxH int) *DrawContext {
+	dc := &DrawContext{maxW: maxW, maxH: maxH}
+	dc.scanner = rasterx.NewScannerGV(maxW, maxH, nil, image.Rect(0, 0, maxW, maxH))
+	dc.raster = rasterx.NewDasher(maxW, maxH, dc.scanner)
+	return dc
+}
+
+// AcquireDrawContext returns a DrawContext from a shared pool that can draw into
+// destinations of at least w by h pixels. Call Release when done with it.
+func AcquireDrawContext(w, h int) *DrawContext {
+	if dc, ok := drawContextPool.Get().(*DrawContext); ok && dc.maxW >= w && dc.maxH >= h {
+		return dc
+	}
+	return NewDrawContext(w, h)
+}
+
+// Release returns the DrawContext to the shared pool. The DrawContext must not be
+// used after it is released.
+func (dc *DrawContext) Release() {
+	dc.scanner.Dest = nil
+	drawContextPool.Put(dc)
+}
+
+// Draw draws the icon into dst, whose size must not exceed the size of the DrawContext.
+// The Transform of the icon maps to the coordinates of dst.
+func (dc *DrawContext) Draw(icon *SvgIcon, dst draw.Image, opacity float64) error {
+	rect := dst.Bounds()
+	w, h := rect.Dx(), rect.Dy()
+	if w > dc.maxW || h > dc.maxH {
+		return errContextTooSmall
+	}
+	dc.scanner.Dest = dst
+	dc.scanner.Targ = rect
+	dc.raster.SetBounds(w, h)
+	t := rasterx.Identity.Translate(-float64(rect.Min.X), -float64(rect.Min.Y)).Mult(icon.Transform)
+	tb := icon.budget()
+	for _, svgp := range icon.SVGPaths {
+		icon.drawPath(&svgp, dc.raster, opacity, t, tb, icon.Quirks)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// eink.go implements change tracking and gray quantization for e-ink displays.
+
+package oksvg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// ChangeTracker records the device bounds of the paths of an icon when it is
+// updated, so that a display that supports partial refresh only needs to refresh
+// the area affected by the paths that changed since the previous update.
+// Paths are matched by their index in SVGPaths.
+type ChangeTracker struct {
+	bounds    image.Rectangle
+	transform rasterx.Matrix2D
+	paths     []trackedPath
+	raster    *rasterx.Dasher
+	scanner   extentScanner
+}
+
+type trackedPath struct {
+	sum    uint64 // fingerprint of the path and its style
+	bounds image.Rectangle
+}
+
+// NewChangeTracker returns a ChangeTracker for an icon drawn into an image of
+// width w and height h.
+func NewChangeTracker(w, h int) *ChangeTracker {
+	ct := &ChangeTracker{bounds: image.Rect(0, 0, w, h)}
+	ct.raster = rasterx.NewDasher(w, h, &ct.scanner)
+	return ct
+}
+
+// Update records the paths of the icon, drawn with its Transform, and returns the
+// rectangle that must be redrawn to bring the previous rendering up to date: the
+// union of the old and new bounds of the paths that were added, removed or changed.
+// The first Update returns the whole image, as nothing was drawn before it.
+func (ct *ChangeTracker) Update(icon *SvgIcon) image.Rectangle {
+	first := ct.paths == nil
+	changed := image.Rectangle{}
+	if icon.Transform != ct.transform {
+		changed = ct.bounds // every path moves
+	}
+	paths := make([]trackedPath, len(icon.SVGPaths))
+	for i := range icon.SVGPaths {
+		svgp := &icon.SVGPaths[i]
+		tp := trackedPath{sum: fingerprint(svgp)}
+		if i < len(ct.paths) && ct.transform == icon.Transform && ct.paths[i].sum == tp.sum {
+			tp.bounds = ct.paths[i].bounds
+		} else {
+			tp.bounds = ct.pathBounds(svgp, icon)
+			changed = changed.Union(tp.bounds)
+			if i < len(ct.paths) {
+				changed = changed.Union(ct.paths[i].bounds)
+			}
+		}
+		paths[i] = tp
+	}
+	for i := len(paths); i < len(ct.paths); i++ {
+		changed = changed.Union(ct.paths[i].bounds)
+	}
+	ct.paths, ct.transform = paths, icon.Transform
+	if first {
+		return ct.bounds
+	}
+	return changed.Intersect(ct.bounds)
+}
+
+// PathBounds returns the device bounds of path i of the icon as of the last Update.
+func (ct *ChangeTracker) PathBounds(i int) image.Rectangle {
+	return ct.paths[i].bounds
+}
+
+// pathBounds returns the device bounds of the fill and stroke of svgp.
+func (ct *ChangeTracker) pathBounds(svgp *SvgPath, icon *SvgIcon) image.Rectangle {
+	ct.scanner.drawn = image.Rectangle{}
+	svgp.drawBudgeted(ct.raster, 1, icon.Transform, svgp.StrokeStyle(), icon.budget())
+	return ct.scanner.drawn.Intersect(ct.bounds)
+}
+
+// fingerprint returns a hash of the path data and style of svgp.
+func fingerprint(svgp *SvgPath) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, svgp.Path)
+	binary.Write(h, binary.LittleEndian, svgp.Dash)
+	binary.Write(h, binary.LittleEndian, svgp.mAdder.M)
+	fmt.Fprint(h, svgp.FillOpacity, svgp.LineOpacity, svgp.LineWidth, svgp.DashOffset,
+		svgp.MiterLimit, svgp.UseNonZeroWinding, svgp.ContinueDash, svgp.LineJoin, svgp.opacity)
+	fmt.Fprintf(h, "%p %p %p %v", svgp.LineGap, svgp.LeadLineCap, svgp.LineCap, svgp.clips)
+	for _, paint := range []interface{}{svgp.fillerColor, svgp.linerColor} {
+		switch p := paint.(type) {
+		case imagePaint:
+			fmt.Fprintf(h, "%p %v %v", p.img, p.rect, p.sampling) // images are not changed in place
+		case *Pattern:
+			fmt.Fprintf(h, "%p", p) // nor are patterns
+		default:
+			fmt.Fprintf(h, "%#v", paint)
+		}
+	}
+	return h.Sum64()
+}
+
+// extentScanner is a rasterx Scanner that only records the pixel bounds of what
+// would be drawn.
+type extentScanner struct {
+	minX, minY, maxX, maxY fixed.Int26_6
+	drawn                  image.Rectangle
+}
+
+func (s *extentScanner) Start(a fixed.Point26_6) { s.set(a) }
+func (s *extentScanner) Line(b fixed.Point26_6)  { s.set(b) }
+
+func (s *extentScanner) set(a fixed.Point26_6) {
+	s.minX, s.minY = minFixed(s.minX, a.X), minFixed(s.minY, a.Y)
+	s.maxX, s.maxY = maxFixed(s.maxX, a.X), maxFixed(s.maxY, a.Y)
+}
+
+// Draw adds the extent of the current path to the drawn rectangle.
+func (s *extentScanner) Draw() {
+	if s.minX <= s.maxX && s.minY <= s.maxY {
+		s.drawn = s.drawn.Union(image.Rect(s.minX.Floor(), s.minY.Floor(), s.maxX.Ceil(), s.maxY.Ceil()))
+	}
+}
+
+// GetPathExtent returns the extent of the path
+func (s *extentScanner) GetPathExtent() fixed.Rectangle26_6 {
+	return fixed.Rectangle26_6{Min: fixed.Point26_6{X: s.minX, Y: s.minY}, Max: fixed.Point26_6{X: s.maxX, Y: s.maxY}}
+}
+
+func (s *extentScanner) SetBounds(w, h int)                {}
+func (s *extentScanner) SetColor(color interface{})        {}
+func (s *extentScanner) SetWinding(useNonZeroWinding bool) {}
+func (s *extentScanner) SetClip(rect image.Rectangle)      {}
+
+// Clear resets the extent of the current path
+func (s *extentScanner) Clear() {
+	s.minX, s.minY = math.MaxInt32, math.MaxInt32
+	s.maxX, s.maxY = math.MinInt32, math.MinInt32
+}
+
+func minFixed(a, b fixed.Int26_6) fixed.Int26_6 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFixed(a, b fixed.Int26_6) fixed.Int26_6 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// QuantizeGray reduces src to the given number of evenly spaced gray levels, such
+// as the 16 levels of most e-ink panels, using the dither mode. Palette index i of
+// the result is the i-th level from black, so the indexes can be sent to the
+// display directly. Levels below 2 are treated as 2.
+func QuantizeGray(src image.Image, levels int, mode DitherMode) *image.Paletted {
+	if levels < 2 {
+		levels = 2
+	}
+	if levels > 256 {
+		levels = 256
+	}
+	pal := make(color.Palette, levels)
+	for i := range pal {
+		pal[i] = color.Gray{uint8(i * 0xFF / (levels - 1))}
+	}
+	b := src.Bounds()
+	dst := image.NewPaletted(b, pal)
+	if mode == DitherFloydSteinberg {
+		draw.FloydSteinberg.Draw(dst, b, src, b.Min)
+		return dst
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y
+			v := float64(g) * float64(levels-1) / 0xFF
+			level := math.Floor(v)
+			t := 0.5
+			if mode == DitherBayer {
+				t = (float64(bayer4[y&3][x&3]) + 0.5) / 16
+			}
+			if v-level >= t {
+				level++
+			}
+			dst.SetColorIndex(x, y, uint8(level))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// even_odd.go implements the evenodd fill rule over nonzero rasterizers.
+
+package oksvg
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// evenOddRows is the number of rows per pixel that evenodd fills are sampled at.
+// It divides 64 so that the rows fall on fixed.Int26_6 values.
+const evenOddRows = 16
+
+// evenOddScanner is a rasterx Scanner that records the flattened edges of a path,
+// so that the interior of the path under the evenodd rule can be added to a
+// nonzero rasterizer, such as the vector rasterizer behind ScannerGV, as rows of
+// rectangles that do not overlap.
+type evenOddScanner struct {
+	edges       []evenOddEdge
+	first, last fixed.Point26_6
+}
+
+// evenOddEdge is an edge of a path from its top at y0 to its bottom at y1, in
+// fixed.Int26_6 units. x is its x at y0 and dxdy its slope.
+type evenOddEdge struct {
+	y0, y1  float64
+	x, dxdy float64
+}
+
+// Start closes the current subpath and starts a new one at a.
+func (s *evenOddScanner) Start(a fixed.Point26_6) {
+	s.closeSubpath()
+	s.first, s.last = a, a
+}
+
+// Line adds an edge from the last point to b.
+func (s *evenOddScanner) Line(b fixed.Point26_6) {
+	a := s.last
+	s.last = b
+	if a.Y == b.Y {
+		return // never crossed by a row
+	}
+	if a.Y > b.Y {
+		a, b = b, a
+	}
+	dxdy := float64(b.X-a.X) / float64(b.Y-a.Y)
+	s.edges = append(s.edges, evenOddEdge{y0: float64(a.Y), y1: float64(b.Y), x: float64(a.X), dxdy: dxdy})
+}
+
+// closeSubpath adds the edge back to the start of the subpath, as filling does.
+func (s *evenOddScanner) closeSubpath() {
+	if s.last != s.first {
+		s.Line(s.first)
+	}
+}
+
+// Draw is a no-op, the recorded path is drawn by fillTo.
+func (s *evenOddScanner) Draw() {}
+
+// GetPathExtent is not used, the extent is that of the rectangles drawn by fillTo.
+func (s *evenOddScanner) GetPathExtent() fixed.Rectangle26_6 { return fixed.Rectangle26_6{} }
+
+func (s *evenOddScanner) SetBounds(w, h int)                {}
+func (s *evenOddScanner) SetColor(color interface{})        {}
+func (s *evenOddScanner) SetWinding(useNonZeroWinding bool) {}
+func (s *evenOddScanner) SetClip(rect image.Rectangle)      {}
+
+// Clear drops the recorded path
+func (s *evenOddScanner) Clear() {
+	s.edges = s.edges[:0]
+	s.first, s.last = fixed.Point26_6{}, fixed.Point26_6{}
+}
+
+// fillTo adds the evenodd interior of the recorded path to a as rectangles. Each
+// row is sampled at its middle, and runs of rows crossed at the same points are
+// joined into a single rectangle.
+func (s *evenOddScanner) fillTo(a rasterx.Adder) {
+	s.closeSubpath()
+	if len(s.edges) == 0 {
+		return
+	}
+	sort.Slice(s.edges, func(i, j int) bool { return s.edges[i].y0 < s.edges[j].y0 })
+	ymax := s.edges[0].y1
+	for _, e := range s.edges {
+		ymax = math.Max(ymax, e.y1)
+	}
+	const step = 64 / evenOddRows
+	var active []evenOddEdge
+	var xs, spans []float64
+	var spansTop fixed.Int26_6
+	flush := func(bottom fixed.Int26_6) {
+		for i := 0; i < len(spans); i += 2 {
+			x0, x1 := fixed.Int26_6(math.Round(spans[i])), fixed.Int26_6(math.Round(spans[i+1]))
+			if x0 == x1 {
+				continue
+			}
+			a.Start(fixed.Point26_6{X: x0, Y: spansTop})
+			a.Line(fixed.Point26_6{X: x1, Y: spansTop})
+			a.Line(fixed.Point26_6{X: x1, Y: bottom})
+			a.Line(fixed.Point26_6{X: x0, Y: bottom})
+			a.Stop(true)
+		}
+	}
+	next := 0
+	y := fixed.Int26_6(math.Floor(s.edges[0].y0/step) * step)
+	for ; float64(y) < ymax; y += step {
+		yc := float64(y) + step/2
+		for next < len(s.edges) && s.edges[next].y0 <= yc {
+			active = append(active, s.edges[next])
+			next++
+		}
+		xs = xs[:0]
+		kept := active[:0]
+		for _, e := range active {
+			if e.y1 > yc {
+				kept = append(kept, e)
+				xs = append(xs, e.x+(yc-e.y0)*e.dxdy)
+			}
+		}
+		active = kept
+		sort.Float64s(xs)
+		xs = xs[:len(xs)&^1]
+		if !sameSpans(xs, spans) {
+			flush(y)
+			spans, spansTop = append(spans[:0], xs...), y
+		}
+	}
+	flush(y)
+}
+
+// sameSpans reports whether the crossings a and b are within half a fixed.Int26_6
+// unit of each other.
+func sameSpans(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) >= 0.5 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// foreign_object.go implements placeholder rendering of foreignObject elements.
+
+package oksvg
+
+import (
+	"encoding/xml"
+	"image"
+	"image/color"
+
+	"github.com/srwiley/rasterx"
+)
+
+// ForeignObjectRenderer renders the content of a foreignObject element, such as
+// HTML, with an engine of the application's choosing. It receives the raw XML of
+// the element, including its own start and end tags, and the rectangle it occupies
+// in user units. The returned image is stretched over that rectangle and drawn in
+// place of the element; a nil image draws nothing.
+type ForeignObjectRenderer func(raw []byte, rect ViewBox) (image.Image, error)
+
+// foreignObjectRenderer is set by SetForeignObjectRenderer.
+var foreignObjectRenderer ForeignObjectRenderer
+
+// SetForeignObjectRenderer sets fn as the renderer for foreignObject elements in icons read
+// afterwards. A nil fn restores the default, in which foreignObject elements are handled
+// as unrecognized elements unless a handler is registered with RegisterElementHandler.
+// Like element handlers, the renderer is not guarded against concurrent use.
+func SetForeignObjectRenderer(fn ForeignObjectRenderer) {
+	foreignObjectRenderer = fn
+}
+
+// readForeignObject renders the foreignObject element se, with raw XML raw,
+// and adds the result to the icon as an image painted rectangle.
+func (c *IconCursor) readForeignObject(se xml.StartElement, raw []byte) error {
+	var rect ViewBox
+	var err error
+	for _, attr := range se.Attr {
+		switch attr.Name.Local {
+		case "x":
+			rect.X, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "y":
+			rect.Y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		case "width":
+			rect.W, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "height":
+			rect.H, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if rect.W <= 0 || rect.H <= 0 {
+		return nil
+	}
+	img, err := foreignObjectRenderer(raw, rect)
+	if err != nil || img == nil || img.Bounds().Empty() {
+		return err
+	}
+	return c.addImage(img, rect, rect)
+}
+
+// addImage adds a path filling the rectangle viewport, in user units, with img
+// stretched over the rectangle rect.
+func (c *IconCursor) addImage(img image.Image, viewport, rect ViewBox) error {
+	viewport = ViewBox{c.scaled(viewport.X), c.scaled(viewport.Y), c.scaled(viewport.W), c.scaled(viewport.H)}
+	rect = ViewBox{c.scaled(rect.X), c.scaled(rect.Y), c.scaled(rect.W), c.scaled(rect.H)}
+	c.Path.Clear()
+	c.RoundRect(viewport.X, viewport.Y, viewport.W, viewport.H, 0, 0)
+	if err := c.checkRange(); err != nil {
+		c.Path.Clear()
+		return err
+	}
+	style := c.pathStyle()
+	sampling := c.sampling
+	if style.pixelated {
+		sampling = NearestSampling
+	}
+	style.fillerColor, style.linerColor = imagePaint{img, rect, sampling}, nil
+	c.addPath(style)
+	c.Path.Clear()
+	return nil
+}
+
+// imagePaint paints an image stretched over a rectangle in path coordinates,
+// interpolated with sampling.
+type imagePaint struct {
+	img      image.Image
+	rect     ViewBox
+	sampling ImageSampling
+}
+
+// colorFunction returns the rasterx.ColorFunc painting the image for a path drawn
+// with transform m.
+func (p imagePaint) colorFunction(m rasterx.Matrix2D, opacity float64) rasterx.ColorFunc {
+	inv := m.Invert()
+	b := p.img.Bounds()
+	sx, sy := float64(b.Dx())/p.rect.W, float64(b.Dy())/p.rect.H
+	return func(x, y int) color.Color {
+		ux, uy := inv.Transform(float64(x)+0.5, float64(y)+0.5)
+		ix, iy := (ux-p.rect.X)*sx, (uy-p.rect.Y)*sy
+		if ix < 0 || iy < 0 || ix >= float64(b.Dx()) || iy >= float64(b.Dy()) {
+			return color.RGBA64{}
+		}
+		c := sampleImage(p.img, ix, iy, p.sampling)
+		scale := func(v uint16) uint16 { return uint16(float64(v) * opacity) }
+		return color.RGBA64{scale(c.R), scale(c.G), scale(c.B), scale(c.A)}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// geometry.go implements transformations of the path data of icons.
+
+package oksvg
+
+import (
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// BakeTransforms applies the transform of each path, the transforms of the
+// elements it was drawn in, to the coordinates of its Path and resets the transform
+// to the identity, so that the Path data is in the user space of the ViewBox and
+// drawing no longer multiplies each point by a matrix. Gradients in userSpaceOnUse
+// units are transformed along with their paths. Stroke widths are drawn in the
+// units of the device and do not change.
+//
+// Paths painted with a pattern or an image, which are laid out in the space of
+// the path, and paths whose coordinates would overflow once transformed, keep
+// their transform. BakeTransforms returns the number of such paths.
+func (s *SvgIcon) BakeTransforms() (kept int) {
+	for i := range s.SVGPaths {
+		svgp := &s.SVGPaths[i]
+		m := svgp.mAdder.M
+		if m == rasterx.Identity {
+			continue
+		}
+		_, fillPattern := svgp.fillerColor.(*Pattern)
+		_, fillImage := svgp.fillerColor.(imagePaint)
+		_, linePattern := svgp.linerColor.(*Pattern)
+		path, ok := transformPath(svgp.Path, m)
+		if fillPattern || fillImage || linePattern || !ok {
+			kept++
+			continue
+		}
+		svgp.Path = path
+		svgp.fillerColor = bakeGradient(svgp.fillerColor, m)
+		svgp.linerColor = bakeGradient(svgp.linerColor, m)
+		svgp.mAdder.M = rasterx.Identity
+	}
+	return kept
+}
+
+// bakeGradient returns the paint p, with m applied to its matrix if it is a
+// gradient in userSpaceOnUse units.
+func bakeGradient(p interface{}, m rasterx.Matrix2D) interface{} {
+	if g, ok := p.(rasterx.Gradient); ok && g.Units == rasterx.UserSpaceOnUse {
+		g.Matrix = m.Mult(g.Matrix)
+		return g
+	}
+	return p
+}
+
+// transformPath returns a copy of path with its points transformed by m. It
+// returns false if a transformed point cannot be represented in fixed point.
+func transformPath(path rasterx.Path, m rasterx.Matrix2D) (rasterx.Path, bool) {
+	out := make(rasterx.Path, len(path))
+	for i := 0; i < len(path); {
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			n = 1
+		case rasterx.PathQuadTo:
+			n = 2
+		case rasterx.PathCubicTo:
+			n = 3
+		}
+		out[i] = path[i]
+		for j := 0; j < n; j++ {
+			k := i + 1 + 2*j
+			x, y := m.Transform(float64(path[k])/64, float64(path[k+1])/64)
+			if !(math.Abs(x) <= maxCoord && math.Abs(y) <= maxCoord) {
+				return nil, false
+			}
+			out[k], out[k+1] = fixed.Int26_6(math.Round(x*64)), fixed.Int26_6(math.Round(y*64))
+		}
+		i += 1 + 2*n
+	}
+	return out, true
+}
+
+// NormalizeToViewBox maps the ViewBox of the icon onto the rectangle from 0,0 to
+// w,h, such as 1,1 for a unit square or the width and height of the ViewBox to
+// move its origin to 0,0, so that icons from different sources share a
+// coordinate space. The transforms of the paths, and of their clip paths and
+// masks, are changed rather than the Path data, which would lose precision in
+// fixed point at small scales. The Transform of the icon is adjusted so that
+// the icon draws as before.
+func (s *SvgIcon) NormalizeToViewBox(w, h float64) {
+	vb := s.ViewBox
+	if !(vb.W > 0 && vb.H > 0 && w > 0 && h > 0) {
+		return
+	}
+	m := rasterx.Identity.Scale(w/vb.W, h/vb.H).Translate(-vb.X, -vb.Y)
+	seen := make(map[*clipPath]bool)
+	for i := range s.SVGPaths {
+		prependMatrix(&s.SVGPaths[i], m, seen)
+	}
+	s.ViewBox = ViewBox{0, 0, w, h}
+	s.Transform = s.Transform.Mult(m.Invert())
+}
+
+// prependMatrix applies m after the transform of svgp and of the paths of its
+// clips in user space, visiting each clip once.
+func prependMatrix(svgp *SvgPath, m rasterx.Matrix2D, seen map[*clipPath]bool) {
+	svgp.mAdder.M = m.Mult(svgp.mAdder.M)
+	for _, cp := range svgp.clips {
+		if seen[cp] || cp.bbox {
+			continue
+		}
+		seen[cp] = true
+		for i := range cp.paths {
+			prependMatrix(&cp.paths[i], m, seen)
+		}
+	}
+}
+
+// pathExtent returns the rectangle bounding the points of path.
+func pathExtent(path rasterx.Path) ViewBox {
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i := 0; i < len(path); {
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			n = 1
+		case rasterx.PathQuadTo:
+			n = 2
+		case rasterx.PathCubicTo:
+			n = 3
+		}
+		for j := 0; j < n; j++ {
+			x, y := float64(path[i+1+2*j])/64, float64(path[i+2+2*j])/64
+			x0, y0, x1, y1 = math.Min(x0, x), math.Min(y0, y), math.Max(x1, x), math.Max(y1, y)
+		}
+		i += 1 + 2*n
+	}
+	if x0 > x1 {
+		return ViewBox{}
+	}
+	return ViewBox{x0, y0, x1 - x0, y1 - y0}
+}
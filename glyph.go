@@ -0,0 +1,114 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// glyph.go implements monochrome glyph rendering of icons for bitmap fonts.
+
+package oksvg
+
+import (
+	"image"
+	"math"
+
+	"github.com/srwiley/rasterx"
+)
+
+// GlyphOptions configures RasterizeGlyph.
+type GlyphOptions struct {
+	Size      int   // em height in pixels, which the height of the viewBox is scaled to
+	Descent   int   // pixels of the em below the baseline
+	Threshold uint8 // coverage at or above which a pixel is set; zero means 0x80
+}
+
+// GlyphBitmap is a monochrome rendering of an icon with the metrics needed to use
+// it as a glyph of a bitmap font, such as BDF or PCF.
+type GlyphBitmap struct {
+	// Image holds the set pixels as 0xFF and the others as 0. Its bounds are the
+	// ink box of the glyph, relative to the origin of the glyph on the baseline,
+	// with y growing downwards.
+	Image *image.Alpha
+	// Advance is the horizontal distance in pixels to the origin of the next glyph.
+	Advance int
+}
+
+// RasterizeGlyph renders the icon scaled uniformly to the em size of opts and
+// thresholds the result into a GlyphBitmap, so all edges are hard.
+// The Transform of the icon is ignored.
+func RasterizeGlyph(icon *SvgIcon, opts GlyphOptions) *GlyphBitmap {
+	vb := icon.ViewBox
+	if vb.W <= 0 || vb.H <= 0 || opts.Size <= 0 {
+		return &GlyphBitmap{Image: image.NewAlpha(image.Rectangle{})}
+	}
+	scale := float64(opts.Size) / vb.H
+	w, h := int(math.Ceil(vb.W*scale)), opts.Size
+	baseline := h - opts.Descent
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = 0x80
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	t := icon.Transform
+	icon.Transform = vb.TransformTo(ViewBox{W: vb.W * scale, H: float64(h)})
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+	icon.Transform = t
+
+	ink := image.Rectangle{Min: image.Pt(w, h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] >= threshold {
+				ink.Min.X, ink.Max.X = minInt(ink.Min.X, x), maxInt(ink.Max.X, x+1)
+				ink.Min.Y, ink.Max.Y = minInt(ink.Min.Y, y), maxInt(ink.Max.Y, y+1)
+			}
+		}
+	}
+	if ink.Empty() {
+		return &GlyphBitmap{Image: image.NewAlpha(image.Rectangle{}), Advance: w}
+	}
+	bm := image.NewAlpha(ink.Sub(image.Pt(0, baseline)))
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] >= threshold {
+				bm.Pix[bm.PixOffset(x, y-baseline)] = 0xFF
+			}
+		}
+	}
+	return &GlyphBitmap{Image: bm, Advance: w}
+}
+
+// BDFBox returns the glyph bounding box as given by the BBX line of a BDF font:
+// the width and height of the ink box, and the offset of its lower left corner
+// from the origin, with y growing upwards.
+func (g *GlyphBitmap) BDFBox() (w, h, xoff, yoff int) {
+	b := g.Image.Bounds()
+	return b.Dx(), b.Dy(), b.Min.X, -b.Max.Y
+}
+
+// Rows returns the pixels of the glyph packed into bytes, one slice per row from
+// the top, with the leftmost pixel in the most significant bit. This is the order
+// used by the BITMAP section of a BDF font.
+func (g *GlyphBitmap) Rows() [][]byte {
+	b := g.Image.Bounds()
+	rows := make([][]byte, b.Dy())
+	for y := range rows {
+		row := make([]byte, (b.Dx()+7)/8)
+		for x := 0; x < b.Dx(); x++ {
+			if g.Image.Pix[g.Image.PixOffset(b.Min.X+x, b.Min.Y+y)] != 0 {
+				row[x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+		rows[y] = row
+	}
+	return rows
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// glyph_outline.go implements export of icon geometry as TrueType style outlines.
+
+package oksvg
+
+import (
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// OutlinePoint is a point of a TrueType style contour, in font units with y
+// growing upwards. OnCurve is false for the control points of quadratic curves.
+type OutlinePoint struct {
+	X, Y    float64
+	OnCurve bool
+}
+
+// Contour is an implicitly closed sequence of OutlinePoints starting on the curve.
+type Contour []OutlinePoint
+
+// OutlineOptions configures GlyphOutline.
+type OutlineOptions struct {
+	UnitsPerEm float64 // font units the height of the viewBox is scaled to
+	Descent    float64 // font units of the em below the baseline
+	// Tolerance is the largest distance in font units allowed between a cubic
+	// curve and the quadratic curves replacing it; zero means one font unit.
+	Tolerance float64
+}
+
+// GlyphOutline converts the filled paths of the icon into TrueType style quadratic
+// contours, so an icon set can be compiled into an icon font. Cubic curves are
+// approximated by quadratic ones within the tolerance of opts. The origin of the
+// glyph is at the left edge of the viewBox, on the baseline. Contours keep the
+// direction of the SVG paths and overlapping contours are not merged, so icons are
+// expected to use the nonzero fill rule with outer contours drawn clockwise.
+// Strokes and the Transform of the icon are ignored.
+func GlyphOutline(icon *SvgIcon, opts OutlineOptions) []Contour {
+	vb := icon.ViewBox
+	if vb.H <= 0 {
+		return nil
+	}
+	s := opts.UnitsPerEm / vb.H
+	fontM := rasterx.Identity.Translate(0, opts.UnitsPerEm-opts.Descent).Scale(s, -s).Translate(-vb.X, -vb.Y)
+	ca := &contourAdder{tolerance: opts.Tolerance}
+	if ca.tolerance <= 0 {
+		ca.tolerance = 1
+	}
+	for _, svgp := range icon.SVGPaths {
+		if svgp.fillerColor == nil {
+			continue
+		}
+		ca.M = fontM.Mult(svgp.mAdder.M)
+		svgp.Path.AddTo(ca)
+		ca.end()
+	}
+	return ca.contours
+}
+
+// contourAdder collects path commands, transformed by M without rounding to
+// fixed point, into quadratic contours.
+type contourAdder struct {
+	M         rasterx.Matrix2D
+	contours  []Contour
+	cur       Contour
+	x, y      float64 // current point
+	tolerance float64
+}
+
+func (ca *contourAdder) t(p fixed.Point26_6) (float64, float64) {
+	return ca.M.Transform(float64(p.X)/64, float64(p.Y)/64)
+}
+
+// Start starts a new contour at a
+func (ca *contourAdder) Start(a fixed.Point26_6) {
+	ca.end()
+	ca.x, ca.y = ca.t(a)
+	ca.cur = append(ca.cur, OutlinePoint{ca.x, ca.y, true})
+}
+
+// Line adds a line to b
+func (ca *contourAdder) Line(b fixed.Point26_6) {
+	ca.x, ca.y = ca.t(b)
+	ca.cur = append(ca.cur, OutlinePoint{ca.x, ca.y, true})
+}
+
+// QuadBezier adds a quadratic curve with control point b ending at c
+func (ca *contourAdder) QuadBezier(b, c fixed.Point26_6) {
+	bx, by := ca.t(b)
+	cx, cy := ca.t(c)
+	ca.quad(bx, by, cx, cy)
+}
+
+// CubeBezier adds quadratic curves approximating the cubic curve with control
+// points b and c ending at d
+func (ca *contourAdder) CubeBezier(b, c, d fixed.Point26_6) {
+	bx, by := ca.t(b)
+	cx, cy := ca.t(c)
+	dx, dy := ca.t(d)
+	ca.cubeToQuads(ca.x, ca.y, bx, by, cx, cy, dx, dy, 0)
+}
+
+// Stop ends the current contour; contours are always closed
+func (ca *contourAdder) Stop(closeLoop bool) {
+	ca.end()
+}
+
+func (ca *contourAdder) quad(bx, by, cx, cy float64) {
+	ca.cur = append(ca.cur, OutlinePoint{bx, by, false}, OutlinePoint{cx, cy, true})
+	ca.x, ca.y = cx, cy
+}
+
+// end finishes the current contour, dropping a closing point that repeats the
+// start point and contours too small to enclose any area.
+func (ca *contourAdder) end() {
+	c := ca.cur
+	if n := len(c); n > 1 && c[n-1] == c[0] {
+		c = c[:n-1]
+	}
+	if len(c) >= 3 {
+		ca.contours = append(ca.contours, append(Contour(nil), c...))
+	}
+	ca.cur = ca.cur[:0]
+}
+
+// cubeToQuads adds the cubic curve from a through control points b and c to d as
+// quadratic curves, halving it until the quadratic is within the tolerance.
+func (ca *contourAdder) cubeToQuads(ax, ay, bx, by, cx, cy, dx, dy float64, depth int) {
+	// The distance between a cubic and the quadratic with control point
+	// (3(b+c) - (a+d))/4 is at most sqrt(3)/36 * |d - 3c + 3b - a|.
+	ex, ey := dx-3*cx+3*bx-ax, dy-3*cy+3*by-ay
+	if depth >= 16 || math.Sqrt(3)/36*math.Hypot(ex, ey) <= ca.tolerance {
+		ca.quad((3*(bx+cx)-ax-dx)/4, (3*(by+cy)-ay-dy)/4, dx, dy)
+		return
+	}
+	// Split at t = 0.5 with de Casteljau's algorithm
+	abx, aby := (ax+bx)/2, (ay+by)/2
+	bcx, bcy := (bx+cx)/2, (by+cy)/2
+	cdx, cdy := (cx+dx)/2, (cy+dy)/2
+	abcx, abcy := (abx+bcx)/2, (aby+bcy)/2
+	bcdx, bcdy := (bcx+cdx)/2, (bcy+cdy)/2
+	mx, my := (abcx+bcdx)/2, (abcy+bcdy)/2
+	ca.cubeToQuads(ax, ay, abx, aby, abcx, abcy, mx, my, depth+1)
+	ca.cubeToQuads(mx, my, bcdx, bcdy, cdx, cdy, dx, dy, depth+1)
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// gradients.go implements helpers for constructing gradients programmatically.
+
+package oksvg
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/srwiley/rasterx"
+)
+
+// LinearGradientFromAngle returns a linear gradient in objectBoundingBox units
+// that passes through the center of the bounding box at angle degrees,
+// measured from the positive x axis towards the positive y axis. The end points
+// are placed so that the gradient spans the whole bounding box.
+func LinearGradientFromAngle(angle float64, stops []rasterx.GradStop) *rasterx.Gradient {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	half := (math.Abs(cos) + math.Abs(sin)) / 2
+	return &rasterx.Gradient{
+		Points: [5]float64{0.5 - cos*half, 0.5 - sin*half, 0.5 + cos*half, 0.5 + sin*half, 0},
+		Stops:  stops,
+		Bounds: struct{ X, Y, W, H float64 }{0, 0, 1, 1},
+		Matrix: rasterx.Identity,
+		Units:  rasterx.ObjectBoundingBox,
+	}
+}
+
+// RadialGradientAt returns a radial gradient centered at cx, cy of radius r
+// with the focus at the center. The values are fractions of the bounding box if
+// units is rasterx.ObjectBoundingBox or user space coordinates if units is
+// rasterx.UserSpaceOnUse.
+func RadialGradientAt(cx, cy, r float64, units rasterx.GradientUnits, stops []rasterx.GradStop) *rasterx.Gradient {
+	return &rasterx.Gradient{
+		Points:   [5]float64{cx, cy, cx, cy, r},
+		Stops:    stops,
+		Bounds:   struct{ X, Y, W, H float64 }{0, 0, 1, 1},
+		Matrix:   rasterx.Identity,
+		Units:    units,
+		IsRadial: true,
+	}
+}
+
+// GradientColorAt returns the color of the gradient g at x, y, with its opacity
+// multiplied by opacity, so that applications can sample a paint without drawing
+// it. The point is in the user space of the gradient; for objectBoundingBox units
+// that is the space of its Bounds, the box of the painted object.
+func GradientColorAt(g *rasterx.Gradient, x, y, opacity float64) color.Color {
+	sg := *g
+	// GetColorFunctionUS sorts the stops in place
+	sg.Stops = append([]rasterx.GradStop(nil), g.Stops...)
+	// Shift the gradient so that x, y falls on the center of pixel 0, 0 of its
+	// color function
+	dx, dy := 0.5-x, 0.5-y
+	sg.Bounds.X += dx
+	sg.Bounds.Y += dy
+	switch paint := sg.GetColorFunctionUS(opacity, rasterx.Identity.Translate(dx, dy)).(type) {
+	case rasterx.ColorFunc:
+		return paint(0, 0)
+	case color.Color:
+		return paint
+	}
+	return color.Transparent
+}
+
+// ForEachGradient calls fn with each gradient of the icon, by id in Grads and as
+// copied into the fills and lines of its paths, including the paths of its
+// patterns, clip paths and masks, so that an application can recolor or animate
+// the gradients of a parsed icon. Changes fn makes to a gradient, including to
+// its stops, apply to that gradient only.
+func (s *SvgIcon) ForEachGradient(fn func(g *rasterx.Gradient)) {
+	for _, g := range s.Grads {
+		g.Stops = append([]rasterx.GradStop(nil), g.Stops...)
+		fn(g)
+	}
+	seen := make(map[interface{}]bool)
+	for i := range s.SVGPaths {
+		forEachPathGradient(&s.SVGPaths[i], fn, seen)
+	}
+}
+
+// forEachPathGradient calls fn with the gradients painting svgp, and with those
+// of the patterns and clips it uses that are not in seen.
+func forEachPathGradient(svgp *SvgPath, fn func(g *rasterx.Gradient), seen map[interface{}]bool) {
+	for _, paint := range []*interface{}{&svgp.fillerColor, &svgp.linerColor} {
+		switch p := (*paint).(type) {
+		case rasterx.Gradient:
+			g, _ := copyGradient(p)
+			fn(g)
+			*paint = *g
+		case *Pattern:
+			if !seen[p] {
+				seen[p] = true
+				for i := range p.Paths {
+					forEachPathGradient(&p.Paths[i], fn, seen)
+				}
+			}
+		}
+	}
+	for _, cp := range svgp.clips {
+		if !seen[cp] {
+			seen[cp] = true
+			for i := range cp.paths {
+				forEachPathGradient(&cp.paths[i], fn, seen)
+			}
+		}
+	}
+}
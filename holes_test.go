@@ -0,0 +1,168 @@
+// Copyright 2018 The oksvg Authors. All rights reserved.
+// created: 2018 by S.R.Wiley
+package oksvg_test
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/srwiley/oksvg"
+	. "github.com/srwiley/rasterx"
+)
+
+// holeIcon is a hole bearing shape, in the style of icon fonts, drawn in a
+// 100 by 100 viewBox. Points in holes must be left transparent and points in
+// ink must be filled.
+type holeIcon struct {
+	name       string
+	d          string
+	evenOdd    bool
+	holes, ink []image.Point
+}
+
+var holeIcons = []holeIcon{
+	{name: "ring arcs",
+		d:     "M50,10 A40,40 0 1,1 50,90 A40,40 0 1,1 50,10 Z M50,30 A20,20 0 1,0 50,70 A20,20 0 1,0 50,30 Z",
+		holes: []image.Point{{50, 50}, {45, 45}, {2, 2}}, ink: []image.Point{{50, 20}, {15, 50}, {80, 50}}},
+	{name: "ring arcs evenodd", evenOdd: true,
+		d:     "M50,10 A40,40 0 1,1 50,90 A40,40 0 1,1 50,10 Z M50,30 A20,20 0 1,1 50,70 A20,20 0 1,1 50,30 Z",
+		holes: []image.Point{{50, 50}, {45, 45}}, ink: []image.Point{{50, 20}, {15, 50}, {80, 50}}},
+	{name: "ring relative arcs",
+		d:     "m50,10 a40,40 0 1,1 0,80 a40,40 0 1,1 0,-80 z m0,20 a20,20 0 1,0 0,40 a20,20 0 1,0 0,-40 z",
+		holes: []image.Point{{50, 50}, {55, 55}}, ink: []image.Point{{50, 20}, {15, 50}, {80, 50}}},
+	{name: "ring rotated arcs",
+		d:     "M50,10 A40,40 30 1,1 50,90 A40,40 30 1,1 50,10 Z M50,30 A20,20 30 1,0 50,70 A20,20 30 1,0 50,30 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {15, 50}}},
+	{name: "ring scaled up arcs",
+		d:     "M10,50 A1,1 0 0,1 90,50 A1,1 0 0,1 10,50 Z M30,50 A1,1 0 0,0 70,50 A1,1 0 0,0 30,50 Z",
+		holes: []image.Point{{50, 50}, {50, 35}}, ink: []image.Point{{50, 15}, {50, 85}, {20, 50}}},
+	{name: "ellipse ring",
+		d:     "M10,50 A40,20 0 1,1 90,50 A40,20 0 1,1 10,50 Z M35,50 A15,8 0 1,0 65,50 A15,8 0 1,0 35,50 Z",
+		holes: []image.Point{{50, 50}, {50, 20}}, ink: []image.Point{{50, 35}, {20, 50}}},
+	{name: "target",
+		d: "M50,5 A45,45 0 1,1 50,95 A45,45 0 1,1 50,5 Z M50,15 A35,35 0 1,0 50,85 A35,35 0 1,0 50,15 Z " +
+			"M50,25 A25,25 0 1,1 50,75 A25,25 0 1,1 50,25 Z M50,35 A15,15 0 1,0 50,65 A15,15 0 1,0 50,35 Z",
+		holes: []image.Point{{50, 20}, {50, 50}, {50, 80}}, ink: []image.Point{{50, 10}, {50, 30}, {50, 70}, {50, 90}}},
+	{name: "ring cubics",
+		d: "M50,10 C72.09,10 90,27.91 90,50 C90,72.09 72.09,90 50,90 C27.91,90 10,72.09 10,50 C10,27.91 27.91,10 50,10 Z " +
+			"M50,30 C38.95,30 30,38.95 30,50 C30,61.05 38.95,70 50,70 C61.05,70 70,61.05 70,50 C70,38.95 61.05,30 50,30 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {15, 50}}},
+	{name: "ring smooth cubics",
+		d: "M50,10 C72.09,10 90,27.91 90,50 S72.09,90 50,90 S10,72.09 10,50 S27.91,10 50,10 Z " +
+			"M50,30 C38.95,30 30,38.95 30,50 S38.95,70 50,70 S70,61.05 70,50 S61.05,30 50,30 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {15, 50}}},
+	{name: "ring relative cubics",
+		d: "m50,10 c22.09,0 40,17.91 40,40 s-17.91,40 -40,40 s-40,-17.91 -40,-40 s17.91,-40 40,-40 z " +
+			"m0,20 c-11.05,0 -20,8.95 -20,20 s8.95,20 20,20 s20,-8.95 20,-20 s-8.95,-20 -20,-20 z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {15, 50}}},
+	{name: "quad diamond",
+		d:     "M50,5 Q95,5 95,50 Q95,95 50,95 Q5,95 5,50 Q5,5 50,5 Z M50,35 L35,50 L50,65 L65,50 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {20, 50}}},
+	{name: "smooth quad square hole",
+		d:     "M50,5 Q95,5 95,50 T50,95 T5,50 T50,5 Z M40,40 V60 H60 V40 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{50, 20}, {30, 50}}},
+	{name: "frame",
+		d:     "M10,10 H90 V90 H10 Z M30,30 V70 H70 V30 Z",
+		holes: []image.Point{{50, 50}, {31, 31}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame evenodd", evenOdd: true,
+		d:     "M10,10 H90 V90 H10 Z M30,30 H70 V70 H30 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame relative",
+		d:     "M10,10 h80 v80 h-80 z m20,20 v40 h40 v-40 z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame mixed case",
+		d:     "M10,10 h80 V90 H10 z M30,30 v40 H70 V30 z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame implicit lines",
+		d:     "M10,10 90,10 90,90 10,90 Z M30,30 30,70 70,70 70,30 Z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame relative implicit lines",
+		d:     "m10,10 80,0 0,80 -80,0 z m20,20 0,40 40,0 0,-40 z",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "frame unclosed subpaths",
+		d:     "M10,10 H90 V90 H10 M30,30 V70 H70 V30",
+		holes: []image.Point{{50, 50}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "subpath after closepath",
+		d:     "M10,10 H90 V90 H10 Z l20,20 v40 h40 v-40 z",
+		holes: []image.Point{{50, 50}, {60, 35}}, ink: []image.Point{{20, 50}, {50, 85}}},
+	{name: "nested frames evenodd", evenOdd: true,
+		d:     "M5,5 H95 V95 H5 Z M25,25 H75 V75 H25 Z M40,40 H60 V60 H40 Z",
+		holes: []image.Point{{30, 50}, {50, 30}}, ink: []image.Point{{10, 50}, {50, 50}}},
+	{name: "triangle hole",
+		d:     "M10,10 H90 V90 H10 Z M50,30 L35,70 H65 Z",
+		holes: []image.Point{{50, 57}}, ink: []image.Point{{20, 50}, {50, 25}}},
+	{name: "eight",
+		d:     "M20,5 H80 V95 H20 Z M35,15 V45 H65 V15 Z M35,55 V85 H65 V55 Z",
+		holes: []image.Point{{50, 30}, {50, 70}}, ink: []image.Point{{50, 50}, {27, 50}}},
+	{name: "grid",
+		d: "M5,5 H95 V95 H5 Z M15,15 V45 H45 V15 Z M55,15 V45 H85 V15 Z " +
+			"M15,55 V85 H45 V55 Z M55,55 V85 H85 V55 Z",
+		holes: []image.Point{{30, 30}, {70, 30}, {30, 70}, {70, 70}}, ink: []image.Point{{50, 50}, {10, 50}}},
+	{name: "keyhole",
+		d:     "M10,10 H90 V90 H10 Z M50,25 A10,10 0 0,0 44,43 L40,70 H60 L56,43 A10,10 0 0,0 50,25 Z",
+		holes: []image.Point{{50, 60}}, ink: []image.Point{{20, 50}, {80, 50}}},
+	{name: "pentagram evenodd", evenOdd: true,
+		d:     "M50,5 L79,95 L2,40 H98 L21,95 Z",
+		holes: []image.Point{{50, 55}}, ink: []image.Point{{50, 20}, {15, 43}}},
+	{name: "pentagram nonzero",
+		d:     "M50,5 L79,95 L2,40 H98 L21,95 Z",
+		holes: []image.Point{{2, 95}}, ink: []image.Point{{50, 55}, {50, 20}, {15, 43}}},
+	{name: "rounded frame",
+		d: "M20,10 H80 A10,10 0 0,1 90,20 V80 A10,10 0 0,1 80,90 H20 A10,10 0 0,1 10,80 V20 A10,10 0 0,1 20,10 Z " +
+			"M35,30 A5,5 0 0,0 30,35 V65 A5,5 0 0,0 35,70 H65 A5,5 0 0,0 70,65 V35 A5,5 0 0,0 65,30 Z",
+		holes: []image.Point{{50, 50}, {11, 11}}, ink: []image.Point{{20, 50}, {50, 15}}},
+	{name: "rounded frame relative",
+		d: "m20,10 h60 a10,10 0 0,1 10,10 v60 a10,10 0 0,1 -10,10 h-60 a10,10 0 0,1 -10,-10 v-60 a10,10 0 0,1 10,-10 z " +
+			"m15,20 a5,5 0 0,0 -5,5 v30 a5,5 0 0,0 5,5 h30 a5,5 0 0,0 5,-5 v-30 a5,5 0 0,0 -5,-5 z",
+		holes: []image.Point{{50, 50}, {11, 11}}, ink: []image.Point{{20, 50}, {50, 15}}},
+}
+
+func TestHoles(t *testing.T) {
+	const w, h = 100, 100
+	for _, hi := range holeIcons {
+		hi := hi
+		t.Run(hi.name, func(t *testing.T) {
+			rule := "nonzero"
+			if hi.evenOdd {
+				rule = "evenodd"
+			}
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100"><path fill-rule="` +
+				rule + `" d="` + hi.d + `"/></svg>`
+			icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := image.NewRGBA(image.Rect(0, 0, w, h))
+			icon.Draw(NewDasher(w, h, NewScannerGV(w, h, img, img.Bounds())), 1)
+			for _, p := range hi.holes {
+				if a := img.RGBAAt(p.X, p.Y).A; a > 0x10 {
+					t.Errorf("hole at %v is filled, alpha %d", p, a)
+				}
+			}
+			for _, p := range hi.ink {
+				if a := img.RGBAAt(p.X, p.Y).A; a < 0xF0 {
+					t.Errorf("ink at %v is not filled, alpha %d", p, a)
+				}
+			}
+			if t.Failed() {
+				saveHoleIcon(t, hi.name, img)
+			}
+		})
+	}
+}
+
+// saveHoleIcon saves the rendering of a failed hole icon for inspection.
+func saveHoleIcon(t *testing.T, name string, img image.Image) {
+	f, err := os.Create("testdata/holes_" + strings.ReplaceAll(name, " ", "_") + ".png")
+	if err != nil {
+		t.Log(err)
+		return
+	}
+	defer f.Close()
+	if err = png.Encode(f, img); err != nil {
+		t.Log(err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"image/color"
 	"log"
 	"math"
+	"sort"
 	"strings"
 
 	"github.com/srwiley/rasterx"
@@ -23,8 +24,66 @@ type IconCursor struct {
 	icon                                                 *SvgIcon
 	StyleStack                                           []PathStyle
 	grad                                                 *rasterx.Gradient
+	gradRef                                              *rasterx.Gradient // the gradient grad references by href
 	inTitleText, inDescText, inGrad, inDefs, inDefsStyle bool
-	currentDef                                           []definition
+	currentDef                                           []definition        // elements read within defs
+	defStarts                                            []int               // index in currentDef of each open element, -1 if not recorded
+	inSymbol                                             bool                // inDefs was set by a symbol outside defs
+	uses                                                 []string            // hrefs of the use elements being instantiated
+	clipRef, maskRef                                     string              // clip-path and mask of the element whose style is read
+	patterns                                             map[string]*Pattern // patterns read for paints, by url
+	ids                                                  []string            // ids of the open elements
+	textID                                               string              // id of the element the open title or desc describes
+	textOwned                                            bool                // textID names the element the open title or desc describes
+	pos                                                  SourcePos           // location of the element being read
+	ErrorPolicy                                          ErrorPolicy
+	arena                                                *Arena
+	dpi                                                  float64        // for lengths in absolute units
+	sampling                                             ImageSampling  // for images that are not pixelated
+	tags                                                 int            // start and end tags read
+	openElements                                         []int          // index in icon.elements of each open element, -1 if not recorded
+	colorFallback                                        color.Color    // paint for colors that cannot be parsed, if not nil
+	imageLoader                                          ImageLoader    // for the images of image elements that are not data URIs
+	handler                                              ElementHandler // receives the paths as they are read, if not nil
+	started                                              bool           // Start of handler was called
+	strokeOnlyUnfilled                                   bool           // see ParseOptions
+	instances, maxInstances                              int            // elements instantiated from definitions, and the most allowed
+	maxImagePixels                                       int            // see ParseOptions
+	lowMemory                                            bool           // see ParseOptions
+	inPage                                               bool           // a page of a pageSet is being read
+	late                                                 lateRefs       // references to ids not read yet
+}
+
+// textOwner returns the id of the element the title or desc element being read
+// describes, its parent, and whether the text can be found by that id. The
+// texts of the root svg element are found by its id, or by "" if it has none;
+// those of other elements without an id are not found by any id.
+func (c *IconCursor) textOwner() (string, bool) {
+	id := c.parentID()
+	return id, id != "" || len(c.ids) == 2
+}
+
+// parentID returns the id of the parent of the innermost open element.
+func (c *IconCursor) parentID() string {
+	if len(c.ids) < 2 {
+		return ""
+	}
+	return c.ids[len(c.ids)-2]
+}
+
+// elementID returns the value of the id attribute in attrs.
+func elementID(attrs []xml.Attr) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == "id" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Icon returns the SvgIcon being read by the IconCursor.
+func (c *IconCursor) Icon() *SvgIcon {
+	return c.icon
 }
 
 // ReadGradURL reads an SVG format gradient url
@@ -45,6 +104,55 @@ func (c *IconCursor) ReadGradURL(v string, defaultColor interface{}) (grad raste
 	return
 }
 
+// gradientRef returns the gradient named by the href of the gradient element with
+// attrs, or nil if it has none or it is not defined, which is reported as a
+// missing reference. A gradient not read yet is inherited at the end of the
+// document.
+func (c *IconCursor) gradientRef(attrs []xml.Attr) (*rasterx.Gradient, error) {
+	for _, attr := range attrs {
+		if attr.Name.Local != "href" {
+			continue
+		}
+		id := strings.TrimPrefix(strings.TrimSpace(attr.Value), "#")
+		g, ok := c.icon.Grads[id]
+		if c.late.deferring && (!ok || c.late.gradIDs[id] != nil) {
+			c.gradientLate(attrs)
+			return nil, nil
+		}
+		if ok {
+			return g, nil
+		}
+		return nil, c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: gradient %s", errMissingRef, attr.Value))
+	}
+	return nil, nil
+}
+
+// inheritGradient returns g with the units, transform and spread method of the
+// gradient ref it references, if not nil, and its points if both are of the
+// same kind. The attributes of the gradient element then override them, and its
+// stops, if it has none, are inherited by inheritStops.
+func (c *IconCursor) inheritGradient(g rasterx.Gradient, ref *rasterx.Gradient) rasterx.Gradient {
+	c.gradRef = ref
+	if ref == nil {
+		return g
+	}
+	g.Units, g.Matrix, g.Spread = ref.Units, ref.Matrix, ref.Spread
+	if ref.IsRadial == g.IsRadial {
+		g.Points = ref.Points
+	}
+	return g
+}
+
+// inheritStops gives the gradient just read the stops of the gradient it
+// references if it has none of its own.
+func (c *IconCursor) inheritStops() {
+	c.endLateGradient()
+	if c.gradRef != nil && c.grad != nil && len(c.grad.Stops) == 0 {
+		c.grad.Stops = append([]rasterx.GradStop(nil), c.gradRef.Stops...)
+	}
+	c.gradRef = nil
+}
+
 // ReadGradAttr reads an SVG gradient attribute
 func (c *IconCursor) ReadGradAttr(attr xml.Attr) (err error) {
 	switch attr.Name.Local {
@@ -74,20 +182,39 @@ func (c *IconCursor) ReadGradAttr(attr xml.Attr) (err error) {
 // for fill. Note that this parses both the contents of a style attribute plus
 // direct fill and opacity attributes.
 func (c *IconCursor) PushStyle(attrs []xml.Attr) error {
-	var pairs []string
-	className := ""
+	return c.pushStyle("", attrs)
+}
+
+// pushStyle pushes the style of the element tag with attrs, as PushStyle does.
+// The presentation attributes are overridden by the matching rules of the style
+// sheets, from the least to the most specific, which are in turn overridden by
+// the style attribute and then by the !important rules.
+func (c *IconCursor) pushStyle(tag string, attrs []xml.Attr) error {
+	var pairs, stylePairs []string
+	var id string
+	var classes []string
 	for _, attr := range attrs {
 		switch strings.ToLower(attr.Name.Local) {
 		case "style":
-			pairs = append(pairs, strings.Split(attr.Value, ";")...)
+			stylePairs = append(stylePairs, strings.Split(attr.Value, ";")...)
 		case "class":
-			className = attr.Value
+			classes = strings.Fields(attr.Value)
+		case "id":
+			id = attr.Value
+			fallthrough
 		default:
 			pairs = append(pairs, attr.Name.Local+":"+attr.Value)
 		}
 	}
+	pairs, important := c.cssPairs(pairs, tag, id, classes)
+	pairs = append(append(pairs, stylePairs...), important...)
 	// Make a copy of the top style
 	curStyle := c.StyleStack[len(c.StyleStack)-1]
+	// Read the font-size first, as other lengths of the element may be relative
+	// to it, and then the color, which currentColor paints refer to
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairRank(pairs[i]) < pairRank(pairs[j])
+	})
 	for _, pair := range pairs {
 		kv := strings.Split(pair, ":")
 		if len(kv) >= 2 {
@@ -100,11 +227,35 @@ func (c *IconCursor) PushStyle(attrs []xml.Attr) error {
 			}
 		}
 	}
-	c.adaptClasses(&curStyle, className)
+	// The clip path and mask are in the user space of the element, after its transform
+	if clip := c.clipRef; clip != "" {
+		c.clipRef = ""
+		if err := c.addClip(&curStyle, clip, "clipPath"); err != nil {
+			return err
+		}
+	}
+	if mask := c.maskRef; mask != "" {
+		c.maskRef = ""
+		if err := c.addClip(&curStyle, mask, "mask"); err != nil {
+			return err
+		}
+	}
 	c.StyleStack = append(c.StyleStack, curStyle) // Push style onto stack
 	return nil
 }
 
+// pairRank returns the order that the style pair is read in: 0 for the
+// font-size, 1 for the color and 2 for any other property.
+func pairRank(pair string) int {
+	switch k := strings.TrimSpace(strings.SplitN(pair, ":", 2)[0]); {
+	case strings.EqualFold(k, "font-size"):
+		return 0
+	case strings.EqualFold(k, "color"):
+		return 1
+	}
+	return 2
+}
+
 func (c *IconCursor) readTransformAttr(m1 rasterx.Matrix2D, k string) (rasterx.Matrix2D, error) {
 	ln := len(c.points)
 	switch k {
@@ -140,7 +291,7 @@ func (c *IconCursor) readTransformAttr(m1 rasterx.Matrix2D, k string) (rasterx.M
 		}
 	case "scale":
 		if ln == 1 {
-			m1 = m1.Scale(c.points[0], 0)
+			m1 = m1.Scale(c.points[0], c.points[0])
 		} else if ln == 2 {
 			m1 = m1.Scale(c.points[0], c.points[1])
 		} else {
@@ -189,30 +340,49 @@ func (c *IconCursor) parseTransform(v string) (rasterx.Matrix2D, error) {
 }
 
 func (c *IconCursor) readStyleAttr(curStyle *PathStyle, k, v string) error {
+	parent := &c.StyleStack[len(c.StyleStack)-1] // curStyle is not pushed yet
 	switch k {
 	case "fill":
-		gradient, ok := c.ReadGradURL(v, curStyle.fillerColor)
-		if ok {
-			curStyle.fillerColor = gradient
-			break
+		paint, err := c.readPaint(curStyle, curStyle.fillerColor, parent.fillerColor, DefaultStyle.fillerColor, v)
+		if err != nil {
+			return err
 		}
-		var err error
-		curStyle.fillerColor, err = ParseSVGColor(v)
-		return err
+		curStyle.fillerColor, curStyle.fillSet = paint, true
 	case "stroke":
-		gradient, ok := c.ReadGradURL(v, curStyle.linerColor)
-		if ok {
-			curStyle.linerColor = gradient
-			break
+		paint, err := c.readPaint(curStyle, curStyle.linerColor, parent.linerColor, DefaultStyle.linerColor, v)
+		if err != nil {
+			return err
 		}
-		col, errc := ParseSVGColor(v)
-		if errc != nil {
-			return errc
+		curStyle.linerColor = paint
+	case "color":
+		switch strings.ToLower(v) {
+		case "": // not specified
+		case "currentcolor", "inherit", "unset":
+			curStyle.currentColor = parent.currentColor
+		case "initial":
+			curStyle.currentColor = DefaultStyle.currentColor
+		default:
+			col, err := c.readColor(v)
+			if err != nil {
+				return err
+			}
+			if col != nil {
+				curStyle.currentColor = col
+			}
 		}
-		if col != nil {
-			curStyle.linerColor = col.(color.NRGBA)
-		} else {
-			curStyle.linerColor = nil
+	case "image-rendering":
+		switch v {
+		case "pixelated", "crisp-edges", "optimizeSpeed":
+			curStyle.pixelated = true
+		case "auto", "smooth", "optimizeQuality":
+			curStyle.pixelated = false
+		}
+	case "fill-rule":
+		switch v {
+		case "nonzero":
+			curStyle.UseNonZeroWinding = true
+		case "evenodd":
+			curStyle.UseNonZeroWinding = false
 		}
 	case "stroke-linegap":
 		switch v {
@@ -273,42 +443,70 @@ func (c *IconCursor) readStyleAttr(curStyle *PathStyle, k, v string) error {
 		}
 		curStyle.MiterLimit = mLimit
 	case "stroke-width":
-		width, err := parseFloat(v, 64)
+		width, err := c.parseStyleLength(curStyle, v)
 		if err != nil {
 			return err
 		}
 		curStyle.LineWidth = width
 	case "stroke-dashoffset":
-		dashOffset, err := parseFloat(v, 64)
+		dashOffset, err := c.parseStyleLength(curStyle, v)
 		if err != nil {
 			return err
 		}
 		curStyle.DashOffset = dashOffset
 	case "stroke-dasharray":
-		if v != "none" {
-			dashes := splitOnCommaOrSpace(v)
-			dList := make([]float64, len(dashes))
-			for i, dstr := range dashes {
-				d, err := parseFloat(strings.TrimSpace(dstr), 64)
-				if err != nil {
-					return err
-				}
-				dList[i] = d
+		switch v {
+		case "none", "initial":
+			curStyle.Dash = nil
+		case "inherit", "unset":
+			curStyle.Dash = parent.Dash
+		default:
+			dashes, err := c.parseDashArray(curStyle, v)
+			if errors.Is(err, errNegativeDash) {
+				return c.report(c.ErrorPolicy.MalformedValue, err) // the property is ignored
+			}
+			if err != nil {
+				return err
+			}
+			curStyle.Dash = dashes
+		}
+	case "font-size":
+		size, ok := fontSizeKeywords[v]
+		switch {
+		case ok:
+		case v == "larger":
+			size = curStyle.fontSize * 1.2
+		case v == "smaller":
+			size = curStyle.fontSize / 1.2
+		default:
+			var err error
+			// percentages and em units are relative to the font size of the parent
+			size, err = ParseLength(v, c.lengthContext(curStyle.fontSize, curStyle.fontSize))
+			if err != nil {
+				return err
 			}
-			curStyle.Dash = dList
-			break
 		}
+		curStyle.fontSize = size
 	case "opacity", "stroke-opacity", "fill-opacity":
 		op, err := parseFloat(v, 64)
 		if err != nil {
 			return err
 		}
+		if k == "opacity" {
+			curStyle.opacity *= op
+		}
 		if k != "stroke-opacity" {
 			curStyle.FillOpacity *= op
 		}
 		if k != "fill-opacity" {
 			curStyle.LineOpacity *= op
 		}
+	case "marker-start", "marker-mid", "marker-end", "marker":
+		setMarker(curStyle, k, v)
+	case "clip-path":
+		c.clipRef = v
+	case "mask":
+		c.maskRef = v
 	case "transform":
 		m, err := c.parseTransform(v)
 		if err != nil {
@@ -321,71 +519,294 @@ func (c *IconCursor) readStyleAttr(curStyle *PathStyle, k, v string) error {
 
 func (c *IconCursor) readStartElement(se xml.StartElement) (err error) {
 	var skipDef bool
-	if se.Name.Local == "radialGradient" || se.Name.Local == "linearGradient" || c.inGrad {
+	switch se.Name.Local {
+	case "radialGradient", "linearGradient", "title", "desc":
+		// gradients are saved as they are read, and texts belong to their parent
 		skipDef = true
 	}
-	if c.inDefs && !skipDef {
-		ID := ""
-		for _, attr := range se.Attr {
-			if attr.Name.Local == "id" {
-				ID = attr.Value
-			}
-		}
-		if ID != "" && len(c.currentDef) > 0 {
-			c.icon.Defs[c.currentDef[0].ID] = c.currentDef
-			c.currentDef = make([]definition, 0)
+	if c.inGrad {
+		skipDef = true
+	}
+	if drawnByReference(se.Name.Local) && !c.inDefs {
+		// record them like defs
+		c.inDefs, c.inSymbol = true, true
+	}
+	if c.inDefs {
+		if skipDef {
+			c.defStarts = append(c.defStarts, -1)
+		} else {
+			c.defStarts = append(c.defStarts, len(c.currentDef))
+			c.currentDef = append(c.currentDef, definition{
+				ID:    elementID(se.Attr),
+				Tag:   se.Name.Local,
+				Attrs: se.Attr,
+				pos:   c.pos,
+			})
+			return nil
 		}
-		c.currentDef = append(c.currentDef, definition{
-			ID:    ID,
-			Tag:   se.Name.Local,
-			Attrs: se.Attr,
-		})
-		return nil
 	}
 	df, ok := drawFuncs[se.Name.Local]
 	if !ok {
-		errStr := "Cannot process svg element " + se.Name.Local
-		if c.returnError(errStr) {
-			return errors.New(errStr)
-		}
-		return nil
+		return c.report(c.ErrorPolicy.UnknownElement, errors.New("Cannot process svg element "+se.Name.Local))
 	}
 	err = df(c, se.Attr)
 	if err != nil {
-		e := fmt.Sprintf("error during processing svg element %s: %s", se.Name.Local, err.Error())
-		if c.returnError(e) {
-			err = errors.New(e)
+		err = c.report(c.errorMode(err), fmt.Errorf("error during processing svg element %s: %w", se.Name.Local, err))
+		if err != nil {
+			return err
 		}
-		err = nil
 	}
 
 	if len(c.Path) > 0 {
 		//The cursor parsed a path from the xml element
-		pathCopy := make(rasterx.Path, len(c.Path))
-		copy(pathCopy, c.Path)
-		c.icon.SVGPaths = append(c.icon.SVGPaths,
-			SvgPath{c.StyleStack[len(c.StyleStack)-1], pathCopy})
+		c.addPath(c.pathStyle())
+		err = c.drawMarkers(se.Name.Local)
 		c.Path = c.Path[:0]
+		if err != nil {
+			err = c.report(c.errorMode(err), fmt.Errorf("error drawing markers of svg element %s: %w", se.Name.Local, err))
+		}
 	}
 	return
 }
 
-func (c *IconCursor) adaptClasses(pathStyle *PathStyle, className string) {
-	if className == "" || len(c.icon.classes) == 0 {
-		return
+// drawnByReference reports whether elements with tag are only drawn when they
+// are referenced, by a use element, a clip-path, mask or marker property or a paint.
+func drawnByReference(tag string) bool {
+	return tag == "symbol" || tag == "clipPath" || tag == "mask" || tag == "pattern" || tag == "marker"
+}
+
+// closedByEndg reports whether the definitions of elements with tag are closed
+// with an endg definition, as those of containers are.
+func closedByEndg(tag string) bool {
+	return tag == "g" || tag == "svg" || tag == "defs" || drawnByReference(tag)
+}
+
+// endDef ends the element tag read within defs. Container elements are
+// closed with an endg definition, and the definitions of an element with an
+// id are saved for use elements.
+func (c *IconCursor) endDef(tag string) {
+	start := c.defStarts[len(c.defStarts)-1]
+	c.defStarts = c.defStarts[:len(c.defStarts)-1]
+	if start >= 0 {
+		if closedByEndg(tag) {
+			c.currentDef = append(c.currentDef, definition{Tag: "endg"})
+		}
+		if id := c.currentDef[start].ID; id != "" {
+			c.icon.Defs[id] = append([]definition(nil), c.currentDef[start:]...)
+		}
+	}
+	if len(c.defStarts) == 0 && c.inSymbol {
+		c.inDefs, c.inSymbol = false, false
+		c.currentDef = c.currentDef[:0]
+	}
+}
+
+// addPath adds a copy of the parsed path with style to the icon.
+func (c *IconCursor) addPath(style PathStyle) {
+	c.icon.SVGPaths = append(c.icon.SVGPaths, SvgPath{style, c.arena.copyPath(c.Path), c.pos})
+}
+
+// scaledGradient returns g with its userSpaceOnUse coordinates scaled as the path
+// coordinates are, since it is drawn with the transform of the path.
+func (c *IconCursor) scaledGradient(g rasterx.Gradient) rasterx.Gradient {
+	if c.coordScale != 0 && g.Units == rasterx.UserSpaceOnUse {
+		g.Matrix = rasterx.Identity.Scale(c.coordScale, c.coordScale).Mult(g.Matrix)
+	}
+	return g
+}
+
+// pathStyle returns the style on top of the style stack, with its transform
+// compensating for the coordinate scale applied to the paths of very large icons.
+func (c *IconCursor) pathStyle() PathStyle {
+	style := c.StyleStack[len(c.StyleStack)-1]
+	if c.strokeOnlyUnfilled && !style.fillSet && style.linerColor != nil {
+		style.fillerColor = nil
+	}
+	style.fillSet = false // only needed while the style is read
+	if c.coordScale != 0 {
+		style.mAdder.M = style.mAdder.M.Scale(1/c.coordScale, 1/c.coordScale)
+	}
+	return style
+}
+
+// cssPairs appends the declarations of the style sheet rules matching the
+// element tag with the id and classes to pairs, and returns them with the
+// !important declarations.
+func (c *IconCursor) cssPairs(pairs []string, tag, id string, classes []string) ([]string, []string) {
+	if len(c.icon.styleRules) == 0 {
+		return pairs, nil
+	}
+	var matched []*cssRule
+	for i := range c.icon.styleRules {
+		if r := &c.icon.styleRules[i]; r.matches(tag, id, classes) {
+			matched = append(matched, r)
+		}
+	}
+	// Later rules win over earlier ones of the same specificity
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].specificity < matched[j].specificity })
+	var important []string
+	for _, r := range matched {
+		pairs = append(pairs, r.decls...)
+		important = append(important, r.important...)
+	}
+	return pairs, important
+}
+
+// parseDashArray parses the stroke-dasharray v of an element with style, a list
+// of lengths separated by commas or spaces, with percentages relative to the
+// diagonal of the viewport. A list of odd length is repeated to make it even,
+// and a list of zeros draws a solid line, as none does.
+func (c *IconCursor) parseDashArray(style *PathStyle, v string) ([]float64, error) {
+	fields := splitOnCommaOrSpace(v)
+	dashes := make([]float64, 0, 2*len(fields))
+	var sum float64
+	for _, f := range fields {
+		d, err := c.parseStyleLength(style, f)
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%w: %s", errNegativeDash, v)
+		}
+		dashes = append(dashes, d)
+		sum += d
+	}
+	if sum == 0 {
+		return nil, nil
+	}
+	if len(dashes)%2 == 1 {
+		dashes = append(dashes, dashes...)
+	}
+	return dashes, nil
+}
+
+// parseStyleLength parses a stroke length of the style, resolving em units with
+// its font size and percentages with the normalized diagonal of the viewBox.
+func (c *IconCursor) parseStyleLength(style *PathStyle, v string) (float64, error) {
+	return ParseLength(v, c.lengthContext(style.fontSize, c.diagonal()))
+}
+
+// parseLength parses a length of the innermost element, with percentages
+// relative to pct.
+func (c *IconCursor) parseLength(v string, pct float64) (float64, error) {
+	return ParseLength(v, c.lengthContext(c.StyleStack[len(c.StyleStack)-1].fontSize, pct))
+}
+
+// lengthContext returns the context of lengths in the font size fontSize, with
+// percentages relative to pct.
+func (c *IconCursor) lengthContext(fontSize, pct float64) LengthContext {
+	return LengthContext{FontSize: fontSize, RootFontSize: c.rootFontSize(), Percent: pct, DPI: c.dpi}
+}
+
+// diagonal returns the normalized diagonal of the viewBox, the length that
+// percentages of lengths that are neither horizontal nor vertical refer to.
+func (c *IconCursor) diagonal() float64 {
+	vb := c.icon.ViewBox
+	return math.Hypot(vb.W, vb.H) / math.Sqrt2
+}
+
+// rootFontSize returns the font size of the svg element, used for rem units.
+func (c *IconCursor) rootFontSize() float64 {
+	if len(c.StyleStack) > 1 {
+		return c.StyleStack[1].fontSize
+	}
+	return DefaultStyle.fontSize
+}
+
+// readPaint returns the fill or stroke paint v of an element with the style
+// curStyle, whose paint was cur and whose parent paints with parent. An empty
+// value leaves the paint as it was, inherit and unset inherit the paint of the
+// parent, and initial is the paint of the initial value, black for fills and
+// none for strokes. A url is followed by an optional fallback, as in
+// url(#g) red, which paints when the url names no gradient or pattern, without
+// reporting it. Without a fallback, such a url is reported by checkURL. While
+// the document is read, a url naming nothing read yet is resolved at its end.
+func (c *IconCursor) readPaint(curStyle *PathStyle, cur, parent, initial interface{}, v string) (interface{}, error) {
+	switch strings.ToLower(v) {
+	case "":
+		return cur, nil
+	case "inherit", "unset":
+		return parent, nil
+	case "initial":
+		return initial, nil
+	case "currentcolor":
+		return curStyle.currentColor, nil
+	}
+	if !strings.HasPrefix(v, "url(") {
+		return c.readColor(v)
+	}
+	var fallback string
+	if end := strings.IndexByte(v, ')'); end >= 0 {
+		v, fallback = v[:end+1], strings.TrimSpace(v[end+1:])
+	}
+	if c.late.deferring && c.lateURL(v, cur) {
+		return c.deferPaint(curStyle, cur, parent, initial, v, fallback)
 	}
-	for k, v := range c.icon.classes[className] {
-		c.readStyleAttr(pathStyle, k, v)
+	if gradient, ok := c.ReadGradURL(v, cur); ok {
+		return c.scaledGradient(gradient), nil
 	}
+	pattern, err := c.patternURL(v)
+	if err != nil {
+		return nil, err
+	}
+	if pattern != nil {
+		return pattern, nil
+	}
+	if c.late.deferring {
+		return c.deferPaint(curStyle, cur, parent, initial, v, fallback)
+	}
+	if fallback != "" && !strings.HasPrefix(fallback, "url(") {
+		return c.readPaint(curStyle, cur, parent, initial, fallback)
+	}
+	if err := c.checkURL(v); err != nil {
+		return nil, err
+	}
+	return c.readColor(v)
 }
 
-func (c *IconCursor) returnError(errMsg string) bool {
-	if c.ErrorMode == StrictErrorMode {
-		return true
+// report returns err if mode is StrictErrorMode, logs it if mode is
+// WarnErrorMode and otherwise ignores it.
+func (c *IconCursor) report(mode ErrorMode, err error) error {
+	switch mode {
+	case StrictErrorMode:
+		return err
+	case WarnErrorMode:
+		log.Println(err)
 	}
-	if c.ErrorMode == WarnErrorMode {
-		log.Println(errMsg)
+	c.icon.Diagnostics = append(c.icon.Diagnostics, Diagnostic{Pos: c.pos, Err: err})
+	return nil
+}
+
+// readColor parses the color v as ParseSVGColor does. If the cursor has a color
+// fallback, a color that cannot be parsed is reported as a malformed value and
+// the fallback returned in its place, as it is for a url that names nothing.
+func (c *IconCursor) readColor(v string) (color.Color, error) {
+	if c.colorFallback != nil && strings.HasPrefix(v, "url(") {
+		return c.colorFallback, nil // reported by checkURL
+	}
+	col, err := ParseSVGColor(v)
+	if err == nil || c.colorFallback == nil {
+		return col, err
+	}
+	return c.colorFallback, c.report(c.ErrorPolicy.MalformedValue, fmt.Errorf("color %q: %w", v, err))
+}
+
+// errorMode returns the ErrorMode of the ErrorPolicy for the category of err.
+func (c *IconCursor) errorMode(err error) ErrorMode {
+	switch {
+	case errors.Is(err, errMissingRef):
+		return c.ErrorPolicy.MissingReference
+	case errors.Is(err, errCommandUnknown):
+		return c.ErrorPolicy.UnknownElement
 	}
+	return c.ErrorPolicy.MalformedValue
+}
 
-	return false
+// checkURL reports a paint url that names no gradient or pattern as a missing reference.
+// The paint then falls back to black, or to the color fallback of the cursor.
+func (c *IconCursor) checkURL(v string) error {
+	if !strings.HasPrefix(v, "url(") {
+		return nil
+	}
+	return c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: paint %s", errMissingRef, v))
 }
@@ -0,0 +1,137 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// image_element.go implements the image element, for raster images embedded in
+// icons as data URIs or loaded by the application.
+
+package oksvg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"strings"
+
+	// The formats of embedded images
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ImageLoader returns the image that the href of an image element names when
+// it is not a data URI, such as a file name relative to the icon or a URL, so
+// that the application decides what may be read. A nil image draws nothing.
+type ImageLoader func(href string) (image.Image, error)
+
+// imageF reads an image element, drawing the PNG, JPEG or GIF image of a data
+// URI, or one returned by the ImageLoader of the cursor, into its viewport as
+// its preserveAspectRatio says. A width or height that is missing or auto is
+// that of the image, keeping its aspect ratio if the other is given.
+var imageF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+	var vp ViewBox
+	var href, par string
+	autoW, autoH := true, true
+	var err error
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "x":
+			vp.X, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "y":
+			vp.Y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		case "width":
+			if autoW = attr.Value == "auto"; !autoW {
+				vp.W, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+			}
+		case "height":
+			if autoH = attr.Value == "auto"; !autoH {
+				vp.H, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+			}
+		case "href":
+			href = strings.TrimSpace(attr.Value)
+		case "preserveAspectRatio":
+			par = attr.Value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if (!autoW && vp.W <= 0) || (!autoH && vp.H <= 0) || href == "" {
+		return nil
+	}
+	img, err := c.loadImage(href)
+	if err != nil || img == nil || img.Bounds().Empty() {
+		return err
+	}
+	b := img.Bounds()
+	iw, ih := float64(b.Dx()), float64(b.Dy())
+	switch {
+	case autoW && autoH:
+		vp.W, vp.H = iw, ih
+	case autoW:
+		vp.W = vp.H * iw / ih
+	case autoH:
+		vp.H = vp.W * ih / iw
+	}
+	align, slice := parseAspectRatio(par)
+	if strings.TrimSpace(par) == "" {
+		align = "xMidYMid"
+	}
+	t := viewBoxTransform(ViewBox{0, 0, iw, ih}, align, slice, vp.W, vp.H)
+	rect := ViewBox{vp.X + t.E, vp.Y + t.F, iw * t.A, ih * t.D}
+	if !slice {
+		vp = intersectViewBox(vp, rect) // the image does not fill the viewport
+	}
+	return c.addImage(img, vp, rect)
+}
+
+// loadImage returns the image of the data URI href, or that returned by the
+// ImageLoader of the cursor, or nil if it has none.
+func (c *IconCursor) loadImage(href string) (image.Image, error) {
+	if !strings.HasPrefix(href, "data:") {
+		if c.imageLoader == nil || strings.HasPrefix(href, "#") {
+			return nil, nil
+		}
+		return c.imageLoader(href)
+	}
+	comma := strings.IndexByte(href, ',')
+	if comma < 0 {
+		return nil, fmt.Errorf("%w: data URI without data", errParamMismatch)
+	}
+	header, data := href[len("data:"):comma], href[comma+1:]
+	if mediaType := strings.Split(header, ";")[0]; strings.EqualFold(mediaType, "image/svg+xml") {
+		return nil, fmt.Errorf("%w: image of type %s", errCommandUnknown, mediaType)
+	}
+	var raw []byte
+	var err error
+	if strings.HasSuffix(header, ";base64") {
+		// Data URIs are often wrapped, and the padding is sometimes left out
+		data = strings.TrimRight(strings.Join(strings.Fields(data), ""), "=")
+		raw, err = base64.RawStdEncoding.DecodeString(data)
+	} else {
+		data, err = url.PathUnescape(data)
+		raw = []byte(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The size is read from the header before the pixels are decoded
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width < 0 || cfg.Height < 0 || int64(cfg.Width)*int64(cfg.Height) > int64(c.maxImagePixels) {
+		return nil, fmt.Errorf("%w: %d by %d pixels", errImageTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	return img, err
+}
+
+// intersectViewBox returns the rectangle common to a and b.
+func intersectViewBox(a, b ViewBox) ViewBox {
+	x0, y0 := math.Max(a.X, b.X), math.Max(a.Y, b.Y)
+	x1, y1 := math.Min(a.X+a.W, b.X+b.W), math.Min(a.Y+a.H, b.Y+b.H)
+	return ViewBox{x0, y0, math.Max(0, x1-x0), math.Max(0, y1-y0)}
+}
@@ -0,0 +1,397 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// late_refs.go resolves references to elements and gradients that are defined
+// after they are used, as in sprite sheets with their defs at the end.
+
+package oksvg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// lateRefs are the references to ids that were not read yet when they were
+// found. Use elements and markers, paints, clip paths and masks, and gradients
+// inheriting from such gradients are resolved once the document is read, in a
+// single reading of it, and only the references still missing then are reported,
+// at the location they were found.
+type lateRefs struct {
+	deferring  bool // references to ids not read yet are resolved at the end of the document
+	collecting int  // clip paths, masks and patterns whose content is being read
+	missed     bool // the content being collected uses an element not read yet
+	draws      []lateDraw
+	clips      []lateClip
+	paints     []*latePaint
+	grads      []*lateGradient
+	gradIDs    map[string]*lateGradient // the grads with an id, by id
+	grad       *lateGradient            // the gradient element being read, if its href is late
+	dropped    map[*clipPath]bool       // clips whose element was not found
+}
+
+// lateState is the state of the cursor where a late reference was found, which
+// it is resolved in.
+type lateState struct {
+	styles []PathStyle
+	uses   []string
+	pos    SourcePos
+}
+
+// lateDraw draws the element a use element or a marker property references, and
+// its paths are inserted where they would have been drawn.
+type lateDraw struct {
+	lateState
+	draw  func() error
+	msg   string // what the errors it returns are reported as
+	at    int    // index in SVGPaths of its paths
+	tag   int    // the start tag of the element drawing it, among all tags
+	pages int    // pages read when it was found
+	page  int    // the page of a pageSet it is drawn on, or -1
+}
+
+// lateClip is a clip path or mask whose content is read at the end of the document.
+type lateClip struct {
+	lateState
+	clip   *clipPath
+	base   PathStyle
+	v, tag string
+}
+
+// latePaint is a paint url resolved at the end of the document. It holds the
+// place of the paint in the styles until then.
+type latePaint struct {
+	lateState
+	url         string
+	cur         interface{} // the paint the url replaces, for gradient stops without color
+	fallback    interface{} // the paint of the fallback color, if hasFallback
+	hasFallback bool
+	paint       interface{} // the paint, once resolved
+	done        bool
+}
+
+// lateGradient is a gradient element whose href names a gradient not read yet.
+type lateGradient struct {
+	id    string
+	attrs []xml.Attr
+	pos   SourcePos
+	grad  *rasterx.Gradient // as read, without what it inherits
+	done  bool
+}
+
+// lateState returns the state of the cursor, for a late reference found in it.
+func (c *IconCursor) lateState() lateState {
+	return lateState{append([]PathStyle(nil), c.StyleStack...), append([]string(nil), c.uses...), c.pos}
+}
+
+// restoreState makes s the state of the cursor.
+func (c *IconCursor) restoreState(s lateState) {
+	c.StyleStack, c.uses, c.pos = s.styles, s.uses, s.pos
+}
+
+// deferDraw calls draw, which draws an element that is not read yet, at the end
+// of the document, and reports errors it returns as msg. Within the content of a
+// clip path, mask or pattern, whose paths are not added to the icon, the content
+// is read again at the end of the document instead.
+func (c *IconCursor) deferDraw(msg string, draw func() error) {
+	if c.late.collecting > 0 {
+		c.late.missed = true
+		return
+	}
+	d := lateDraw{lateState: c.lateState(), draw: draw, msg: msg, at: len(c.icon.SVGPaths), tag: c.tags,
+		pages: len(c.icon.pages), page: -1}
+	if c.inPage {
+		d.page = len(c.icon.pages) - 1
+	}
+	c.late.draws = append(c.late.draws, d)
+}
+
+// collect calls read, which reads the content of a clip path, mask or pattern
+// and reports whether its element was found, and also returns whether the
+// content uses elements that are not read yet.
+func (c *IconCursor) collect(read func() (bool, error)) (found, missed bool, err error) {
+	was := c.late.missed
+	c.late.collecting++
+	c.late.missed = false
+	found, err = read()
+	missed = c.late.missed
+	c.late.collecting--
+	c.late.missed = was
+	return found, missed, err
+}
+
+// deferClip reads the content of the clip path or mask cp, as tag is, referenced
+// by the value v on an element with the style base, at the end of the document.
+func (c *IconCursor) deferClip(cp *clipPath, base PathStyle, v, tag string) {
+	cp.paths = nil
+	c.late.clips = append(c.late.clips, lateClip{c.lateState(), cp, base, v, tag})
+}
+
+// lateURL reports whether the paint url v, replacing the paint cur, is resolved
+// at the end of the document as it names a gradient inheriting from one not read
+// yet, or cur is.
+func (c *IconCursor) lateURL(v string, cur interface{}) bool {
+	if _, ok := cur.(*latePaint); ok {
+		return true
+	}
+	if !strings.HasSuffix(v, ")") {
+		return false
+	}
+	id := strings.TrimSpace(v[4 : len(v)-1])
+	return strings.HasPrefix(id, "#") && c.late.gradIDs[id[1:]] != nil
+}
+
+// deferPaint returns the paint url v, resolved at the end of the document, of an
+// element with the style curStyle, as readPaint does with the other arguments.
+// The fallback, if any, is read now.
+func (c *IconCursor) deferPaint(curStyle *PathStyle, cur, parent, initial interface{}, v, fallback string) (interface{}, error) {
+	p := &latePaint{lateState: c.lateState(), url: v, cur: cur}
+	if fallback != "" && !strings.HasPrefix(fallback, "url(") {
+		var err error
+		if p.fallback, err = c.readPaint(curStyle, cur, parent, initial, fallback); err != nil {
+			return nil, err
+		}
+		p.hasFallback = true
+	}
+	c.late.paints = append(c.late.paints, p)
+	return p, nil
+}
+
+// gradientLate records the gradient element with attrs, whose href names a
+// gradient not read yet, to inherit from it at the end of the document.
+func (c *IconCursor) gradientLate(attrs []xml.Attr) {
+	c.late.grad = &lateGradient{id: elementID(attrs), attrs: attrs, pos: c.pos}
+}
+
+// endLateGradient ends the gradient element read last, if its href is late.
+func (c *IconCursor) endLateGradient() {
+	g := c.late.grad
+	if g == nil {
+		return
+	}
+	c.late.grad, g.grad = nil, c.grad
+	c.late.grads = append(c.late.grads, g)
+	if g.id != "" {
+		if c.late.gradIDs == nil {
+			c.late.gradIDs = make(map[string]*lateGradient)
+		}
+		c.late.gradIDs[g.id] = g
+	}
+}
+
+// resolveLate resolves the late references once the document is read, and
+// reports those still missing.
+func (c *IconCursor) resolveLate() error {
+	c.late.deferring = false
+	end := c.lateState()
+	defer c.restoreState(end)
+	for _, g := range c.late.grads {
+		if err := c.resolveGradient(g); err != nil {
+			return err
+		}
+	}
+	if err := c.drawLate(); err != nil {
+		return err
+	}
+	for _, l := range c.late.clips {
+		c.restoreState(l.lateState)
+		found, err := c.readClip(l.clip, l.base, l.v, l.tag)
+		if err != nil {
+			return err
+		}
+		if !found {
+			if c.late.dropped == nil {
+				c.late.dropped = make(map[*clipPath]bool)
+			}
+			c.late.dropped[l.clip] = true
+			if err = c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: %s %s", errMissingRef, l.tag, l.v)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range c.late.paints {
+		if _, err := c.resolvePaint(p); err != nil {
+			return err
+		}
+	}
+	if len(c.late.paints) > 0 || len(c.late.dropped) > 0 {
+		seen := make(map[interface{}]bool)
+		for i := range c.icon.SVGPaths {
+			c.resolveStyle(&c.icon.SVGPaths[i].PathStyle, seen)
+		}
+		for _, e := range c.icon.elements {
+			for i := range e.styles {
+				c.resolveStyle(&e.styles[i], seen)
+			}
+		}
+	}
+	c.late = lateRefs{}
+	return nil
+}
+
+// resolveGradient reads the late gradient g again, now that the gradient its
+// href names is read, keeping the stops it read, or reports that gradient as
+// missing.
+func (c *IconCursor) resolveGradient(g *lateGradient) error {
+	if g.done {
+		return nil
+	}
+	g.done = true
+	var href string
+	for _, attr := range g.attrs {
+		if attr.Name.Local == "href" {
+			href = attr.Value
+		}
+	}
+	id := strings.TrimPrefix(strings.TrimSpace(href), "#")
+	if ref := c.late.gradIDs[id]; ref != nil {
+		if err := c.resolveGradient(ref); err != nil {
+			return err
+		}
+	}
+	tag := "linearGradient"
+	if g.grad.IsRadial {
+		tag = "radialGradient"
+	}
+	c.pos = g.pos
+	if _, ok := c.icon.Grads[id]; !ok {
+		err := c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: gradient %s", errMissingRef, href))
+		if err != nil {
+			return fmt.Errorf("error during processing svg element %s: %w", tag, err)
+		}
+		return nil
+	}
+	if g.id != "" && c.icon.Grads[g.id] != g.grad {
+		return nil // a later gradient has its id
+	}
+	// Its errors were reported when it was first read
+	policy, n := c.ErrorPolicy, len(c.icon.Diagnostics)
+	c.ErrorPolicy = ErrorPolicy{}
+	f := linearGradientF
+	if g.grad.IsRadial {
+		f = radialGradientF
+	}
+	_ = f(c, g.attrs)
+	c.grad.Stops = g.grad.Stops
+	c.inheritStops()
+	c.grad, c.inGrad = nil, false
+	c.ErrorPolicy, c.icon.Diagnostics = policy, c.icon.Diagnostics[:n]
+	return nil
+}
+
+// drawLate draws the late use elements and markers, inserting their paths where
+// they would have been drawn, and moving the elements and pages after them.
+func (c *IconCursor) drawLate() error {
+	shift := 0
+	for _, d := range c.late.draws {
+		c.restoreState(d.lateState)
+		n := len(c.icon.SVGPaths)
+		err := d.draw()
+		if err != nil {
+			err = c.report(c.errorMode(err), fmt.Errorf("%s: %w", d.msg, err))
+		}
+		paths, at := c.icon.SVGPaths, d.at+shift
+		added := append([]SvgPath(nil), paths[n:]...)
+		c.icon.SVGPaths = append(paths[:at], append(added, paths[at:n]...)...)
+		if err != nil {
+			return err
+		}
+		k := len(added)
+		shift += k
+		for i := range c.icon.elements {
+			e := &c.icon.elements[i]
+			switch {
+			case e.open > d.tag:
+				e.first, e.end = e.first+k, e.end+k
+			case e.close > d.tag: // the element drawing it or an ancestor
+				e.end += k
+			}
+		}
+		for i := range c.icon.pages {
+			p := &c.icon.pages[i]
+			switch {
+			case i == d.page:
+				p.end += k
+			case i >= d.pages:
+				p.first, p.end = p.first+k, p.end+k
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePaint returns the paint of the late paint p, reporting its url as
+// readPaint does if it names nothing.
+func (c *IconCursor) resolvePaint(p *latePaint) (interface{}, error) {
+	if p.done {
+		return p.paint, nil
+	}
+	cur := p.cur
+	if l, ok := cur.(*latePaint); ok {
+		var err error
+		if cur, err = c.resolvePaint(l); err != nil {
+			return nil, err
+		}
+	}
+	c.restoreState(p.lateState)
+	if gradient, ok := c.ReadGradURL(p.url, cur); ok {
+		p.paint = c.scaledGradient(gradient)
+	} else {
+		pattern, err := c.patternURL(p.url)
+		switch {
+		case err != nil:
+			return nil, err
+		case pattern != nil:
+			p.paint = pattern
+		case p.hasFallback:
+			p.paint = p.fallback
+		default:
+			if err = c.checkURL(p.url); err != nil {
+				return nil, err
+			}
+			if p.paint, err = c.readColor(p.url); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p.done = true
+	return p.paint, nil
+}
+
+// resolveStyle replaces the late paints of style, and of the paths of its
+// patterns and clips, by their paints, and drops its clips that were not found.
+// The patterns and clips in seen are already resolved.
+func (c *IconCursor) resolveStyle(style *PathStyle, seen map[interface{}]bool) {
+	for _, paint := range []*interface{}{&style.fillerColor, &style.linerColor} {
+		if p, ok := (*paint).(*latePaint); ok {
+			*paint = p.paint
+		}
+		if p, ok := (*paint).(*Pattern); ok && !seen[p] {
+			seen[p] = true
+			for i := range p.Paths {
+				c.resolveStyle(&p.Paths[i].PathStyle, seen)
+			}
+		}
+	}
+	var dropped bool
+	for _, cp := range style.clips {
+		dropped = dropped || c.late.dropped[cp]
+		if !seen[cp] {
+			seen[cp] = true
+			for i := range cp.paths {
+				c.resolveStyle(&cp.paths[i].PathStyle, seen)
+			}
+		}
+	}
+	if dropped {
+		// The clips of style are shared with the styles it was copied from
+		clips := make([]*clipPath, 0, len(style.clips))
+		for _, cp := range style.clips {
+			if !c.late.dropped[cp] {
+				clips = append(clips, cp)
+			}
+		}
+		style.clips = clips
+	}
+}
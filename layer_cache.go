@@ -0,0 +1,127 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// layer_cache.go implements caching of rasterized path layers so that icons
+// can be redrawn at varying opacity without re-rasterizing.
+
+package oksvg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/srwiley/rasterx"
+)
+
+// LayerCache holds the rasterized fills and strokes of an SvgIcon at a fixed
+// size and transform. Solid paints are stored as coverage masks that are tinted
+// when drawn, and gradients as pre-rendered layers, so that the icon can be drawn
+// every frame with a varying opacity, as in fade animations, without re-rasterizing.
+type LayerCache struct {
+	bounds image.Rectangle
+	layers []cachedLayer
+}
+
+// cachedLayer is the rasterized fill or stroke of a single path.
+type cachedLayer struct {
+	rect    image.Rectangle
+	mask    *image.Alpha // coverage of a solid paint
+	clr     color.Color  // the solid paint, nil if img holds a gradient layer
+	opacity float64      // fill or stroke opacity of the solid paint
+	img     *image.RGBA  // pre-rendered gradient layer
+}
+
+// NewLayerCache rasterizes the paths of the icon using its current Transform into
+// layers of width w and height h. The paths are drawn as Draw draws them, within
+// the Budget of the icon and following its Quirks and IsolateOpacity, so that a
+// frame drawn at opacity 1 matches what Draw produces.
+func NewLayerCache(icon *SvgIcon, w, h int) *LayerCache {
+	lc := &LayerCache{bounds: image.Rect(0, 0, w, h)}
+	mask := image.NewAlpha(lc.bounds)
+	img := image.NewRGBA(lc.bounds)
+	maskRaster := rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, mask, lc.bounds))
+	imgRaster := rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, lc.bounds))
+	tb := icon.budget()
+	for _, svgp := range icon.SVGPaths {
+		if icon.IsolateOpacity && !icon.Quirks.StrokeBeforeFill && svgp.fillerColor != nil && svgp.linerColor != nil && svgp.opacity > 0 && svgp.opacity < 1 {
+			// The fill and stroke are composited as one layer
+			lc.addImage(icon, &svgp, tb, imgRaster, img)
+			continue
+		}
+		fill := svgp
+		fill.linerColor = nil
+		line := svgp
+		line.fillerColor = nil
+		parts := []*SvgPath{&fill, &line}
+		if icon.Quirks.StrokeBeforeFill {
+			parts[0], parts[1] = &line, &fill
+		}
+		for _, part := range parts {
+			paint, opacity := &part.fillerColor, &part.FillOpacity
+			if part.fillerColor == nil {
+				paint, opacity = &part.linerColor, &part.LineOpacity
+			}
+			if clr, ok := (*paint).(color.Color); ok {
+				op := *opacity
+				*paint, *opacity = color.White, 1
+				lc.addMask(icon, part, tb, maskRaster, mask, clr, op)
+			} else if *paint != nil {
+				lc.addImage(icon, part, tb, imgRaster, img)
+			}
+		}
+	}
+	return lc
+}
+
+// Draw composites the cached layers over dst, which should have the size
+// the LayerCache was created with, at the given opacity.
+func (lc *LayerCache) Draw(dst draw.Image, opacity float64) {
+	opMask := image.NewUniform(color.Alpha{uint8(opacity * 0xFF)})
+	for _, l := range lc.layers {
+		if l.clr != nil {
+			src := image.NewUniform(rasterx.ApplyOpacity(l.clr, l.opacity*opacity))
+			draw.DrawMask(dst, l.rect, src, image.Point{}, l.mask, l.rect.Min, draw.Over)
+			continue
+		}
+		draw.DrawMask(dst, l.rect, l.img, l.rect.Min, opMask, image.Point{}, draw.Over)
+	}
+}
+
+// extent returns the pixel rectangle covered by the last path drawn into r.
+func (lc *LayerCache) extent(r *rasterx.Dasher) image.Rectangle {
+	e := r.Scanner.GetPathExtent()
+	return image.Rect(e.Min.X.Floor(), e.Min.Y.Floor(), e.Max.X.Ceil(), e.Max.Y.Ceil()).Intersect(lc.bounds)
+}
+
+// addMask rasterizes the opaque white path svgp of icon, within the budget tb,
+// into the scratch mask and keeps the covered part of it as a layer to be tinted
+// by clr.
+func (lc *LayerCache) addMask(icon *SvgIcon, svgp *SvgPath, tb TessellationBudget, r *rasterx.Dasher,
+	mask *image.Alpha, clr color.Color, opacity float64) {
+	r.Scanner.Clear() // a culled path must not leave the extent of the last one
+	icon.drawPath(svgp, r, 1, icon.Transform, tb, icon.Quirks)
+	rect := lc.extent(r)
+	if rect.Empty() {
+		return
+	}
+	l := cachedLayer{rect: rect, mask: image.NewAlpha(rect), clr: clr, opacity: opacity}
+	draw.Draw(l.mask, rect, mask, rect.Min, draw.Src)
+	draw.Draw(mask, rect, image.Transparent, image.Point{}, draw.Src)
+	lc.layers = append(lc.layers, l)
+}
+
+// addImage rasterizes the path svgp of icon, painted with a gradient or with
+// both a fill and a stroke, within the budget tb into the scratch image and
+// keeps the covered part of it as a layer.
+func (lc *LayerCache) addImage(icon *SvgIcon, svgp *SvgPath, tb TessellationBudget, r *rasterx.Dasher, img *image.RGBA) {
+	r.Scanner.Clear() // a culled path must not leave the extent of the last one
+	icon.drawPath(svgp, r, 1, icon.Transform, tb, icon.Quirks)
+	rect := lc.extent(r)
+	if rect.Empty() {
+		return
+	}
+	l := cachedLayer{rect: rect, img: image.NewRGBA(rect)}
+	draw.Draw(l.img, rect, img, rect.Min, draw.Src)
+	draw.Draw(img, rect, image.Transparent, image.Point{}, draw.Src)
+	lc.layers = append(lc.layers, l)
+}
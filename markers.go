@@ -0,0 +1,318 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// markers.go implements the marker-start, marker-mid and marker-end properties,
+// which draw marker elements, such as arrowheads, at the vertices of paths.
+
+package oksvg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// markerRefs are the marker-start, marker-mid and marker-end properties of a
+// style, as url references, empty if none.
+type markerRefs [3]string
+
+// markerVertex is a vertex of a path, in user units, with the angles in radians
+// of the directions the path enters and leaves it, NaN if it does not.
+type markerVertex struct {
+	x, y    float64
+	in, out float64
+}
+
+// setMarker sets the marker property k of curStyle to v, or all of them for the
+// marker shorthand. The markers of curStyle are shared with the styles it was
+// copied from, so they are copied before they are changed.
+func setMarker(curStyle *PathStyle, k, v string) {
+	if v == "none" {
+		v = ""
+	}
+	var refs markerRefs
+	if curStyle.markers != nil {
+		refs = *curStyle.markers
+	}
+	switch k {
+	case "marker-start":
+		refs[0] = v
+	case "marker-mid":
+		refs[1] = v
+	case "marker-end":
+		refs[2] = v
+	default:
+		refs = markerRefs{v, v, v}
+	}
+	curStyle.markers = nil
+	if refs != (markerRefs{}) {
+		curStyle.markers = &refs
+	}
+}
+
+// drawMarkers adds the markers of the style on top of the style stack at the
+// vertices of the path just read from the element tag, in which only path,
+// line, polyline and polygon elements draw markers.
+func (c *IconCursor) drawMarkers(tag string) error {
+	style := c.StyleStack[len(c.StyleStack)-1]
+	if style.markers == nil {
+		return nil
+	}
+	switch tag {
+	case "path", "line", "polyline", "polygon":
+	default:
+		return nil
+	}
+	vertices := pathVertices(c.Path, c.coordScale)
+	if len(vertices) == 0 {
+		return nil
+	}
+	refs := *style.markers
+	for i, v := range vertices {
+		var err error
+		switch {
+		case i == 0:
+			err = c.drawMarker(tag, refs[0], v, style, true)
+		case i < len(vertices)-1:
+			err = c.drawMarker(tag, refs[1], v, style, false)
+		}
+		if err == nil && i == len(vertices)-1 {
+			err = c.drawMarker(tag, refs[2], v, style, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawMarker draws the marker named by the url ref at the vertex v of a path
+// with style, drawn by an element tag, which is at the start of the path if
+// start is true. Markers are drawn with the style of the root svg element rather
+// than that of the path, with the clips and opacity of the path. Unlike in
+// browsers, markers are not clipped to their viewport. A marker not read yet is
+// drawn at the end of the document.
+func (c *IconCursor) drawMarker(tag, ref string, v markerVertex, style PathStyle, start bool) error {
+	if ref == "" {
+		return nil
+	}
+	var id string
+	if strings.HasPrefix(ref, "url(") && strings.HasSuffix(ref, ")") {
+		id = strings.TrimSpace(ref[4 : len(ref)-1])
+	}
+	defs, ok := c.icon.Defs[strings.TrimPrefix(id, "#")]
+	if !ok || !strings.HasPrefix(id, "#") || defs[0].Tag != "marker" {
+		if c.late.deferring {
+			c.deferDraw("error drawing markers of svg element "+tag, func() error { return c.drawMarker(tag, ref, v, style, start) })
+			return nil
+		}
+		return c.report(c.ErrorPolicy.MissingReference, fmt.Errorf("%w: marker %s", errMissingRef, ref))
+	}
+	for _, u := range c.uses {
+		if u == id {
+			return fmt.Errorf("marker %s references itself", ref)
+		}
+	}
+	m, err := c.markerTransform(defs[0].Attrs, v, style, start)
+	if err != nil {
+		return err
+	}
+	base := c.StyleStack[0]
+	if len(c.StyleStack) > 1 {
+		base = c.StyleStack[1] // the root svg element
+	}
+	if base.opacity > 0 {
+		k := style.opacity / base.opacity
+		base.FillOpacity, base.LineOpacity, base.opacity = base.FillOpacity*k, base.LineOpacity*k, style.opacity
+	}
+	base.mAdder.M, base.clips, base.markers = m, style.clips, nil
+	depth := len(c.StyleStack)
+	c.StyleStack = append(c.StyleStack, base)
+	c.uses = append(c.uses, id)
+	err = c.instantiate(defs, 0, 0)
+	c.uses = c.uses[:len(c.uses)-1]
+	c.StyleStack = c.StyleStack[:depth]
+	return err
+}
+
+// markerTransform returns the transform of the content of the marker element
+// with attrs drawn at the vertex v of a path with style.
+func (c *IconCursor) markerTransform(attrs []xml.Attr, v markerVertex, style PathStyle,
+	start bool) (rasterx.Matrix2D, error) {
+	var (
+		vb          ViewBox
+		w, h        = 3.0, 3.0
+		refX, refY  float64
+		orient      string
+		strokeWidth = true
+		par         string
+		err         error
+	)
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "markerWidth":
+			w, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "markerHeight":
+			h, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		case "refX":
+			refX, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "refY":
+			refY, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		case "orient":
+			orient = strings.TrimSpace(attr.Value)
+		case "markerUnits":
+			strokeWidth = attr.Value != "userSpaceOnUse"
+		case "viewBox":
+			if err = c.GetPoints(attr.Value); err == nil && len(c.points) != 4 {
+				err = errParamMismatch
+			}
+			if err == nil {
+				vb = ViewBox{c.points[0], c.points[1], c.points[2], c.points[3]}
+			}
+		case "preserveAspectRatio":
+			par = attr.Value
+		}
+		if err != nil {
+			return rasterx.Identity, err
+		}
+	}
+	var angle float64
+	switch orient {
+	case "auto", "auto-start-reverse":
+		angle = bisector(v.in, v.out)
+		if start && orient == "auto-start-reverse" {
+			angle += math.Pi
+		}
+	case "":
+	default:
+		if angle, err = parseAngle(orient); err != nil {
+			return rasterx.Identity, err
+		}
+	}
+	m := style.mAdder.M.Translate(v.x, v.y).Rotate(angle)
+	if strokeWidth {
+		m = m.Scale(style.LineWidth, style.LineWidth)
+	}
+	content := rasterx.Identity
+	if vb.W > 0 && vb.H > 0 {
+		align, slice := parseAspectRatio(par)
+		if strings.TrimSpace(par) == "" {
+			align = "xMidYMid"
+		}
+		content = viewBoxTransform(vb, align, slice, w, h)
+	}
+	// The reference point, in the coordinates of the content, is put on the vertex
+	rx, ry := content.Transform(refX, refY)
+	return m.Translate(-rx, -ry).Mult(content), nil
+}
+
+// bisector returns the angle halfway between the angles in and out, either of
+// which may be NaN if the path does not enter or leave the vertex.
+func bisector(in, out float64) float64 {
+	switch {
+	case math.IsNaN(in) && math.IsNaN(out):
+		return 0
+	case math.IsNaN(in):
+		return out
+	case math.IsNaN(out):
+		return in
+	}
+	return in + math.Remainder(out-in, 2*math.Pi)/2
+}
+
+// parseAngle parses an angle in degrees, or in the deg, rad, grad or turn units.
+func parseAngle(v string) (float64, error) {
+	scale := math.Pi / 180
+	for _, u := range []struct {
+		unit  string
+		scale float64
+	}{{"deg", math.Pi / 180}, {"grad", math.Pi / 200}, {"rad", 1}, {"turn", 2 * math.Pi}} {
+		if strings.HasSuffix(v, u.unit) {
+			v, scale = strings.TrimSuffix(v, u.unit), u.scale
+			break
+		}
+	}
+	a, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	return a * scale, err
+}
+
+// pathVertices returns the vertices of path, whose coordinates are scaled by
+// coordScale if it is not zero, in the user units of the path. The point that
+// closes a subpath is a vertex, which the path enters from its last segment and
+// leaves along its first, as it enters its start.
+func pathVertices(path rasterx.Path, coordScale float64) []markerVertex {
+	unscale := 1.0 / 64
+	if coordScale != 0 {
+		unscale /= coordScale
+	}
+	var vertices []markerVertex
+	var cur, first [2]float64
+	subStart := 0
+	// segment adds the vertex at the end of a segment from cur through the
+	// control points pts, the last of which is the end.
+	segment := func(pts ...[2]float64) {
+		end := pts[len(pts)-1]
+		out, in := math.NaN(), math.NaN()
+		for _, p := range pts {
+			if p != cur {
+				out = math.Atan2(p[1]-cur[1], p[0]-cur[0])
+				break
+			}
+		}
+		for i := len(pts) - 2; i >= -1; i-- {
+			p := cur
+			if i >= 0 {
+				p = pts[i]
+			}
+			if p != end {
+				in = math.Atan2(end[1]-p[1], end[0]-p[0])
+				break
+			}
+		}
+		if last := &vertices[len(vertices)-1]; math.IsNaN(last.out) {
+			last.out = out
+		}
+		vertices = append(vertices, markerVertex{end[0], end[1], in, math.NaN()})
+		cur = end
+	}
+	for i := 0; i < len(path); {
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			n = 1
+		case rasterx.PathQuadTo:
+			n = 2
+		case rasterx.PathCubicTo:
+			n = 3
+		}
+		pts := make([][2]float64, n)
+		for j := range pts {
+			pts[j] = [2]float64{float64(path[i+1+2*j]) * unscale, float64(path[i+2+2*j]) * unscale}
+		}
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo:
+			cur, first, subStart = pts[0], pts[0], len(vertices)
+			vertices = append(vertices, markerVertex{cur[0], cur[1], math.NaN(), math.NaN()})
+		case rasterx.PathClose:
+			if len(vertices) == 0 {
+				break
+			}
+			if cur != first {
+				segment(first)
+			}
+			if end := &vertices[len(vertices)-1]; len(vertices)-1 > subStart {
+				end.out = vertices[subStart].out
+				vertices[subStart].in = end.in
+			}
+		default:
+			if len(vertices) > 0 {
+				segment(pts...)
+			}
+		}
+		i += 1 + 2*n
+	}
+	return vertices
+}
@@ -0,0 +1,424 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// marshal.go implements writing parsed icons back to SVG.
+
+package oksvg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// capNames and gapNames are the values of stroke-linecap and stroke-linegap
+// for the functions of capFuncs and gapFuncs, by index.
+var (
+	capNames = []string{"", "butt", "round", "square", "cubic", "quadratic"}
+	gapNames = []string{"", "flat", "round", "cubic", "quadratic"}
+)
+
+// joinNames are the values of stroke-linejoin, by rasterx.JoinMode.
+var joinNames = map[rasterx.JoinMode]string{rasterx.Arc: "arc", rasterx.ArcClip: "arc-clip",
+	rasterx.Miter: "miter", rasterx.MiterClip: "miter-clip", rasterx.Bevel: "bevel", rasterx.Round: "round"}
+
+// MarshalSVG writes the icon to w as an SVG document, so that an icon that was
+// read, recolored or transformed can be saved. Each path is written as a path
+// element with its transform and style, within g elements for its clip paths
+// and masks, and image paints as image elements. The gradients, patterns, clip
+// paths and masks the paths use are written in defs with generated ids, along
+// with the titles and descriptions of the icon.
+//
+// The document describes what oksvg draws, rather than the source it was read
+// from: groups, use elements and the definitions that are not drawn are not
+// kept, and the stroke width and oksvg extensions such as stroke-linegap are
+// written as oksvg reads them. Clip paths and masks applied to the content of
+// clip paths and masks are not written.
+func (s *SvgIcon) MarshalSVG(w io.Writer) error {
+	m := &svgMarshaler{ids: make(map[interface{}]string)}
+	cs := coordScaleFor(s.ViewBox)
+	var body bytes.Buffer
+	for i := range s.SVGPaths {
+		m.element(&body, &s.SVGPaths[i], cs)
+	}
+	if m.err != nil {
+		return m.err
+	}
+	var b bytes.Buffer
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="` + s.ViewBox.String() + `"`)
+	if s.physW > 0 && s.physH > 0 {
+		b.WriteString(` width="` + num(s.physW) + `mm" height="` + num(s.physH) + `mm"`)
+	}
+	if s.PreserveAspectRatio != "" {
+		writeAttr(&b, "preserveAspectRatio", s.PreserveAspectRatio)
+	}
+	b.WriteString(">\n")
+	for _, t := range []struct {
+		tag   string
+		texts []string
+	}{{"title", s.Titles}, {"desc", s.Descriptions}} {
+		for _, text := range t.texts {
+			b.WriteString("<" + t.tag + ">")
+			xml.EscapeText(&b, []byte(text))
+			b.WriteString("</" + t.tag + ">\n")
+		}
+	}
+	if m.defs.Len() > 0 {
+		b.WriteString("<defs>\n")
+		b.Write(m.defs.Bytes())
+		b.WriteString("</defs>\n")
+	}
+	b.Write(body.Bytes())
+	b.WriteString("</svg>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// svgMarshaler collects the definitions of the paths written by MarshalSVG.
+type svgMarshaler struct {
+	defs bytes.Buffer
+	ids  map[interface{}]string // ids of the written patterns and clip paths
+	n    int                    // number of ids generated
+	err  error
+}
+
+// newID returns a new id starting with prefix.
+func (m *svgMarshaler) newID(prefix string) string {
+	m.n++
+	return prefix + strconv.Itoa(m.n)
+}
+
+// element writes svgp to b, within a g element for each of its clip paths and
+// masks. The coordinates of its Path are scaled by cs, if it is not zero.
+func (m *svgMarshaler) element(b *bytes.Buffer, svgp *SvgPath, cs float64) {
+	for _, cp := range svgp.clips {
+		attr := "clip-path"
+		if cp.luminance {
+			attr = "mask"
+		}
+		b.WriteString("<g " + attr + `="url(#` + m.clipID(cp, cs) + `)">`)
+	}
+	m.shape(b, svgp, rasterx.Identity, cs)
+	for range svgp.clips {
+		b.WriteString("</g>")
+	}
+	b.WriteString("\n")
+}
+
+// shape writes svgp as a path element, or as an image element if it is painted
+// with an image, in a context with the transform parent.
+func (m *svgMarshaler) shape(b *bytes.Buffer, svgp *SvgPath, parent rasterx.Matrix2D, cs float64) {
+	t := parent.Invert().Mult(svgp.mAdder.M)
+	unscale := 1.0
+	if cs != 0 {
+		t, unscale = t.Scale(cs, cs), 1/cs
+	}
+	if p, ok := svgp.fillerColor.(imagePaint); ok {
+		var img bytes.Buffer
+		if err := png.Encode(&img, p.img); err != nil && m.err == nil {
+			m.err = err
+		}
+		vp, par := imageViewport(svgp.Path, p.rect)
+		b.WriteString(`<image x="` + num(vp.X*unscale) + `" y="` + num(vp.Y*unscale) +
+			`" width="` + num(vp.W*unscale) + `" height="` + num(vp.H*unscale) + `" preserveAspectRatio="` + par + `"`)
+		writeTransform(b, "transform", t)
+		if svgp.FillOpacity != 1 {
+			writeAttr(b, "opacity", num(svgp.FillOpacity))
+		}
+		if p.sampling == NearestSampling {
+			writeAttr(b, "image-rendering", "pixelated")
+		}
+		b.WriteString(` href="data:image/png;base64,` + base64.StdEncoding.EncodeToString(img.Bytes()) + `"/>`)
+		return
+	}
+	b.WriteString(`<path d="` + pathData(svgp.Path, unscale/64) + `"`)
+	writeTransform(b, "transform", t)
+	m.paint(b, "fill", svgp.fillerColor, svgp.FillOpacity, cs)
+	if !svgp.UseNonZeroWinding {
+		writeAttr(b, "fill-rule", "evenodd")
+	}
+	if svgp.linerColor != nil {
+		m.paint(b, "stroke", svgp.linerColor, svgp.LineOpacity, cs)
+		writeAttr(b, "stroke-width", num(svgp.LineWidth))
+		if name := capName(svgp.LineCap); name != "" {
+			writeAttr(b, "stroke-linecap", name)
+		}
+		if svgp.LeadLineCap != nil && !sameFunc(svgp.LeadLineCap, svgp.LineCap) {
+			if name := capName(svgp.LeadLineCap); name != "" {
+				writeAttr(b, "stroke-leadlinecap", name)
+			}
+		}
+		if svgp.LineGap != nil {
+			if name := gapName(svgp.LineGap); name != "" {
+				writeAttr(b, "stroke-linegap", name)
+			}
+		}
+		if name, ok := joinNames[svgp.LineJoin]; ok {
+			writeAttr(b, "stroke-linejoin", name)
+		}
+		writeAttr(b, "stroke-miterlimit", num(svgp.MiterLimit))
+		if len(svgp.Dash) > 0 {
+			dash := make([]string, len(svgp.Dash))
+			for i, d := range svgp.Dash {
+				dash[i] = num(d)
+			}
+			writeAttr(b, "stroke-dasharray", strings.Join(dash, " "))
+			if svgp.DashOffset != 0 {
+				writeAttr(b, "stroke-dashoffset", num(svgp.DashOffset))
+			}
+		}
+	}
+	b.WriteString("/>")
+}
+
+// paint writes the attribute, fill or stroke, for the paint p with opacity, and
+// the opacity attribute if it is not one.
+func (m *svgMarshaler) paint(b *bytes.Buffer, attr string, p interface{}, opacity float64, cs float64) {
+	switch p := p.(type) {
+	case nil:
+		writeAttr(b, attr, "none")
+		return
+	case color.Color:
+		var c string
+		c, opacity = hexColor(p, opacity)
+		writeAttr(b, attr, c)
+	case rasterx.Gradient:
+		writeAttr(b, attr, "url(#"+m.gradient(p, cs)+")")
+	case *Pattern:
+		writeAttr(b, attr, "url(#"+m.pattern(p)+")")
+	}
+	if opacity != 1 {
+		writeAttr(b, attr+"-opacity", num(opacity))
+	}
+}
+
+// gradient writes g to the definitions and returns its id. The coordinates of
+// the paths it paints are scaled by cs, if it is not zero.
+func (m *svgMarshaler) gradient(g rasterx.Gradient, cs float64) string {
+	id := m.newID("gradient")
+	var b bytes.Buffer
+	if g.IsRadial {
+		b.WriteString(`<radialGradient id="` + id + `" cx="` + num(g.Points[0]) + `" cy="` + num(g.Points[1]) +
+			`" fx="` + num(g.Points[2]) + `" fy="` + num(g.Points[3]) + `" r="` + num(g.Points[4]) + `"`)
+	} else {
+		b.WriteString(`<linearGradient id="` + id + `" x1="` + num(g.Points[0]) + `" y1="` + num(g.Points[1]) +
+			`" x2="` + num(g.Points[2]) + `" y2="` + num(g.Points[3]) + `"`)
+	}
+	if g.Units == rasterx.UserSpaceOnUse {
+		writeAttr(&b, "gradientUnits", "userSpaceOnUse")
+		if cs != 0 { // see scaledGradient
+			g.Matrix = rasterx.Identity.Scale(1/cs, 1/cs).Mult(g.Matrix)
+		}
+	}
+	switch g.Spread {
+	case rasterx.ReflectSpread:
+		writeAttr(&b, "spreadMethod", "reflect")
+	case rasterx.RepeatSpread:
+		writeAttr(&b, "spreadMethod", "repeat")
+	}
+	writeTransform(&b, "gradientTransform", g.Matrix)
+	b.WriteString(">\n")
+	for _, stop := range g.Stops {
+		b.WriteString(`<stop offset="` + num(stop.Offset) + `"`)
+		opacity := stop.Opacity
+		if stop.StopColor != nil {
+			var c string
+			c, opacity = hexColor(stop.StopColor, opacity)
+			writeAttr(&b, "stop-color", c)
+		}
+		if opacity != 1 {
+			writeAttr(&b, "stop-opacity", num(opacity))
+		}
+		b.WriteString("/>\n")
+	}
+	if g.IsRadial {
+		b.WriteString("</radialGradient>\n")
+	} else {
+		b.WriteString("</linearGradient>\n")
+	}
+	m.defs.Write(b.Bytes())
+	return id
+}
+
+// pattern writes p to the definitions, once, and returns its id.
+func (m *svgMarshaler) pattern(p *Pattern) string {
+	if id, ok := m.ids[p]; ok {
+		return id
+	}
+	id := m.newID("pattern")
+	m.ids[p] = id
+	var b bytes.Buffer
+	b.WriteString(`<pattern id="` + id + `" x="` + num(p.X) + `" y="` + num(p.Y) +
+		`" width="` + num(p.W) + `" height="` + num(p.H) + `"`)
+	if p.Units == rasterx.UserSpaceOnUse {
+		writeAttr(&b, "patternUnits", "userSpaceOnUse")
+	}
+	if p.ContentUnits == rasterx.ObjectBoundingBox {
+		writeAttr(&b, "patternContentUnits", "objectBoundingBox")
+	}
+	if p.ViewBox.W != 0 && p.ViewBox.H != 0 {
+		writeAttr(&b, "viewBox", p.ViewBox.String())
+		par := p.align
+		if p.slice {
+			par += " slice"
+		}
+		writeAttr(&b, "preserveAspectRatio", par)
+	}
+	writeTransform(&b, "patternTransform", p.Matrix)
+	b.WriteString(">\n")
+	for i := range p.Paths {
+		m.element(&b, &p.Paths[i], p.coordScale)
+	}
+	b.WriteString("</pattern>\n")
+	m.defs.Write(b.Bytes())
+	return id
+}
+
+// clipID writes the clip path or mask cp to the definitions, once, and returns
+// its id.
+func (m *svgMarshaler) clipID(cp *clipPath, cs float64) string {
+	if id, ok := m.ids[cp]; ok {
+		return id
+	}
+	tag, unitsAttr := "clipPath", "clipPathUnits"
+	if cp.luminance {
+		tag, unitsAttr = "mask", "maskContentUnits"
+	}
+	id := m.newID(tag)
+	m.ids[cp] = id
+	var b bytes.Buffer
+	b.WriteString("<" + tag + ` id="` + id + `"`)
+	if cp.bbox {
+		writeAttr(&b, unitsAttr, "objectBoundingBox")
+	}
+	b.WriteString(">\n")
+	for i := range cp.paths {
+		m.shape(&b, &cp.paths[i], rasterx.Identity, cs)
+		b.WriteString("\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	m.defs.Write(b.Bytes())
+	return id
+}
+
+// imageViewport returns the extent of the path filled with an image stretched
+// over rect, and the preserveAspectRatio value that lays the image out over it
+// as rect does: none if they are the same, and otherwise the slice of the image
+// aligned with the edges of the viewport it shares.
+func imageViewport(path rasterx.Path, rect ViewBox) (ViewBox, string) {
+	vp := pathExtent(path)
+	const eps = 1.0 / 32 // within the rounding of fixed point coordinates
+	near := func(a, b float64) bool { return math.Abs(a-b) <= eps }
+	if near(vp.X, rect.X) && near(vp.Y, rect.Y) && near(vp.W, rect.W) && near(vp.H, rect.H) {
+		return rect, "none"
+	}
+	align := func(lo, size, rlo, rsize float64, min, mid, max string) string {
+		switch {
+		case near(lo, rlo):
+			return min
+		case near(lo+size, rlo+rsize):
+			return max
+		}
+		return mid
+	}
+	return vp, align(vp.X, vp.W, rect.X, rect.W, "xMin", "xMid", "xMax") +
+		align(vp.Y, vp.H, rect.Y, rect.H, "YMin", "YMid", "YMax") + " slice"
+}
+
+// PathToSVG returns the path data of path, as written in the d attribute of a
+// path element, so that tools which change paths can write them back to SVG.
+// The fixed point coordinates of path are written as they are, without the
+// transform of the SvgPath they may come from. Arcs are converted to cubic
+// Bézier curves when icons are read, and are written as curves.
+func PathToSVG(path rasterx.Path) string {
+	return pathData(path, 1.0/64)
+}
+
+// String returns the path data of the path, as PathToSVG does. Transform maps
+// its coordinates to the user units of the ViewBox.
+func (svgp *SvgPath) String() string {
+	return PathToSVG(svgp.Path)
+}
+
+// pathData returns the path data of path, with its coordinates multiplied by k.
+func pathData(path rasterx.Path, k float64) string {
+	var b strings.Builder
+	for i := 0; i < len(path); {
+		var cmd string
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo:
+			cmd, n = "M", 1
+		case rasterx.PathLineTo:
+			cmd, n = "L", 1
+		case rasterx.PathQuadTo:
+			cmd, n = "Q", 2
+		case rasterx.PathCubicTo:
+			cmd, n = "C", 3
+		case rasterx.PathClose:
+			cmd = "Z"
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(cmd)
+		for j := 1; j <= 2*n; j++ {
+			b.WriteString(" " + num(float64(path[i+j])*k))
+		}
+		i += 1 + 2*n
+	}
+	return b.String()
+}
+
+// hexColor returns c as a #rrggbb color and opacity multiplied by the alpha of c.
+func hexColor(c color.Color, opacity float64) (string, float64) {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B), opacity * float64(n.A) / 0xff
+}
+
+// capName and gapName return the name in capNames or gapNames of f, or "" if f
+// is not defined by rasterx.
+func capName(f rasterx.CapFunc) string {
+	for i := 1; i < len(capFuncs); i++ {
+		if sameFunc(f, capFuncs[i]) {
+			return capNames[i]
+		}
+	}
+	return ""
+}
+
+func gapName(f rasterx.GapFunc) string {
+	for i := 1; i < len(gapFuncs); i++ {
+		if sameFunc(f, gapFuncs[i]) {
+			return gapNames[i]
+		}
+	}
+	return ""
+}
+
+// writeTransform writes the attribute for the matrix t, unless it is the identity.
+func writeTransform(b *bytes.Buffer, attr string, t rasterx.Matrix2D) {
+	if t != rasterx.Identity {
+		writeAttr(b, attr, "matrix("+num(t.A)+" "+num(t.B)+" "+num(t.C)+" "+num(t.D)+" "+num(t.E)+" "+num(t.F)+")")
+	}
+}
+
+// writeAttr writes the attribute with the escaped value v.
+func writeAttr(b *bytes.Buffer, attr, v string) {
+	b.WriteString(" " + attr + `="`)
+	xml.EscapeText(b, []byte(v))
+	b.WriteString(`"`)
+}
+
+// num formats v as the shortest number that reads back as v.
+func num(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// pages.go implements documents of several pages, such as print documents and
+// music scores, which are drawn page by page.
+
+package oksvg
+
+import (
+	"encoding/xml"
+
+	"github.com/srwiley/rasterx"
+)
+
+// Page is a page of a document of several pages, drawn as an icon of its own.
+type Page struct {
+	ID    string
+	Label string // the inkscape:label of the page, if any
+	// Icon draws the page, with the area of the page as its ViewBox. It shares
+	// the paths, gradients and definitions of the document, and its elements
+	// cannot be replaced.
+	Icon *SvgIcon
+}
+
+// pageSpan is a page read from the document.
+type pageSpan struct {
+	id, label string
+	area      ViewBox // the area of the page, or of the root svg element for the pages of a pageSet
+	// content is true for the pages of a pageSet, which draw the paths
+	// SVGPaths[first:end]
+	content    bool
+	first, end int
+}
+
+// pageF reads a page element, either an Inkscape page, which is an area of the
+// canvas with x, y, width and height attributes, or a page of an SVG 1.2
+// pageSet, which holds the elements drawn on it.
+var pageF svgFunc = func(c *IconCursor, attrs []xml.Attr) error {
+	p := pageSpan{area: c.icon.ViewBox, first: len(c.icon.SVGPaths), end: len(c.icon.SVGPaths), content: true}
+	var err error
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "id":
+			p.id = attr.Value
+		case "label":
+			p.label = attr.Value
+		case "x":
+			p.area.X, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "y":
+			p.area.Y, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		case "width":
+			p.content = false
+			p.area.W, err = c.parseLength(attr.Value, c.icon.ViewBox.W)
+		case "height":
+			p.content = false
+			p.area.H, err = c.parseLength(attr.Value, c.icon.ViewBox.H)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !p.content && (p.area.W <= 0 || p.area.H <= 0) {
+		return nil
+	}
+	c.icon.pages = append(c.icon.pages, p)
+	c.inPage = p.content
+	return nil
+}
+
+// endPage ends the page element that was read last, which ends the paths drawn
+// on a page of a pageSet.
+func (c *IconCursor) endPage() {
+	c.inPage = false
+	if n := len(c.icon.pages); n > 0 && c.icon.pages[n-1].content {
+		c.icon.pages[n-1].end = len(c.icon.SVGPaths)
+	}
+}
+
+// Pages returns the pages of the document in document order, so that print
+// documents exported from Inkscape, and documents with an SVG 1.2 pageSet, can
+// be rasterized page by page. The pages of Inkscape are areas of the canvas,
+// and each draws all that is within its area. The pages of a pageSet are drawn
+// in the viewBox of the document, and each draws the elements within it and
+// those outside of the pageSet, which are on every page. An icon without pages
+// has one page, which draws the whole icon.
+//
+// Draw and Rasterize draw the whole canvas of the document, with every page of
+// a pageSet on top of each other.
+func (s *SvgIcon) Pages() []Page {
+	if len(s.pages) == 0 {
+		return []Page{{Icon: s.pageIcon(s.ViewBox, s.SVGPaths)}}
+	}
+	pages := make([]Page, len(s.pages))
+	for i, p := range s.pages {
+		paths := s.SVGPaths
+		if p.content {
+			// Leave out the paths of the other pages of the pageSet
+			paths = make([]SvgPath, 0, len(s.SVGPaths))
+			next := 0
+			for j, q := range s.pages {
+				if !q.content || j == i {
+					continue
+				}
+				paths = append(paths, s.SVGPaths[next:q.first]...)
+				next = q.end
+			}
+			paths = append(paths, s.SVGPaths[next:]...)
+		}
+		pages[i] = Page{ID: p.id, Label: p.label, Icon: s.pageIcon(p.area, paths)}
+	}
+	return pages
+}
+
+// pageIcon returns an icon drawing paths, with the area of the icon s as its
+// ViewBox, and a physical size in proportion to that of s.
+func (s *SvgIcon) pageIcon(area ViewBox, paths []SvgPath) *SvgIcon {
+	page := *s
+	page.ViewBox, page.SVGPaths, page.Transform = area, paths, rasterx.Identity
+	page.elements, page.pages = nil, nil
+	if s.ViewBox.W > 0 && s.ViewBox.H > 0 {
+		page.physW *= area.W / s.ViewBox.W
+		page.physH *= area.H / s.ViewBox.H
+	}
+	return &page
+}
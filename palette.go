@@ -0,0 +1,164 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// palette.go implements extraction of the dominant colors of icons from their
+// paints, as for adaptive backgrounds.
+
+package oksvg
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// paletteMaxColors is the most distinct colors that Palette clusters; icons
+// with more, as from many gradients, are first reduced by coarser channels.
+const paletteMaxColors = 256
+
+// PaletteColor is one of the dominant colors of an icon.
+type PaletteColor struct {
+	Color  color.NRGBA // opaque; transparent paints count for less
+	Weight float64     // the share of the painted area of the icon, from 0 to 1
+}
+
+// Palette returns at most n dominant colors of the icon, in decreasing order of
+// weight, without rasterizing it. The colors of fills, strokes and gradient
+// stops are weighted by the area they paint, as tessellated by Tessellate at
+// one pixel per user unit, and by their opacity. Gradients share the area of a
+// path among their stops by the offsets of the stops. Colors are clustered,
+// merging the closest ones, until at most n remain. Patterns, images, clip
+// paths and masks are not taken into account, and paint that is covered by
+// other paths still counts.
+func (s *SvgIcon) Palette(n int) []PaletteColor {
+	if n <= 0 {
+		return nil
+	}
+	weights := make(map[color.NRGBA]float64)
+	add := func(c color.Color, w float64) {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		w *= float64(nc.A) / 0xFF
+		if w > 0 {
+			nc.A = 0xFF
+			weights[nc] += w
+		}
+	}
+	for _, mesh := range s.Tessellate(TessellateOptions{}) {
+		area := mesh.area() * mesh.Paint.Opacity
+		switch {
+		case mesh.Paint.Color != nil:
+			add(mesh.Paint.Color, area)
+		case mesh.Paint.Gradient != nil:
+			stops := mesh.Paint.Gradient.Stops
+			for i, stop := range stops {
+				if stop.StopColor == nil {
+					continue
+				}
+				// Each stop paints up to halfway to its neighbors, and the first
+				// and last stops paint the ends
+				lo, hi := 0.0, 1.0
+				if i > 0 {
+					lo = (stops[i-1].Offset + stop.Offset) / 2
+				}
+				if i < len(stops)-1 {
+					hi = (stop.Offset + stops[i+1].Offset) / 2
+				}
+				add(stop.StopColor, area*stop.Opacity*math.Max(0, hi-lo))
+			}
+		}
+	}
+	colors := clusterColors(weights, n)
+	var total float64
+	for _, c := range colors {
+		total += c.Weight
+	}
+	for i := range colors {
+		colors[i].Weight /= total
+	}
+	sort.SliceStable(colors, func(i, j int) bool { return colors[i].Weight > colors[j].Weight })
+	return colors
+}
+
+// area returns the area covered by the triangles of the mesh.
+func (m Mesh) area() float64 {
+	var a float64
+	v := m.Vertices
+	for i := 0; i+2 < len(m.Indices); i += 3 {
+		p, q, r := 2*m.Indices[i], 2*m.Indices[i+1], 2*m.Indices[i+2]
+		a += math.Abs(float64((v[q]-v[p])*(v[r+1]-v[p+1])-(v[q+1]-v[p+1])*(v[r]-v[p]))) / 2
+	}
+	return a
+}
+
+// clusterColors returns the colors of weights, merged into at most n colors.
+// The two closest colors are merged into their weighted mean until n remain.
+func clusterColors(weights map[color.NRGBA]float64, n int) []PaletteColor {
+	type cluster struct {
+		rgb [3]float64
+		w   float64
+	}
+	var clusters []cluster
+	// Colors are put in coarser bins until there are few enough to cluster
+	for shift := uint(0); ; shift++ {
+		bins := make(map[[3]uint8]int)
+		clusters = clusters[:0]
+		for c, w := range weights {
+			key := [3]uint8{c.R >> shift, c.G >> shift, c.B >> shift}
+			i, ok := bins[key]
+			if !ok {
+				i = len(clusters)
+				bins[key] = i
+				clusters = append(clusters, cluster{})
+			}
+			cl := &clusters[i]
+			for k, v := range []uint8{c.R, c.G, c.B} {
+				cl.rgb[k] += float64(v) * w
+			}
+			cl.w += w
+		}
+		if len(clusters) <= paletteMaxColors {
+			break
+		}
+	}
+	for i := range clusters {
+		for k := range clusters[i].rgb {
+			clusters[i].rgb[k] /= clusters[i].w
+		}
+	}
+	// The order of the map is random, so the clusters are sorted to merge ties
+	// the same way every time
+	sort.Slice(clusters, func(i, j int) bool {
+		a, b := clusters[i], clusters[j]
+		if a.w != b.w {
+			return a.w > b.w
+		}
+		return a.rgb[0]*65536+a.rgb[1]*256+a.rgb[2] < b.rgb[0]*65536+b.rgb[1]*256+b.rgb[2]
+	})
+	for len(clusters) > n {
+		bi, bj, best := 0, 1, math.Inf(1)
+		for i := range clusters {
+			for j := i + 1; j < len(clusters); j++ {
+				var d float64
+				for k := range clusters[i].rgb {
+					d += (clusters[i].rgb[k] - clusters[j].rgb[k]) * (clusters[i].rgb[k] - clusters[j].rgb[k])
+				}
+				if d < best {
+					bi, bj, best = i, j, d
+				}
+			}
+		}
+		a, b := clusters[bi], clusters[bj]
+		w := a.w + b.w
+		for k := range a.rgb {
+			a.rgb[k] = (a.rgb[k]*a.w + b.rgb[k]*b.w) / w
+		}
+		a.w = w
+		clusters[bi] = a
+		clusters = append(clusters[:bj], clusters[bj+1:]...)
+	}
+	colors := make([]PaletteColor, len(clusters))
+	for i, c := range clusters {
+		colors[i] = PaletteColor{Color: color.NRGBA{uint8(math.Round(c.rgb[0])), uint8(math.Round(c.rgb[1])),
+			uint8(math.Round(c.rgb[2])), 0xFF}, Weight: c.w}
+	}
+	return colors
+}
@@ -9,7 +9,6 @@ import (
 	"errors"
 	"log"
 	"math"
-	"unicode"
 
 	"github.com/srwiley/rasterx"
 
@@ -29,6 +28,7 @@ type (
 		lastKey                uint8
 		ErrorMode              ErrorMode
 		inPath                 bool
+		coordScale             float64 // if not zero, scales coordinates to fit fixed point range
 	}
 )
 
@@ -43,10 +43,45 @@ const (
 	StrictErrorMode
 )
 
+// ErrorPolicy sets how the parser reacts to each category of problem found
+// in an icon, for example to ignore unknown elements but fail on malformed paths.
+type ErrorPolicy struct {
+	UnknownElement   ErrorMode // elements and path commands that are not supported
+	MalformedValue   ErrorMode // element geometry, such as paths and points, that cannot be parsed
+	MissingReference ErrorMode // use elements and paint urls naming ids that are not defined
+}
+
+// Policy returns the ErrorPolicy that reacts to every category of problem with
+// the ErrorMode m.
+func (m ErrorMode) Policy() ErrorPolicy {
+	return ErrorPolicy{m, m, m}
+}
+
+// Diagnostic is a problem found while reading an icon that the ErrorPolicy let
+// the parser read past, by ignoring or logging it.
+type Diagnostic struct {
+	Pos SourcePos // location of the element the problem was found in
+	Err error
+}
+
 var (
 	errParamMismatch  = errors.New("param mismatch")
+	errNegativeDash   = errors.New("negative length in stroke-dasharray")
 	errCommandUnknown = errors.New("unknown command")
 	errZeroLengthID   = errors.New("zero length id")
+	errCoordOverflow  = errors.New("coordinate exceeds fixed point range")
+	errMissingRef     = errors.New("reference not found")
+	errTooManyInst    = errors.New("too many elements instantiated from definitions")
+	errImageTooLarge  = errors.New("image too large")
+	errDuplicateID    = errors.New("id already in use")
+)
+
+const (
+	// maxCoord is the largest coordinate magnitude representable in a fixed.Int26_6.
+	maxCoord = float64(math.MaxInt32) / 64
+	// maxSafeExtent is the largest viewBox extent drawn without scaling the
+	// coordinates; it leaves room for geometry and control points outside of the viewBox.
+	maxSafeExtent = 1 << 20
 )
 
 // ReadFloat reads a floating point value and adds it to the cursor's points slice.
@@ -78,40 +113,74 @@ func (c *PathCursor) ReadFloat(numStr string) error {
 // GetPoints reads a set of floating point values from the SVG format number string,
 // and add them to the cursor's points slice.
 func (c *PathCursor) GetPoints(dataPoints string) error {
-	lastIndex := -1
 	c.points = c.points[0:0]
-	lr := ' '
-	for i, r := range dataPoints {
-		if !unicode.IsNumber(r) && r != '.' && !(r == '-' && lr == 'e') && r != 'e' {
-			if lastIndex != -1 {
-				if err := c.ReadFloat(dataPoints[lastIndex:i]); err != nil {
-					return err
-				}
-			}
-			if r == '-' {
-				lastIndex = i
-			} else {
-				lastIndex = -1
-			}
-		} else if lastIndex == -1 {
-			lastIndex = i
+	for i := 0; i < len(dataPoints); {
+		if !isNumberStart(dataPoints[i]) {
+			i++
+			continue
 		}
-		lr = r
-	}
-	if lastIndex != -1 && lastIndex != len(dataPoints) {
-		if err := c.ReadFloat(dataPoints[lastIndex:]); err != nil {
+		j := scanNumber(dataPoints, i)
+		f, err := parseFloat(dataPoints[i:j], 64)
+		if err != nil {
 			return err
 		}
+		c.points = append(c.points, f)
+		i = j
 	}
 	return nil
 }
 
-// EllipseAt adds a path of an elipse centered at cx, cy of radius rx and ry
+// isNumberStart reports whether b can begin a number in SVG number lists.
+func isNumberStart(b byte) bool {
+	return (b >= '0' && b <= '9') || b == '.' || b == '-' || b == '+'
+}
+
+// scanNumber returns the index just past the number starting at s[i].
+// A second decimal point, or a sign that does not follow an exponent,
+// starts the next number, so "1.5.5-2" reads as 1.5, .5 and -2.
+func scanNumber(s string, i int) int {
+	if s[i] == '-' || s[i] == '+' {
+		i++
+	}
+	dot, exp := false, false
+	for ; i < len(s); i++ {
+		switch b := s[i]; {
+		case b >= '0' && b <= '9':
+		case b == '.' && !dot && !exp:
+			dot = true
+		case (b == 'e' || b == 'E') && !exp:
+			exp = true
+			if i+1 < len(s) && (s[i+1] == '-' || s[i+1] == '+') {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// EllipseAt adds a path of an ellipse centered at cx, cy of radius rx and ry
 // to the PathCursor
 func (c *PathCursor) EllipseAt(cx, cy, rx, ry float64) {
-	c.placeX, c.placeY = cx+rx, cy
+	c.RotatedEllipseAt(cx, cy, rx, ry, 0)
+}
+
+// ElipseAt adds a path of an ellipse centered at cx, cy of radius rx and ry
+// to the PathCursor
+//
+// Deprecated: use EllipseAt instead.
+func (c *PathCursor) ElipseAt(cx, cy, rx, ry float64) {
+	c.EllipseAt(cx, cy, rx, ry)
+}
+
+// RotatedEllipseAt adds a path of an ellipse centered at cx, cy of radius rx and ry,
+// with the x axis rotated around the center by rot degrees, to the PathCursor
+func (c *PathCursor) RotatedEllipseAt(cx, cy, rx, ry, rot float64) {
+	c.placeX, c.placeY = rasterx.Identity.Translate(cx, cy).
+		Rotate(rot*math.Pi/180).Translate(-cx, -cy).Transform(cx+rx, cy)
 	c.points = c.points[0:0]
-	c.points = append(c.points, rx, ry, 0.0, 1.0, 0.0, c.placeX, c.placeY)
+	c.points = append(c.points, rx, ry, rot, 1.0, 0.0, c.placeX, c.placeY)
 	c.Path.Start(fixed.Point26_6{
 		X: fixed.Int26_6(c.placeX * 64),
 		Y: fixed.Int26_6(c.placeY * 64)})
@@ -119,6 +188,79 @@ func (c *PathCursor) EllipseAt(cx, cy, rx, ry float64) {
 	c.Path.Stop(true)
 }
 
+// RoundRect adds a path of a rectangle with top left corner at x, y of size w and h,
+// with corners rounded by radius rx and ry, to the PathCursor
+func (c *PathCursor) RoundRect(x, y, w, h, rx, ry float64) {
+	if w == 0 || h == 0 {
+		return
+	}
+	rasterx.AddRoundRect(x, y, x+w, y+h, rx, ry, 0, rasterx.RoundGap, &c.Path)
+	c.placeX, c.placeY = x, y
+}
+
+// ArcAt adds an open path of a circular arc centered at cx, cy of radius r
+// to the PathCursor. The arc sweeps from startAngle to endAngle, in degrees
+// measured from the positive x axis towards the positive y axis.
+func (c *PathCursor) ArcAt(cx, cy, r, startAngle, endAngle float64) {
+	c.Path.Start(c.pointAtAngle(cx, cy, r, startAngle))
+	c.addArcSpan(cx, cy, r, startAngle, endAngle)
+	c.Path.Stop(false)
+}
+
+// PieAt adds a closed path of a pie wedge centered at cx, cy of radius r
+// to the PathCursor, spanning startAngle to endAngle in degrees.
+func (c *PathCursor) PieAt(cx, cy, r, startAngle, endAngle float64) {
+	c.Path.Start(rasterx.ToFixedP(cx, cy))
+	c.Path.Line(c.pointAtAngle(cx, cy, r, startAngle))
+	c.addArcSpan(cx, cy, r, startAngle, endAngle)
+	c.Path.Stop(true)
+	c.placeX, c.placeY = cx, cy
+}
+
+// RingSectorAt adds a closed path of a ring sector centered at cx, cy between
+// the inner radius r1 and the outer radius r2 to the PathCursor, spanning
+// startAngle to endAngle in degrees.
+func (c *PathCursor) RingSectorAt(cx, cy, r1, r2, startAngle, endAngle float64) {
+	c.Path.Start(c.pointAtAngle(cx, cy, r2, startAngle))
+	c.addArcSpan(cx, cy, r2, startAngle, endAngle)
+	c.Path.Line(c.pointAtAngle(cx, cy, r1, endAngle))
+	c.addArcSpan(cx, cy, r1, endAngle, startAngle)
+	c.Path.Stop(true)
+}
+
+// pointAtAngle returns the point on the circle at cx, cy of radius r at angle
+// degrees and sets it as the current place of the PathCursor
+func (c *PathCursor) pointAtAngle(cx, cy, r, angle float64) fixed.Point26_6 {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	c.placeX, c.placeY = cx+r*cos, cy+r*sin
+	return rasterx.ToFixedP(c.placeX, c.placeY)
+}
+
+// addArcSpan adds a circular arc from the current place of the PathCursor,
+// which must lie on the circle at startAngle, through to endAngle.
+// The span is split into pieces of at most a quarter turn so that full
+// circles and the large arc flag do not need special handling.
+func (c *PathCursor) addArcSpan(cx, cy, r, startAngle, endAngle float64) {
+	span := endAngle - startAngle
+	if span > 360 {
+		span = 360
+	} else if span < -360 {
+		span = -360
+	}
+	segs := int(math.Ceil(math.Abs(span) / 90))
+	var sweep float64
+	if span > 0 {
+		sweep = 1
+	}
+	for i := 1; i <= segs; i++ {
+		a := startAngle + span*float64(i)/float64(segs)
+		sin, cos := math.Sincos(a * math.Pi / 180)
+		ex, ey := cx+r*cos, cy+r*sin
+		c.placeX, c.placeY = rasterx.AddArc([]float64{r, r, 0, 0, sweep, ex, ey},
+			cx, cy, c.placeX, c.placeY, &c.Path)
+	}
+}
+
 // AddArcFromA adds a path of an arc element to the cursor path to the PathCursor
 func (c *PathCursor) AddArcFromA(points []float64) {
 	cx, cy := rasterx.FindEllipseCenter(&points[0], &points[1], points[2]*math.Pi/180, c.placeX,
@@ -129,23 +271,38 @@ func (c *PathCursor) AddArcFromA(points []float64) {
 // CompilePath translates the svgPath description string into a rasterx path.
 // All valid SVG path elements are interpreted to rasterx equivalents.
 // The resulting path element is stored in the PathCursor.
+// The description is read in a single pass, without intermediate strings,
+// so very long paths compile in time proportional to their length.
 func (c *PathCursor) CompilePath(svgPath string) error {
 	c.init()
-	lastIndex := -1
-	for i, v := range svgPath {
-		if unicode.IsLetter(v) && v != 'e' {
-			if lastIndex != -1 {
-				if err := c.addSeg(svgPath[lastIndex:i]); err != nil {
+	c.points = c.points[0:0]
+	var k uint8
+	for i := 0; i < len(svgPath); {
+		b := svgPath[i]
+		switch {
+		case isNumberStart(b):
+			j := scanNumber(svgPath, i)
+			f, err := parseFloat(svgPath[i:j], 64)
+			if err != nil {
+				return err
+			}
+			c.points = append(c.points, f)
+			i = j
+		case (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z'):
+			if k != 0 {
+				if err := c.addSeg(k); err != nil {
 					return err
 				}
 			}
-			lastIndex = i
+			k = b
+			c.points = c.points[0:0]
+			i++
+		default:
+			i++
 		}
 	}
-	if lastIndex != -1 {
-		if err := c.addSeg(svgPath[lastIndex:]); err != nil {
-			return err
-		}
+	if k != 0 {
+		return c.addSeg(k)
 	}
 	return nil
 }
@@ -202,16 +359,20 @@ func (c *PathCursor) reflectControlCube() {
 	}
 }
 
-// addSeg decodes an SVG seqment string into equivalent raster path commands saved
-// in the cursor's Path
-func (c *PathCursor) addSeg(segString string) error {
-	// Parse the string describing the numeric points in SVG format
-	if err := c.GetPoints(segString[1:]); err != nil {
-		return err
+// addSeg decodes an SVG segment of type k, with its numeric values already read
+// into the cursor's points, into equivalent raster path commands saved in the cursor's Path
+func (c *PathCursor) addSeg(k uint8) error {
+	n := len(c.Path)
+	if c.coordScale != 0 {
+		c.scalePoints(k)
 	}
 	l := len(c.points)
-	k := segString[0]
 	rel := false
+	if !c.inPath && len(c.Path) > 0 && k != 'm' && k != 'M' && k != 'z' && k != 'Z' {
+		// A subpath drawn after a closepath starts where the closed one did
+		c.Path.Start(fixed.Point26_6{X: fixed.Int26_6(c.placeX * 64), Y: fixed.Int26_6(c.placeY * 64)})
+		c.inPath = true
+	}
 	switch k {
 	case 'z':
 		fallthrough
@@ -384,9 +545,67 @@ func (c *PathCursor) addSeg(segString string) error {
 	}
 	// So we know how to extend some segment types
 	c.lastKey = k
+	if err := c.checkRange(); err != nil {
+		c.Path = c.Path[:n] // drop the segment rather than wrap around
+		return err
+	}
 	return nil
 }
 
+// scaled returns v multiplied by the coordinate scale of the PathCursor.
+func (c *PathCursor) scaled(v float64) float64 {
+	if c.coordScale == 0 {
+		return v
+	}
+	return v * c.coordScale
+}
+
+// scalePoints applies the coordinate scale of the PathCursor to the
+// coordinates within the points of a segment of type k.
+func (c *PathCursor) scalePoints(k uint8) {
+	if k == 'a' || k == 'A' {
+		for i := 0; i+6 < len(c.points); i += 7 {
+			c.points[i] *= c.coordScale
+			c.points[i+1] *= c.coordScale
+			c.points[i+5] *= c.coordScale
+			c.points[i+6] *= c.coordScale
+		}
+		return
+	}
+	for i := range c.points {
+		c.points[i] *= c.coordScale
+	}
+}
+
+// checkRange returns errCoordOverflow if the current place or points of the
+// PathCursor cannot be converted to fixed point without wrapping.
+func (c *PathCursor) checkRange() error {
+	if math.Abs(c.placeX) > maxCoord || math.Abs(c.placeY) > maxCoord {
+		return errCoordOverflow
+	}
+	for _, p := range c.points {
+		if math.Abs(p) > maxCoord {
+			return errCoordOverflow
+		}
+	}
+	return nil
+}
+
+// coordScaleFor returns the power of two scale that fits the coordinates of the viewBox
+// within maxSafeExtent, or zero if they already fit.
+func coordScaleFor(v ViewBox) float64 {
+	ext := math.Max(math.Max(math.Abs(v.X), math.Abs(v.Y)),
+		math.Max(math.Abs(v.X+v.W), math.Abs(v.Y+v.H)))
+	if !(ext > maxSafeExtent) || math.IsInf(ext, 0) {
+		return 0
+	}
+	s := 1.0
+	for ext*s > maxSafeExtent {
+		s /= 2
+	}
+	return s
+}
+
 func (c *PathCursor) init() {
 	c.placeX = 0.0
 	c.placeY = 0.0
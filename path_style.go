@@ -17,19 +17,82 @@ type PathStyle struct {
 	LineWidth, DashOffset, MiterLimit float64
 	Dash                              []float64
 	UseNonZeroWinding                 bool
-	fillerColor, linerColor           interface{} // either color.Color or rasterx.Gradient
+	fillerColor, linerColor           interface{} // color.Color, rasterx.Gradient, *Pattern or imagePaint
 	LineGap                           rasterx.GapFunc
-	LeadLineCap                       rasterx.CapFunc // This is used if different than LineCap
+	LeadLineCap                       rasterx.CapFunc // see StrokeStyle
 	LineCap                           rasterx.CapFunc
 	LineJoin                          rasterx.JoinMode
+	ContinueDash                      bool                // see StrokeStyle
 	mAdder                            rasterx.MatrixAdder // current transform
+	opacity                           float64             // product of opacity attributes, included in Fill and LineOpacity
+	fontSize                          float64             // computed font-size, for lengths in em units
+	clips                             []*clipPath         // clip paths and masks of the element and its ancestors
+	currentColor                      color.Color         // inherited color property, painted by currentColor
+	pixelated                         bool                // inherited image-rendering keeps the pixels of images sharp
+	markers                           *markerRefs         // inherited marker properties, nil if none
+	fillSet                           bool                // fill is set on the element or an ancestor
 }
 
-// styleAttribute describes draw options, such as {"fill":"black"; "stroke":"white"}.
-type styleAttribute = map[string]string
+// StrokeStyle holds the parameters and functions used to stroke a path.
+// It allows the stroke appearance of an SvgPath to be set at draw time
+// independently of the parsed style.
+type StrokeStyle struct {
+	LineWidth, DashOffset, MiterLimit float64
+	Dash                              []float64
+	// ContinueDash makes the dash pattern of each subpath carry on from where
+	// the previous subpath of the path ended, as diagram tools and some browsers
+	// draw it, instead of restarting at DashOffset.
+	ContinueDash bool
+	LineGap      rasterx.GapFunc
+	// LeadLineCap caps the leading end of each stroke, the end reached last in
+	// the direction of the path, and of each dash. LineCap caps the trailing
+	// end. If LeadLineCap is nil, LineCap is used at both ends. It is set by
+	// the non standard stroke-leadlinecap attribute.
+	LeadLineCap rasterx.CapFunc
+	LineCap     rasterx.CapFunc
+	LineJoin    rasterx.JoinMode
+}
+
+// StrokeStyle returns the stroke parameters of the PathStyle with
+// nil gap and cap functions resolved to those of the DefaultStyle.
+func (s *PathStyle) StrokeStyle() StrokeStyle {
+	ss := StrokeStyle{
+		LineWidth:    s.LineWidth,
+		DashOffset:   s.DashOffset,
+		MiterLimit:   s.MiterLimit,
+		Dash:         s.Dash,
+		ContinueDash: s.ContinueDash,
+		LineGap:      s.LineGap,
+		LeadLineCap:  s.LeadLineCap,
+		LineCap:      s.LineCap,
+		LineJoin:     s.LineJoin,
+	}
+	if ss.LineGap == nil {
+		ss.LineGap = DefaultStyle.LineGap
+	}
+	if ss.LineCap == nil {
+		ss.LineCap = DefaultStyle.LineCap
+	}
+	if ss.LeadLineCap == nil {
+		ss.LeadLineCap = ss.LineCap
+	}
+	return ss
+}
 
 // DefaultStyle sets the default PathStyle to fill black, winding rule,
 // full opacity, no stroke, ButtCap line end and Bevel line connect.
-var DefaultStyle = PathStyle{1.0, 1.0, 2.0, 0.0, 4.0, nil, true,
-	color.NRGBA{0x00, 0x00, 0x00, 0xff}, nil,
-	nil, nil, rasterx.ButtCap, rasterx.Bevel, rasterx.MatrixAdder{M: rasterx.Identity}}
+var DefaultStyle = PathStyle{
+	FillOpacity:       1.0,
+	LineOpacity:       1.0,
+	LineWidth:         2.0,
+	DashOffset:        0.0,
+	MiterLimit:        4.0,
+	UseNonZeroWinding: true,
+	fillerColor:       color.NRGBA{0x00, 0x00, 0x00, 0xff},
+	LineCap:           rasterx.ButtCap,
+	LineJoin:          rasterx.Bevel,
+	mAdder:            rasterx.MatrixAdder{M: rasterx.Identity},
+	opacity:           1,
+	fontSize:          16,
+	currentColor:      color.NRGBA{0x00, 0x00, 0x00, 0xff},
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// pattern.go implements the tiling of paths by pattern paint servers.
+
+package oksvg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// maxPatternTile is the largest width or height in pixels that a pattern tile is
+// rendered at. Tiles drawn larger are scaled up from it.
+const maxPatternTile = 2048
+
+// Pattern is a paint that repeats its paths over the plane in tiles, as the
+// pattern element does. A fill or stroke that refers to a pattern element holds
+// a *Pattern, as one referring to a gradient holds a rasterx.Gradient.
+type Pattern struct {
+	X, Y, W, H float64 // the first tile, in Units
+	// Units are the patternUnits, ObjectBoundingBox unless the pattern sets them
+	Units rasterx.GradientUnits
+	// ContentUnits are the patternContentUnits, UserSpaceOnUse unless the
+	// pattern sets them. They are ignored if the pattern has a ViewBox.
+	ContentUnits rasterx.GradientUnits
+	ViewBox      ViewBox          // mapped to each tile if its width and height are not zero
+	Matrix       rasterx.Matrix2D // the patternTransform
+	Paths        []SvgPath        // the content of a tile
+
+	align      string  // preserveAspectRatio of the ViewBox
+	slice      bool    // the ViewBox covers rather than fits the tile
+	coordScale float64 // scale of the path coordinates, see coordScaleFor
+}
+
+// patternURL returns the pattern named by the paint value v, or nil if v names
+// no pattern element, or, while the document is read, if its content uses
+// elements not read yet. The content of the pattern is read once per id, as a
+// use element would draw it in the user space of the pattern.
+func (c *IconCursor) patternURL(v string) (*Pattern, error) {
+	if !strings.HasPrefix(v, "url(") || !strings.HasSuffix(v, ")") {
+		return nil, nil
+	}
+	id := strings.TrimSpace(v[4 : len(v)-1])
+	if p, ok := c.patterns[id]; ok {
+		return p, nil
+	}
+	defs, ok := c.icon.Defs[strings.TrimPrefix(id, "#")]
+	if !ok || !strings.HasPrefix(id, "#") || defs[0].Tag != "pattern" {
+		return nil, nil
+	}
+	for _, u := range c.uses {
+		if u == id {
+			return nil, fmt.Errorf("pattern %s references itself", v)
+		}
+	}
+	p, err := c.readPattern(defs[0].Attrs)
+	if err != nil {
+		return nil, err
+	}
+	n, depth := len(c.icon.SVGPaths), len(c.StyleStack)
+	c.StyleStack = append(c.StyleStack, c.StyleStack[0])
+	c.uses = append(c.uses, id)
+	_, missed, err := c.collect(func() (bool, error) { return true, c.instantiate(defs, 0, 0) })
+	c.uses = c.uses[:len(c.uses)-1]
+	c.StyleStack = c.StyleStack[:depth]
+	p.Paths = append([]SvgPath(nil), c.icon.SVGPaths[n:]...)
+	c.icon.SVGPaths = c.icon.SVGPaths[:n]
+	if err != nil {
+		return nil, err
+	}
+	if missed {
+		return nil, nil // its content is read at the end of the document
+	}
+	if c.patterns == nil {
+		c.patterns = make(map[string]*Pattern)
+	}
+	c.patterns[id] = p
+	return p, nil
+}
+
+// readPattern reads the tile and units of a pattern element from its attrs.
+func (c *IconCursor) readPattern(attrs []xml.Attr) (*Pattern, error) {
+	p := &Pattern{Units: rasterx.ObjectBoundingBox, ContentUnits: rasterx.UserSpaceOnUse,
+		Matrix: rasterx.Identity, align: "xMidYMid", coordScale: c.coordScale}
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "patternUnits", "patternContentUnits":
+			units := rasterx.ObjectBoundingBox
+			if strings.TrimSpace(attr.Value) == "userSpaceOnUse" {
+				units = rasterx.UserSpaceOnUse
+			}
+			if attr.Name.Local == "patternUnits" {
+				p.Units = units
+			} else {
+				p.ContentUnits = units
+			}
+		}
+	}
+	var err error
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "x":
+			p.X, err = c.readPatternLength(p.Units, attr.Value, c.icon.ViewBox.W)
+		case "y":
+			p.Y, err = c.readPatternLength(p.Units, attr.Value, c.icon.ViewBox.H)
+		case "width":
+			p.W, err = c.readPatternLength(p.Units, attr.Value, c.icon.ViewBox.W)
+		case "height":
+			p.H, err = c.readPatternLength(p.Units, attr.Value, c.icon.ViewBox.H)
+		case "patternTransform":
+			p.Matrix, err = c.parseTransform(attr.Value)
+		case "viewBox":
+			if err = c.GetPoints(attr.Value); err == nil && len(c.points) != 4 {
+				err = errParamMismatch
+			}
+			if err == nil {
+				p.ViewBox = ViewBox{c.points[0], c.points[1], c.points[2], c.points[3]}
+			}
+		case "preserveAspectRatio":
+			if fields := strings.Fields(attr.Value); len(fields) > 0 {
+				p.align = fields[0]
+				p.slice = len(fields) > 1 && fields[1] == "slice"
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// readPatternLength reads a coordinate of the tile, a fraction of the bounding
+// box in ObjectBoundingBox units and a length, which may be a percentage of pct,
+// in user space.
+func (c *IconCursor) readPatternLength(units rasterx.GradientUnits, v string, pct float64) (float64, error) {
+	if units == rasterx.ObjectBoundingBox {
+		return readFraction(v)
+	}
+	return c.parseLength(v, pct)
+}
+
+// colorFunction returns the rasterx.ColorFunc painting the pattern for path,
+// drawn with transform m. One tile is rendered at the scale of the device and
+// repeated.
+func (p *Pattern) colorFunction(m rasterx.Matrix2D, path rasterx.Path, opacity float64,
+	tb TessellationBudget) rasterx.ColorFunc {
+	transparent := func(x, y int) color.Color { return color.Transparent }
+	toUser := rasterx.Identity
+	if p.coordScale != 0 {
+		toUser = toUser.Scale(1/p.coordScale, 1/p.coordScale)
+	}
+	bbox := pathBounds(path)
+	bbox.X, bbox.Y = toUser.Transform(bbox.X, bbox.Y)
+	bbox.W, bbox.H = toUser.TransformVector(bbox.W, bbox.H)
+	x, y, w, h := p.X, p.Y, p.W, p.H
+	if p.Units == rasterx.ObjectBoundingBox {
+		x, y, w, h = bbox.X+x*bbox.W, bbox.Y+y*bbox.H, w*bbox.W, h*bbox.H
+	}
+	if !(w > 0 && h > 0) {
+		return transparent
+	}
+	content := rasterx.Identity
+	switch {
+	case p.ViewBox.W > 0 && p.ViewBox.H > 0:
+		content = viewBoxTransform(p.ViewBox, p.align, p.slice, w, h)
+	case p.ContentUnits == rasterx.ObjectBoundingBox:
+		content = content.Scale(bbox.W, bbox.H)
+	}
+	// The transform of the tile from pattern space to the device
+	toDevice := m.Mult(toUser.Invert()).Mult(p.Matrix).Translate(x, y)
+	sx := math.Min(math.Ceil(w*math.Hypot(toDevice.A, toDevice.B)), maxPatternTile)
+	sy := math.Min(math.Ceil(h*math.Hypot(toDevice.C, toDevice.D)), maxPatternTile)
+	if !(sx >= 1 && sy >= 1) {
+		return transparent
+	}
+	pw, ph := int(sx), int(sy)
+	sx, sy = sx/w, sy/h
+	tile := image.NewRGBA(image.Rect(0, 0, pw, ph))
+	r := rasterx.NewDasher(pw, ph, rasterx.NewScannerGV(pw, ph, tile, tile.Bounds()))
+	for _, svgp := range p.Paths {
+		svgp.drawBudgeted(r, 1, rasterx.Identity.Scale(sx, sy).Mult(content), svgp.StrokeStyle(), tb)
+	}
+	inv := toDevice.Invert()
+	return func(x, y int) color.Color {
+		tx, ty := inv.Transform(float64(x)+0.5, float64(y)+0.5)
+		tx, ty = tx-w*math.Floor(tx/w), ty-h*math.Floor(ty/h)
+		c := tile.RGBAAt(minInt(int(tx*sx), pw-1), minInt(int(ty*sy), ph-1))
+		return color.RGBA{uint8(float64(c.R) * opacity), uint8(float64(c.G) * opacity),
+			uint8(float64(c.B) * opacity), uint8(float64(c.A) * opacity)}
+	}
+}
+
+// pathBounds returns the bounding box of the points of path, including the
+// control points of its curves.
+func pathBounds(path rasterx.Path) ViewBox {
+	mnx, mny := math.Inf(1), math.Inf(1)
+	mxx, mxy := math.Inf(-1), math.Inf(-1)
+	for i := 0; i < len(path); {
+		n := 0
+		switch rasterx.PathCommand(path[i]) {
+		case rasterx.PathMoveTo, rasterx.PathLineTo:
+			n = 1
+		case rasterx.PathQuadTo:
+			n = 2
+		case rasterx.PathCubicTo:
+			n = 3
+		}
+		for j := 0; j < n; j++ {
+			x, y := float64(path[i+1+2*j])/64, float64(path[i+2+2*j])/64
+			mnx, mny = math.Min(mnx, x), math.Min(mny, y)
+			mxx, mxy = math.Max(mxx, x), math.Max(mxy, y)
+		}
+		i += 1 + 2*n
+	}
+	if mnx > mxx {
+		return ViewBox{}
+	}
+	return ViewBox{X: mnx, Y: mny, W: mxx - mnx, H: mxy - mny}
+}
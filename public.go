@@ -29,83 +29,281 @@ import (
 // if it does not handle an element found in the icon file. Ignore warnings is
 // the default if no ErrorMode value is provided.
 func ReadIconStream(stream io.Reader, errMode ...ErrorMode) (*SvgIcon, error) {
-	icon := &SvgIcon{Defs: make(map[string][]definition), Grads: make(map[string]*rasterx.Gradient), Transform: rasterx.Identity}
-	cursor := &IconCursor{StyleStack: []PathStyle{DefaultStyle}, icon: icon}
+	var mode ErrorMode
 	if len(errMode) > 0 {
-		cursor.ErrorMode = errMode[0]
+		mode = errMode[0]
+	}
+	return ReadIconStreamPolicy(stream, mode.Policy())
+}
+
+// ReadIconStreamPolicy reads the Icon from the given io.Reader as ReadIconStream
+// does, reacting to each category of problem found in the icon as set by policy.
+func ReadIconStreamPolicy(stream io.Reader, policy ErrorPolicy) (*SvgIcon, error) {
+	return ReadIconStreamOptions(stream, ParseOptions{ErrorPolicy: policy})
+}
+
+// ParseOptions configures how ReadIconStreamOptions reads an icon.
+type ParseOptions struct {
+	ErrorPolicy ErrorPolicy
+	// ColorScheme selects the @media (prefers-color-scheme) rules of the
+	// style sheets of the icon that apply.
+	ColorScheme ColorScheme
+	// LowMemory discards what is not needed to draw the icon: titles,
+	// descriptions, source positions and the elements that ReplaceElement
+	// needs are not recorded, and the definitions and gradients, which are
+	// copied into the paths that use them, are dropped once the document is
+	// read, as references to them can be found until then. The paths are
+	// stored in one compact allocation.
+	LowMemory bool
+	// Arena, if not nil, allocates the paths and gradients of the icon.
+	Arena *Arena
+	// DPI is the number of user units per inch that lengths in absolute units,
+	// such as mm and pt, are converted at. If zero, 96 is used, as in CSS.
+	DPI float64
+	// ImageSampling is how images painted in the icon are interpolated when
+	// scaled, unless their image-rendering property asks for pixelated images,
+	// which are always drawn with NearestSampling.
+	ImageSampling ImageSampling
+	// ColorFallback, if not nil, paints fills, strokes and gradient stops whose
+	// color cannot be parsed, and paints whose url names nothing, instead of
+	// the icon failing to be read or the paint being black. Each of them is
+	// reported as the ErrorPolicy says, and recorded in the Diagnostics of the
+	// icon unless that stops the reading. An opaque magenta makes them stand out
+	// while icons are designed, and a transparent color hides them in production.
+	ColorFallback color.Color
+	// ImageLoader, if not nil, returns the images of image elements whose href
+	// is not a data URI. If nil, such image elements draw nothing.
+	ImageLoader ImageLoader
+	// StrokeOnlyUnfilled leaves shapes unfilled when they are stroked and no
+	// fill is set on them or their ancestors, by attributes or style sheets.
+	// By the SVG specification such shapes are filled black, but icon sets
+	// converted from icon fonts and outline drawings often expect them to be
+	// outlines only.
+	StrokeOnlyUnfilled bool
+	// MaxInstances is the most elements that use elements, clip paths, masks,
+	// patterns and markers may instantiate from definitions in one icon, so
+	// that use elements nested to expand exponentially cannot exhaust memory.
+	// Instances beyond it are reported as malformed values. If zero,
+	// DefaultMaxInstances is used.
+	MaxInstances int
+	// MaxImagePixels is the most pixels, width times height, of an image in a
+	// data URI that is decoded. Larger images are reported as malformed values
+	// and not drawn, so that a small compressed image cannot take gigabytes of
+	// memory. Images returned by the ImageLoader are not limited. If zero,
+	// DefaultMaxImagePixels is used.
+	MaxImagePixels int
+}
+
+// DefaultMaxImagePixels is the MaxImagePixels of ParseOptions that leave it
+// zero, enough for a 4096 by 4096 image.
+const DefaultMaxImagePixels = 1 << 24
+
+// DefaultMaxInstances is the MaxInstances of ParseOptions that leave it zero.
+const DefaultMaxInstances = 1 << 18
+
+// ColorScheme is the color scheme an icon is rendered for.
+type ColorScheme uint8
+
+// ColorScheme constants
+const (
+	LightColorScheme ColorScheme = iota
+	DarkColorScheme
+)
+
+// ReadIconStreamOptions reads the Icon from the given io.Reader as ReadIconStream
+// does, with the options opts.
+//
+// References to elements and gradients defined later in the document, as in
+// sprite sheets with their defs at the end, are resolved once the whole
+// document is read, so the stream is read once. Only references that are still
+// missing then are reported as the ErrorPolicy says, at the location of the
+// element that makes them.
+func ReadIconStreamOptions(stream io.Reader, opts ParseOptions) (*SvgIcon, error) {
+	icon, err := readIcon(stream, opts)
+	if err != nil {
+		return icon, err
 	}
+	icon.readOpts = opts
+	icon.readOpts.Arena = nil // paths added later are not allocated in the arena
+	if opts.LowMemory {
+		icon.compact(opts.Arena == nil)
+	}
+	if opts.Arena != nil {
+		icon.SVGPaths = opts.Arena.copyPaths(icon.SVGPaths)
+	}
+	return icon, nil
+}
+
+// readIcon reads an icon from stream with the options opts.
+func readIcon(stream io.Reader, opts ParseOptions) (*SvgIcon, error) {
+	icon := &SvgIcon{Defs: make(map[string][]definition), Grads: make(map[string]*rasterx.Gradient), Transform: rasterx.Identity}
+	err := newIconCursor(icon, opts).read(stream, opts)
+	return icon, err
+}
+
+// newIconCursor returns a cursor reading into icon with the options opts.
+func newIconCursor(icon *SvgIcon, opts ParseOptions) *IconCursor {
+	cursor := &IconCursor{StyleStack: []PathStyle{DefaultStyle}, icon: icon, ErrorPolicy: opts.ErrorPolicy, arena: opts.Arena, dpi: opts.DPI,
+		sampling: opts.ImageSampling, imageLoader: opts.ImageLoader, strokeOnlyUnfilled: opts.StrokeOnlyUnfilled, maxInstances: opts.MaxInstances, maxImagePixels: opts.MaxImagePixels,
+		lowMemory: opts.LowMemory}
+	if cursor.maxInstances == 0 {
+		cursor.maxInstances = DefaultMaxInstances
+	}
+	if cursor.maxImagePixels == 0 {
+		cursor.maxImagePixels = DefaultMaxImagePixels
+	}
+	if opts.ColorFallback != nil {
+		cursor.colorFallback = color.NRGBAModel.Convert(opts.ColorFallback) // as parsed colors are
+	}
+	cursor.ErrorMode = opts.ErrorPolicy.UnknownElement // for unknown path commands
+	return cursor
+}
+
+// read reads the elements of stream into the icon of the cursor.
+func (c *IconCursor) read(stream io.Reader, opts ParseOptions) error {
+	icon := c.icon
 	classInfo := ""
+	lines := &lineReader{r: stream, noPos: opts.LowMemory}
+	stream = lines
+	var (
+		raw     *bytes.Buffer // keeps the input from rawBase on for the raw XML of foreignObject elements
+		rawBase int64
+	)
+	if foreignObjectRenderer != nil {
+		raw = &bytes.Buffer{}
+		stream = io.TeeReader(stream, raw)
+	}
 	decoder := xml.NewDecoder(stream)
 	decoder.CharsetReader = charset.NewReaderLabel
+	// Streamed paths cannot wait for the references that follow them
+	c.late.deferring = c.handler == nil
 	for {
+		if err := c.flush(); err != nil {
+			return err
+		}
+		start := decoder.InputOffset()
+		if raw != nil { // only the input from the current token on can be needed
+			raw.Next(int(start - rawBase))
+			rawBase = start
+		}
 		t, err := decoder.Token()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return icon, err
+			return err
 		}
 		// Inspect the type of the XML token
 		switch se := t.(type) {
 		case xml.StartElement:
+			c.pos = lines.pos(start)
 			// Reads all recognized style attributes from the start element
 			// and places it on top of the styleStack
-			err = cursor.PushStyle(se.Attr)
+			err = c.pushStyle(se.Name.Local, se.Attr)
 			if err != nil {
-				return icon, err
+				return err
+			}
+			c.ids = append(c.ids, elementID(se.Attr))
+			c.openElement(se.Name.Local)
+			if se.Name.Local == "foreignObject" && raw != nil && !c.inDefs {
+				if err = decoder.Skip(); err != nil {
+					return err
+				}
+				err = c.readForeignObject(se, raw.Bytes()[:decoder.InputOffset()-rawBase])
+				if err != nil {
+					return err
+				}
+				c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
+				c.ids = c.ids[:len(c.ids)-1]
+				c.closeElement()
+				continue
 			}
-			err = cursor.readStartElement(se)
+			err = c.readStartElement(se)
 			if err != nil {
-				return icon, err
+				return err
 			}
-			if se.Name.Local == "style" && cursor.inDefs {
-				cursor.inDefsStyle = true
+			if se.Name.Local == "style" && c.inDefs {
+				c.inDefsStyle = true
 			}
 		case xml.EndElement:
 			// pop style
-			cursor.StyleStack = cursor.StyleStack[:len(cursor.StyleStack)-1]
+			c.StyleStack = c.StyleStack[:len(c.StyleStack)-1]
+			c.ids = c.ids[:len(c.ids)-1]
+			c.closeElement()
+			// Only the defs element that started the definitions ends them, not
+			// those nested in it
+			endsDefs := len(c.defStarts) == 0
+			if c.inDefs && !endsDefs {
+				c.endDef(se.Name.Local)
+			}
 			switch se.Name.Local {
-			case "g":
-				if cursor.inDefs {
-					cursor.currentDef = append(cursor.currentDef, definition{
-						Tag: "endg",
-					})
-				}
 			case "title":
-				cursor.inTitleText = false
+				if c.inTitleText && c.textOwned {
+					icon.titles = setText(icon.titles, c.textID, icon.Titles[len(icon.Titles)-1])
+				}
+				c.inTitleText = false
 			case "desc":
-				cursor.inDescText = false
+				if c.inDescText && c.textOwned {
+					icon.descriptions = setText(icon.descriptions, c.textID, icon.Descriptions[len(icon.Descriptions)-1])
+				}
+				c.inDescText = false
 			case "defs":
-				if len(cursor.currentDef) > 0 {
-					cursor.icon.Defs[cursor.currentDef[0].ID] = cursor.currentDef
-					cursor.currentDef = make([]definition, 0)
+				if endsDefs {
+					c.currentDef = c.currentDef[:0]
+					c.inDefs = false
 				}
-				cursor.inDefs = false
+			case "page":
+				c.endPage()
 			case "radialGradient", "linearGradient":
-				cursor.inGrad = false
+				c.inGrad = false
+				c.inheritStops()
 
 			case "style":
-				if cursor.inDefsStyle {
-					icon.classes, err = parseClasses(classInfo)
+				if c.inDefsStyle {
+					rules, err := parseStyleSheet(selectMedia(classInfo, opts.ColorScheme))
 					if err != nil {
-						return icon, err
+						return err
 					}
-					cursor.inDefsStyle = false
+					icon.styleRules = append(icon.styleRules, rules...)
+					c.inDefsStyle = false
+					classInfo = ""
 				}
 			}
 		case xml.CharData:
-			if cursor.inTitleText {
+			if c.inDefsStyle {
+				classInfo += string(se)
+			}
+			if c.inTitleText {
 				icon.Titles[len(icon.Titles)-1] += string(se)
 			}
-			if cursor.inDescText {
+			if c.inDescText {
 				icon.Descriptions[len(icon.Descriptions)-1] += string(se)
 			}
-			if cursor.inDefsStyle {
-				classInfo = string(se)
-			}
 		}
 	}
-	return icon, nil
+	return c.resolveLate()
+}
+
+// setSource records the source location of the definition id in m, creating m
+// if needed. Locations are not recorded when they are unknown.
+func setSource(m map[string]SourcePos, id string, pos SourcePos) map[string]SourcePos {
+	if pos == (SourcePos{}) {
+		return m
+	}
+	if m == nil {
+		m = make(map[string]SourcePos)
+	}
+	m[id] = pos
+	return m
+}
+
+// setText associates text with the element id in m, creating m if needed.
+func setText(m map[string]string, id, text string) map[string]string {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[id] = text
+	return m
 }
 
 // ReadReplacingCurrentColor replaces currentColor value with specified value and loads SvgIcon as ReadIconStream do.
@@ -144,6 +342,12 @@ func ReadIcon(iconFile string, errMode ...ErrorMode) (*SvgIcon, error) {
 	return ReadIconStream(fin, errMode...)
 }
 
+// ReadIconBytes reads the Icon from data, such as an SVG embedded with go:embed,
+// as ReadIconStream does.
+func ReadIconBytes(data []byte, errMode ...ErrorMode) (*SvgIcon, error) {
+	return ReadIconStream(bytes.NewReader(data), errMode...)
+}
+
 // ParseSVGColorNum reads the SFG color string e.g. #FBD9BD
 func ParseSVGColorNum(colorStr string) (r, g, b uint8, err error) {
 	colorStr = strings.TrimPrefix(colorStr, "#")
@@ -174,6 +378,139 @@ func ParseSVGColorNum(colorStr string) (r, g, b uint8, err error) {
 	return
 }
 
+// parseHSL parses the arguments of an hsl or hsla color in the CSS Color 4
+// syntax: a hue, with deg, rad, grad or turn units and degrees if none,
+// saturation and lightness percentages, and an optional alpha number or
+// percentage. Arguments are separated by commas or by spaces, with the alpha
+// after a slash in that case. Out of range values are clamped.
+func parseHSL(args string) (color.Color, error) {
+	vals := colorArgs(args)
+	if len(vals) != 3 && len(vals) != 4 {
+		return color.NRGBA{}, errParamMismatch
+	}
+	H, err := parseHue(vals[0])
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hue in hsl: '%s' (%s)", vals[0], err)
+	}
+	S, err := parseFraction(vals[1], 100)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid saturation in hsl: '%s' (%s)", vals[1], err)
+	}
+	L, err := parseFraction(vals[2], 100)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid lightness in hsl: '%s' (%s)", vals[2], err)
+	}
+	A := 1.0
+	if len(vals) == 4 {
+		if A, err = parseFraction(vals[3], 1); err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid alpha in hsl: '%s' (%s)", vals[3], err)
+		}
+	}
+
+	C := (1 - math.Abs((2*L)-1)) * S
+	X := C * (1 - math.Abs(math.Mod(H/60, 2)-1))
+	m := L - C/2
+
+	var rp, gp, bp float64
+	if H < 60 {
+		rp, gp, bp = C, X, 0
+	} else if H < 120 {
+		rp, gp, bp = X, C, 0
+	} else if H < 180 {
+		rp, gp, bp = 0, C, X
+	} else if H < 240 {
+		rp, gp, bp = 0, X, C
+	} else if H < 300 {
+		rp, gp, bp = X, 0, C
+	} else {
+		rp, gp, bp = C, 0, X
+	}
+	return color.NRGBA{
+		uint8(math.Round(clamp01(rp+m) * 255)),
+		uint8(math.Round(clamp01(gp+m) * 255)),
+		uint8(math.Round(clamp01(bp+m) * 255)),
+		uint8(math.Round(A * 255)),
+	}, nil
+}
+
+// parseRGB parses the arguments of an rgb or rgba color, in the same syntaxes
+// as parseHSL: three numbers from 0 to 255 or percentages, and an optional
+// alpha number or percentage. Out of range values are clamped.
+func parseRGB(args string) (color.Color, error) {
+	vals := colorArgs(args)
+	if len(vals) != 3 && len(vals) != 4 {
+		return color.NRGBA{}, errParamMismatch
+	}
+	var c [4]uint8
+	var err error
+	for i := 0; i < 3; i++ {
+		if c[i], err = parseColorValue(vals[i]); err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid component in rgb: '%s' (%s)", vals[i], err)
+		}
+	}
+	A := 1.0
+	if len(vals) == 4 {
+		if A, err = parseFraction(vals[3], 1); err != nil {
+			return color.NRGBA{}, fmt.Errorf("invalid alpha in rgb: '%s' (%s)", vals[3], err)
+		}
+	}
+	return color.NRGBA{c[0], c[1], c[2], uint8(math.Round(A * 255))}, nil
+}
+
+// colorArgs splits the arguments of a color function, separated by commas or
+// by spaces with the alpha after a slash.
+func colorArgs(args string) []string {
+	var vals []string
+	if strings.Contains(args, ",") {
+		vals = strings.Split(args, ",")
+	} else {
+		vals = strings.Fields(strings.Replace(args, "/", " ", 1))
+	}
+	for i := range vals {
+		vals[i] = strings.TrimSpace(vals[i])
+	}
+	return vals
+}
+
+// hueUnits are the number of degrees in each CSS angle unit.
+var hueUnits = []struct {
+	suffix  string
+	degrees float64
+}{{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}}
+
+// parseHue parses a CSS hue angle into degrees in the range [0, 360).
+func parseHue(s string) (float64, error) {
+	scale := 1.0
+	for _, u := range hueUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = s[:len(s)-len(u.suffix)], u.degrees
+			break
+		}
+	}
+	h, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	h = math.Mod(h*scale, 360)
+	if h < 0 {
+		h += 360
+	}
+	return h, nil
+}
+
+// parseFraction parses a percentage, or a number out of max, into the range [0, 1].
+func parseFraction(s string, max float64) (float64, error) {
+	if strings.HasSuffix(s, "%") {
+		s, max = s[:len(s)-1], 100
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return clamp01(f / max), err
+}
+
+func clamp01(f float64) float64 {
+	return math.Max(0, math.Min(1, f))
+}
+
 // ParseSVGColor parses an SVG color string in all forms
 // including all SVG1.1 names, obtained from the image.colornames package
 func ParseSVGColor(colorStr string) (color.Color, error) {
@@ -188,6 +525,8 @@ func ParseSVGColor(colorStr string) (color.Color, error) {
 		// nil signals that the function (fill or stroke) is off;
 		// not the same as black
 		return nil, nil
+	case "transparent":
+		return color.NRGBA{}, nil
 	default:
 		cn, ok := colornames.Map[v]
 		if ok {
@@ -195,93 +534,36 @@ func ParseSVGColor(colorStr string) (color.Color, error) {
 			return color.NRGBA{uint8(r), uint8(g), uint8(b), uint8(a)}, nil
 		}
 	}
-	cStr := strings.TrimPrefix(colorStr, "rgb(")
-	if cStr != colorStr {
-		cStr := strings.TrimSuffix(cStr, ")")
-		vals := strings.Split(cStr, ",")
-		if len(vals) != 3 {
-			return color.NRGBA{}, errParamMismatch
-		}
-		var cvals [3]uint8
-		var err error
-		for i := range cvals {
-			cvals[i], err = parseColorValue(vals[i])
-			if err != nil {
-				return nil, err
-			}
+	for _, fn := range []string{"rgb(", "rgba("} {
+		if strings.HasPrefix(v, fn) && strings.HasSuffix(v, ")") {
+			return parseRGB(v[len(fn) : len(v)-1])
 		}
-		return color.NRGBA{cvals[0], cvals[1], cvals[2], 0xFF}, nil
 	}
-
-	cStr = strings.TrimPrefix(colorStr, "hsl(")
-	if cStr != colorStr {
-		cStr := strings.TrimSuffix(cStr, ")")
-		vals := strings.Split(cStr, ",")
-		if len(vals) != 3 {
-			return color.NRGBA{}, errParamMismatch
-		}
-
-		H, err := strconv.ParseInt(strings.TrimSpace(vals[0]), 10, 64)
-		if err != nil {
-			return color.NRGBA{}, fmt.Errorf("invalid hue in hsl: '%s' (%s)", vals[0], err)
-		}
-
-		S, err := strconv.ParseFloat(strings.TrimSpace(vals[1][:len(vals[1])-1]), 64)
-		if err != nil {
-			return color.NRGBA{}, fmt.Errorf("invalid saturation in hsl: '%s' (%s)", vals[1], err)
+	for _, fn := range []string{"hsl(", "hsla("} {
+		if strings.HasPrefix(v, fn) && strings.HasSuffix(v, ")") {
+			return parseHSL(v[len(fn) : len(v)-1])
 		}
-		S = S / 100
-
-		L, err := strconv.ParseFloat(strings.TrimSpace(vals[2][:len(vals[2])-1]), 64)
-		if err != nil {
-			return color.NRGBA{}, fmt.Errorf("invalid lightness in hsl: '%s' (%s)", vals[2], err)
-		}
-		L = L / 100
-
-		C := (1 - math.Abs((2*L)-1)) * S
-		X := C * (1 - math.Abs(math.Mod((float64(H)/60), 2)-1))
-		m := L - C/2
-
-		var rp, gp, bp float64
-		if H < 60 {
-			rp, gp, bp = float64(C), float64(X), float64(0)
-		} else if H < 120 {
-			rp, gp, bp = float64(X), float64(C), float64(0)
-		} else if H < 180 {
-			rp, gp, bp = float64(0), float64(C), float64(X)
-		} else if H < 240 {
-			rp, gp, bp = float64(0), float64(X), float64(C)
-		} else if H < 300 {
-			rp, gp, bp = float64(X), float64(0), float64(C)
-		} else {
-			rp, gp, bp = float64(C), float64(0), float64(X)
-		}
-
-		r, g, b := math.Round((rp+m)*255), math.Round((gp+m)*255), math.Round((bp+m)*255)
-		if r > 255 {
-			r = 255
-		}
-		if g > 255 {
-			g = 255
-		}
-		if b > 255 {
-			b = 255
-		}
-
-		return color.NRGBA{
-			uint8(r),
-			uint8(g),
-			uint8(b),
-			0xFF,
-		}, nil
 	}
 
 	if colorStr[0] == '#' {
-		r, g, b, err := ParseSVGColorNum(colorStr)
+		// The CSS Color 4 forms #rgba and #rrggbbaa end with the alpha
+		hex, a := colorStr, uint64(0xFF)
+		if n := len(hex) - 1; n == 4 || n == 8 {
+			alpha := hex[1+n*3/4:]
+			if n == 4 {
+				alpha += alpha
+			}
+			var err error
+			if a, err = strconv.ParseUint(alpha, 16, 8); err != nil {
+				return nil, err
+			}
+			hex = hex[:1+n*3/4]
+		}
+		r, g, b, err := ParseSVGColorNum(hex)
 		if err != nil {
 			return nil, err
 		}
-		return color.NRGBA{r, g, b, 0xFF}, nil
+		return color.NRGBA{r, g, b, uint8(a)}, nil
 	}
 	return nil, errParamMismatch
 }
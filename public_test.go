@@ -3,7 +3,11 @@
 package oksvg_test
 
 import (
+	"bytes"
+	"fmt"
 	"image"
+	"os"
+	"strings"
 	"testing"
 
 	. "github.com/srwiley/oksvg"
@@ -80,3 +84,153 @@ func BenchmarkSportsIcons(b *testing.B) {
 		}
 	}
 }
+
+// readIconData reads the raw bytes of the named icons in folder.
+func readIconData(b *testing.B, folder string, paths []string) (data [][]byte) {
+	for _, p := range paths {
+		d, err := os.ReadFile(folder + p + ".svg")
+		if err != nil {
+			b.Fatal(err)
+		}
+		data = append(data, d)
+	}
+	return
+}
+
+// benchmarkRaster draws the icons scaled to a size by size image.
+func benchmarkRaster(b *testing.B, icons []*SvgIcon, size int) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	raster := NewDasher(size, size, NewScannerGV(size, size, img, img.Bounds()))
+	for _, ic := range icons {
+		ic.SetTarget(0, 0, float64(size), float64(size))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, ic := range icons {
+			ic.Draw(raster, 1.0)
+		}
+	}
+}
+
+// BenchmarkRasterQuality rasterizes the same icons at each Quality preset and
+// reports, as maxdiff, the largest difference of a color channel from the
+// BestQuality rendering.
+func BenchmarkRasterQuality(b *testing.B) {
+	icons := ReadIconSet("testdata/landscapeIcons/", []string{"beach", "cape", "iceberg", "village"})
+	best := make([]*image.RGBA, len(icons))
+	for i, ic := range icons {
+		img, err := ic.Rasterize(512, 512, WithQuality(BestQuality))
+		if err != nil {
+			b.Fatal(err)
+		}
+		best[i] = img
+	}
+	for _, q := range []struct {
+		name    string
+		quality Quality
+	}{{"Fast", FastQuality}, {"Balanced", BalancedQuality}, {"Best", BestQuality}} {
+		b.Run(q.name, func(b *testing.B) {
+			var maxDiff int
+			for i := 0; i < b.N; i++ {
+				for j, ic := range icons {
+					img, err := ic.Rasterize(512, 512, WithQuality(q.quality))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if i > 0 {
+						continue
+					}
+					for k := range img.Pix {
+						d := int(img.Pix[k]) - int(best[j].Pix[k])
+						if d < 0 {
+							d = -d
+						}
+						if d > maxDiff {
+							maxDiff = d
+						}
+					}
+				}
+			}
+			b.ReportMetric(float64(maxDiff), "maxdiff")
+		})
+	}
+}
+
+// BenchmarkRasterZoomed draws a small part of a large icon, as viewers that
+// pan and zoom do, in which most paths are outside of the image and skipped.
+func BenchmarkRasterZoomed(b *testing.B) {
+	icon, err := ReadIcon("testdata/landscapeIcons/village.svg", IgnoreErrorMode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	raster := NewDasher(256, 256, NewScannerGV(256, 256, img, img.Bounds()))
+	icon.SetTarget(-2048, -2048, 8192, 8192)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		icon.Draw(raster, 1.0)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	data := readIconData(b, "testdata/landscapeIcons/", []string{
+		"beach", "cape", "iceberg", "island",
+		"mountains", "sea", "trees", "village"})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, d := range data {
+			if _, err := ReadIconStream(bytes.NewReader(d)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCompilePath(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("M0,0")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, " l%d.5,%d.25 c1,2 3,4 5,6 a5,5 0 0,1 10,-10 q2,2 4,0", i%13, -(i % 7))
+	}
+	path := sb.String()
+	c := &PathCursor{}
+	b.SetBytes(int64(len(path)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.CompilePath(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCompileLongPath compiles a single multi-megabyte path of short
+// line segments, like those found in map exports.
+func BenchmarkCompileLongPath(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("M0,0")
+	for i := 0; sb.Len() < 4<<20; i++ {
+		fmt.Fprintf(&sb, "l%d.%d,-%d.%d ", i%17, i%10, i%5, i%3)
+	}
+	path := sb.String()
+	c := &PathCursor{}
+	b.SetBytes(int64(len(path)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.CompilePath(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRasterSmall(b *testing.B) {
+	benchmarkRaster(b, ReadIconSet("testdata/sportsIcons/", []string{
+		"archery", "fencing", "rugby_sevens", "cycling_bmx", "trophy"}), 32)
+}
+
+func BenchmarkRasterLarge(b *testing.B) {
+	benchmarkRaster(b, ReadIconSet("testdata/landscapeIcons/", []string{"beach"}), 1024)
+}
+
+func BenchmarkRasterGradients(b *testing.B) {
+	benchmarkRaster(b, ReadIconSet("testdata/testIcons/", []string{"original", "tagsremoved"}), 512)
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// quality.go implements named presets trading rendering quality for speed.
+
+package oksvg
+
+import (
+	"image"
+
+	"github.com/srwiley/rasterx"
+)
+
+// Quality is a named preset of the settings that trade the fidelity of a
+// rendering for its speed, for callers that want one dial rather than each
+// setting. It is selected for each draw with WithQuality.
+//
+// The presets select only how finely curves are flattened and which scanner
+// computes the coverage of the paths, which decides whether the whole image or
+// only the extent of each path is swept. There is no anti-aliasing level or
+// gradient lookup table to select: both scanners anti-alias every path,
+// gradients are evaluated for each pixel, and images are still sampled as
+// ParseOptions.ImageSampling and their image-rendering property say.
+// BenchmarkRasterQuality reports the time and the largest difference from
+// BestQuality of each preset.
+type Quality uint8
+
+// Quality constants
+const (
+	// DefaultQuality draws with the Budget of the icon and a rasterx.ScannerGV.
+	DefaultQuality Quality = iota
+	// FastQuality flattens curves within half a pixel, with at most 64 segments
+	// for each curve, and rasterizes only the extent of each path, which is
+	// fastest for icons of many small paths and previews.
+	FastQuality
+	// BalancedQuality flattens curves within a tenth of a pixel, which is not
+	// visible in most icons, and rasterizes only the extent of each path.
+	BalancedQuality
+	// BestQuality flattens curves as finely as rasterx does and sweeps the whole
+	// image for each path with a rasterx.ScannerGV, as the reference rendering.
+	BestQuality
+)
+
+// Budget returns the tessellation budget that curves are flattened within at
+// the quality q. It is DefaultTessellationBudget for DefaultQuality and
+// BestQuality.
+func (q Quality) Budget() TessellationBudget {
+	switch q {
+	case FastQuality:
+		return TessellationBudget{MaxCurveSegments: 64, MaxPathSegments: 1 << 16, Tolerance: 0.5}
+	case BalancedQuality:
+		return TessellationBudget{MaxCurveSegments: 256, MaxPathSegments: 1 << 18, Tolerance: 0.1}
+	}
+	return DefaultTessellationBudget
+}
+
+// scanner returns the scanner that draws into the width by height img at the
+// quality q.
+func (q Quality) scanner(width, height int, img *image.RGBA) rasterx.Scanner {
+	switch q {
+	case FastQuality, BalancedQuality:
+		return NewScannerSpan(width, height, img, nil)
+	}
+	return rasterx.NewScannerGV(width, height, img, img.Bounds())
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// quirks.go implements rendering behaviors of legacy SVG generators.
+
+package oksvg
+
+// Quirks is a named profile of rendering behaviors that differ from the SVG
+// specification, so that archives of documents made for a particular generator
+// or viewer can be reproduced as they were first seen. The zero Quirks renders
+// as the specification requires. A profile is followed when it is the Quirks of
+// an icon, or chosen for one rendering with the WithQuirks option of Rasterize.
+type Quirks struct {
+	Name string
+	// StrokeBeforeFill draws the stroke of each path below its fill, so that
+	// only the outer half of the stroke shows. The fill and stroke of a path are
+	// then drawn separately, and not isolated by the IsolateOpacity option.
+	StrokeBeforeFill bool
+}
+
+// StrokeFirstQuirks is the profile of documents made for viewers that painted
+// the strokes of paths before their fills.
+var StrokeFirstQuirks = Quirks{Name: "stroke-first", StrokeBeforeFill: true}
+
+// quirksProfiles lists the profiles found by QuirksByName.
+var quirksProfiles = []Quirks{StrokeFirstQuirks}
+
+// QuirksByName returns the quirks profile with the given name, and whether
+// there is one.
+func QuirksByName(name string) (Quirks, bool) {
+	for _, q := range quirksProfiles {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Quirks{}, false
+}
@@ -0,0 +1,191 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// rasterize.go implements rendering of icons into new images.
+
+package oksvg
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/srwiley/rasterx"
+)
+
+var errEmptyRaster = errors.New("cannot rasterize an empty icon or image")
+
+const (
+	// fingerprintGrid is the number of cells across and down that RenderFingerprint
+	// averages the rendering over.
+	fingerprintGrid = 8
+	// fingerprintLevels is the number of levels each averaged channel is
+	// quantized to, one hexadecimal digit.
+	fingerprintLevels = 16
+)
+
+// rasterConfig holds the settings of Rasterize.
+type rasterConfig struct {
+	opacity    float64
+	background color.Color
+	align      string
+	slice      bool
+	quality    Quality
+	quirks     Quirks
+}
+
+// RasterOption changes how Rasterize renders an icon.
+type RasterOption func(*rasterConfig)
+
+// WithOpacity draws the icon with opacity instead of fully opaque.
+func WithOpacity(opacity float64) RasterOption {
+	return func(rc *rasterConfig) { rc.opacity = opacity }
+}
+
+// WithBackground fills the image with c before the icon is drawn over it.
+// By default the background is transparent.
+func WithBackground(c color.Color) RasterOption {
+	return func(rc *rasterConfig) { rc.background = c }
+}
+
+// WithAspectRatio keeps the aspect ratio of the ViewBox as the SVG
+// preserveAspectRatio attribute value par does, for example "xMidYMid meet".
+// By default the PreserveAspectRatio of the icon is followed, or
+// "xMidYMid meet" if it is empty, as in browsers. WithAspectRatio("none")
+// stretches the ViewBox over the whole image.
+func WithAspectRatio(par string) RasterOption {
+	return func(rc *rasterConfig) { rc.align, rc.slice = parseAspectRatio(par) }
+}
+
+// WithQuality draws the icon with the settings of the Quality preset q. By
+// default the icon is drawn with its own Budget, as with DefaultQuality.
+func WithQuality(q Quality) RasterOption {
+	return func(rc *rasterConfig) { rc.quality = q }
+}
+
+// WithQuirks draws the icon following the quirks profile q, such as
+// StrokeFirstQuirks, so that one icon can be rendered both as the specification
+// requires and as a legacy viewer showed it. By default the Quirks of the icon
+// are followed.
+func WithQuirks(q Quirks) RasterOption {
+	return func(rc *rasterConfig) { rc.quirks = q }
+}
+
+// Rasterize renders the icon into a new width by height image, with its ViewBox
+// mapped onto the image as its PreserveAspectRatio says, centered and keeping its
+// aspect ratio if that is empty. The Transform of the icon is ignored and not
+// changed, so an icon may be rasterized by several goroutines at once.
+func (s *SvgIcon) Rasterize(width, height int, opts ...RasterOption) (*image.RGBA, error) {
+	vb := s.ViewBox
+	if width <= 0 || height <= 0 || !(vb.W > 0 && vb.H > 0) {
+		return nil, errEmptyRaster
+	}
+	rc := rasterConfig{opacity: 1, quirks: s.Quirks}
+	rc.align, rc.slice = s.aspectRatio()
+	for _, opt := range opts {
+		opt(&rc)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if rc.background != nil {
+		draw.Draw(img, img.Bounds(), image.NewUniform(rc.background), image.Point{}, draw.Src)
+	}
+	t := viewBoxTransform(vb, rc.align, rc.slice, float64(width), float64(height))
+	r := rasterx.NewDasher(width, height, rc.quality.scanner(width, height, img))
+	tb := s.budget()
+	if rc.quality != DefaultQuality {
+		tb = rc.quality.Budget()
+	}
+	for _, svgp := range s.SVGPaths {
+		s.drawPath(&svgp, r, rc.opacity, t, tb, rc.quirks)
+	}
+	return img, nil
+}
+
+// RenderAtDPI rasterizes the icon, as Rasterize does, at its PhysicalSize at dpi
+// pixels per inch. An icon without a physical size is taken to be as many CSS
+// pixels of 1/96 inch wide and high as its ViewBox is.
+func (s *SvgIcon) RenderAtDPI(dpi float64, opts ...RasterOption) (*image.RGBA, error) {
+	w, h, ok := s.PhysicalSize()
+	if !ok {
+		w, h = s.ViewBox.W*mmPerUnit["px"], s.ViewBox.H*mmPerUnit["px"]
+	}
+	// Sizes within a thousandth of a pixel of a whole number are not rounded up
+	pw := int(math.Ceil(w/25.4*dpi - 1e-3))
+	ph := int(math.Ceil(h/25.4*dpi - 1e-3))
+	return s.Rasterize(pw, ph, opts...)
+}
+
+// RenderFingerprint renders the icon into a size by size image, keeping the
+// aspect ratio of its ViewBox, and returns a fingerprint of the result that a
+// test can compare with a stored one, to catch visible changes without keeping
+// golden images. The image is averaged over an 8 by 8 grid and each channel of
+// the premultiplied averages is quantized to 16 levels, written as one
+// hexadecimal digit. The small differences in anti-aliasing that changes in
+// rasterization make can move an average that is close to the boundary of two
+// levels to the next one, so fingerprints should be compared with
+// FingerprintDistance. The result is empty if the icon or size is empty.
+func RenderFingerprint(icon *SvgIcon, size int) string {
+	img, err := icon.Rasterize(size, size, WithAspectRatio("xMidYMid meet"))
+	if err != nil {
+		return ""
+	}
+	var sums [fingerprintGrid * fingerprintGrid * 4]int
+	var counts [fingerprintGrid * fingerprintGrid]int
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			cell := y*fingerprintGrid/size*fingerprintGrid + x*fingerprintGrid/size
+			p := img.Pix[img.PixOffset(x, y):]
+			for c := 0; c < 4; c++ {
+				sums[4*cell+c] += int(p[c])
+			}
+			counts[cell]++
+		}
+	}
+	const digits = "0123456789abcdef"
+	fp := make([]byte, len(sums))
+	for i, sum := range sums {
+		level := 0
+		if n := counts[i/4]; n > 0 {
+			level = (sum*(fingerprintLevels-1) + n*0xFF/2) / (n * 0xFF)
+		}
+		fp[i] = digits[level]
+	}
+	return string(fp)
+}
+
+// FingerprintDistance returns the largest difference between the levels of
+// the fingerprints a and b made by RenderFingerprint, at most 15, or -1 if they
+// are not fingerprints of the same length. A distance of zero or one is
+// within the noise of anti-aliasing, while visible changes of color or shape
+// make larger ones.
+func FingerprintDistance(a, b string) int {
+	if len(a) != len(b) || len(a) == 0 {
+		return -1
+	}
+	d := 0
+	for i := 0; i < len(a); i++ {
+		la, oka := hexDigit(a[i])
+		lb, okb := hexDigit(b[i])
+		if !oka || !okb {
+			return -1
+		}
+		if la-lb > d {
+			d = la - lb
+		} else if lb-la > d {
+			d = lb - la
+		}
+	}
+	return d
+}
+
+// hexDigit returns the value of the lower case hexadecimal digit c.
+func hexDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// recolor.go implements changing the colors of parsed icons, as for theming.
+
+package oksvg
+
+import (
+	"image/color"
+
+	"github.com/srwiley/rasterx"
+)
+
+// SetColor paints every filled or stroked path of the icon with c, keeping
+// their fill and stroke opacities, as monochrome icons are themed. Gradient and
+// pattern paints are replaced by c, while images, and paths that are not filled
+// or stroked, are left as they are. Clip paths and masks are not changed, so
+// they still shape the icon as before.
+func (s *SvgIcon) SetColor(c color.Color) {
+	for i := range s.SVGPaths {
+		svgp := &s.SVGPaths[i]
+		for _, paint := range []*interface{}{&svgp.fillerColor, &svgp.linerColor} {
+			if _, isImage := (*paint).(imagePaint); *paint != nil && !isImage {
+				*paint = c
+			}
+		}
+	}
+}
+
+// ReplaceColor changes the fills, strokes and gradient stops of the icon that
+// are the color old, compared in premultiplied RGBA, to the color new. The
+// gradients in Grads and the paths of patterns are changed too, while clip
+// paths and masks are not.
+func (s *SvgIcon) ReplaceColor(old, new color.Color) {
+	for _, g := range s.Grads {
+		g.Stops = replaceStopColor(g.Stops, old, new)
+	}
+	seen := make(map[*Pattern]bool)
+	for i := range s.SVGPaths {
+		replacePathColor(&s.SVGPaths[i], old, new, seen)
+	}
+}
+
+// replacePathColor changes the paints of svgp, and of the patterns it uses that
+// are not in seen, from the color old to new.
+func replacePathColor(svgp *SvgPath, old, new color.Color, seen map[*Pattern]bool) {
+	for _, paint := range []*interface{}{&svgp.fillerColor, &svgp.linerColor} {
+		switch p := (*paint).(type) {
+		case color.Color:
+			if sameColor(p, old) {
+				*paint = new
+			}
+		case rasterx.Gradient:
+			p.Stops = replaceStopColor(p.Stops, old, new)
+			*paint = p
+		case *Pattern:
+			if !seen[p] {
+				seen[p] = true
+				for i := range p.Paths {
+					replacePathColor(&p.Paths[i], old, new, seen)
+				}
+			}
+		}
+	}
+}
+
+// replaceStopColor returns stops, copied if any of them is the color old, with
+// those stops changed to new. Stops may be shared by several paths.
+func replaceStopColor(stops []rasterx.GradStop, old, new color.Color) []rasterx.GradStop {
+	for i, s := range stops {
+		if s.StopColor != nil && sameColor(s.StopColor, old) {
+			stops = append([]rasterx.GradStop(nil), stops...)
+			for j := i; j < len(stops); j++ {
+				if stops[j].StopColor != nil && sameColor(stops[j].StopColor, old) {
+					stops[j].StopColor = new
+				}
+			}
+			break
+		}
+	}
+	return stops
+}
+
+// sameColor reports whether a and b are the same color in premultiplied RGBA.
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// replace.go implements replacing single elements of a parsed icon.
+
+package oksvg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/srwiley/rasterx"
+)
+
+// elementSpan records the paths drawn by an element with an id outside defs, and
+// the state it was read in, so that it can be read again in its place.
+type elementSpan struct {
+	id          string
+	first, end  int         // the paths drawn by the element are SVGPaths[first:end]
+	titles      [2]int      // the titles within the element are Titles[titles[0]:titles[1]]
+	descs       [2]int      // the descriptions within the element are Descriptions[descs[0]:descs[1]]
+	open, close int         // positions of the start and end tags of the element among all tags
+	styles      []PathStyle // the style stack the element was read on, ending with its parent's style
+	ids         []string    // ids of the ancestors of the element
+}
+
+// openElement records the start of the element tag, whose style and id have
+// been pushed, if it has an id and is drawn in the icon.
+func (c *IconCursor) openElement(tag string) {
+	c.tags++
+	id := c.ids[len(c.ids)-1]
+	// The root svg is not replaced, nor are the elements of streamed documents,
+	// whose paths are not kept, or of icons that are not to keep them
+	if id == "" || c.inDefs || drawnByReference(tag) || len(c.ids) < 2 || c.handler != nil || c.lowMemory {
+		c.openElements = append(c.openElements, -1)
+		return
+	}
+	c.openElements = append(c.openElements, len(c.icon.elements))
+	c.icon.elements = append(c.icon.elements, elementSpan{
+		id:     id,
+		first:  len(c.icon.SVGPaths),
+		end:    len(c.icon.SVGPaths),
+		titles: [2]int{len(c.icon.Titles), len(c.icon.Titles)},
+		descs:  [2]int{len(c.icon.Descriptions), len(c.icon.Descriptions)},
+		open:   c.tags,
+		styles: append([]PathStyle(nil), c.StyleStack[:len(c.StyleStack)-1]...),
+		ids:    append([]string(nil), c.ids[:len(c.ids)-1]...),
+	})
+}
+
+// closeElement records the end of the innermost open element.
+func (c *IconCursor) closeElement() {
+	c.tags++
+	if i := c.openElements[len(c.openElements)-1]; i >= 0 {
+		e := &c.icon.elements[i]
+		e.end, e.close = len(c.icon.SVGPaths), c.tags
+		e.titles[1], e.descs[1] = len(c.icon.Titles), len(c.icon.Descriptions)
+	}
+	c.openElements = c.openElements[:len(c.openElements)-1]
+}
+
+// ReplaceElement reads svgFragment, the source of one or more elements, in place
+// of the element with the id, so that an editor can update the icon as an element
+// is edited without reading the whole document again. The fragment is read with
+// the style and transform that the element inherited, and the paths it draws
+// replace those the element drew. The source positions of the new paths are
+// within the fragment. If more than one element has the id, the first is replaced.
+//
+// If the id is that of a gradient or of elements within defs, the fragment is read
+// as if within defs and replaces that definition. Paths already drawn with the
+// definition keep their copy of it until the elements drawing them are replaced.
+//
+// Gradients and definitions in the fragment are added to those of the icon. If the
+// fragment cannot be read, the icon is not changed. Icons read with
+// ParseOptions.LowMemory have no elements or definitions to replace.
+func (s *SvgIcon) ReplaceElement(id, svgFragment string) error {
+	for i, e := range s.elements {
+		if e.id == id {
+			return s.replaceSpan(i, svgFragment)
+		}
+	}
+	if _, ok := s.Grads[id]; ok || len(s.Defs[id]) > 0 {
+		frag, _, err := s.readFragment("<defs>"+svgFragment+"</defs>", []PathStyle{DefaultStyle}, nil, id)
+		if err != nil {
+			return err
+		}
+		delete(s.titles, id)
+		delete(s.descriptions, id)
+		s.adopt(frag)
+		s.Titles = append(s.Titles, frag.Titles...)
+		s.Descriptions = append(s.Descriptions, frag.Descriptions...)
+		return nil
+	}
+	return fmt.Errorf("%w: element %s", errMissingRef, id)
+}
+
+// replaceSpan replaces the paths and recorded elements of s.elements[i] by those
+// read from svgFragment.
+func (s *SvgIcon) replaceSpan(i int, svgFragment string) error {
+	e := s.elements[i]
+	frag, tags, err := s.readFragment(svgFragment, e.styles, e.ids, "")
+	if err != nil {
+		return err
+	}
+	// The texts of the element and its descendants are those of the fragment
+	for _, o := range s.elements[i:] {
+		if o.open > e.close {
+			break
+		}
+		delete(s.titles, o.id)
+		delete(s.descriptions, o.id)
+	}
+	s.adopt(frag)
+	s.Titles = splice(s.Titles, e.titles, frag.Titles)
+	s.Descriptions = splice(s.Descriptions, e.descs, frag.Descriptions)
+	paths := make([]SvgPath, 0, len(s.SVGPaths)-(e.end-e.first)+len(frag.SVGPaths))
+	paths = append(append(append(paths, s.SVGPaths[:e.first]...), frag.SVGPaths...), s.SVGPaths[e.end:]...)
+	s.SVGPaths = paths
+	// Paths and tags after the element move by the difference in their numbers
+	pathShift := len(frag.SVGPaths) - (e.end - e.first)
+	tagShift := tags - (e.close - e.open + 1)
+	titleShift := len(frag.Titles) - (e.titles[1] - e.titles[0])
+	descShift := len(frag.Descriptions) - (e.descs[1] - e.descs[0])
+	elements := make([]elementSpan, 0, len(s.elements)+len(frag.elements))
+	for _, o := range s.elements[:i] {
+		if o.close > e.close { // an ancestor
+			o.end += pathShift
+			o.close += tagShift
+			o.titles[1] += titleShift
+			o.descs[1] += descShift
+		}
+		elements = append(elements, o)
+	}
+	for _, o := range frag.elements {
+		o.first, o.end = o.first+e.first, o.end+e.first
+		o.open, o.close = o.open+e.open-1, o.close+e.open-1
+		o.titles = [2]int{o.titles[0] + e.titles[0], o.titles[1] + e.titles[0]}
+		o.descs = [2]int{o.descs[0] + e.descs[0], o.descs[1] + e.descs[0]}
+		elements = append(elements, o)
+	}
+	for _, o := range s.elements[i+1:] {
+		if o.open < e.close { // a descendant, which was replaced
+			continue
+		}
+		o.first, o.end = o.first+pathShift, o.end+pathShift
+		o.open, o.close = o.open+tagShift, o.close+tagShift
+		o.titles = [2]int{o.titles[0] + titleShift, o.titles[1] + titleShift}
+		o.descs = [2]int{o.descs[0] + descShift, o.descs[1] + descShift}
+		elements = append(elements, o)
+	}
+	s.elements = elements
+	return nil
+}
+
+// readFragment reads svgFragment into a new icon sharing the ViewBox and style
+// rules of s, on a copy of the style stack styles with the ancestors ids, and
+// returns it with the number of tags read. The icon has copies of the gradients
+// and definitions of s, without those with the id without, so that s is not
+// changed if the fragment cannot be read.
+func (s *SvgIcon) readFragment(svgFragment string, styles []PathStyle, ids []string,
+	without string) (*SvgIcon, int, error) {
+	frag := &SvgIcon{ViewBox: s.ViewBox, Grads: make(map[string]*rasterx.Gradient, len(s.Grads)),
+		Defs: make(map[string][]definition, len(s.Defs)), Transform: rasterx.Identity,
+		styleRules: s.styleRules[:len(s.styleRules):len(s.styleRules)]}
+	for id, g := range s.Grads {
+		frag.Grads[id] = g
+	}
+	for id, d := range s.Defs {
+		frag.Defs[id] = d
+	}
+	delete(frag.Grads, without)
+	delete(frag.Defs, without)
+	c := newIconCursor(frag, s.readOpts)
+	c.StyleStack = append([]PathStyle(nil), styles...)
+	c.ids = append([]string(nil), ids...)
+	c.coordScale = coordScaleFor(s.ViewBox)
+	if err := c.read(strings.NewReader(svgFragment), s.readOpts); err != nil {
+		return nil, 0, err
+	}
+	return frag, c.tags, nil
+}
+
+// adopt takes the gradients, definitions, style rules, texts by id and diagnostics of frag,
+// read by readFragment, into s. The caller places the Titles and Descriptions of frag.
+func (s *SvgIcon) adopt(frag *SvgIcon) {
+	s.Grads, s.Defs, s.styleRules = frag.Grads, frag.Defs, frag.styleRules
+	s.titles = mergeText(s.titles, frag.titles, nil)
+	s.descriptions = mergeText(s.descriptions, frag.descriptions, nil)
+	s.gradSources = mergeSources(s.gradSources, frag.gradSources, nil)
+	s.Diagnostics = append(s.Diagnostics, frag.Diagnostics...)
+}
+
+// splice returns texts with texts[span[0]:span[1]] replaced by with.
+func splice(texts []string, span [2]int, with []string) []string {
+	out := make([]string, 0, len(texts)-(span[1]-span[0])+len(with))
+	return append(append(append(out, texts[:span[0]]...), with...), texts[span[1]:]...)
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// sampling.go implements the resampling of images painted in icons.
+
+package oksvg
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ImageSampling is how the pixels of an image painted in an icon are
+// interpolated when the image is scaled.
+type ImageSampling uint8
+
+// ImageSampling constants
+const (
+	// NearestSampling repeats the nearest pixel, keeping pixel art sharp.
+	NearestSampling ImageSampling = iota
+	// BilinearSampling blends the four nearest pixels.
+	BilinearSampling
+	// CatmullRomSampling blends the sixteen nearest pixels with a Catmull-Rom
+	// cubic, which keeps edges sharper than BilinearSampling.
+	CatmullRomSampling
+)
+
+// sampleImage returns the premultiplied color of img at x, y, in pixels from the
+// top left corner of its bounds, interpolated with sampling. Pixels beyond the
+// edges repeat those at the edges.
+func sampleImage(img image.Image, x, y float64, sampling ImageSampling) color.RGBA64 {
+	b := img.Bounds()
+	if sampling == NearestSampling {
+		ix := clampInt(b.Min.X+int(math.Floor(x)), b.Min.X, b.Max.X-1)
+		iy := clampInt(b.Min.Y+int(math.Floor(y)), b.Min.Y, b.Max.Y-1)
+		return color.RGBA64Model.Convert(img.At(ix, iy)).(color.RGBA64)
+	}
+	// The centers of the pixels are at half units
+	x, y = x-0.5, y-0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	var wx, wy [4]float64
+	taps, first := 2, 0
+	if sampling == CatmullRomSampling {
+		taps, first = 4, -1
+		wx, wy = catmullRom(fx), catmullRom(fy)
+	} else {
+		wx, wy = [4]float64{1 - fx, fx}, [4]float64{1 - fy, fy}
+	}
+	var sum [4]float64
+	for j := 0; j < taps; j++ {
+		iy := clampInt(b.Min.Y+int(y0)+first+j, b.Min.Y, b.Max.Y-1)
+		for i := 0; i < taps; i++ {
+			ix := clampInt(b.Min.X+int(x0)+first+i, b.Min.X, b.Max.X-1)
+			w := wx[i] * wy[j]
+			r, g, bl, a := img.At(ix, iy).RGBA()
+			sum[0] += w * float64(r)
+			sum[1] += w * float64(g)
+			sum[2] += w * float64(bl)
+			sum[3] += w * float64(a)
+		}
+	}
+	a := math.Max(0, math.Min(0xffff, sum[3]))
+	channel := func(v float64) uint16 { // premultiplied channels do not exceed alpha
+		return uint16(math.Round(math.Max(0, math.Min(a, v))))
+	}
+	return color.RGBA64{channel(sum[0]), channel(sum[1]), channel(sum[2]), uint16(math.Round(a))}
+}
+
+// catmullRom returns the weights of the four pixels around a point at t, from 0
+// to 1, past the second of them.
+func catmullRom(t float64) [4]float64 {
+	t2, t3 := t*t, t*t*t
+	return [4]float64{
+		(-t3 + 2*t2 - t) / 2,
+		(3*t3 - 5*t2 + 2) / 2,
+		(-3*t3 + 4*t2 + t) / 2,
+		(t3 - t2) / 2,
+	}
+}
+
+// clampInt returns v limited to the range from lo to hi.
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// scanner_span.go implements a rasterx Scanner that composites solid fills
+// through a pluggable SpanFiller.
+
+package oksvg
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// ScannerSpan is a rasterx Scanner that uses the golang.org/x/image/vector
+// rasterizer to compute coverage. When the destination is an *image.RGBA and
+// the color is solid, the coverage is composited one row at a time by the Filler,
+// which allows optimized span blitters to be plugged in. Other destinations and
+// gradient colors are drawn as by rasterx.ScannerGV.
+//
+// Unlike rasterx.ScannerGV, which sweeps the whole image for every path, a
+// ScannerSpan records the path and rasterizes only the pixels of its extent,
+// so drawing many small paths into a large image takes time in proportion to
+// the area they cover rather than to the size of the image.
+type ScannerSpan struct {
+	r                      vector.Rasterizer
+	Dest                   draw.Image
+	Filler                 SpanFiller // DefaultSpanFiller is used if nil
+	mask                   []uint8    // coverage of the path extent, reused between draws
+	clr                    color.Color
+	colorFunc              rasterx.ColorFunc
+	clip                   image.Rectangle
+	width, height          int
+	points                 []spanPoint   // the path since the last Clear
+	minX, minY, maxX, maxY fixed.Int26_6 // keep track of bounds
+}
+
+// spanPoint is a point of the path recorded by a ScannerSpan, which starts a
+// new subpath if start is true and otherwise ends a line.
+type spanPoint struct {
+	p     fixed.Point26_6
+	start bool
+}
+
+// NewScannerSpan creates a new ScannerSpan of the given size drawing into dest.
+// If filler is nil DefaultSpanFiller is used.
+func NewScannerSpan(width, height int, dest draw.Image, filler SpanFiller) *ScannerSpan {
+	s := &ScannerSpan{Dest: dest, Filler: filler, clr: color.Black}
+	s.SetBounds(width, height)
+	s.Clear()
+	return s
+}
+
+// GetPathExtent returns the extent of the path
+func (s *ScannerSpan) GetPathExtent() fixed.Rectangle26_6 {
+	return fixed.Rectangle26_6{Min: fixed.Point26_6{X: s.minX, Y: s.minY}, Max: fixed.Point26_6{X: s.maxX, Y: s.maxY}}
+}
+
+// SetWinding set the winding rule for the scanner
+func (s *ScannerSpan) SetWinding(useNonZeroWinding bool) {
+	// no-op as the vector rasterizer does not support even-odd winding
+}
+
+// SetColor sets the color to a color.Color or a rasterx.ColorFunc
+func (s *ScannerSpan) SetColor(clr interface{}) {
+	switch c := clr.(type) {
+	case color.Color:
+		s.clr, s.colorFunc = c, nil
+	case rasterx.ColorFunc:
+		s.clr, s.colorFunc = nil, c
+	}
+}
+
+// SetClip sets an optional clipping rectangle to restrict rendering only to
+// that region -- if size is 0 then ignored (set to image.ZR to clear)
+func (s *ScannerSpan) SetClip(rect image.Rectangle) {
+	s.clip = rect
+}
+
+func (s *ScannerSpan) set(a fixed.Point26_6) {
+	if s.maxX < a.X {
+		s.maxX = a.X
+	}
+	if s.maxY < a.Y {
+		s.maxY = a.Y
+	}
+	if s.minX > a.X {
+		s.minX = a.X
+	}
+	if s.minY > a.Y {
+		s.minY = a.Y
+	}
+}
+
+// Start starts a new path at the given point.
+func (s *ScannerSpan) Start(a fixed.Point26_6) {
+	s.set(a)
+	s.points = append(s.points, spanPoint{a, true})
+}
+
+// Line adds a linear segment to the current curve.
+func (s *ScannerSpan) Line(b fixed.Point26_6) {
+	s.set(b)
+	s.points = append(s.points, spanPoint{b, false})
+}
+
+// Draw renders the accumulated scan to the destination
+func (s *ScannerSpan) Draw() {
+	// Only the part of the extent of the path within the bounds, the clip and
+	// the destination is rasterized
+	rect := image.Rect(s.minX.Floor(), s.minY.Floor(), s.maxX.Ceil(), s.maxY.Ceil()).
+		Intersect(image.Rect(0, 0, s.width, s.height))
+	if s.clip != image.ZR {
+		rect = rect.Intersect(s.clip)
+	}
+	dmin := s.Dest.Bounds().Min
+	rect = rect.Intersect(s.Dest.Bounds().Sub(dmin))
+	if rect.Empty() {
+		return
+	}
+	s.r.Reset(rect.Dx(), rect.Dy())
+	ox, oy := float32(rect.Min.X), float32(rect.Min.Y)
+	for _, sp := range s.points {
+		if sp.start {
+			s.r.MoveTo(float32(sp.p.X)/64-ox, float32(sp.p.Y)/64-oy)
+		} else {
+			s.r.LineTo(float32(sp.p.X)/64-ox, float32(sp.p.Y)/64-oy)
+		}
+	}
+	dst, ok := s.Dest.(*image.RGBA)
+	if !ok || s.clr == nil {
+		s.drawImage(rect)
+		return
+	}
+	// Rasterize the coverage into the mask, then composite it one row at a time
+	w, h := rect.Dx(), rect.Dy()
+	if cap(s.mask) < w*h {
+		s.mask = make([]uint8, w*h)
+	}
+	mask := &image.Alpha{Pix: s.mask[:w*h], Stride: w, Rect: image.Rect(0, 0, w, h)}
+	s.r.DrawOp = draw.Src
+	s.r.Draw(mask, mask.Rect, image.Opaque, image.Point{})
+	filler := s.Filler
+	if filler == nil {
+		filler = DefaultSpanFiller
+	}
+	c := color.RGBAModel.Convert(s.clr).(color.RGBA)
+	for y := 0; y < h; y++ {
+		di := dst.PixOffset(rect.Min.X+dmin.X, rect.Min.Y+y+dmin.Y)
+		filler.FillSpan(dst.Pix[di:di+w*4], mask.Pix[y*w:y*w+w], c)
+	}
+}
+
+// drawImage draws the accumulated scan, rasterized over rect, through the
+// vector rasterizer.
+func (s *ScannerSpan) drawImage(rect image.Rectangle) {
+	var src image.Image
+	switch {
+	case s.colorFunc == nil && s.clip == image.ZR:
+		src = image.NewUniform(s.clr)
+	case s.colorFunc == nil:
+		c := s.clr
+		src = colorFuncImage(func(x, y int) color.Color { return c })
+	default:
+		src = colorFuncImage(s.colorFunc)
+	}
+	if s.clip != image.ZR {
+		f, clip := src.(colorFuncImage), s.clip
+		src = colorFuncImage(func(x, y int) color.Color {
+			if !(image.Point{x, y}).In(clip) {
+				return color.Transparent
+			}
+			return f(x, y)
+		})
+	}
+	s.r.Draw(s.Dest, rect.Add(s.Dest.Bounds().Min), src, rect.Min)
+}
+
+// Clear cancels any previous accumulated scans
+func (s *ScannerSpan) Clear() {
+	s.points = s.points[:0]
+	const mxfi = fixed.Int26_6(math.MaxInt32)
+	s.minX, s.minY, s.maxX, s.maxY = mxfi, mxfi, -mxfi, -mxfi
+}
+
+// SetBounds sets the maximum width and height of the rasterized image and
+// calls Clear. The width and height are in pixels, not fixed.Int26_6 units.
+func (s *ScannerSpan) SetBounds(width, height int) {
+	s.width, s.height = width, height
+	s.Clear()
+}
+
+// colorFuncImage is an unbounded image whose colors are given by a rasterx.ColorFunc.
+type colorFuncImage rasterx.ColorFunc
+
+func (f colorFuncImage) ColorModel() color.Model { return color.RGBAModel }
+
+func (f colorFuncImage) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+func (f colorFuncImage) At(x, y int) color.Color { return f(x, y) }
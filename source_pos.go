@@ -0,0 +1,70 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// source_pos.go implements tracking of the source location of parsed elements.
+
+package oksvg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SourcePos is the location of an element in the SVG source it was read from.
+// Line and Column start at 1, and Column counts bytes. The zero SourcePos means
+// the location is unknown, for example for paths added by code.
+type SourcePos struct {
+	Offset       int64 // byte offset of the start tag
+	Line, Column int
+}
+
+// String returns the position formatted as line:column.
+func (p SourcePos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// lineReader records the offsets of the line breaks read through it, so that
+// decoder offsets can be converted to lines and columns. As the offsets are
+// converted in increasing order, only the line breaks from the line of the last
+// converted offset on are kept.
+type lineReader struct {
+	r       io.Reader
+	n       int64
+	breaks  []int64
+	dropped int  // number of line breaks before breaks[0]
+	noPos   bool // do not record line breaks, positions are unknown
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.noPos {
+		return n, err
+	}
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.breaks = append(l.breaks, l.n+int64(i))
+		}
+	}
+	l.n += int64(n)
+	return n, err
+}
+
+// pos returns the SourcePos of the byte offset, which must already have been read
+// and must not be before the offsets pos was called with.
+func (l *lineReader) pos(offset int64) SourcePos {
+	if l.noPos {
+		return SourcePos{}
+	}
+	line := sort.Search(len(l.breaks), func(i int) bool { return l.breaks[i] >= offset })
+	col := offset
+	if line > 0 {
+		col = offset - l.breaks[line-1] - 1
+	}
+	p := SourcePos{Offset: offset, Line: l.dropped + line + 1, Column: int(col) + 1}
+	// The line break ending the previous line is kept for the columns of later offsets
+	if line > 1 {
+		l.breaks = append(l.breaks[:0], l.breaks[line-1:]...)
+		l.dropped += line - 1
+	}
+	return p
+}
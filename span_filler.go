@@ -0,0 +1,53 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// span_filler.go implements the compositing of solid color spans used by ScannerSpan.
+
+package oksvg
+
+import "image/color"
+
+// SpanFiller composites runs of a solid color over image.RGBA pixels.
+// Implementations may use assembly or SIMD to speed up large solid fills.
+type SpanFiller interface {
+	// FillSpan composites the premultiplied color c, scaled by the coverage values in
+	// cov, over the premultiplied RGBA pixels in pix, which holds 4*len(cov) bytes.
+	FillSpan(pix, cov []uint8, c color.RGBA)
+}
+
+// SpanFillerFunc adapts an ordinary function to the SpanFiller interface.
+type SpanFillerFunc func(pix, cov []uint8, c color.RGBA)
+
+// FillSpan calls f(pix, cov, c).
+func (f SpanFillerFunc) FillSpan(pix, cov []uint8, c color.RGBA) {
+	f(pix, cov, c)
+}
+
+// DefaultSpanFiller is the SpanFiller used by a ScannerSpan without a Filler.
+// On amd64 and arm64 fully covered runs of an opaque color are written
+// a pixel word at a time.
+var DefaultSpanFiller SpanFiller = SpanFillerFunc(fillSpan)
+
+// GenericSpanFiller is a portable SpanFiller that composites one channel at a time.
+var GenericSpanFiller SpanFiller = SpanFillerFunc(fillSpanGeneric)
+
+// fillSpanGeneric composites c over pix one channel at a time, using the
+// Porter-Duff over operator as in the image/draw package.
+func fillSpanGeneric(pix, cov []uint8, c color.RGBA) {
+	sr, sg, sb, sa := uint32(c.R)*0x101, uint32(c.G)*0x101, uint32(c.B)*0x101, uint32(c.A)*0x101
+	for i, m := range cov {
+		if m == 0 {
+			continue
+		}
+		p := pix[i*4 : i*4+4 : i*4+4]
+		if m == 0xff && sa == 0xffff {
+			p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			continue
+		}
+		ma := uint32(m) * 0x101
+		a := 0xffff - sa*ma/0xffff
+		p[0] = uint8((uint32(p[0])*0x101*a + sr*ma) / 0xffff >> 8)
+		p[1] = uint8((uint32(p[1])*0x101*a + sg*ma) / 0xffff >> 8)
+		p[2] = uint8((uint32(p[2])*0x101*a + sb*ma) / 0xffff >> 8)
+		p[3] = uint8((uint32(p[3])*0x101*a + sa*ma) / 0xffff >> 8)
+	}
+}
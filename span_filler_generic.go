@@ -0,0 +1,15 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// span_filler_generic.go selects the portable span filler on architectures
+// without a word at a time implementation.
+
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package oksvg
+
+import "image/color"
+
+func fillSpan(pix, cov []uint8, c color.RGBA) {
+	fillSpanGeneric(pix, cov, c)
+}
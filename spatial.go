@@ -0,0 +1,184 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// spatial.go implements an index of the bounds of the paths of an icon, for
+// hit testing and culling in interactive applications.
+
+package oksvg
+
+import (
+	"math"
+	"sort"
+
+	"github.com/srwiley/rasterx"
+)
+
+// indexFanout is the most children of a node of a SpatialIndex.
+const indexFanout = 16
+
+// SpatialIndex is an R-tree over the bounds of the paths of an icon, which finds
+// the paths near a point or within a region without looking at every path, for
+// hit testing and for drawing the visible part of icons with tens of thousands
+// of paths. Bounds are in the user units of the ViewBox, before the Transform of
+// the icon, and contain all a path draws, including its stroke drawn one pixel
+// per user unit, so a path found near a point may not cover it. The index is
+// built once, packed with the sort-tile-recursive method, and is not changed
+// with the paths of the icon; it must be built again after paths are added,
+// removed or moved.
+type SpatialIndex struct {
+	bounds []ViewBox     // of each path, by its index in SVGPaths
+	order  []int         // path indices, ordered by the leaves they are in
+	levels [][]indexNode // the leaves first and the top level, of at most indexFanout nodes, last
+	stroke float64       // the largest distance in pixels a stroke reaches beyond its path
+}
+
+// indexNode is a node of a SpatialIndex, bounding its children, which are the
+// entries first to end of the level below or, for leaves, of order.
+type indexNode struct {
+	box        ViewBox
+	first, end int
+}
+
+// SpatialIndex returns a new SpatialIndex of the paths of the icon.
+func (s *SvgIcon) SpatialIndex() *SpatialIndex {
+	x := &SpatialIndex{bounds: make([]ViewBox, len(s.SVGPaths)), order: make([]int, len(s.SVGPaths))}
+	for i := range s.SVGPaths {
+		svgp := &s.SVGPaths[i]
+		ss := svgp.StrokeStyle()
+		x.bounds[i] = svgp.bounds(svgp.mAdder.M, ss)
+		x.order[i] = i
+		if svgp.linerColor != nil {
+			x.stroke = math.Max(x.stroke, ss.LineWidth/2*math.Max(ss.MiterLimit, 2))
+		}
+	}
+	x.build()
+	return x
+}
+
+// build packs the paths of x into leaves and the leaves into levels of nodes
+// until one level has at most indexFanout nodes.
+func (x *SpatialIndex) build() {
+	center := func(b ViewBox) (float64, float64) { return b.X + b.W/2, b.Y + b.H/2 }
+	// Sort the paths into vertical slices by their centers, and each slice from
+	// top to bottom, so that the paths of each leaf are close together
+	n := len(x.order)
+	leaves := (n + indexFanout - 1) / indexFanout
+	slice := int(math.Ceil(math.Sqrt(float64(leaves)))) * indexFanout
+	sort.SliceStable(x.order, func(i, j int) bool {
+		xi, _ := center(x.bounds[x.order[i]])
+		xj, _ := center(x.bounds[x.order[j]])
+		return xi < xj
+	})
+	for i := 0; i < n; i += slice {
+		part := x.order[i:minInt(i+slice, n)]
+		sort.SliceStable(part, func(i, j int) bool {
+			_, yi := center(x.bounds[part[i]])
+			_, yj := center(x.bounds[part[j]])
+			return yi < yj
+		})
+	}
+	level := make([]indexNode, 0, leaves)
+	for i := 0; i < n; i += indexFanout {
+		node := indexNode{first: i, end: minInt(i+indexFanout, n)}
+		node.box = x.bounds[x.order[i]]
+		for _, p := range x.order[i+1 : node.end] {
+			node.box = unionViewBox(node.box, x.bounds[p])
+		}
+		level = append(level, node)
+	}
+	// Nodes next to each other in a level are close, as their paths are
+	for len(level) > indexFanout {
+		x.levels = append(x.levels, level)
+		up := make([]indexNode, 0, (len(level)+indexFanout-1)/indexFanout)
+		for i := 0; i < len(level); i += indexFanout {
+			node := indexNode{box: level[i].box, first: i, end: minInt(i+indexFanout, len(level))}
+			for _, c := range level[i+1 : node.end] {
+				node.box = unionViewBox(node.box, c.box)
+			}
+			up = append(up, node)
+		}
+		level = up
+	}
+	x.levels = append(x.levels, level)
+}
+
+// Len returns the number of paths in the index.
+func (x *SpatialIndex) Len() int {
+	return len(x.bounds)
+}
+
+// Bounds returns the bounds of the path with index i in SVGPaths.
+func (x *SpatialIndex) Bounds(i int) ViewBox {
+	return x.bounds[i]
+}
+
+// Search returns the indices in SVGPaths, in increasing order, which is the
+// order they are drawn in, of the paths whose bounds intersect the region r,
+// including those that only touch it.
+func (x *SpatialIndex) Search(r ViewBox) []int {
+	var found []int
+	top := len(x.levels) - 1
+	// Each entry is a level and the index of a node in it
+	stack := make([][2]int, 0, 2*indexFanout)
+	for i := range x.levels[top] {
+		stack = append(stack, [2]int{top, i})
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := x.levels[e[0]][e[1]]
+		if !overlaps(node.box, r) {
+			continue
+		}
+		if e[0] > 0 {
+			for c := node.first; c < node.end; c++ {
+				stack = append(stack, [2]int{e[0] - 1, c})
+			}
+			continue
+		}
+		for _, p := range x.order[node.first:node.end] {
+			if overlaps(x.bounds[p], r) {
+				found = append(found, p)
+			}
+		}
+	}
+	sort.Ints(found)
+	return found
+}
+
+// At returns the indices in SVGPaths, in increasing order, of the paths whose
+// bounds contain the point x, y in user units, for hit testing. The last of them
+// is drawn on top of the others. The index of an icon drawn with a Transform is
+// searched at the point mapped back by its inverse, as with
+// icon.Transform.Invert().Transform(x, y).
+func (x *SpatialIndex) At(px, py float64) []int {
+	return x.Search(ViewBox{X: px, Y: py})
+}
+
+// Visible returns the indices in SVGPaths, in increasing order, of the paths
+// that may draw into a w by h image when the icon is drawn with the transform
+// t, such as the Transform of an icon zoomed into by a viewer. Drawing only
+// them draws the same image as drawing all paths.
+func (x *SpatialIndex) Visible(t rasterx.Matrix2D, w, h int) []int {
+	inv := t.Invert()
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	// Strokes are as wide in pixels at any scale, and anti-aliasing reaches
+	// into the next pixel
+	m := x.stroke + 1
+	for _, p := range [][2]float64{{-m, -m}, {float64(w) + m, -m}, {-m, float64(h) + m}, {float64(w) + m, float64(h) + m}} {
+		ux, uy := inv.Transform(p[0], p[1])
+		x0, y0, x1, y1 = math.Min(x0, ux), math.Min(y0, uy), math.Max(x1, ux), math.Max(y1, uy)
+	}
+	return x.Search(ViewBox{x0, y0, x1 - x0, y1 - y0})
+}
+
+// overlaps reports whether the rectangles a and b intersect or touch.
+func overlaps(a, b ViewBox) bool {
+	return a.X <= b.X+b.W && b.X <= a.X+a.W && a.Y <= b.Y+b.H && b.Y <= a.Y+a.H
+}
+
+// unionViewBox returns the smallest rectangle containing a and b.
+func unionViewBox(a, b ViewBox) ViewBox {
+	x0, y0 := math.Min(a.X, b.X), math.Min(a.Y, b.Y)
+	x1, y1 := math.Max(a.X+a.W, b.X+b.W), math.Max(a.Y+a.H, b.Y+b.H)
+	return ViewBox{x0, y0, x1 - x0, y1 - y0}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// stream.go implements reading of documents one path at a time, for maps and
+// CAD exports too large to hold as an SvgIcon.
+
+package oksvg
+
+import (
+	"io"
+
+	"github.com/srwiley/rasterx"
+)
+
+// ElementHandler receives the document read by ParseSVG as it is read.
+type ElementHandler interface {
+	// Start is called once, before any path, with an icon holding the ViewBox,
+	// the Transform and the physical size of the document and none of its paths.
+	// ParseSVG goes on reading into the icon, so the handler must not change it.
+	Start(icon *SvgIcon) error
+	// Path is called with each path drawn by the document, in drawing order,
+	// with its resolved style and its Transform. The path is not kept by
+	// ParseSVG, so the handler may keep it.
+	Path(path SvgPath) error
+}
+
+// ParseSVG reads the document from r as ReadIconStream does, passing each path
+// to handler as soon as the element that draws it is read, instead of
+// returning them all as an icon. Only the definitions, gradients, style sheets,
+// titles and descriptions of the document are kept while it is read, so
+// documents with more paths than fit in memory can be processed. An error
+// returned by handler stops the reading and is returned.
+func ParseSVG(r io.Reader, handler ElementHandler) error {
+	return ParseSVGOptions(r, handler, ParseOptions{})
+}
+
+// ParseSVGOptions reads the document from r as ParseSVG does, with the options
+// opts. As the document is read once, references to elements and gradients
+// defined later in it are not resolved, and are reported as the ErrorPolicy
+// says.
+func ParseSVGOptions(r io.Reader, handler ElementHandler, opts ParseOptions) error {
+	icon := &SvgIcon{Defs: make(map[string][]definition), Grads: make(map[string]*rasterx.Gradient), Transform: rasterx.Identity}
+	c := newIconCursor(icon, opts)
+	c.handler = handler
+	if err := c.read(r, opts); err != nil {
+		return err
+	}
+	if err := c.flush(); err != nil || c.started {
+		return err
+	}
+	return handler.Start(icon) // the document is empty
+}
+
+// flush passes the paths read so far to the handler of the cursor, if it has
+// one, and drops them from the icon. It is called between tokens, when the
+// paths of clip paths, masks and patterns have been taken out of SVGPaths.
+func (c *IconCursor) flush() error {
+	if c.handler == nil {
+		return nil
+	}
+	// The root svg element sets the ViewBox before anything is drawn
+	if !c.started && (len(c.ids) > 0 || len(c.icon.SVGPaths) > 0) {
+		c.started = true
+		if err := c.handler.Start(c.icon); err != nil {
+			return err
+		}
+	}
+	for _, svgp := range c.icon.SVGPaths {
+		if err := c.handler.Path(svgp); err != nil {
+			return err
+		}
+	}
+	c.icon.SVGPaths = c.icon.SVGPaths[:0]
+	return nil
+}
@@ -6,32 +6,422 @@
 package oksvg
 
 import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+
 	"github.com/srwiley/rasterx"
 )
 
+// ViewBox is the rectangle in user space that is mapped to the bounds of an SvgIcon.
+type ViewBox struct{ X, Y, W, H float64 }
+
 // SvgIcon holds data from parsed SVGs.
 type SvgIcon struct {
-	ViewBox      struct{ X, Y, W, H float64 }
+	ViewBox      ViewBox
 	Titles       []string // Title elements collect here
 	Descriptions []string // Description elements collect here
 	Grads        map[string]*rasterx.Gradient
 	Defs         map[string][]definition
 	SVGPaths     []SvgPath
 	Transform    rasterx.Matrix2D
-	classes      map[string]styleAttribute
+	Budget       *TessellationBudget // if nil, DefaultTessellationBudget is used
+	// PreserveAspectRatio is the preserveAspectRatio attribute of the svg element,
+	// such as "xMidYMid meet", which SetTarget and Rasterize honor when mapping the
+	// ViewBox. If it is empty, as when the attribute is missing, the aspect ratio
+	// of the ViewBox is kept and it is centered, as browsers do. Setting it to
+	// "none" stretches the ViewBox over the whole target.
+	PreserveAspectRatio string
+	// IsolateOpacity composites the fill and stroke of each path with an opacity
+	// attribute as one layer, instead of applying the opacity to each of them.
+	IsolateOpacity bool
+	Quirks         Quirks               // rendering behaviors of legacy generators; none by default, see WithQuirks
+	Diagnostics    []Diagnostic         // problems that were ignored or logged while reading the icon
+	styleRules     []cssRule            // rules of the style elements, in document order
+	titles         map[string]string    // title text by the id of the element it describes
+	descriptions   map[string]string    // desc text by the id of the element it describes
+	gradSources    map[string]SourcePos // location of each gradient in the source, by id
+	physW, physH   float64              // width and height of the svg element in millimeters, zero if unknown
+	elements       []elementSpan        // elements with an id outside defs, in document order
+	pages          []pageSpan           // pages of a document of several pages, in document order
+	readOpts       ParseOptions         // options the icon was read with, for ReplaceElement
 }
 
 // Draw the compiled SVG icon into the GraphicContext.
 // All elements should be contained by the Bounds rectangle of the SvgIcon.
 func (s *SvgIcon) Draw(r *rasterx.Dasher, opacity float64) {
+	tb := s.budget()
+	for _, svgp := range s.SVGPaths {
+		s.drawPath(&svgp, r, opacity, s.Transform, tb, s.Quirks)
+	}
+}
+
+// drawPath draws svgp, isolating its opacity if the IsolateOpacity option is set
+// and following the quirks profile q.
+func (s *SvgIcon) drawPath(svgp *SvgPath, r *rasterx.Dasher, opacity float64,
+	t rasterx.Matrix2D, tb TessellationBudget, q Quirks) {
+	if q.StrokeBeforeFill && svgp.fillerColor != nil && svgp.linerColor != nil {
+		line, fill := *svgp, *svgp
+		line.fillerColor, fill.linerColor = nil, nil
+		s.drawPath(&line, r, opacity, t, tb, q)
+		s.drawPath(&fill, r, opacity, t, tb, q)
+		return
+	}
+	if s.IsolateOpacity {
+		svgp.drawIsolated(r, opacity, t, svgp.StrokeStyle(), tb)
+		return
+	}
+	svgp.drawBudgeted(r, opacity, t, svgp.StrokeStyle(), tb)
+}
+
+// compact drops the data of the icon that is not needed to draw it and, if
+// packPaths is true, moves its paths into a single allocation.
+func (s *SvgIcon) compact(packPaths bool) {
+	s.Titles, s.Descriptions, s.titles, s.descriptions = nil, nil, nil, nil
+	s.Defs, s.Grads, s.styleRules, s.gradSources = nil, nil, nil, nil
+	s.elements = nil
+	if !packPaths { // already packed in an arena
+		for i := range s.SVGPaths {
+			s.SVGPaths[i].Source = SourcePos{}
+		}
+		return
+	}
+	n := 0
 	for _, svgp := range s.SVGPaths {
-		svgp.DrawTransformed(r, opacity, s.Transform)
+		n += len(svgp.Path)
 	}
+	buf := make(rasterx.Path, 0, n)
+	paths := make([]SvgPath, len(s.SVGPaths))
+	for i, svgp := range s.SVGPaths {
+		start := len(buf)
+		buf = append(buf, svgp.Path...)
+		svgp.Path = buf[start:len(buf):len(buf)]
+		svgp.Source = SourcePos{}
+		paths[i] = svgp
+	}
+	s.SVGPaths = paths
+}
+
+// DrawIn draws the compiled SVG icon into the rectangle rect of dst, using a scanner
+// sized to rect rather than to all of dst. The Transform of the icon maps to the
+// coordinates of dst, and anything outside of rect is clipped. This makes stamping
+// small icons onto a large canvas cheap.
+func (s *SvgIcon) DrawIn(dst draw.Image, rect image.Rectangle, opacity float64) {
+	rect = rect.Intersect(dst.Bounds())
+	if rect.Empty() {
+		return
+	}
+	var sub draw.Image
+	if si, ok := dst.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		sub, _ = si.SubImage(rect).(draw.Image)
+	}
+	if sub == nil {
+		sub = &boundedImage{dst, rect}
+	}
+	dc := AcquireDrawContext(rect.Dx(), rect.Dy())
+	dc.Draw(s, sub, opacity)
+	dc.Release()
 }
 
-// SetTarget sets the Transform matrix to draw within the bounds of the rectangle arguments
+// boundedImage exposes the rectangle rect of a draw.Image that does not support
+// SubImage as a draw.Image with bounds rect.
+type boundedImage struct {
+	draw.Image
+	rect image.Rectangle
+}
+
+// Bounds returns the rectangle of the underlying image exposed by the boundedImage.
+func (o *boundedImage) Bounds() image.Rectangle { return o.rect }
+
+// SetTarget sets the Transform matrix to draw within the bounds of the rectangle arguments.
+// The ViewBox is mapped onto the rectangle as the PreserveAspectRatio of the icon
+// says, scaled uniformly and aligned within the rectangle, or stretched if their
+// aspect ratios differ when it is "none". With slice, the parts of the ViewBox
+// outside the rectangle are drawn too.
 func (s *SvgIcon) SetTarget(x, y, w, h float64) {
-	scaleW := w / s.ViewBox.W
-	scaleH := h / s.ViewBox.H
-	s.Transform = rasterx.Identity.Translate(x-s.ViewBox.X, y-s.ViewBox.Y).Scale(scaleW, scaleH)
+	align, slice := s.aspectRatio()
+	s.Transform = rasterx.Identity.Translate(x, y).Mult(viewBoxTransform(s.ViewBox, align, slice, w, h))
+}
+
+// aspectRatio returns the alignment of the PreserveAspectRatio of the icon and
+// whether it slices, "xMidYMid" meet if it is empty as in SVG.
+func (s *SvgIcon) aspectRatio() (align string, slice bool) {
+	if s.PreserveAspectRatio == "" {
+		return "xMidYMid", false
+	}
+	return parseAspectRatio(s.PreserveAspectRatio)
+}
+
+// PhysicalSize returns the width and height of the icon in millimeters, from the
+// width and height attributes of its svg element. Lengths without a unit are in
+// CSS pixels of 1/96 inch. The result is false if either length is missing or
+// relative, as percentages are.
+func (s *SvgIcon) PhysicalSize() (w, h float64, ok bool) {
+	return s.physW, s.physH, s.physW > 0 && s.physH > 0
+}
+
+// AspectRatio returns the ratio of the width to the height of the ViewBox,
+// or zero if the height is zero.
+func (v ViewBox) AspectRatio() float64 {
+	if v.H == 0 {
+		return 0
+	}
+	return v.W / v.H
+}
+
+// Contains reports whether the point x, y lies within the ViewBox.
+func (v ViewBox) Contains(x, y float64) bool {
+	return x >= v.X && x < v.X+v.W && y >= v.Y && y < v.Y+v.H
+}
+
+// TransformTo returns the matrix that maps the ViewBox onto the rectangle r.
+func (v ViewBox) TransformTo(r ViewBox) rasterx.Matrix2D {
+	return rasterx.Identity.Translate(r.X, r.Y).Scale(r.W/v.W, r.H/v.H).Translate(-v.X, -v.Y)
+}
+
+// String returns the ViewBox in the format of the SVG viewBox attribute.
+func (v ViewBox) String() string {
+	return strconv.FormatFloat(v.X, 'g', -1, 64) + " " + strconv.FormatFloat(v.Y, 'g', -1, 64) + " " +
+		strconv.FormatFloat(v.W, 'g', -1, 64) + " " + strconv.FormatFloat(v.H, 'g', -1, 64)
+}
+
+// MarshalText encodes the ViewBox in the format of the SVG viewBox attribute,
+// which is also used when the ViewBox is marshaled to JSON.
+func (v ViewBox) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText decodes the ViewBox from the format of the SVG viewBox attribute.
+func (v *ViewBox) UnmarshalText(text []byte) error {
+	var c PathCursor
+	if err := c.GetPoints(string(text)); err != nil {
+		return err
+	}
+	if len(c.points) != 4 {
+		return errParamMismatch
+	}
+	v.X, v.Y, v.W, v.H = c.points[0], c.points[1], c.points[2], c.points[3]
+	return nil
+}
+
+// TitleFor returns the text of the title element belonging to the element with
+// the given id, or to the root svg element if id is empty.
+func (s *SvgIcon) TitleFor(id string) string {
+	return s.titles[id]
+}
+
+// DescriptionFor returns the text of the desc element belonging to the element with
+// the given id, or to the root svg element if id is empty.
+func (s *SvgIcon) DescriptionFor(id string) string {
+	return s.descriptions[id]
+}
+
+// RenameID re-keys the gradient or definition with id oldID to newID, and updates
+// all references to oldID within the definitions of the icon.
+// Paths that were already parsed hold their own copy of any referenced gradient,
+// so they are unaffected. If a gradient or definition already has the id newID,
+// nothing is renamed and an error is returned.
+func (s *SvgIcon) RenameID(oldID, newID string) error {
+	if oldID == newID {
+		return nil
+	}
+	if _, ok := s.Grads[newID]; ok || len(s.Defs[newID]) > 0 {
+		return fmt.Errorf("%w: %s", errDuplicateID, newID)
+	}
+	s.rekeyIDs(map[string]string{oldID: newID})
+	return nil
+}
+
+// PrefixIDs prepends prefix to the ids of all gradients and definitions of the icon,
+// and updates the references to them within the definitions.
+func (s *SvgIcon) PrefixIDs(prefix string) {
+	if prefix != "" {
+		s.rekeyIDs(s.prefixedIDs(prefix))
+	}
+}
+
+// Merge appends the paths, gradients and definitions of o to the icon.
+// The ids of o are namespaced by prefix, so they do not collide with the ids of
+// the icon. The paths of o are drawn using the Transform of the icon.
+// o is not modified.
+func (s *SvgIcon) Merge(o *SvgIcon, prefix string) {
+	if s.Grads == nil {
+		s.Grads = make(map[string]*rasterx.Gradient)
+	}
+	if s.Defs == nil {
+		s.Defs = make(map[string][]definition)
+	}
+	keys := o.prefixedIDs(prefix)
+	for id, g := range o.Grads {
+		gc := *g
+		gc.Stops = append([]rasterx.GradStop(nil), g.Stops...)
+		s.Grads[keys[id]] = &gc
+	}
+	for id, defs := range o.Defs {
+		dc := make([]definition, len(defs))
+		for i, def := range defs {
+			dc[i] = def
+			dc[i].Attrs = append([]xml.Attr(nil), def.Attrs...)
+		}
+		s.Defs[keys[id]] = dc
+	}
+	for _, p := range o.SVGPaths {
+		p.Path = append(rasterx.Path(nil), p.Path...)
+		s.SVGPaths = append(s.SVGPaths, p)
+	}
+	s.Titles = append(s.Titles, o.Titles...)
+	s.Descriptions = append(s.Descriptions, o.Descriptions...)
+	s.titles = mergeText(s.titles, o.titles, keys)
+	s.descriptions = mergeText(s.descriptions, o.descriptions, keys)
+	s.gradSources = mergeSources(s.gradSources, o.gradSources, keys)
+	// Only the newly added definitions refer to the ids of o
+	added := make(map[string][]definition, len(o.Defs))
+	for id := range o.Defs {
+		added[keys[id]] = s.Defs[keys[id]]
+	}
+	rekeyDefs(added, keys)
+}
+
+// prefixedIDs maps each gradient and definition id of the icon to the id with prefix prepended.
+func (s *SvgIcon) prefixedIDs(prefix string) map[string]string {
+	keys := make(map[string]string, len(s.Grads)+len(s.Defs))
+	for id := range s.Grads {
+		if id != "" {
+			keys[id] = prefix + id
+		}
+	}
+	for id, defs := range s.Defs {
+		if id != "" {
+			keys[id] = prefix + id
+		}
+		for _, def := range defs {
+			if def.ID != "" {
+				keys[def.ID] = prefix + def.ID
+			}
+		}
+	}
+	for _, text := range []map[string]string{s.titles, s.descriptions} {
+		for id := range text {
+			if id != "" {
+				keys[id] = prefix + id
+			}
+		}
+	}
+	return keys
+}
+
+// rekeyIDs renames the gradients and definitions of the icon according to keys,
+// which maps old ids to new ids, and updates the references within the definitions.
+func (s *SvgIcon) rekeyIDs(keys map[string]string) {
+	grads := make(map[string]*rasterx.Gradient, len(s.Grads))
+	for id, g := range s.Grads {
+		if nk, ok := keys[id]; ok {
+			id = nk
+		}
+		grads[id] = g
+	}
+	s.Grads = grads
+	defs := make(map[string][]definition, len(s.Defs))
+	for id, d := range s.Defs {
+		if nk, ok := keys[id]; ok {
+			id = nk
+		}
+		defs[id] = d
+	}
+	s.Defs = defs
+	rekeyDefs(s.Defs, keys)
+	s.titles = mergeText(nil, s.titles, keys)
+	s.descriptions = mergeText(nil, s.descriptions, keys)
+	s.gradSources = mergeSources(nil, s.gradSources, keys)
+}
+
+// mergeText adds the title or desc texts of src to dst, renaming their element ids
+// according to keys, and returns dst. The text of the root element of src is kept
+// only if dst has none of its own.
+func mergeText(dst, src map[string]string, keys map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for id, text := range src {
+		if nk, ok := keys[id]; ok {
+			id = nk
+		}
+		if _, ok := dst[id]; ok && id == "" {
+			continue
+		}
+		dst[id] = text
+	}
+	return dst
+}
+
+// mergeSources adds the source locations of src to dst, renaming their ids
+// according to keys, and returns dst.
+func mergeSources(dst, src map[string]SourcePos, keys map[string]string) map[string]SourcePos {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]SourcePos, len(src))
+	}
+	for id, pos := range src {
+		if nk, ok := keys[id]; ok {
+			id = nk
+		}
+		dst[id] = pos
+	}
+	return dst
+}
+
+// rekeyDefs renames the ids of the definitions and the references within their
+// attributes according to keys.
+func rekeyDefs(defs map[string][]definition, keys map[string]string) {
+	for _, ds := range defs {
+		for i := range ds {
+			if nk, ok := keys[ds[i].ID]; ok && ds[i].ID != "" {
+				ds[i].ID = nk
+			}
+			for j, attr := range ds[i].Attrs {
+				ds[i].Attrs[j].Value = rekeyRefs(attr.Value, keys)
+			}
+		}
+	}
+}
+
+// rekeyRefs replaces the href or url references in the attribute value v
+// to ids found in keys with the mapped ids.
+func rekeyRefs(v string, keys map[string]string) string {
+	if strings.HasPrefix(v, "#") {
+		if nk, ok := keys[v[1:]]; ok {
+			return "#" + nk
+		}
+		return v
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(v, "url(#")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(v[i:], ')')
+		if j < 0 {
+			break
+		}
+		id := v[i+5 : i+j]
+		if nk, ok := keys[strings.TrimSpace(id)]; ok {
+			id = nk
+		}
+		b.WriteString(v[:i+5])
+		b.WriteString(id)
+		v = v[i+j:]
+	}
+	b.WriteString(v)
+	return b.String()
 }
@@ -6,7 +6,9 @@
 package oksvg
 
 import (
+	"image"
 	"image/color"
+	"math"
 
 	"github.com/srwiley/rasterx"
 	"golang.org/x/image/math/fixed"
@@ -15,79 +17,269 @@ import (
 // SvgPath binds a style to a path.
 type SvgPath struct {
 	PathStyle
-	Path rasterx.Path
+	Path   rasterx.Path
+	Source SourcePos // location of the element the path was read from
 }
 
-// Draw the compiled SvgPath into the Dasher.
+// Draw the compiled SvgPath into the Dasher. A ScannerSpan scanner rasterizes
+// only the extent of the path, while a rasterx.ScannerGV sweeps its whole image.
 func (svgp *SvgPath) Draw(r *rasterx.Dasher, opacity float64) {
 	svgp.DrawTransformed(r, opacity, rasterx.Identity)
 }
 
 // DrawTransformed draws the compiled SvgPath into the Dasher while applying transform t.
 func (svgp *SvgPath) DrawTransformed(r *rasterx.Dasher, opacity float64, t rasterx.Matrix2D) {
+	svgp.DrawWithStroke(r, opacity, t, svgp.StrokeStyle())
+}
+
+// DrawWithStroke draws the compiled SvgPath into the Dasher while applying transform t,
+// using the StrokeStyle ss in place of the parsed stroke style of the SvgPath.
+// Nil cap and gap functions of ss are resolved by the Dasher: a nil LineCap is a
+// ButtCap and a nil LeadLineCap is the LineCap, as StrokeStyle resolves them.
+// The segments, joins and caps of the whole stroke are added to the scanner as
+// one path, so scanners that accumulate coverage, as ScannerGV and ScannerSpan
+// do, composite the stroke once and overlaps are not darkened by its opacity.
+func (svgp *SvgPath) DrawWithStroke(r *rasterx.Dasher, opacity float64, t rasterx.Matrix2D, ss StrokeStyle) {
+	svgp.drawBudgeted(r, opacity, t, ss, DefaultTessellationBudget)
+}
+
+// drawBudgeted draws the SvgPath as DrawWithStroke does, flattening its curves
+// within the TessellationBudget tb.
+func (svgp *SvgPath) drawBudgeted(r *rasterx.Dasher, opacity float64, t rasterx.Matrix2D,
+	ss StrokeStyle, tb TessellationBudget) {
+	if !svgp.mayDraw(r, t, ss) {
+		return
+	}
+	if len(svgp.clips) > 0 {
+		svgp.drawClipped(r, opacity, t, ss, tb)
+		return
+	}
 	m := svgp.mAdder.M
 	svgp.mAdder.M = t.Mult(m)
 	defer func() { svgp.mAdder.M = m }() // Restore untransformed matrix
 	if svgp.fillerColor != nil {
+		// Path.AddTo stops each subpath, which the Filler closes, so fills close open
+		// subpaths as browsers do, while strokes below keep them open
 		r.Clear()
 		rf := &r.Filler
 		rf.SetWinding(svgp.UseNonZeroWinding)
-		svgp.mAdder.Adder = rf // This allows transformations to be applied
-		svgp.Path.AddTo(&svgp.mAdder)
+		if svgp.UseNonZeroWinding {
+			svgp.mAdder.Adder = &budgetAdder{Adder: rf, TessellationBudget: tb} // This allows transformations to be applied
+			svgp.Path.AddTo(&svgp.mAdder)
+		} else {
+			// The vector rasterizer only fills with the nonzero rule
+			var eo evenOddScanner
+			svgp.mAdder.Adder = &budgetAdder{Adder: &rasterx.Filler{Scanner: &eo}, TessellationBudget: tb}
+			svgp.Path.AddTo(&svgp.mAdder)
+			eo.fillTo(rf)
+		}
 
 		switch fillerColor := svgp.fillerColor.(type) {
 		case color.Color:
 			rf.SetColor(rasterx.ApplyOpacity(fillerColor, svgp.FillOpacity*opacity))
 		case rasterx.Gradient:
 			if fillerColor.Units == rasterx.ObjectBoundingBox {
-				fRect := rf.Scanner.GetPathExtent()
-				mnx, mny := float64(fRect.Min.X)/64, float64(fRect.Min.Y)/64
-				mxx, mxy := float64(fRect.Max.X)/64, float64(fRect.Max.Y)/64
-				fillerColor.Bounds.X, fillerColor.Bounds.Y = mnx, mny
-				fillerColor.Bounds.W, fillerColor.Bounds.H = mxx-mnx, mxy-mny
+				fillerColor.Bounds = objectBounds(rf.Scanner)
 			}
-			rf.SetColor(fillerColor.GetColorFunction(svgp.FillOpacity * opacity))
+			// The stops are sorted in place and may be shared by paths drawn concurrently
+			fillerColor.Stops = append([]rasterx.GradStop(nil), fillerColor.Stops...)
+			rf.SetColor(fillerColor.GetColorFunctionUS(svgp.FillOpacity*opacity, userMatrix(&fillerColor, svgp.mAdder.M)))
+		case imagePaint:
+			rf.SetColor(fillerColor.colorFunction(svgp.mAdder.M, svgp.FillOpacity*opacity))
+		case *Pattern:
+			rf.SetColor(fillerColor.colorFunction(svgp.mAdder.M, svgp.Path, svgp.FillOpacity*opacity, tb))
 		}
 		rf.Draw()
 		// default is true
 		rf.SetWinding(true)
 	}
 	if svgp.linerColor != nil {
-		r.Clear()
-		svgp.mAdder.Adder = r
-		lineGap := svgp.LineGap
-		if lineGap == nil {
-			lineGap = DefaultStyle.LineGap
+		var bbox ViewBox
+		if g, ok := svgp.linerColor.(rasterx.Gradient); ok && g.Units == rasterx.ObjectBoundingBox {
+			// The bounding box is that of the dashes, without the stroke width
+			bbox = svgp.dashBounds(ss, tb)
 		}
-		lineCap := svgp.LineCap
-		if lineCap == nil {
-			lineCap = DefaultStyle.LineCap
-		}
-		leadLineCap := lineCap
-		if svgp.LeadLineCap != nil {
-			leadLineCap = svgp.LeadLineCap
-		}
-		r.SetStroke(fixed.Int26_6(svgp.LineWidth*64),
-			fixed.Int26_6(svgp.MiterLimit*64), leadLineCap, lineCap,
-			lineGap, svgp.LineJoin, svgp.Dash, svgp.DashOffset)
-		svgp.Path.AddTo(&svgp.mAdder)
+		svgp.addStroke(r, ss, tb)
 		switch linerColor := svgp.linerColor.(type) {
 		case color.Color:
 			r.SetColor(rasterx.ApplyOpacity(linerColor, svgp.LineOpacity*opacity))
 		case rasterx.Gradient:
 			if linerColor.Units == rasterx.ObjectBoundingBox {
-				fRect := r.Scanner.GetPathExtent()
-				mnx, mny := float64(fRect.Min.X)/64, float64(fRect.Min.Y)/64
-				mxx, mxy := float64(fRect.Max.X)/64, float64(fRect.Max.Y)/64
-				linerColor.Bounds.X, linerColor.Bounds.Y = mnx, mny
-				linerColor.Bounds.W, linerColor.Bounds.H = mxx-mnx, mxy-mny
+				linerColor.Bounds = bbox
 			}
-			r.SetColor(linerColor.GetColorFunction(svgp.LineOpacity * opacity))
+			// The stops are sorted in place and may be shared by paths drawn concurrently
+			linerColor.Stops = append([]rasterx.GradStop(nil), linerColor.Stops...)
+			r.SetColor(linerColor.GetColorFunctionUS(svgp.LineOpacity*opacity, userMatrix(&linerColor, svgp.mAdder.M)))
+		case *Pattern:
+			r.SetColor(linerColor.colorFunction(svgp.mAdder.M, svgp.Path, svgp.LineOpacity*opacity, tb))
 		}
 		r.Draw()
 	}
 }
 
+// addStroke clears r and adds the outline of the stroke of the SvgPath, with the
+// stroke style ss, to its scanner, flattening its curves within the
+// TessellationBudget tb. The svgp transform must already include the drawing
+// transform.
+func (svgp *SvgPath) addStroke(r *rasterx.Dasher, ss StrokeStyle, tb TessellationBudget) {
+	r.Clear()
+	svgp.mAdder.Adder = &budgetAdder{Adder: r, TessellationBudget: tb}
+	r.SetStroke(fixed.Int26_6(ss.LineWidth*64),
+		fixed.Int26_6(ss.MiterLimit*64), ss.LeadLineCap, ss.LineCap,
+		ss.LineGap, ss.LineJoin, ss.Dash, ss.DashOffset)
+	if ss.ContinueDash && len(r.Dashes) > 0 {
+		svgp.mAdder.Adder = &budgetAdder{Adder: newDashPhaseAdder(r), TessellationBudget: tb}
+	}
+	svgp.Path.AddTo(&svgp.mAdder)
+}
+
+// drawIsolated draws the SvgPath as drawBudgeted does, except that when it has
+// both a fill and a stroke and an opacity attribute below one, they are drawn
+// into a layer that is composited once with that opacity, as browsers do, so the
+// fill does not show through the stroke where they overlap.
+func (svgp *SvgPath) drawIsolated(r *rasterx.Dasher, opacity float64, t rasterx.Matrix2D,
+	ss StrokeStyle, tb TessellationBudget) {
+	op := svgp.opacity
+	if !svgp.mayDraw(r, t, ss) {
+		return
+	}
+	if svgp.fillerColor == nil || svgp.linerColor == nil || op <= 0 || op >= 1 {
+		svgp.drawBudgeted(r, opacity, t, ss, tb)
+		return
+	}
+	var es extentScanner
+	svgp.drawBudgeted(rasterx.NewDasher(1, 1, &es), 1, t, ss, tb)
+	rect := es.drawn.Intersect(image.Rect(0, 0, math.MaxInt32, math.MaxInt32))
+	if sr, ok := scannerRect(r.Scanner); ok {
+		// Only the part of the layer that reaches the image is drawn
+		rect = rect.Intersect(sr)
+	}
+	if rect.Empty() {
+		return
+	}
+	w, h := rect.Dx(), rect.Dy()
+	layer := image.NewRGBA(image.Rect(0, 0, w, h))
+	lr := rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, layer, layer.Bounds()))
+	inner := *svgp
+	inner.FillOpacity /= op
+	inner.LineOpacity /= op
+	inner.drawBudgeted(lr, 1, rasterx.Identity.Translate(-float64(rect.Min.X), -float64(rect.Min.Y)).Mult(t), ss, tb)
+
+	a := op * opacity
+	r.Clear()
+	rf := &r.Filler
+	rasterx.AddRect(float64(rect.Min.X), float64(rect.Min.Y), float64(rect.Max.X), float64(rect.Max.Y), 0, rf)
+	rf.SetColor(rasterx.ColorFunc(func(x, y int) color.Color {
+		c := layer.RGBAAt(x-rect.Min.X, y-rect.Min.Y)
+		return color.RGBA{uint8(float64(c.R) * a), uint8(float64(c.G) * a),
+			uint8(float64(c.B) * a), uint8(float64(c.A) * a)}
+	}))
+	rf.Draw()
+}
+
+// mayDraw reports whether the SvgPath, drawn with transform t and stroke style
+// ss, may cover any pixel of the image of r. Paths outside of the image are
+// skipped before they are flattened, so that drawing a small part of a large
+// icon, as viewers that pan and zoom do, takes time in proportion to the paths
+// that are visible. Only the images of rasterx.ScannerGV and ScannerSpan
+// scanners are known; paths drawn with other scanners are never skipped.
+func (svgp *SvgPath) mayDraw(r *rasterx.Dasher, t rasterx.Matrix2D, ss StrokeStyle) bool {
+	rect, ok := scannerRect(r.Scanner)
+	if !ok {
+		return true
+	}
+	b := svgp.bounds(t.Mult(svgp.mAdder.M), ss)
+	const margin = 1 // anti-aliasing reaches into the next pixel
+	return b.X+b.W+margin > float64(rect.Min.X) && b.Y+b.H+margin > float64(rect.Min.Y) &&
+		b.X-margin < float64(rect.Max.X) && b.Y-margin < float64(rect.Max.Y)
+}
+
+// scannerRect returns the rectangle, in the coordinates paths are drawn in, that
+// the scanner s may draw pixels into, if s is a scanner whose image is known.
+// A ScannerGV draws its mask at the origin of its destination, whatever its
+// Targ, so it may draw anywhere in the size of the destination.
+func scannerRect(s rasterx.Scanner) (image.Rectangle, bool) {
+	switch s := s.(type) {
+	case *rasterx.ScannerGV:
+		return image.Rectangle{Max: s.Dest.Bounds().Size()}, true
+	case *ScannerSpan:
+		rect := image.Rect(0, 0, s.width, s.height).Intersect(image.Rectangle{Max: s.Dest.Bounds().Size()})
+		if s.clip != image.ZR {
+			rect = rect.Intersect(s.clip)
+		}
+		return rect, true
+	}
+	return image.Rectangle{}, false
+}
+
+// bounds returns a rectangle containing all that the SvgPath draws with the
+// transform m, which includes that of the path, and stroke style ss: the
+// bounds of the control points of the path, widened by the longest miter of
+// its stroke.
+func (svgp *SvgPath) bounds(m rasterx.Matrix2D, ss StrokeStyle) ViewBox {
+	ext := pathExtent(svgp.Path)
+	x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range [][2]float64{{ext.X, ext.Y}, {ext.X + ext.W, ext.Y}, {ext.X, ext.Y + ext.H}, {ext.X + ext.W, ext.Y + ext.H}} {
+		x, y := m.Transform(p[0], p[1])
+		x0, y0, x1, y1 = math.Min(x0, x), math.Min(y0, y), math.Max(x1, x), math.Max(y1, y)
+	}
+	if svgp.linerColor != nil {
+		// Stroke widths are taken to be scaled by the transform, which is
+		// the larger of the widths they are drawn with
+		scale := math.Max(1, math.Max(math.Hypot(m.A, m.B), math.Hypot(m.C, m.D)))
+		margin := ss.LineWidth / 2 * math.Max(ss.MiterLimit, 2) * scale
+		x0, y0, x1, y1 = x0-margin, y0-margin, x1+margin, y1+margin
+	}
+	return ViewBox{x0, y0, x1 - x0, y1 - y0}
+}
+
+// userMatrix returns the transform from the user space of the gradient g, painting
+// a path drawn with transform m, to the device. Only userSpaceOnUse gradients
+// are transformed; objectBoundingBox ones are mapped onto the device bounds.
+func userMatrix(g *rasterx.Gradient, m rasterx.Matrix2D) rasterx.Matrix2D {
+	if g.Units == rasterx.ObjectBoundingBox {
+		return rasterx.Identity
+	}
+	return m
+}
+
+// geometryBounds returns the bounding box of the flattened path, in the device
+// coordinates of its transform, as objectBounds does for a fill. The svgp
+// transform must already include the drawing transform.
+func (svgp *SvgPath) geometryBounds(tb TessellationBudget) ViewBox {
+	var es extentScanner
+	es.Clear()
+	svgp.mAdder.Adder = &budgetAdder{Adder: &rasterx.Filler{Scanner: &es}, TessellationBudget: tb}
+	svgp.Path.AddTo(&svgp.mAdder)
+	return objectBounds(&es)
+}
+
+// dashBounds returns the bounding box of the dashes of the stroke of the
+// flattened path with the stroke style ss, without the stroke width, or that of
+// the whole path if the stroke is not dashed, as geometryBounds does.
+func (svgp *SvgPath) dashBounds(ss StrokeStyle, tb TessellationBudget) ViewBox {
+	if len(ss.Dash) == 0 {
+		return svgp.geometryBounds(tb)
+	}
+	var es extentScanner
+	es.Clear()
+	// A hairline with butt caps and bevel joins covers only the dashes themselves
+	ss.LineWidth, ss.LeadLineCap, ss.LineCap, ss.LineGap, ss.LineJoin = 2.0/64, rasterx.ButtCap, rasterx.ButtCap,
+		rasterx.FlatGap, rasterx.Bevel
+	svgp.addStroke(rasterx.NewDasher(1, 1, &es), ss, tb)
+	return objectBounds(&es)
+}
+
+// objectBounds returns the bounding box of the path last added to the scanner s,
+// in the device coordinates the path was drawn with. It is the box that paints,
+// and any other content using objectBoundingBox units, are mapped onto.
+func objectBounds(s rasterx.Scanner) ViewBox {
+	fRect := s.GetPathExtent()
+	mnx, mny := float64(fRect.Min.X)/64, float64(fRect.Min.Y)/64
+	mxx, mxy := float64(fRect.Max.X)/64, float64(fRect.Max.Y)/64
+	return ViewBox{X: mnx, Y: mny, W: mxx - mnx, H: mxy - mny}
+}
+
 // GetFillColor returns the fill color of the SvgPath if one is defined and otherwise returns colornames.Black
 func (svgp *SvgPath) GetFillColor() color.Color {
 	return getColor(svgp.fillerColor)
@@ -107,3 +299,49 @@ func (svgp *SvgPath) SetFillColor(clr color.Color) {
 func (svgp *SvgPath) SetLineColor(clr color.Color) {
 	svgp.linerColor = clr
 }
+
+// SetStrokeColor sets the stroke color of the SvgPath, as SetLineColor does
+func (svgp *SvgPath) SetStrokeColor(clr color.Color) {
+	svgp.SetLineColor(clr)
+}
+
+// SetFillGradient sets the fill of the SvgPath to the gradient g
+func (svgp *SvgPath) SetFillGradient(g *rasterx.Gradient) {
+	svgp.fillerColor = *g
+}
+
+// SetLineGradient sets the line of the SvgPath to the gradient g
+func (svgp *SvgPath) SetLineGradient(g *rasterx.Gradient) {
+	svgp.linerColor = *g
+}
+
+// FillGradient returns a copy of the gradient filling the SvgPath, with stops
+// of its own, or false if the fill is not a gradient. Changes to the copy, such
+// as new stop colors, are drawn once it is set back with SetFillGradient.
+func (svgp *SvgPath) FillGradient() (*rasterx.Gradient, bool) {
+	return copyGradient(svgp.fillerColor)
+}
+
+// LineGradient returns a copy of the gradient of the line of the SvgPath, as
+// FillGradient does for the fill.
+func (svgp *SvgPath) LineGradient() (*rasterx.Gradient, bool) {
+	return copyGradient(svgp.linerColor)
+}
+
+// Transform returns the transform from the coordinates of the Path to the
+// user units of the ViewBox, which includes the transforms of the element and
+// its ancestors.
+func (svgp *SvgPath) Transform() rasterx.Matrix2D {
+	return svgp.mAdder.M
+}
+
+// copyGradient returns a copy of the paint p with its own stops if it is a
+// gradient.
+func copyGradient(p interface{}) (*rasterx.Gradient, bool) {
+	g, ok := p.(rasterx.Gradient)
+	if !ok {
+		return nil, false
+	}
+	g.Stops = append([]rasterx.GradStop(nil), g.Stops...)
+	return &g, true
+}
@@ -4,17 +4,31 @@ package oksvg_test
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
 
+	"image/jpeg"
 	"image/png"
 	"strings"
 	"testing"
 
 	. "github.com/srwiley/oksvg"
 	. "github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
 	//"github.com/srwiley/go/scanFT"
 )
 
@@ -205,6 +219,1056 @@ func TestBadColor(t *testing.T) {
 	}
 }
 
+func TestErrorPolicy(t *testing.T) {
+	const (
+		unknown = `<foo/>`
+		badPath = `<path d="M0,0 L1"/>`
+		missing = `<use href="#none"/><rect width="1" height="1" fill="url(#none)"/>`
+	)
+	lenient := ErrorPolicy{UnknownElement: IgnoreErrorMode, MalformedValue: StrictErrorMode,
+		MissingReference: IgnoreErrorMode}
+	for _, tc := range []struct {
+		body   string
+		policy ErrorPolicy
+		fail   bool
+	}{
+		{unknown + missing, lenient, false},
+		{badPath, lenient, true},
+		{unknown, StrictErrorMode.Policy(), true},
+		{badPath, StrictErrorMode.Policy(), true},
+		{missing, StrictErrorMode.Policy(), true},
+		{missing, ErrorPolicy{MissingReference: StrictErrorMode}, true},
+		{unknown + badPath + missing, IgnoreErrorMode.Policy(), false},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">` + tc.body + `</svg>`
+		_, err := ReadIconStreamPolicy(strings.NewReader(svg), tc.policy)
+		if (err != nil) != tc.fail {
+			t.Errorf("%s with %+v: error %v", tc.body, tc.policy, err)
+		}
+	}
+}
+
+func TestRelativeLengths(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 300 400" font-size="10">
+	<g font-size="2em">
+		<path d="M0,0 H10" stroke="black" stroke-width="0.5em" stroke-dasharray="1em,0.5rem"/>
+		<path d="M0,0 H10" stroke="black" stroke-dashoffset="1em" font-size="150%"/>
+		<path d="M0,0 H10" stroke="black" stroke-width="1%" font-size="larger"/>
+	</g>
+	<path d="M0,0 H10" stroke="black" style="stroke-width:2em;font-size:small"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := icon.SVGPaths
+	if p[0].LineWidth != 10 || len(p[0].Dash) != 2 || p[0].Dash[0] != 20 || p[0].Dash[1] != 5 {
+		t.Errorf("em lengths: width %v, dashes %v", p[0].LineWidth, p[0].Dash)
+	}
+	if p[1].DashOffset != 30 {
+		t.Errorf("em offset with percentage font size: %v", p[1].DashOffset)
+	}
+	// The normalized diagonal of a 300 by 400 viewBox is 500/sqrt(2)
+	if w := p[2].LineWidth; math.Abs(w-5/math.Sqrt2) > 1e-9 {
+		t.Errorf("percentage width: %v", w)
+	}
+	if p[3].LineWidth != 26 {
+		t.Errorf("em width with keyword font size: %v", p[3].LineWidth)
+	}
+}
+
+func TestLowMemory(t *testing.T) {
+	full, err := ReadIcon("testdata/landscapeIcons/beach.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/landscapeIcons/beach.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	low, err := ReadIconStreamOptions(f, ParseOptions{LowMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if low.Titles != nil || low.Descriptions != nil || low.Defs != nil || low.Grads != nil {
+		t.Error("low memory icon keeps data not needed to draw it")
+	}
+	if len(low.SVGPaths) != len(full.SVGPaths) {
+		t.Fatalf("got %d paths, want %d", len(low.SVGPaths), len(full.SVGPaths))
+	}
+	for i := range low.SVGPaths {
+		if low.SVGPaths[i].Source != (SourcePos{}) || !reflect.DeepEqual(low.SVGPaths[i].Path, full.SVGPaths[i].Path) {
+			t.Fatalf("path %d differs", i)
+		}
+	}
+	w, h := int(full.ViewBox.W), int(full.ViewBox.H)
+	a, b := image.NewRGBA(image.Rect(0, 0, w, h)), image.NewRGBA(image.Rect(0, 0, w, h))
+	full.Draw(NewDasher(w, h, NewScannerGV(w, h, a, a.Bounds())), 1)
+	low.Draw(NewDasher(w, h, NewScannerGV(w, h, b, b.Bounds())), 1)
+	if !bytes.Equal(a.Pix, b.Pix) {
+		t.Error("low memory icon draws differently")
+	}
+}
+
+func TestArena(t *testing.T) {
+	arena := &Arena{BlockSize: 256}
+	for _, file := range []string{"testdata/landscapeIcons/beach.svg", "testdata/TestShapes6.svg"} {
+		for _, lowMemory := range []bool{false, true} {
+			full, err := ReadIcon(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			icon, err := ReadIconStreamOptions(f, ParseOptions{Arena: arena, LowMemory: lowMemory})
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(icon.SVGPaths) != len(full.SVGPaths) {
+				t.Fatalf("%s: got %d paths, want %d", file, len(icon.SVGPaths), len(full.SVGPaths))
+			}
+			for i := range icon.SVGPaths {
+				if p := icon.SVGPaths[i].Path; !reflect.DeepEqual(p, full.SVGPaths[i].Path) || cap(p) != len(p) {
+					t.Fatalf("%s: path %d differs", file, i)
+				}
+			}
+			w, h := int(full.ViewBox.W), int(full.ViewBox.H)
+			a, b := image.NewRGBA(image.Rect(0, 0, w, h)), image.NewRGBA(image.Rect(0, 0, w, h))
+			full.Draw(NewDasher(w, h, NewScannerGV(w, h, a, a.Bounds())), 1)
+			icon.Draw(NewDasher(w, h, NewScannerGV(w, h, b, b.Bounds())), 1)
+			if !bytes.Equal(a.Pix, b.Pix) {
+				t.Errorf("%s: icon allocated in an arena draws differently", file)
+			}
+		}
+	}
+}
+
+func TestEncodeBinary(t *testing.T) {
+	for _, file := range []string{"testdata/landscapeIcons/beach.svg", "testdata/TestShapes6.svg", "testdata/testIcons/original.svg"} {
+		icon, err := ReadIcon(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = icon.EncodeBinary(&buf); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		decoded, err := DecodeBinary(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		if decoded.ViewBox != icon.ViewBox || decoded.Transform != icon.Transform ||
+			!reflect.DeepEqual(decoded.Titles, icon.Titles) || len(decoded.SVGPaths) != len(icon.SVGPaths) {
+			t.Fatalf("%s: decoded icon differs", file)
+		}
+		for i := range icon.SVGPaths {
+			if !reflect.DeepEqual(decoded.SVGPaths[i].Path, icon.SVGPaths[i].Path) ||
+				decoded.SVGPaths[i].Source != icon.SVGPaths[i].Source {
+				t.Fatalf("%s: path %d differs", file, i)
+			}
+		}
+		w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+		a, b := image.NewRGBA(image.Rect(0, 0, w, h)), image.NewRGBA(image.Rect(0, 0, w, h))
+		icon.Draw(NewDasher(w, h, NewScannerGV(w, h, a, a.Bounds())), 1)
+		decoded.Draw(NewDasher(w, h, NewScannerGV(w, h, b, b.Bounds())), 1)
+		if !bytes.Equal(a.Pix, b.Pix) {
+			t.Errorf("%s: decoded icon draws differently", file)
+		}
+		if _, err = DecodeBinary(bytes.NewReader(data[:len(data)/2])); err == nil {
+			t.Errorf("%s: truncated data decoded without error", file)
+		}
+	}
+	if _, err := DecodeBinary(strings.NewReader("<svg/>")); err == nil {
+		t.Error("svg decoded as binary icon")
+	}
+	icon, _ := ReadIconStream(strings.NewReader(`<svg viewBox="0 0 4 4"><path d="M0,0 L4,4" stroke="red"/></svg>`))
+	icon.SVGPaths[0].LineCap = func(p Adder, a, eNorm fixed.Point26_6) {}
+	if err := icon.EncodeBinary(io.Discard); err == nil {
+		t.Error("custom cap function encoded without error")
+	}
+	// A corrupt length must not allocate for elements the input does not hold
+	header := append([]byte("OKSVG\x04"), make([]byte, 12*8+4)...)
+	huge := []byte{0x80, 0x80, 0x80, 0x20} // the uvarint 1<<26
+	for _, tail := range [][]byte{huge, append([]byte{1}, huge...)} {
+		if _, err := DecodeBinary(bytes.NewReader(append(header, tail...))); err == nil {
+			t.Errorf("corrupt length %v decoded without error", tail)
+		}
+	}
+	// Paths and styles that cannot be drawn are not decoded
+	for i, corrupt := range []func(svgp *SvgPath){
+		func(svgp *SvgPath) { svgp.Path = svgp.Path[:len(svgp.Path)-1] },
+		func(svgp *SvgPath) { svgp.Path[0] = 99 },
+		func(svgp *SvgPath) { svgp.LineJoin = 99 },
+		func(svgp *SvgPath) { svgp.SetFillGradient(&Gradient{Spread: 99}) },
+		func(svgp *SvgPath) { svgp.SetFillGradient(&Gradient{Units: 99}) },
+	} {
+		icon, _ = ReadIconStream(strings.NewReader(`<svg viewBox="0 0 4 4"><path d="M0,0 L4,4" stroke="red"/></svg>`))
+		corrupt(&icon.SVGPaths[0])
+		var buf bytes.Buffer
+		if err := icon.EncodeBinary(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeBinary(&buf); err == nil {
+			t.Errorf("corrupt path %d decoded without error", i)
+		}
+	}
+	var buf bytes.Buffer
+	icon, _ = ReadIconStream(strings.NewReader(`<svg viewBox="0 0 4 4"><path d="M0,0 L4,4" stroke="red" stroke-dasharray="1"/></svg>`))
+	if err := icon.EncodeBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for i := 6; i < buf.Len(); i++ {
+		data := append([]byte(nil), buf.Bytes()...)
+		data[i] = 0xFF
+		DecodeBinary(bytes.NewReader(data)) // must fail or succeed, not run out of memory
+	}
+}
+
+func TestUse(t *testing.T) {
+	for _, tc := range []struct {
+		name, body string
+		paths      int
+		ink, clear []image.Point
+	}{
+		{"second def with offset and fill", `<defs><rect id="a" width="10" height="10"/><rect id="b" width="10" height="10"/></defs>
+			<use href="#b" x="20" y="20" fill="red"/>`, 1, []image.Point{{25, 25}}, []image.Point{{5, 5}}},
+		{"group and nested id", `<defs><g id="g"><rect id="r" width="5" height="5"/><rect x="10" width="5" height="5"/></g></defs>
+			<use href="#g"/><use href="#r" y="20"/>`, 3, []image.Point{{2, 2}, {12, 2}, {2, 22}}, []image.Point{{12, 22}}},
+		{"symbol viewBox", `<symbol id="s" viewBox="0 0 10 10"><rect width="10" height="10"/></symbol>
+			<use href="#s" x="10" y="10" width="20" height="20"/>`, 1, []image.Point{{11, 11}, {28, 28}}, []image.Point{{9, 9}, {31, 31}}},
+		{"symbol meet", `<defs><symbol id="s" viewBox="0 0 10 20"><rect width="10" height="20"/></symbol></defs>
+			<use href="#s" width="40" height="20"/>`, 1, []image.Point{{20, 10}}, []image.Point{{10, 10}, {30, 10}}},
+		{"xlink href", `<defs><circle id="c" cx="20" cy="20" r="5"/></defs><use xlink:href="#c"/>`,
+			1, []image.Point{{20, 20}}, []image.Point{{5, 5}}},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 40 40">` +
+			tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if len(icon.SVGPaths) != tc.paths {
+			t.Errorf("%s: got %d paths, want %d", tc.name, len(icon.SVGPaths), tc.paths)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+		for _, p := range tc.ink {
+			if img.RGBAAt(p.X, p.Y).A != 0xFF {
+				t.Errorf("%s: %v is not filled", tc.name, p)
+			}
+		}
+		for _, p := range tc.clear {
+			if img.RGBAAt(p.X, p.Y).A != 0 {
+				t.Errorf("%s: %v is filled", tc.name, p)
+			}
+		}
+	}
+	loop := `<svg viewBox="0 0 10 10"><defs><g id="g"><use href="#g"/></g></defs><use href="#g"/></svg>`
+	if _, err := ReadIconStream(strings.NewReader(loop), StrictErrorMode); err == nil {
+		t.Error("use referencing itself read without error")
+	}
+}
+
+func TestDefs(t *testing.T) {
+	red, blue := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}
+	for _, tc := range []struct {
+		name, body string
+		paths      int
+		colors     map[image.Point]color.RGBA // the color of points, transparent for points left clear
+	}{
+		{"not drawn", `<defs><rect id="r" width="40" height="40" fill="red"/><linearGradient id="g"/></defs>`,
+			0, map[image.Point]color.RGBA{{20, 20}: {}}},
+		{"gradient", `<defs><linearGradient id="g"><stop stop-color="blue"/></linearGradient></defs>
+			<rect width="10" height="10" fill="url(#g)"/>`, 1, map[image.Point]color.RGBA{{5, 5}: blue, {20, 20}: {}}},
+		{"path", `<defs><path id="p" d="M0 0H10V10H0Z" fill="red"/></defs><use href="#p" x="20"/>`,
+			1, map[image.Point]color.RGBA{{25, 5}: red, {5, 5}: {}}},
+		{"symbol", `<defs><symbol id="s" viewBox="0 0 1 1"><rect width="1" height="1" fill="blue"/></symbol></defs>
+			<use href="#s" y="20" width="20" height="20"/>`, 1, map[image.Point]color.RGBA{{10, 30}: blue, {30, 30}: {}, {10, 10}: {}}},
+		{"nested defs", `<defs><defs><rect id="a" width="10" height="10" fill="red"/></defs>
+			<rect id="b" x="20" width="10" height="10" fill="blue"/></defs><use href="#b" y="20"/>`,
+			1, map[image.Point]color.RGBA{{25, 25}: blue, {25, 5}: {}, {5, 5}: {}}},
+		{"defs in a used group", `<defs><g id="g"><defs><rect id="a" width="10" height="10" fill="red"/></defs>
+			<rect x="20" width="10" height="10" fill="blue"/></g></defs><use href="#g"/><use href="#a" y="20"/>`,
+			2, map[image.Point]color.RGBA{{25, 5}: blue, {5, 25}: red, {5, 5}: {}}},
+		{"symbol in a used group", `<defs><g id="g"><symbol id="s"><rect width="10" height="10" fill="red"/></symbol>
+			<rect x="20" width="10" height="10" fill="blue"/></g></defs><use href="#g"/>`,
+			1, map[image.Point]color.RGBA{{25, 5}: blue, {5, 5}: {}}},
+		{"defs in a group", `<g><defs><rect id="a" width="10" height="10" fill="red"/></defs>
+			<rect x="20" width="10" height="10" fill="blue"/></g><use href="#a" y="20"/>`,
+			2, map[image.Point]color.RGBA{{25, 5}: blue, {5, 25}: red, {5, 5}: {}}},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if len(icon.SVGPaths) != tc.paths {
+			t.Errorf("%s: got %d paths, want %d", tc.name, len(icon.SVGPaths), tc.paths)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+		for p, want := range tc.colors {
+			if got := img.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: got %v at %v, want %v", tc.name, got, p, want)
+			}
+		}
+	}
+}
+
+func TestUseBomb(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><defs><rect id="l0" width="1" height="1"/>`)
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&b, `<g id="l%d"><use href="#l%d"/><use href="#l%d"/></g>`, i, i-1, i-1)
+	}
+	b.WriteString(`</defs><use href="#l30"/><rect width="2" height="2"/></svg>`)
+	if _, err := ReadIconStream(strings.NewReader(b.String()), StrictErrorMode); err == nil {
+		t.Error("nested use bomb read without error")
+	}
+	icon, err := ReadIconStreamOptions(strings.NewReader(b.String()), ParseOptions{MaxInstances: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) > 1000 || len(icon.Diagnostics) == 0 {
+		t.Errorf("got %d paths and %d diagnostics", len(icon.SVGPaths), len(icon.Diagnostics))
+	}
+	// The elements after the bomb are still read, with the style stack intact
+	if _, _, x1, _ := icon.SVGPaths[len(icon.SVGPaths)-1].Bounds(); x1 != 2 {
+		t.Error("element after the bomb not read")
+	}
+}
+
+func TestClipPath(t *testing.T) {
+	for _, tc := range []struct {
+		name, body string
+		ink, clear []image.Point
+	}{
+		{"outside defs", `<clipPath id="c"><rect x="10" y="10" width="20" height="20"/></clipPath>
+			<rect width="40" height="40" clip-path="url(#c)"/>`, []image.Point{{11, 11}, {28, 28}}, []image.Point{{5, 5}, {35, 35}}},
+		{"object bounding box", `<defs><clipPath id="c" clipPathUnits="objectBoundingBox"><rect width="0.5" height="1"/></clipPath></defs>
+			<rect x="20" y="20" width="20" height="20" clip-path="url(#c)"/>`, []image.Point{{21, 30}, {28, 30}}, []image.Point{{32, 30}, {5, 5}}},
+		{"transformed clipPath", `<defs><clipPath id="c" transform="translate(20,0)"><rect width="20" height="40"/></clipPath></defs>
+			<rect width="40" height="40" clip-path="url(#c)"/>`, []image.Point{{30, 20}}, []image.Point{{10, 20}}},
+		{"nested clips intersect", `<defs><clipPath id="a"><rect width="20" height="40"/></clipPath>
+			<clipPath id="b"><rect width="40" height="20"/></clipPath></defs>
+			<g clip-path="url(#a)"><rect width="40" height="40" clip-path="url(#b)"/></g>`,
+			[]image.Point{{10, 10}}, []image.Point{{30, 10}, {10, 30}, {30, 30}}},
+		{"clip of stroke", `<defs><clipPath id="c"><rect width="20" height="40"/></clipPath></defs>
+			<line x1="0" y1="20" x2="40" y2="20" stroke="black" stroke-width="4" clip-path="url(#c)"/>`,
+			[]image.Point{{10, 20}}, []image.Point{{30, 20}}},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		var buf bytes.Buffer
+		if err = icon.EncodeBinary(&buf); err != nil {
+			t.Fatal(tc.name, err)
+		}
+		decoded, err := DecodeBinary(&buf)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		for _, icon := range []*SvgIcon{icon, decoded} {
+			img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+			icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+			for _, p := range tc.ink {
+				if img.RGBAAt(p.X, p.Y).A != 0xFF {
+					t.Errorf("%s: %v is not drawn", tc.name, p)
+				}
+			}
+			for _, p := range tc.clear {
+				if img.RGBAAt(p.X, p.Y).A != 0 {
+					t.Errorf("%s: %v is not clipped", tc.name, p)
+				}
+			}
+		}
+	}
+	// Zoomed far into the clip, the layer is only as large as the image
+	zoomed, err := ReadIconStream(strings.NewReader(`<svg viewBox="0 0 40 40"><clipPath id="c"><rect width="20" height="40"/></clipPath>
+		<rect width="40" height="40" fill="red" clip-path="url(#c)"/></svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoomed.SetTarget(-1e6, -1e6, 4e6, 4e6)
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	zoomed.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+	if img.RGBAAt(20, 20).A != 0xFF {
+		t.Error("zoomed clip not drawn")
+	}
+	missing := `<svg viewBox="0 0 10 10"><rect width="10" height="10" clip-path="url(#none)"/></svg>`
+	if _, err := ReadIconStream(strings.NewReader(missing), StrictErrorMode); err == nil {
+		t.Error("missing clip path not reported")
+	}
+}
+
+func TestMask(t *testing.T) {
+	for _, tc := range []struct {
+		name, body string
+		probes     map[image.Point]uint8 // alpha at points
+	}{
+		{"white and black", `<defs><mask id="m"><rect width="20" height="40" fill="white"/>
+			<rect x="20" width="20" height="40" fill="black"/></mask></defs>
+			<rect width="40" height="40" fill="red" mask="url(#m)"/>`, map[image.Point]uint8{{10, 20}: 0xFF, {30, 20}: 0}},
+		{"gray", `<mask id="m"><rect width="40" height="40" fill="#808080"/></mask>
+			<rect width="40" height="40" fill="red" mask="url(#m)"/>`, map[image.Point]uint8{{10, 20}: 0x80}},
+		{"translucent white", `<defs><mask id="m"><rect width="40" height="40" fill="white" fill-opacity="0.25"/></mask></defs>
+			<rect width="40" height="40" fill="red" mask="url(#m)"/>`, map[image.Point]uint8{{10, 20}: 0x40}},
+		{"content units", `<defs><mask id="m" maskContentUnits="objectBoundingBox"><rect width="1" height="0.5" fill="white"/></mask></defs>
+			<rect x="20" y="20" width="20" height="20" fill="red" mask="url(#m)"/>`,
+			map[image.Point]uint8{{30, 25}: 0xFF, {30, 35}: 0, {10, 10}: 0}},
+		{"mask and clip", `<defs><mask id="m"><rect width="40" height="20" fill="white"/></mask>
+			<clipPath id="c"><rect width="20" height="40"/></clipPath></defs>
+			<rect width="40" height="40" fill="red" mask="url(#m)" clip-path="url(#c)"/>`,
+			map[image.Point]uint8{{10, 10}: 0xFF, {30, 10}: 0, {10, 30}: 0}},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if len(icon.SVGPaths) != 1 {
+			t.Errorf("%s: got %d paths, want 1", tc.name, len(icon.SVGPaths))
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+		for p, a := range tc.probes {
+			if got := img.RGBAAt(p.X, p.Y).A; int(got) < int(a)-2 || int(got) > int(a)+2 {
+				t.Errorf("%s: alpha at %v is %d, want %d", tc.name, p, got, a)
+			}
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	red, clear := color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{}
+	checker := map[image.Point]color.RGBA{{2, 2}: red, {12, 12}: red, {32, 2}: red, {7, 7}: clear, {17, 2}: clear}
+	for _, tc := range []struct {
+		name, body string
+		probes     map[image.Point]color.RGBA
+	}{
+		{"user space", `<defs><pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse">
+			<rect width="5" height="5" fill="red"/></pattern></defs>
+			<rect width="40" height="40" fill="url(#p)"/>`, checker},
+		{"bounding box", `<defs><pattern id="p" width="0.5" height="0.5"><rect width="5" height="5" fill="red"/></pattern></defs>
+			<rect x="20" y="20" width="20" height="20" fill="url(#p)"/>`,
+			map[image.Point]color.RGBA{{22, 22}: red, {32, 32}: red, {27, 27}: clear, {2, 2}: clear}},
+		{"content units", `<defs><pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse"
+			patternContentUnits="objectBoundingBox"><rect width="0.125" height="0.125" fill="red"/></pattern></defs>
+			<rect width="40" height="40" fill="url(#p)"/>`, checker},
+		{"view box", `<defs><pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse" viewBox="0 0 2 2">
+			<rect width="1" height="1" fill="red"/></pattern></defs>
+			<rect width="40" height="40" fill="url(#p)"/>`, checker},
+		{"transform", `<defs><pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse"
+			patternTransform="translate(5,0)"><rect width="5" height="5" fill="red"/></pattern></defs>
+			<rect width="40" height="40" fill="url(#p)"/>`, map[image.Point]color.RGBA{{7, 2}: red, {2, 2}: clear}},
+		{"stroke", `<pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse">
+			<rect width="5" height="5" fill="red"/></pattern>
+			<rect x="2" y="2" width="36" height="36" fill="none" stroke="url(#p)" stroke-width="4"/>`,
+			map[image.Point]color.RGBA{{2, 20}: red, {7, 2}: clear, {20, 20}: clear}},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if len(icon.SVGPaths) != 1 {
+			t.Errorf("%s: got %d paths, want 1", tc.name, len(icon.SVGPaths))
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+		for p, c := range tc.probes {
+			if got := img.RGBAAt(p.X, p.Y); got != c {
+				t.Errorf("%s: color at %v is %v, want %v", tc.name, p, got, c)
+			}
+		}
+	}
+}
+
+func TestReadIconBytes(t *testing.T) {
+	data, err := os.ReadFile("testdata/landscapeIcons/beach.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon, err := ReadIconBytes(data, StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileIcon, err := ReadIcon("testdata/landscapeIcons/beach.svg", StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != len(fileIcon.SVGPaths) || icon.ViewBox != fileIcon.ViewBox {
+		t.Fatal("icon read from bytes differs from the icon read from the file")
+	}
+	for i := range icon.SVGPaths {
+		if !reflect.DeepEqual(icon.SVGPaths[i].Path, fileIcon.SVGPaths[i].Path) {
+			t.Errorf("path %d differs from the path read from the file", i)
+		}
+	}
+	if _, err = ReadIconBytes([]byte("<svg"), StrictErrorMode); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+}
+
+func TestSetTarget(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="10 10 20 20"><defs>
+		<linearGradient id="g" x1="10" x2="30" gradientUnits="userSpaceOnUse"><stop offset="0" stop-color="red"/>
+		<stop offset="1" stop-color="blue"/></linearGradient></defs>
+		<rect x="10" y="10" width="20" height="20" fill="url(#g)"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	icon.SetTarget(40, 0, 40, 40)
+	icon.Draw(NewDasher(80, 40, NewScannerGV(80, 40, img, img.Bounds())), 1)
+	for _, tc := range []struct {
+		p image.Point
+		c color.RGBA
+	}{
+		{image.Point{39, 20}, color.RGBA{}},
+		{image.Point{40, 20}, color.RGBA{0xFE, 0, 0, 0xFF}},
+		{image.Point{79, 39}, color.RGBA{0, 0, 0xFE, 0xFF}},
+	} {
+		if got := img.RGBAAt(tc.p.X, tc.p.Y); !nearColor(color.NRGBA(got), color.NRGBA(tc.c), 3) {
+			t.Errorf("at %v got %v, want %v", tc.p, got, tc.c)
+		}
+	}
+}
+
+func TestDrawConcurrent(t *testing.T) {
+	for _, name := range []string{"sea", "beach", "village"} {
+		icon, err := ReadIcon("testdata/landscapeIcons/"+name+".svg", WarnErrorMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+		want := image.NewRGBA(image.Rect(0, 0, w, h))
+		icon.Draw(NewDasher(w, h, NewScannerGV(w, h, want, want.Bounds())), 0.8)
+		// The Transform maps to the coordinates of dst, wherever its bounds start
+		got := image.NewRGBA(image.Rect(10, 20, w+10, h+20))
+		icon.SetTarget(10, 20, float64(w), float64(h))
+		icon.DrawConcurrent(4, got, 0.8)
+		// Compositing the premultiplied layers rounds slightly differently
+		for i := range want.Pix {
+			if d := int(got.Pix[i]) - int(want.Pix[i]); d < -4 || d > 4 {
+				t.Errorf("%s: concurrently drawn byte %d is %d, want %d", name, i, got.Pix[i], want.Pix[i])
+				break
+			}
+		}
+	}
+}
+
+func TestBakeTransforms(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+		<defs><linearGradient id="g" gradientUnits="userSpaceOnUse" x1="0" y1="0" x2="20" y2="0">
+			<stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient></defs>
+		<g transform="translate(10 20)"><g transform="rotate(30) scale(2)">
+			<rect width="20" height="10" fill="url(#g)" stroke="green" stroke-width="2"/>
+			<circle cx="10" cy="20" r="5" fill="orange"/></g></g></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const w, h = 100, 100
+	want := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, want, want.Bounds())), 1)
+	if kept := icon.BakeTransforms(); kept != 0 {
+		t.Errorf("%d paths kept their transform, want 0", kept)
+	}
+	if p := icon.SVGPaths[0].Path; p[1] != 10*64 || p[2] != 20*64 {
+		t.Errorf("rect starts at %v,%v, want 10,20", float64(p[1])/64, float64(p[2])/64)
+	}
+	got := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, got, got.Bounds())), 1)
+	// Rounding the baked points to fixed point moves edges by a fraction of a pixel
+	for i := range want.Pix {
+		if d := int(got.Pix[i]) - int(want.Pix[i]); d < -8 || d > 8 {
+			t.Fatalf("baked icon byte %d is %d, want %d", i, got.Pix[i], want.Pix[i])
+		}
+	}
+}
+
+func TestNormalizeToViewBox(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="-50 -20 200 100">
+		<defs><clipPath id="c"><circle cx="50" cy="30" r="40"/></clipPath>
+		<radialGradient id="g" gradientUnits="userSpaceOnUse" cx="50" cy="30" r="60">
+			<stop offset="0" stop-color="yellow"/><stop offset="1" stop-color="purple"/></radialGradient></defs>
+		<rect x="-50" y="-20" width="200" height="100" fill="url(#g)"/>
+		<rect x="0" y="0" width="100" height="60" fill="teal" clip-path="url(#c)"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const w, h = 120, 60
+	icon.SetTarget(0, 0, w, h)
+	want := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, want, want.Bounds())), 1)
+	icon.NormalizeToViewBox(1, 1)
+	if icon.ViewBox != (ViewBox{0, 0, 1, 1}) {
+		t.Errorf("ViewBox is %v, want the unit square", icon.ViewBox)
+	}
+	got := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, got, got.Bounds())), 1)
+	for i := range want.Pix {
+		if d := int(got.Pix[i]) - int(want.Pix[i]); d < -2 || d > 2 {
+			t.Fatalf("normalized icon byte %d is %d, want %d", i, got.Pix[i], want.Pix[i])
+		}
+	}
+	icon.PreserveAspectRatio = "none" // the unit square is stretched onto the target
+	icon.SetTarget(0, 0, w, h)
+	again := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, again, again.Bounds())), 1)
+	if !bytes.Equal(again.Pix, got.Pix) {
+		t.Error("SetTarget of the normalized icon draws differently")
+	}
+}
+
+func TestRenderFingerprint(t *testing.T) {
+	read := func(svg string) *SvgIcon {
+		icon, err := ReadIconStream(strings.NewReader(svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return icon
+	}
+	base := read(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+		<circle cx="50" cy="50" r="30" fill="teal"/></svg>`)
+	fp := RenderFingerprint(base, 128)
+	if fp == "" || fp != RenderFingerprint(base, 128) {
+		t.Fatalf("fingerprint %q is not stable", fp)
+	}
+	// A shift of a hundredth of a pixel only changes anti-aliasing
+	nudged := read(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+		<circle cx="50.01" cy="50" r="30" fill="teal"/></svg>`)
+	if d := FingerprintDistance(RenderFingerprint(nudged, 128), fp); d < 0 || d > 1 {
+		t.Errorf("fingerprint distance is %d for an invisible change", d)
+	}
+	recolored := read(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+		<circle cx="50" cy="50" r="30" fill="orange"/></svg>`)
+	if d := FingerprintDistance(RenderFingerprint(recolored, 128), fp); d <= 1 {
+		t.Errorf("fingerprint distance is %d for a new color", d)
+	}
+	if d := FingerprintDistance(fp, fp[1:]); d != -1 {
+		t.Errorf("distance to a truncated fingerprint is %d, want -1", d)
+	}
+	if got := RenderFingerprint(base, 0); got != "" {
+		t.Errorf("fingerprint of an empty size is %q, want empty", got)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	level := func(fs []Feature, name string) SupportLevel {
+		for _, f := range fs {
+			if f.Name == name {
+				return f.Level
+			}
+		}
+		t.Fatalf("feature %q is not listed", name)
+		return Unsupported
+	}
+	cs := Capabilities()
+	for _, tc := range []struct {
+		fs   []Feature
+		name string
+		want SupportLevel
+	}{
+		{cs.Elements, "path", Supported},
+		{cs.Elements, "pattern", Supported},
+		{cs.Elements, "style", Partial},
+		{cs.Elements, "text", Unsupported},
+		{cs.Properties, "fill-rule", Supported},
+		{cs.Properties, "filter", Unsupported},
+		{cs.Filters, "feGaussianBlur", Unsupported},
+		{cs.Units, "em", Supported},
+		{cs.Units, "mm", Supported},
+		{cs.Units, "vw", Unsupported},
+	} {
+		if got := level(tc.fs, tc.name); got != tc.want {
+			t.Errorf("%s is %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if !sort.SliceIsSorted(cs.Elements, func(i, j int) bool { return cs.Elements[i].Name < cs.Elements[j].Name }) {
+		t.Error("elements are not sorted by name")
+	}
+
+	RegisterElementHandler("text", func(c *IconCursor, attrs []xml.Attr) error { return nil })
+	defer RegisterElementHandler("text", nil)
+	if got := level(Capabilities().Elements, "text"); got != Supported {
+		t.Errorf("text with a handler is %v, want %v", got, Supported)
+	}
+}
+
+func TestDashedGradientStroke(t *testing.T) {
+	// A progress ring: the gradient of the dashes is mapped onto the bounding box
+	// of the dashes, without the stroke width
+	ring := func(dash, units string) *image.RGBA {
+		icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+			<defs><linearGradient id="g" ` + units + `>
+				<stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient></defs>
+			<circle cx="50" cy="50" r="40" fill="none" stroke="url(#g)" stroke-width="10" stroke-dasharray="` +
+			dash + `"/></svg>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := icon.Rasterize(100, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	bbox := `x1="0" y1="0" x2="1" y2="0"`
+	for _, tc := range []struct {
+		dash, like string // like is the gradient of the undashed ring drawing the same colors
+	}{
+		// The dash is the quarter from 3 to 6 o'clock, whose box spans x from 50 to 90
+		{"62.83 1000", `gradientUnits="userSpaceOnUse" x1="50" y1="0" x2="90" y2="0"`},
+		// The dashes reach the extremes of the ring, so their box is that of the ring
+		{"20 10", bbox},
+	} {
+		img, full := ring(tc.dash, bbox), ring("none", tc.like)
+		drawn := 0
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 100; x++ {
+				// Only the pixels covered by the dashes are compared, as the
+				// antialiased pixels at their ends differ in coverage
+				got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if got.A != 0xFF {
+					continue
+				}
+				drawn++
+				if want := color.NRGBAModel.Convert(full.At(x, y)).(color.NRGBA); !nearColor(got, want, 4) {
+					t.Fatalf("dash %s: pixel %d,%d is %v, want %v", tc.dash, x, y, got, want)
+				}
+			}
+		}
+		if drawn == 0 {
+			t.Errorf("dash %s draws nothing", tc.dash)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	ctx := LengthContext{FontSize: 10, RootFontSize: 16, Percent: 200}
+	for _, tc := range []struct {
+		v    string
+		want float64
+	}{
+		{"12", 12}, {" 12px ", 12}, {"1in", 96}, {"2.54cm", 96}, {"25.4mm", 96}, {"101.6Q", 96},
+		{"72pt", 96}, {"6pc", 96}, {"1.5em", 15}, {"2rem", 32}, {"25%", 50}, {"1e1px", 10}, {"-1e-1em", -1},
+	} {
+		got, err := ParseLength(tc.v, ctx)
+		if err != nil || math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("ParseLength(%q) = %v, %v, want %v", tc.v, got, err, tc.want)
+		}
+	}
+	for _, v := range []string{"", "px", "12furlongs", "1.2.3mm"} {
+		if _, err := ParseLength(v, ctx); err == nil {
+			t.Errorf("ParseLength(%q) did not fail", v)
+		}
+	}
+	if got, _ := ParseLength("1in", LengthContext{DPI: 300}); got != 300 {
+		t.Errorf("1in at 300 DPI = %v, want 300", got)
+	}
+
+	// Shapes in physical units, at the DPI of the options
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="2in" height="1in">
+	<rect x="0.5in" y="0" width="72pt" height="50%" fill="red"/>
+	<circle cx="1.5in" cy="0.5in" r="0.25in" stroke="blue" stroke-width="2mm"/></svg>`
+	for _, dpi := range []float64{0, 150} {
+		icon, err := ReadIconStreamOptions(strings.NewReader(svg), ParseOptions{DPI: dpi})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := dpi
+		if in == 0 {
+			in = 96
+		}
+		if vb := icon.ViewBox; vb.W != 2*in || vb.H != in {
+			t.Errorf("DPI %v: viewBox %v, want %vx%v", dpi, vb, 2*in, in)
+		}
+		w, h := int(2*in), int(in)
+		icon.SetTarget(0, 0, float64(w), float64(h))
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		icon.Draw(NewDasher(w, h, NewScannerGV(w, h, img, img.Bounds())), 1)
+		for _, p := range []struct {
+			x, y float64
+			red  bool
+		}{{1, 0.25, true}, {0.25, 0.25, false}, {1, 0.75, false}, {0.9, 0.45, true}} {
+			if got := img.RGBAAt(int(p.x*in), int(p.y*in)).R == 0xff; got != p.red {
+				t.Errorf("DPI %v: red at %v,%v in is %v", dpi, p.x, p.y, got)
+			}
+		}
+		if lw, want := icon.SVGPaths[1].LineWidth, 2*in/25.4; math.Abs(lw-want) > 1e-9 {
+			t.Errorf("DPI %v: stroke width %v, want %v", dpi, lw, want)
+		}
+	}
+}
+
+func TestImplicitClose(t *testing.T) {
+	// The paths omit z: the fills close, but the strokes along the diagonal do not
+	for _, rule := range []string{"nonzero", "evenodd"} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+		<clipPath id="c"><path d="M0,0 V100 H100"/></clipPath>
+		<rect width="100" height="100" fill="lime" clip-path="url(#c)"/>
+		<path d="M10,10 H90 V90" fill="red" fill-rule="` + rule + `" stroke="blue" stroke-width="4"/></svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		icon.SetTarget(0, 0, 100, 100)
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		icon.Draw(NewDasher(100, 100, NewScannerGV(100, 100, img, img.Bounds())), 1)
+		for _, p := range []struct {
+			x, y int
+			want color.RGBA
+		}{
+			{70, 30, color.RGBA{R: 0xff, A: 0xff}}, // inside the closed fill
+			{20, 70, color.RGBA{G: 0xff, A: 0xff}}, // inside the closed clip
+			{51, 49, color.RGBA{R: 0xff, A: 0xff}}, // along the closing edge, no stroke
+			{50, 10, color.RGBA{B: 0xff, A: 0xff}}, // the stroke of the open path
+		} {
+			if got := img.RGBAAt(p.x, p.y); got != p.want {
+				t.Errorf("%s: pixel %d,%d is %v, want %v", rule, p.x, p.y, got, p.want)
+			}
+		}
+	}
+}
+
+func TestPreserveAspectRatio(t *testing.T) {
+	for _, tc := range []struct {
+		par      string
+		min, max [2]float64 // where the corners of the viewBox land
+	}{
+		{"", [2]float64{10, 45}, [2]float64{110, 95}},
+		{"none", [2]float64{10, 20}, [2]float64{110, 120}},
+		{"xMidYMid meet", [2]float64{10, 45}, [2]float64{110, 95}},
+		{"xMaxYMax", [2]float64{10, 70}, [2]float64{110, 120}},
+		{"xMinYMax slice", [2]float64{10, 20}, [2]float64{210, 120}},
+		{"xMidYMid slice", [2]float64{-40, 20}, [2]float64{160, 120}},
+	} {
+		attr := ""
+		if tc.par != "" {
+			attr = ` preserveAspectRatio="` + tc.par + `"`
+		}
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50"` + attr + `>
+		<rect width="100" height="50" fill="red"/></svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if icon.PreserveAspectRatio != tc.par {
+			t.Errorf("%q: read %q", tc.par, icon.PreserveAspectRatio)
+		}
+		icon.SetTarget(10, 20, 100, 100)
+		x0, y0 := icon.Transform.Transform(0, 0)
+		x1, y1 := icon.Transform.Transform(100, 50)
+		if got := [4]float64{x0, y0, x1, y1}; got != [4]float64{tc.min[0], tc.min[1], tc.max[0], tc.max[1]} {
+			t.Errorf("%q: viewBox mapped to %v, want %v to %v", tc.par, got, tc.min, tc.max)
+		}
+
+		var buf bytes.Buffer
+		if err := icon.EncodeBinary(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if decoded, err := DecodeBinary(&buf); err != nil || decoded.PreserveAspectRatio != tc.par {
+			t.Errorf("%q: decoded %v, %v", tc.par, decoded, err)
+		}
+	}
+
+	// Rasterize follows the icon unless told otherwise
+	icon, _ := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50"
+		preserveAspectRatio="xMidYMin meet"><rect width="100" height="50" fill="red"/></svg>`))
+	img, err := icon.Rasterize(20, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := img.RGBAAt(10, 5).A; a != 0xff {
+		t.Errorf("alpha at the top is %d, want 255", a)
+	}
+	if a := img.RGBAAt(10, 15).A; a != 0 {
+		t.Errorf("alpha below the viewBox is %d, want 0", a)
+	}
+	if img, _ = icon.Rasterize(20, 20, WithAspectRatio("none")); img.RGBAAt(10, 15).A != 0xff {
+		t.Error("WithAspectRatio(\"none\") did not stretch the viewBox")
+	}
+}
+
+func TestNestedSvg(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+	<defs><svg id="s" viewBox="0 0 10 10" width="20" height="20"><rect width="10" height="5" fill="blue"/></svg></defs>
+	<symbol id="a" viewBox="0 0 10 10"><rect width="10" height="10" fill="red"/></symbol>
+	<symbol id="b" viewBox="-5 -5 10 10"><circle r="5" fill="lime"/></symbol>
+	<svg x="50" width="50" height="50" viewBox="0 0 2 2"><rect x="1" width="1" height="1" fill="red"/></svg>
+	<rect y="60" width="10" height="10"/>
+	<use href="#s" y="80"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.ViewBox != (ViewBox{0, 0, 100, 100}) {
+		t.Errorf("nested svg changed the viewBox to %v", icon.ViewBox)
+	}
+	img, err := icon.Rasterize(100, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{87, 12, color.RGBA{R: 0xff, A: 0xff}}, // in the nested viewport
+		{60, 12, color.RGBA{}},
+		{5, 65, color.RGBA{A: 0xff}},           // after the nested svg, in the outer coordinates
+		{10, 85, color.RGBA{B: 0xff, A: 0xff}}, // the svg in defs, through use
+		{10, 95, color.RGBA{}},
+	} {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("pixel %d,%d is %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+
+	if ids := icon.Symbols(); !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("symbols are %v, want a and b", ids)
+	}
+	b, err := icon.Symbol("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ViewBox != (ViewBox{0, 0, 10, 10}) || len(b.SVGPaths) != 1 {
+		t.Fatalf("symbol b has viewBox %v and %d paths", b.ViewBox, len(b.SVGPaths))
+	}
+	if img, _ = b.Rasterize(10, 10); img.RGBAAt(5, 5) != (color.RGBA{G: 0xff, A: 0xff}) || img.RGBAAt(0, 0).A != 0 {
+		t.Errorf("symbol b drew %v at the center and %v in the corner", img.RGBAAt(5, 5), img.RGBAAt(0, 0))
+	}
+	for _, id := range []string{"s", "missing"} {
+		if _, err := icon.Symbol(id); err == nil {
+			t.Errorf("Symbol(%q) did not fail", id)
+		}
+	}
+}
+
+func TestSpriteLayouts(t *testing.T) {
+	// Sprite sheets often put their symbols or defs after the elements using them
+	type probe struct {
+		x, y int
+		want color.RGBA
+	}
+	for _, tc := range []struct {
+		file   string
+		probes []probe
+	}{
+		{"bootstrap_layout", []probe{{8, 8, color.RGBA{0x0d, 0x6e, 0xfd, 0xff}}, {1, 1, color.RGBA{}},
+			{24, 8, color.RGBA{0x0d, 0x6e, 0xfd, 0xff}}, {17, 1, color.RGBA{}}}},
+		{"material_layout", []probe{{12, 12, color.RGBA{0xea, 0x43, 0x35, 0xff}}, {2, 2, color.RGBA{}},
+			{36, 12, color.RGBA{0x42, 0x85, 0xf4, 0xff}}}},
+		{"late_defs", []probe{{5, 5, color.RGBA{R: 0xff, A: 0xff}}, {12, 5, color.RGBA{G: 0xff, A: 0xff}},
+			{17, 5, color.RGBA{}}, {25, 5, color.RGBA{B: 0xff, A: 0xff}}}},
+	} {
+		f, err := os.Open("testdata/sprites/" + tc.file + ".svg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		icon, err := ReadIconStreamPolicy(f, ErrorPolicy{MissingReference: StrictErrorMode})
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tc.file, err)
+			continue
+		}
+		img, err := icon.Rasterize(int(icon.ViewBox.W), int(icon.ViewBox.H))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range tc.probes {
+			if got := img.RGBAAt(p.x, p.y); got != p.want {
+				t.Errorf("%s: pixel %d,%d is %v, want %v", tc.file, p.x, p.y, got, p.want)
+			}
+		}
+	}
+
+	// References to ids defined nowhere are still reported
+	_, err := ReadIconStreamPolicy(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+		<use href="#later"/><use href="#nowhere"/><defs><rect id="later" width="5" height="5"/></defs></svg>`),
+		ErrorPolicy{MissingReference: StrictErrorMode})
+	if err == nil || !strings.Contains(err.Error(), "#nowhere") {
+		t.Errorf("missing reference reported as %v", err)
+	}
+
+	// Streams that cannot seek resolve them too, as the document is read once
+	forward := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+		<use href="#later"/><defs><rect id="later" width="5" height="5"/></defs></svg>`
+	icon, err := ReadIconStreamOptions(struct{ io.Reader }{strings.NewReader(forward)},
+		ParseOptions{ErrorPolicy: ErrorPolicy{MissingReference: StrictErrorMode}})
+	if err != nil || len(icon.SVGPaths) != 1 {
+		t.Errorf("forward reference of a stream read as %v", err)
+	}
+}
+
+func TestLateReferences(t *testing.T) {
+	// Each kind of reference to an element read later is resolved in one reading
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 20">
+	<use href="#nowhere"/>
+	<g id="row"><use href="#sq" fill="url(#g2)"/><rect id="after" x="30" width="10" height="10" fill="#00ff00"/></g>
+	<path d="M 0 15 L 20 15" stroke="black" marker-end="url(#m)"/>
+	<rect x="30" y="10" width="10" height="10" fill="#00ff00" clip-path="url(#c)"/>
+	<image href="pic.png" x="10" width="5" height="5"/>
+	<defs>
+		<linearGradient id="g2" href="#g1" x2="0"/>
+		<linearGradient id="g1"><stop offset="0" stop-color="#ff0000"/><stop offset="1" stop-color="#ff0000"/></linearGradient>
+		<rect id="sq" width="10" height="10"/>
+		<marker id="m" markerWidth="4" markerHeight="4" refX="2" refY="2" markerUnits="userSpaceOnUse">
+			<rect width="4" height="4" fill="#0000ff"/>
+		</marker>
+		<clipPath id="c"><use href="#corner"/></clipPath>
+		<rect id="corner" x="30" y="10" width="5" height="5"/>
+	</defs></svg>`
+	loads := 0
+	opts := ParseOptions{ImageLoader: func(href string) (image.Image, error) {
+		loads++
+		return image.NewRGBA(image.Rect(0, 0, 1, 1)), nil
+	}}
+	icon, err := ReadIconStreamOptions(strings.NewReader(svg), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 {
+		t.Errorf("image loaded %d times", loads)
+	}
+	if len(icon.Diagnostics) != 1 || icon.Diagnostics[0].Pos.Line != 2 ||
+		!strings.Contains(icon.Diagnostics[0].Err.Error(), "#nowhere") {
+		t.Errorf("diagnostics are %v", icon.Diagnostics)
+	}
+	if g := icon.Grads["g2"]; len(g.Stops) != 2 || g.Points[2] != 0 {
+		t.Errorf("gradient g2 inherited stops %v and has points %v", g.Stops, g.Points)
+	}
+	// The element after the late use is replaced, not the paths of the use
+	if err = icon.ReplaceElement("after", `<rect x="30" width="10" height="10" fill="#0000ff"/>`); err != nil {
+		t.Fatal(err)
+	}
+	img, err := icon.Rasterize(40, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{5, 5, color.RGBA{R: 0xff, A: 0xff}},   // the late symbol, with the gradient inheriting a later one
+		{35, 5, color.RGBA{B: 0xff, A: 0xff}},  // the replaced element
+		{21, 16, color.RGBA{B: 0xff, A: 0xff}}, // the late marker
+		{32, 12, color.RGBA{G: 0xff, A: 0xff}}, // within the clip using a later element
+		{37, 17, color.RGBA{}},
+	} {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("pixel %d,%d is %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+}
+
 func TestTopLevelStyle(t *testing.T) {
 	// Test that a top level style tag without enclosing defs is supported
 	_, errSvg := ReadIcon("testdata/TopLevelStyle.svg", StrictErrorMode)
@@ -213,31 +1277,2556 @@ func TestTopLevelStyle(t *testing.T) {
 	}
 }
 
-func TestClassesIcon(t *testing.T) {
-	SaveIcon(t, "testdata/TestClasses.svg")
+func TestColorSchemeMedia(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><style>
+	.ink { fill: #000000 }
+	@media (prefers-color-scheme: dark) { .ink { fill: #FFFFFF } .bg { fill: #202020 } }
+	@media screen and (prefers-color-scheme: light) { .bg { fill: #F0F0F0 } }
+	@media print { .ink { fill: #FF0000 } }
+	</style><rect class="bg" width="10" height="10"/><circle class="ink" cx="5" cy="5" r="2"/></svg>`
+	for _, tc := range []struct {
+		scheme  ColorScheme
+		bg, ink color.Color
+	}{
+		{LightColorScheme, color.NRGBA{0xF0, 0xF0, 0xF0, 0xFF}, color.NRGBA{0, 0, 0, 0xFF}},
+		{DarkColorScheme, color.NRGBA{0x20, 0x20, 0x20, 0xFF}, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+	} {
+		icon, err := ReadIconStreamOptions(strings.NewReader(svg), ParseOptions{
+			ErrorPolicy: StrictErrorMode.Policy(), ColorScheme: tc.scheme})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bg, ink := icon.SVGPaths[0].GetFillColor(), icon.SVGPaths[1].GetFillColor(); bg != tc.bg || ink != tc.ink {
+			t.Errorf("scheme %d: background %v, ink %v", tc.scheme, bg, ink)
+		}
+	}
+}
+
+func TestClassesIcon(t *testing.T) {
+	SaveIcon(t, "testdata/TestClasses.svg")
+
+	// Test error handling in parseClasses and parseAttrs
+	_, errSvg := ReadIcon("testdata/TestClasses_bad1.svg", WarnErrorMode)
+	if errSvg == nil {
+		t.Error("failed to catch class defs error")
+	}
+	_, errSvg = ReadIcon("testdata/TestClasses_bad2.svg", WarnErrorMode)
+	if errSvg == nil {
+		t.Error("failed to catch attribute format error")
+	}
+
+}
+
+func TestStyleSheet(t *testing.T) {
+	const svg = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 10">
+		<style type="text/css"><![CDATA[
+			/* Illustrator puts all paints in classes */
+			rect { fill: blue }
+			.st0 { fill: #FF0000 }
+			.st0.wide { fill: lime }
+			#special { fill: yellow }
+			.st1 { fill: black !important }
+		]]></style>
+		<rect x="0" width="10" height="10"/>
+		<rect x="10" width="10" height="10" class="st0" fill="purple"/>
+		<rect x="20" width="10" height="10" class="wide st0"/>
+		<rect x="30" width="10" height="10" class="st0" id="special"/>
+		<rect x="40" width="10" height="10" class="st0" style="fill:white"/>
+		<rect x="50" width="10" height="10" class="st1" style="fill:white"/>
+		</svg>`
+	for _, lowMemory := range []bool{false, true} {
+		icon, err := ReadIconStreamOptions(strings.NewReader(svg), ParseOptions{LowMemory: lowMemory})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := icon.Rasterize(60, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []color.RGBA{
+			{0, 0, 0xFF, 0xFF},       // element selector
+			{0xFF, 0, 0, 0xFF},       // class over presentation attribute
+			{0, 0xFF, 0, 0xFF},       // two classes over one
+			{0xFF, 0xFF, 0, 0xFF},    // id over class
+			{0xFF, 0xFF, 0xFF, 0xFF}, // style attribute over class
+			{0, 0, 0, 0xFF},          // important over style attribute
+		} {
+			if got := img.RGBAAt(10*i+5, 5); got != want {
+				t.Errorf("low memory %v: rect %d is %v, want %v", lowMemory, i, got, want)
+			}
+		}
+	}
+}
+
+func TestCurrentColor(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 10" color="blue">
+		<defs>
+			<rect id="r" width="10" height="10" fill="currentColor"/>
+			<linearGradient id="g" color="yellow"><stop offset="0" stop-color="currentColor"/>
+				<stop offset="1" stop-color="currentColor"/></linearGradient>
+		</defs>
+		<rect width="10" height="10" fill="currentColor"/>
+		<use href="#r" x="10" color="lime"/>
+		<rect x="20" width="10" height="10" fill="url(#g)"/>
+		<g style="color:red"><line x1="30" y1="5" x2="40" y2="5" stroke="currentColor" stroke-width="10"/></g>
+		</svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := icon.Rasterize(40, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []color.RGBA{
+		{0, 0, 0xFF, 0xFF},    // color of the svg element
+		{0, 0xFF, 0, 0xFF},    // color of the use element
+		{0xFF, 0xFF, 0, 0xFF}, // color of the gradient, for its stops
+		{0xFF, 0, 0, 0xFF},    // color of a style attribute, for a stroke
+	} {
+		if got := img.RGBAAt(10*i+5, 5); got != want {
+			t.Errorf("rect %d is %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestHSL(t *testing.T) {
+	c, err := ParseSVGColor("hsl(198, 47%, 65%)")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rgb := c.(color.NRGBA)
+	if rgb.R != 124 || rgb.G != 183 || rgb.B != 208 || rgb.A != 255 {
+		t.Errorf("Invalid conversion: rgba(%d, %d, %d, %d)", rgb.R, rgb.G, rgb.B, rgb.A)
+		return
+	}
+
+	for _, tc := range []struct {
+		s    string
+		want color.NRGBA
+	}{
+		{"hsl(198deg 47% 65%)", color.NRGBA{124, 183, 208, 255}},
+		{"hsl(0.55turn, 47%, 65%)", color.NRGBA{124, 183, 208, 255}},
+		{"hsl(3.4557rad 47% 65%)", color.NRGBA{124, 183, 208, 255}},
+		{"hsl(220grad, 47.0%, 65.0%)", color.NRGBA{124, 183, 208, 255}},
+		{"hsl(-162, 47%, 65%)", color.NRGBA{124, 183, 208, 255}},
+		{"hsl(120.5, 150%, -10%)", color.NRGBA{0, 0, 0, 255}},
+		{"hsl(0 100% 50% / 50%)", color.NRGBA{255, 0, 0, 128}},
+		{"hsla(240, 100%, 50%, 0.25)", color.NRGBA{0, 0, 255, 64}},
+	} {
+		c, err := ParseSVGColor(tc.s)
+		if err != nil {
+			t.Error(tc.s, err)
+		} else if c != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.s, c, tc.want)
+		}
+	}
+	for _, s := range []string{"hsl(1x, 50%, 50%)", "hsl(10, 50%)", "hsl(10, fifty%, 50%)"} {
+		if _, err := ParseSVGColor(s); err == nil {
+			t.Error("no error for", s)
+		}
+	}
+}
+
+func TestRGBA(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want color.NRGBA
+	}{
+		{"rgb(255, 128, 0)", color.NRGBA{255, 128, 0, 255}},
+		{"RGB(100%, 50%, 0%)", color.NRGBA{255, 128, 0, 255}},
+		{"rgba(255, 128, 0, 0.5)", color.NRGBA{255, 128, 0, 128}},
+		{"rgba(255,128,0,25%)", color.NRGBA{255, 128, 0, 64}},
+		{"rgb(255 128 0 / 0.5)", color.NRGBA{255, 128, 0, 128}},
+		{"rgb(127.6, 300, -5)", color.NRGBA{128, 255, 0, 255}},
+		{"#ff800080", color.NRGBA{255, 128, 0, 128}},
+		{"#f808", color.NRGBA{255, 136, 0, 136}},
+		{"transparent", color.NRGBA{}},
+	} {
+		c, err := ParseSVGColor(tc.s)
+		if err != nil {
+			t.Error(tc.s, err)
+		} else if c != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.s, c, tc.want)
+		}
+	}
+	for _, s := range []string{"rgb(1, 2)", "rgba(1, 2, 3, x)", "rgb(red, 2, 3)", "#12345"} {
+		if _, err := ParseSVGColor(s); err == nil {
+			t.Error("no error for", s)
+		}
+	}
+}
+
+func TestShapeBuilders(t *testing.T) {
+	w := 400
+	img := image.NewRGBA(image.Rect(0, 0, w, w))
+	scannerGV := NewScannerGV(w, w, img, img.Bounds())
+	raster := NewDasher(w, w, scannerGV)
+
+	c := &PathCursor{}
+	c.RoundRect(10, 10, 180, 80, 20, 10)
+	c.PieAt(300, 100, 80, -30, 210)
+	c.RingSectorAt(100, 300, 40, 80, 0, 360)
+	c.RingSectorAt(300, 300, 40, 80, 45, -90)
+	fill := SvgPath{PathStyle: DefaultStyle, Path: c.Path}
+	fill.Draw(raster, 1)
+
+	c.Path.Clear()
+	c.ArcAt(200, 200, 190, 180, 450)
+	line := SvgPath{PathStyle: DefaultStyle, Path: c.Path}
+	line.SetFillColor(nil)
+	line.SetLineColor(color.NRGBA{0, 0, 255, 255})
+	line.Draw(raster, 1)
+
+	black, blue := color.RGBA{A: 0xFF}, color.RGBA{B: 0xFF, A: 0xFF}
+	for _, p := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{100, 50, black}, {30, 12, black}, {11, 11, color.RGBA{}}, // the rounded rect and a corner
+		{300, 160, black}, {300, 105, black}, {300, 60, color.RGBA{}}, // the pie and its gap
+		{160, 300, black}, {100, 360, black}, {100, 300, color.RGBA{}}, // the full ring
+		{350, 300, black}, {342, 258, black}, {300, 350, color.RGBA{}}, {300, 300, color.RGBA{}}, // the sector
+		{389, 200, blue}, {200, 10, blue}, {21, 265, color.RGBA{}}, // the arc and the quarter it skips
+	} {
+		if got := img.RGBAAt(p.x, p.y); got != p.want {
+			t.Errorf("pixel %d,%d is %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+}
+
+func TestGradientHelpers(t *testing.T) {
+	w := 100
+	img := image.NewRGBA(image.Rect(0, 0, w, w))
+	scannerGV := NewScannerGV(w, w, img, img.Bounds())
+	raster := NewDasher(w, w, scannerGV)
+
+	stops := []GradStop{
+		{StopColor: color.NRGBA{255, 0, 0, 255}, Offset: 0, Opacity: 1},
+		{StopColor: color.NRGBA{0, 0, 255, 255}, Offset: 1, Opacity: 1}}
+	c := &PathCursor{}
+	c.RoundRect(0, 0, 100, 50, 0, 0)
+	p := SvgPath{PathStyle: DefaultStyle, Path: c.Path}
+	p.SetFillGradient(LinearGradientFromAngle(0, stops))
+	p.Draw(raster, 1)
+
+	c.Path.Clear()
+	c.RoundRect(0, 50, 100, 50, 0, 0)
+	p = SvgPath{PathStyle: DefaultStyle, Path: c.Path}
+	p.SetFillGradient(RadialGradientAt(50, 75, 50, UserSpaceOnUse, stops))
+	p.Draw(raster, 1)
+
+	if r, _, b, _ := img.At(2, 25).RGBA(); r < b {
+		t.Error("linear gradient should start red")
+	}
+	if r, _, b, _ := img.At(97, 25).RGBA(); r > b {
+		t.Error("linear gradient should end blue")
+	}
+	if r, _, b, _ := img.At(50, 75).RGBA(); r < b {
+		t.Error("radial gradient should be red at the center")
+	}
+	if r, _, b, _ := img.At(1, 99).RGBA(); r > b {
+		t.Error("radial gradient should be blue at the corner")
+	}
+}
+
+func TestGradientColorAt(t *testing.T) {
+	stops := []GradStop{
+		{StopColor: color.NRGBA{0, 0, 0, 255}, Offset: 1, Opacity: 1},
+		{StopColor: color.NRGBA{255, 255, 255, 255}, Offset: 0, Opacity: 1}}
+	linear := &Gradient{Points: [5]float64{10, 0, 110, 0}, Stops: stops, Matrix: Identity, Units: UserSpaceOnUse}
+	box := LinearGradientFromAngle(90, stops)
+	box.Bounds = struct{ X, Y, W, H float64 }{20, 20, 10, 40} // the gradient runs down the box
+	radial := RadialGradientAt(50, 50, 10, UserSpaceOnUse, stops)
+	radial.Matrix = Identity.Scale(2, 1)
+	for _, tc := range []struct {
+		name    string
+		g       *Gradient
+		x, y    float64
+		opacity float64
+		want    color.NRGBA
+	}{
+		{"linear start", linear, 10, 7, 1, color.NRGBA{255, 255, 255, 255}},
+		{"linear middle", linear, 60, -3, 1, color.NRGBA{127, 127, 127, 255}},
+		{"linear padded", linear, 500, 0, 1, color.NRGBA{0, 0, 0, 255}},
+		{"linear opacity", linear, 10, 0, 0.5, color.NRGBA{255, 255, 255, 127}},
+		{"bounding box top", box, 25, 20, 1, color.NRGBA{255, 255, 255, 255}},
+		{"bounding box middle", box, 123, 40, 1, color.NRGBA{127, 127, 127, 255}},
+		{"radial center", radial, 100, 50, 1, color.NRGBA{255, 255, 255, 255}},
+		{"radial edge", radial, 110, 50, 1, color.NRGBA{127, 127, 127, 255}},
+		{"single stop", &Gradient{Stops: stops[:1], Matrix: Identity}, 3, 4, 1, color.NRGBA{0, 0, 0, 255}},
+	} {
+		got := color.NRGBAModel.Convert(GradientColorAt(tc.g, tc.x, tc.y, tc.opacity)).(color.NRGBA)
+		if !nearColor(got, tc.want, 2) {
+			t.Errorf("%s: color %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if stops[0].Offset != 1 {
+		t.Error("the stops of the gradient were sorted")
+	}
+}
+
+func TestGradientHref(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 200 100">
+	<defs>
+		<linearGradient id="shared"><stop offset="0" stop-color="#ff0000"/><stop offset="1" stop-color="#0000ff"/></linearGradient>
+		<linearGradient id="a" xlink:href="#shared" x1="0" y1="0" x2="100" y2="0" gradientUnits="userSpaceOnUse"/>
+		<radialGradient id="b" xlink:href="#shared" cx="150" cy="50" r="50" gradientUnits="userSpaceOnUse"/>
+		<linearGradient id="c" href="#a" spreadMethod="reflect"/>
+		<radialGradient id="d" href="#b" r="25"><stop offset="0" stop-color="#00ff00"/></radialGradient>
+		<linearGradient id="e" href="#later"/>
+		<linearGradient id="later"><stop offset="0" stop-color="#ffffff"/><stop offset="1" stop-color="#000000"/></linearGradient>
+	</defs>
+	<rect width="100" height="100" fill="url(#a)"/></svg>`
+	icon, err := ReadIconStreamPolicy(strings.NewReader(svg), ErrorPolicy{MissingReference: StrictErrorMode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		id     string
+		points [5]float64
+		units  GradientUnits
+		spread SpreadMethod
+		stops  int
+	}{
+		{"a", [5]float64{0, 0, 100, 0, 0}, UserSpaceOnUse, PadSpread, 2},
+		{"b", [5]float64{150, 50, 150, 50, 50}, UserSpaceOnUse, PadSpread, 2},
+		{"c", [5]float64{0, 0, 100, 0, 0}, UserSpaceOnUse, ReflectSpread, 2},
+		{"d", [5]float64{150, 50, 150, 50, 25}, UserSpaceOnUse, PadSpread, 1},
+		{"e", [5]float64{0, 0, 1, 0, 0}, ObjectBoundingBox, PadSpread, 2},
+	} {
+		g := icon.Grads[tc.id]
+		if g.Points != tc.points || g.Units != tc.units || g.Spread != tc.spread || len(g.Stops) != tc.stops {
+			t.Errorf("gradient %s has points %v, units %v, spread %v and %d stops", tc.id, g.Points, g.Units, g.Spread, len(g.Stops))
+		}
+	}
+	if c := icon.Grads["e"].Stops[0].StopColor; c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("gradient e starts with %v, want the stops of the later gradient", c)
+	}
+	img, err := icon.Rasterize(200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.RGBAAt(2, 50); !nearColor(color.NRGBA(got), color.NRGBA{250, 0, 5, 255}, 4) {
+		t.Errorf("inherited stops drew %v", got)
+	}
+}
+
+func TestForEachGradient(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 30 10">
+	<defs>
+		<linearGradient id="g"><stop offset="0" stop-color="#ff0000"/><stop offset="1" stop-color="#ff0000"/></linearGradient>
+		<pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse"><rect width="10" height="10" fill="url(#g)"/></pattern>
+	</defs>
+	<rect width="10" height="10" fill="url(#g)"/>
+	<rect x="10" width="10" height="10" fill="url(#p)"/>
+	<path d="M20,5 H30" stroke="url(#g)" stroke-width="10"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, ok := icon.SVGPaths[0].FillGradient()
+	if !ok {
+		t.Fatal("the fill of the first path is not a gradient")
+	}
+	g.Stops[0].StopColor = color.NRGBA{0, 0, 255, 255}
+	g.Stops[1].StopColor = color.NRGBA{0, 0, 255, 255}
+	if c := icon.Grads["g"].Stops[0].StopColor; c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("changing a copy of a gradient changed the gradient to %v", c)
+	}
+	if _, ok := icon.SVGPaths[1].FillGradient(); ok {
+		t.Error("the pattern fill was taken for a gradient")
+	}
+
+	n := 0
+	icon.ForEachGradient(func(g *Gradient) {
+		n++
+		for i := range g.Stops {
+			g.Stops[i].StopColor = color.NRGBA{0, 255, 0, 255}
+		}
+	})
+	if n != 4 { // by id, the fill, the line and in the pattern
+		t.Errorf("%d gradients visited, want 4", n)
+	}
+	if c := g.Stops[0].StopColor; c != (color.NRGBA{0, 0, 255, 255}) {
+		t.Errorf("the copy was recolored to %v", c)
+	}
+	img, err := icon.Rasterize(30, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []int{5, 15, 25} {
+		if got := img.RGBAAt(x, 5); got != (color.RGBA{G: 0xff, A: 0xff}) {
+			t.Errorf("pixel %d,5 is %v after recoloring", x, got)
+		}
+	}
+
+	icon.SVGPaths[0].SetFillGradient(g)
+	if img, _ = icon.Rasterize(30, 10); img.RGBAAt(5, 5) != (color.RGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("set gradient drew %v", img.RGBAAt(5, 5))
+	}
+}
+
+func TestMergeIDs(t *testing.T) {
+	const src = `<svg viewBox="0 0 10 10"><defs>
+	<linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient>
+	<rect id="r" width="5" height="5" fill="url(#g)"/></defs>
+	<use href="#r"/></svg>`
+	a, err := ReadIconStream(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ReadIconStream(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Merge(b, "b-")
+	if len(a.SVGPaths) != 2 {
+		t.Error("merge should append paths", len(a.SVGPaths))
+	}
+	if a.Grads["g"] == nil || a.Grads["b-g"] == nil {
+		t.Error("merged gradient ids are not namespaced")
+	}
+	defs := a.Defs["b-r"]
+	if len(defs) == 0 || defs[0].ID != "b-r" {
+		t.Fatal("merged definition ids are not namespaced")
+	}
+	for _, attr := range defs[0].Attrs {
+		if attr.Name.Local == "fill" && attr.Value != "url(#b-g)" {
+			t.Error("merged reference not re-keyed", attr.Value)
+		}
+	}
+	if a.Defs["r"][0].Attrs[len(a.Defs["r"][0].Attrs)-1].Value != "url(#g)" {
+		t.Error("merge should not change the original references")
+	}
+
+	a.PrefixIDs("a-")
+	if a.Grads["a-b-g"] == nil || a.Defs["a-r"] == nil || a.Defs["a-r"][0].ID != "a-r" {
+		t.Error("prefixing ids failed")
+	}
+	if err := a.RenameID("a-g", "a-r"); err == nil || a.Grads["a-g"] == nil || a.Defs["a-r"] == nil {
+		t.Error("renaming onto a definition should fail and change nothing", err)
+	}
+	if err := a.RenameID("a-g", "a-b-g"); err == nil || a.Grads["a-g"] == nil {
+		t.Error("renaming onto a gradient should fail and change nothing", err)
+	}
+	if err := a.RenameID("a-g", "x"); err != nil {
+		t.Error(err)
+	}
+	if a.Grads["x"] == nil || a.Defs["a-r"][0].Attrs[len(a.Defs["a-r"][0].Attrs)-1].Value != "url(#x)" {
+		t.Error("renaming id failed")
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	icon, err := ReadIcon("testdata/testIcons/defs.svg", StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range icon.Definitions() {
+		switch def := d.(type) {
+		case GradientDef:
+			if g, ok := icon.LookupGradient(def.ID); !ok || g != def.Gradient {
+				t.Error("gradient lookup failed", def.ID)
+			}
+		case ElementDef:
+			if def.Tag == "" {
+				t.Error("element definition without tag", def.ID)
+			}
+		default:
+			t.Errorf("unexpected definition type %T", d)
+		}
+	}
+	if _, ok := icon.LookupDefinition("not-an-id"); ok {
+		t.Error("lookup of missing id should fail")
+	}
+
+	icon, err = ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><defs>
+		<symbol id="s" viewBox="0 0 4 4"><rect width="4" height="4"/></symbol>
+		<clipPath id="c"><rect width="5" height="5"/></clipPath>
+		<mask id="m"><rect width="5" height="5" fill="#ffffff"/></mask>
+		<pattern id="p" width="2" height="2"><rect width="1" height="1"/></pattern>
+		<rect id="r" width="1" height="1"/></defs></svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]string)
+	for _, d := range icon.Definitions() {
+		types[d.DefID()] = fmt.Sprintf("%T", d)
+	}
+	for id, want := range map[string]string{"s": "oksvg.SymbolDef", "c": "oksvg.ClipDef",
+		"m": "oksvg.ClipDef", "p": "oksvg.PatternDef", "r": "oksvg.ElementDef"} {
+		if types[id] != want {
+			t.Errorf("definition %s is a %s, want %s", id, types[id], want)
+		}
+	}
+	if d, ok := icon.LookupClip("m"); !ok || !d.Mask || d.Source.Line != 4 {
+		t.Errorf("mask looked up as %+v", d)
+	}
+	if d, ok := icon.LookupClip("c"); !ok || d.Mask {
+		t.Errorf("clip path looked up as %+v", d)
+	}
+	if d, ok := icon.LookupSymbol("s"); !ok || len(d.Attrs) != 2 {
+		t.Errorf("symbol looked up as %+v", d)
+	}
+	if _, ok := icon.LookupPattern("p"); !ok {
+		t.Error("pattern lookup failed")
+	}
+	if _, ok := icon.LookupSymbol("r"); ok {
+		t.Error("rect looked up as a symbol")
+	}
+}
+
+func TestViewBox(t *testing.T) {
+	v := ViewBox{X: 10, Y: 20, W: 40, H: 20}
+	if v.AspectRatio() != 2 {
+		t.Error("aspect ratio failed", v.AspectRatio())
+	}
+	if !v.Contains(10, 20) || v.Contains(50, 30) {
+		t.Error("contains failed")
+	}
+	x, y := v.TransformTo(ViewBox{0, 0, 80, 40}).Transform(50, 40)
+	if x != 80 || y != 40 {
+		t.Error("transform failed", x, y)
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != `"10 20 40 20"` {
+		t.Error("marshal failed", string(b), err)
+	}
+	var u ViewBox
+	if err = json.Unmarshal([]byte(`"-1.5,0 24 24"`), &u); err != nil || u != (ViewBox{-1.5, 0, 24, 24}) {
+		t.Error("unmarshal failed", u, err)
+	}
+}
+
+func TestLayerCache(t *testing.T) {
+	icon, err := ReadIcon("testdata/landscapeIcons/sea.svg", WarnErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+	direct := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, direct, direct.Bounds())), 0.5)
+
+	cached := image.NewRGBA(image.Rect(0, 0, w, h))
+	NewLayerCache(icon, w, h).Draw(cached, 0.5)
+
+	var maxDiff int
+	for i := range direct.Pix {
+		d := int(direct.Pix[i]) - int(cached.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > maxDiff {
+			maxDiff = d
+		}
+	}
+	if maxDiff > 4 {
+		t.Error("cached layers differ from direct drawing by", maxDiff)
+	}
+}
+
+func TestLayerCacheQuirks(t *testing.T) {
+	const svg = `<svg viewBox="0 0 40 40">
+<path d="M8,8 H32 V32 H8 Z" fill="blue" stroke="red" stroke-width="6" opacity="0.5"/>
+<circle cx="20" cy="20" r="8" fill="yellow" stroke="green" stroke-width="4"/>
+</svg>`
+	for _, c := range []struct {
+		name    string
+		isolate bool
+		quirks  Quirks
+	}{
+		{"isolated", true, Quirks{}},
+		{"stroke-first", false, StrokeFirstQuirks},
+		{"isolated stroke-first", true, StrokeFirstQuirks},
+	} {
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		icon.IsolateOpacity, icon.Quirks = c.isolate, c.quirks
+		direct := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, direct, direct.Bounds())), 1)
+
+		cached := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		NewLayerCache(icon, 40, 40).Draw(cached, 1)
+
+		var maxDiff int
+		for i := range direct.Pix {
+			d := int(direct.Pix[i]) - int(cached.Pix[i])
+			if d < 0 {
+				d = -d
+			}
+			if d > maxDiff {
+				maxDiff = d
+			}
+		}
+		if maxDiff > 4 {
+			t.Error(c.name, "cached layers differ from Draw by", maxDiff)
+		}
+	}
+}
+
+func TestDrawIn(t *testing.T) {
+	icon, err := ReadIcon("testdata/landscapeIcons/sea.svg", WarnErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+	single := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, single, single.Bounds())), 1)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, w*4, h*4))
+	rect := image.Rect(w, 2*h, 2*w, 3*h)
+	icon.Transform = Identity.Translate(float64(rect.Min.X), float64(rect.Min.Y))
+	icon.DrawIn(canvas, rect, 1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if single.RGBAAt(x, y) != canvas.RGBAAt(x+rect.Min.X, y+rect.Min.Y) {
+				t.Fatal("icon drawn into sub-rectangle differs at", x, y)
+			}
+		}
+	}
+	if canvas.RGBAAt(rect.Min.X-1, rect.Min.Y).A != 0 {
+		t.Error("icon drawn outside of sub-rectangle")
+	}
+}
+
+func TestRasterize(t *testing.T) {
+	icon, err := ReadIcon("testdata/landscapeIcons/sea.svg", WarnErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+	single := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, single, single.Bounds())), 1)
+	img, err := icon.Rasterize(w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.Pix, single.Pix) {
+		t.Error("rasterized icon differs from the drawn icon")
+	}
+
+	icon, err = ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+		<rect width="10" height="10" fill="red"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = icon.Rasterize(40, 20, WithAspectRatio("xMidYMid meet"), WithBackground(color.White), WithOpacity(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.RGBAAt(5, 10); got != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) {
+		t.Errorf("background is %v, want white", got)
+	}
+	if got := img.RGBAAt(20, 10); !nearColor(color.NRGBA(got), color.NRGBA{0xFF, 0x80, 0x80, 0xFF}, 2) {
+		t.Errorf("icon is %v, want red at half opacity over white", got)
+	}
+	if _, err = icon.Rasterize(0, 10); err == nil {
+		t.Error("expected an error for an empty image")
+	}
+
+	// Without preserveAspectRatio, the ViewBox is centered as in browsers
+	img, _ = icon.Rasterize(40, 20)
+	if img.RGBAAt(5, 10).A != 0 || img.RGBAAt(20, 10).A != 0xFF {
+		t.Error("icon without preserveAspectRatio is stretched", img.RGBAAt(5, 10), img.RGBAAt(20, 10))
+	}
+	if img, _ = icon.Rasterize(40, 20, WithAspectRatio("none")); img.RGBAAt(5, 10).A != 0xFF {
+		t.Error("icon not stretched with none", img.RGBAAt(5, 10))
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">
+		<rect x="10" y="10" width="20" height="20" fill="red" stroke="blue" stroke-width="4"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, ok := QuirksByName("stroke-first")
+	if !ok || !q.StrokeBeforeFill {
+		t.Fatal("stroke-first quirks profile not found")
+	}
+	for _, tc := range []struct {
+		quirks       Quirks
+		outer, inner color.RGBA
+	}{
+		{Quirks{}, color.RGBA{0, 0, 0xFF, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF}},
+		{q, color.RGBA{0, 0, 0xFF, 0xFF}, color.RGBA{0xFF, 0, 0, 0xFF}},
+	} {
+		img, err := icon.Rasterize(40, 40, WithQuirks(tc.quirks))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBAAt(9, 20); got != tc.outer {
+			t.Errorf("%q: outer half of the stroke is %v, want %v", tc.quirks.Name, got, tc.outer)
+		}
+		if got := img.RGBAAt(10, 20); got != tc.inner {
+			t.Errorf("%q: inner half of the stroke is %v, want %v", tc.quirks.Name, got, tc.inner)
+		}
+	}
+
+	// The Quirks of the icon are followed unless an option overrides them
+	icon.Quirks = q
+	if img, _ := icon.Rasterize(40, 40); img.RGBAAt(10, 20) != (color.RGBA{0xFF, 0, 0, 0xFF}) {
+		t.Error("quirks of the icon not followed", img.RGBAAt(10, 20))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	icon.Draw(NewDasher(40, 40, NewScannerGV(40, 40, img, img.Bounds())), 1)
+	if img.RGBAAt(10, 20) != (color.RGBA{0xFF, 0, 0, 0xFF}) {
+		t.Error("quirks of the icon not followed by Draw", img.RGBAAt(10, 20))
+	}
+	if img, _ := icon.Rasterize(40, 40, WithQuirks(Quirks{})); img.RGBAAt(10, 20) != (color.RGBA{0, 0, 0xFF, 0xFF}) {
+		t.Error("quirks of the icon not overridden", img.RGBAAt(10, 20))
+	}
+}
+
+func TestPhysicalSize(t *testing.T) {
+	for _, tc := range []struct {
+		attrs  string
+		w, h   float64
+		ok     bool
+		dpi    float64
+		pw, ph int
+	}{
+		{`width="50mm" height="2in"`, 50, 50.8, true, 254, 500, 508},
+		{`width="96" height="48px"`, 25.4, 12.7, true, 96, 96, 48},
+		{`width="100%" height="10cm"`, 0, 100, false, 192, 20, 40},
+		{``, 0, 0, false, 192, 20, 40},
+	} {
+		icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" ` + tc.attrs +
+			` viewBox="0 0 10 20"><rect width="10" height="20"/></svg>`))
+		if err != nil {
+			t.Fatal(tc.attrs, err)
+		}
+		w, h, ok := icon.PhysicalSize()
+		if ok != tc.ok || math.Abs(w-tc.w) > 1e-9 || math.Abs(h-tc.h) > 1e-9 {
+			t.Errorf("%s: physical size is %v, %v, %v, want %v, %v, %v", tc.attrs, w, h, ok, tc.w, tc.h, tc.ok)
+		}
+		img, err := icon.RenderAtDPI(tc.dpi)
+		if err != nil {
+			t.Fatal(tc.attrs, err)
+		}
+		if b := img.Bounds(); b.Dx() != tc.pw || b.Dy() != tc.ph {
+			t.Errorf("%s: image at %v dpi is %v, want %dx%d", tc.attrs, tc.dpi, b, tc.pw, tc.ph)
+		}
+	}
+}
+
+func TestDrawContext(t *testing.T) {
+	icons := ReadIconSet("testdata/landscapeIcons/", []string{"beach", "cape", "sea"})
+	dc := NewDrawContext(512, 512)
+	for _, icon := range icons {
+		w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+		direct := image.NewRGBA(image.Rect(0, 0, w, h))
+		icon.Draw(NewDasher(w, h, NewScannerGV(w, h, direct, direct.Bounds())), 1)
+		pooled := image.NewRGBA(image.Rect(0, 0, w, h))
+		if err := dc.Draw(icon, pooled, 1); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(direct.Pix, pooled.Pix) {
+			t.Error("draw context output differs from direct drawing")
+		}
+	}
+	if err := dc.Draw(icons[0], image.NewRGBA(image.Rect(0, 0, 600, 10)), 1); err == nil {
+		t.Error("draw context should reject oversized destinations")
+	}
+}
+
+func TestLargeCoordinates(t *testing.T) {
+	const largeSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1e8 1e8">
+	<rect x="0" y="0" width="5e7" height="1e8" fill="red"/>
+	<path d="M5e7,0 h5e7 v1e8 h-5e7 z" fill="none"/>
+	<path d="M0,0 L1e8,1e8" stroke="none"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(largeSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := 100, 100
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, img, img.Bounds())), 1)
+	if img.RGBAAt(25, 50).A != 255 {
+		t.Error("left half of large icon not filled")
+	}
+	if img.RGBAAt(75, 50).A != 0 {
+		t.Error("right half of large icon filled")
+	}
+
+	var c PathCursor
+	if err = c.CompilePath("M0,0 L1e9,1e9"); err == nil {
+		t.Error("expected error for coordinates outside of fixed point range")
+	}
+}
+
+func TestRegisterElementHandler(t *testing.T) {
+	const foreignSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<foreignObject width="10" height="10" fill="blue"/>
+	</svg>`
+	if _, err := ReadIconStream(strings.NewReader(foreignSVG), StrictErrorMode); err == nil {
+		t.Error("expected error for unhandled foreignObject")
+	}
+	RegisterElementHandler("foreignObject", func(c *IconCursor, attrs []xml.Attr) error {
+		c.Icon().Descriptions = append(c.Icon().Descriptions, "foreignObject placeholder")
+		c.RoundRect(0, 0, 10, 10, 0, 0)
+		return nil
+	})
+	defer RegisterElementHandler("foreignObject", nil)
+	icon, err := ReadIconStream(strings.NewReader(foreignSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != 1 || len(icon.Descriptions) != 1 {
+		t.Fatal("foreignObject handler not used", len(icon.SVGPaths), icon.Descriptions)
+	}
+	if icon.SVGPaths[0].GetFillColor() != (color.NRGBA{0, 0, 255, 255}) {
+		t.Error("placeholder should use the style of the element", icon.SVGPaths[0].GetFillColor())
+	}
+}
+
+func TestForeignObjectRenderer(t *testing.T) {
+	const foreignSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<foreignObject x="0" y="0" width="10" height="10"><p xmlns="http://www.w3.org/1999/xhtml">html</p></foreignObject>
+	<rect x="10" y="10" width="10" height="10" fill="green"/>
+	</svg>`
+	var got string
+	var gotRect ViewBox
+	SetForeignObjectRenderer(func(raw []byte, rect ViewBox) (image.Image, error) {
+		got, gotRect = string(raw), rect
+		img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+		img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+		img.Set(0, 1, color.RGBA{255, 0, 0, 255})
+		img.Set(1, 0, color.RGBA{0, 0, 255, 255})
+		img.Set(1, 1, color.RGBA{0, 0, 255, 255})
+		return img, nil
+	})
+	defer SetForeignObjectRenderer(nil)
+	icon, err := ReadIconStream(strings.NewReader(foreignSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "<foreignObject") || !strings.HasSuffix(got, "</foreignObject>") ||
+		!strings.Contains(got, "<p xmlns=\"http://www.w3.org/1999/xhtml\">html</p>") {
+		t.Error("renderer received wrong raw XML", got)
+	}
+	if gotRect != (ViewBox{0, 0, 10, 10}) {
+		t.Error("renderer received wrong rect", gotRect)
+	}
+	if len(icon.SVGPaths) != 2 {
+		t.Fatal("expected foreignObject and rect paths, got", len(icon.SVGPaths))
+	}
+	w, h := 20, 20
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(NewDasher(w, h, NewScannerGV(w, h, img, img.Bounds())), 1)
+	if c := img.RGBAAt(2, 5); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Error("left of foreignObject image wrong", c)
+	}
+	if c := img.RGBAAt(7, 5); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Error("right of foreignObject image wrong", c)
+	}
+	if c := img.RGBAAt(15, 5); c.A != 0 {
+		t.Error("foreignObject image drawn outside of its rect", c)
+	}
+	if c := img.RGBAAt(15, 15); c.G == 0 {
+		t.Error("element after foreignObject not drawn", c)
+	}
+}
+
+func TestImageSampling(t *testing.T) {
+	SetForeignObjectRenderer(func(raw []byte, rect ViewBox) (image.Image, error) {
+		img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+		img.Set(1, 0, color.RGBA{0, 0, 255, 255})
+		return img, nil
+	})
+	defer SetForeignObjectRenderer(nil)
+	draw := func(style string, sampling ImageSampling) *image.RGBA {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10">
+		<foreignObject ` + style + ` width="20" height="10"><p/></foreignObject></svg>`
+		icon, err := ReadIconStreamOptions(strings.NewReader(svg), ParseOptions{ImageSampling: sampling})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+		icon.Draw(NewDasher(20, 10, NewScannerGV(20, 10, img, img.Bounds())), 1)
+		return img
+	}
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	for _, tc := range []struct {
+		name     string
+		style    string
+		sampling ImageSampling
+		sharp    bool
+	}{
+		{"default", "", NearestSampling, true},
+		{"bilinear", "", BilinearSampling, false},
+		{"catmull-rom", "", CatmullRomSampling, false},
+		{"pixelated", `image-rendering="pixelated"`, BilinearSampling, true},
+		{"pixelated style", `style="image-rendering:crisp-edges"`, CatmullRomSampling, true},
+		{"auto", `image-rendering="auto"`, BilinearSampling, false},
+	} {
+		img := draw(tc.style, tc.sampling)
+		if c := img.RGBAAt(0, 5); c != red {
+			t.Error(tc.name, "edge of the image should not blend", c)
+		}
+		if c := img.RGBAAt(19, 5); c != blue {
+			t.Error(tc.name, "edge of the image should not blend", c)
+		}
+		left, right := img.RGBAAt(9, 5), img.RGBAAt(10, 5)
+		if sharp := left == red && right == blue; sharp != tc.sharp {
+			t.Error(tc.name, "expected sharp", tc.sharp, "got", left, right)
+		}
+		if !tc.sharp && !(left.R > left.B && right.B > right.R) {
+			t.Error(tc.name, "blended pixels should lean to the nearest pixel", left, right)
+		}
+	}
+}
+
+func TestImageElement(t *testing.T) {
+	quads := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	red, lime := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}
+	blue, white := color.RGBA{0, 0, 255, 255}, color.RGBA{255, 255, 255, 255}
+	quads.Set(0, 0, red)
+	quads.Set(1, 0, lime)
+	quads.Set(0, 1, blue)
+	quads.Set(1, 1, white)
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, quads); err != nil {
+		t.Fatal(err)
+	}
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pngData.Bytes())
+	var loaded []string
+	opts := ParseOptions{ErrorPolicy: StrictErrorMode.Policy(), ImageLoader: func(href string) (image.Image, error) {
+		loaded = append(loaded, href)
+		return quads, nil
+	}}
+	draw := func(svg string) (*SvgIcon, *image.RGBA) {
+		icon, err := ReadIconStreamOptions(strings.NewReader(svg), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		icon.Draw(NewDasher(40, 20, NewScannerGV(40, 20, img, img.Bounds())), 1)
+		return icon, img
+	}
+	const head = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 40 20" image-rendering="pixelated">`
+	for _, tc := range []struct {
+		name  string
+		image string
+		want  map[image.Point]color.RGBA
+	}{
+		{"stretched", `<image width="40" height="20" preserveAspectRatio="none" href="` + dataURI + `"/>`,
+			map[image.Point]color.RGBA{{5, 5}: red, {35, 5}: lime, {5, 15}: blue, {35, 15}: white}},
+		{"meet", `<image width="40" height="20" xlink:href="` + dataURI + `"/>`,
+			map[image.Point]color.RGBA{{5, 5}: {}, {15, 5}: red, {25, 15}: white, {35, 15}: {}}},
+		{"slice", `<image width="40" height="20" preserveAspectRatio="xMidYMin slice" href="` + dataURI + `"/>`,
+			map[image.Point]color.RGBA{{5, 5}: red, {5, 15}: red, {35, 15}: lime}},
+		{"auto size", `<image x="10" y="5" transform="scale(2)" href="` + dataURI + `"/>`,
+			map[image.Point]color.RGBA{{20, 10}: red, {23, 13}: white, {19, 10}: {}, {24, 14}: {}}},
+		{"loaded", `<image width="40" height="20" preserveAspectRatio="none" href="quads.png"/>`,
+			map[image.Point]color.RGBA{{5, 5}: red, {35, 15}: white}},
+	} {
+		icon, img := draw(head + tc.image + `</svg>`)
+		for p, want := range tc.want {
+			if c := img.RGBAAt(p.X, p.Y); c != want {
+				t.Error(tc.name, "at", p, "want", want, "got", c)
+			}
+		}
+		// Images are written back as image elements drawing the same pixels
+		var b bytes.Buffer
+		if err := icon.MarshalSVG(&b); err != nil {
+			t.Fatal(err)
+		}
+		_, again := draw(b.String())
+		if !bytes.Equal(img.Pix, again.Pix) {
+			t.Error(tc.name, "should draw the same when marshaled", b.String())
+		}
+	}
+	if !reflect.DeepEqual(loaded, []string{"quads.png"}) {
+		t.Error("the loader should be called for hrefs that are not data URIs", loaded)
+	}
+
+	var jpegData bytes.Buffer
+	solid := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range solid.Pix {
+		solid.Pix[i] = []uint8{0, 0, 255, 255}[i%4]
+	}
+	if err := jpeg.Encode(&jpegData, solid, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Wrapped base64 without padding, as some generators write it
+	enc := strings.TrimRight(base64.StdEncoding.EncodeToString(jpegData.Bytes()), "=")
+	_, img := draw(head + `<image width="40" height="20" href="data:image/jpeg;base64,` + enc[:20] + "\n  " + enc[20:] + `"/></svg>`)
+	if c := img.RGBAAt(20, 10); !nearColor(color.NRGBA(c), color.NRGBA(blue), 8) {
+		t.Error("jpeg image should be drawn", c)
+	}
+	if _, err := ReadIconStreamOptions(strings.NewReader(head+`<image width="4" height="4" href="data:image/png;base64,AAAA"/></svg>`),
+		opts); err == nil {
+		t.Error("bad image data should be reported")
+	}
+	small := ParseOptions{ErrorPolicy: StrictErrorMode.Policy(), MaxImagePixels: 3}
+	if _, err := ReadIconStreamOptions(strings.NewReader(head+`<image width="4" height="4" href="`+dataURI+`"/></svg>`),
+		small); err == nil {
+		t.Error("image above MaxImagePixels should be reported")
+	}
+	small.MaxImagePixels = 4
+	if icon, err := ReadIconStreamOptions(strings.NewReader(head+`<image width="4" height="4" href="`+dataURI+`"/></svg>`),
+		small); err != nil || len(icon.SVGPaths) != 1 {
+		t.Error("image of MaxImagePixels should be drawn", err)
+	}
+	if _, err := ReadIconStreamOptions(strings.NewReader(head+`<image width="4" height="4" href="data:image/svg+xml,%3Csvg/%3E"/></svg>`),
+		ParseOptions{ErrorPolicy: ErrorPolicy{UnknownElement: IgnoreErrorMode, MalformedValue: StrictErrorMode}}); err != nil {
+		t.Error("svg images should be reported as unsupported", err)
+	}
+}
+
+func TestMarkers(t *testing.T) {
+	const markerSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 20">
+	<defs>
+	<marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="4" markerHeight="4" orient="auto-start-reverse">
+		<path d="M0 0L10 5L0 10z"/>
+	</marker>
+	</defs>
+	<marker id="dot" markerWidth="2" markerHeight="2" refX="1" refY="1" markerUnits="userSpaceOnUse">
+		<circle cx="1" cy="1" r="1" fill="red"/>
+	</marker>
+	<g marker-start="url(#arrow)">
+		<path d="M5 10H35" stroke="black" stroke-width="2" fill="none" style="marker-end:url(#arrow)"/>
+		<rect x="0" y="0" width="1" height="1" fill="none"/>
+	</g>
+	<polyline points="5,3 20,3 35,3" fill="none" marker-mid="url(#dot)"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(markerSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	icon.Draw(NewDasher(40, 20, NewScannerGV(40, 20, img, img.Bounds())), 1)
+	black := color.RGBA{0, 0, 0, 255}
+	for _, tc := range []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"end arrow", 29, 8, black},
+		{"end arrow", 29, 11, black},
+		{"beyond end arrow", 36, 10, color.RGBA{}},
+		{"reversed start arrow", 10, 8, black},
+		{"reversed start arrow", 10, 11, black},
+		{"behind end arrow", 24, 7, color.RGBA{}},
+		{"no start dot", 5, 2, color.RGBA{}},
+		{"no end dot", 34, 2, color.RGBA{}},
+		{"no rect marker", 1, 1, color.RGBA{}},
+	} {
+		if c := img.RGBAAt(tc.x, tc.y); c != tc.want {
+			t.Error(tc.name, "at", tc.x, tc.y, "want", tc.want, "got", c)
+		}
+	}
+	for _, p := range []image.Point{{19, 2}, {20, 2}, {19, 3}, {20, 3}} {
+		if c := img.RGBAAt(p.X, p.Y); c.R < 150 || c.R != c.A || c.G != 0 {
+			t.Error("mid dot should be drawn at", p, c)
+		}
+	}
+
+	missing := strings.Replace(markerSVG, `marker-mid="url(#dot)"`, `marker-mid="url(#nothing)"`, 1)
+	if _, err := ReadIconStream(strings.NewReader(missing), StrictErrorMode); err == nil {
+		t.Error("missing marker should be reported")
+	}
+}
+
+// unknownScanner hides the type of the scanner it wraps, so that paths are
+// drawn with it without being culled.
+type unknownScanner struct {
+	Scanner
+}
+
+func TestCulling(t *testing.T) {
+	const edgesSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+	<path d="M-20 10L2 20L-20 30" fill="none" stroke="black" stroke-width="6" stroke-linejoin="miter" stroke-miterlimit="10"/>
+	<path d="M120 10L98 20L120 30" fill="none" stroke="blue" stroke-width="6" stroke-linejoin="miter" stroke-miterlimit="10"/>
+	<circle cx="50" cy="-3" r="4" fill="red"/>
+	<rect x="40" y="101" width="20" height="20" fill="none" stroke="green" stroke-width="4"/>
+	<circle cx="300" cy="300" r="4" fill="red"/>
+	</svg>`
+	beach, err := ReadIcon("testdata/landscapeIcons/beach.svg", StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edges, err := ReadIconStream(strings.NewReader(edgesSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name       string
+		icon       *SvgIcon
+		x, y, w, h float64
+	}{
+		{"edges", edges, 0, 0, 64, 64},
+		{"zoomed", beach, -300, -150, 512, 512},
+		{"zoomed corner", beach, -450, -450, 512, 512},
+	} {
+		tc.icon.SetTarget(tc.x, tc.y, tc.w, tc.h)
+		culled := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		tc.icon.Draw(NewDasher(64, 64, NewScannerGV(64, 64, culled, culled.Bounds())), 1)
+		all := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		tc.icon.Draw(NewDasher(64, 64, unknownScanner{NewScannerGV(64, 64, all, all.Bounds())}), 1)
+		if !bytes.Equal(culled.Pix, all.Pix) {
+			t.Error(tc.name, "culling should not change what is drawn")
+		}
+		span := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		tc.icon.Draw(NewDasher(64, 64, NewScannerSpan(64, 64, span, nil)), 1)
+		spanAll := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		tc.icon.Draw(NewDasher(64, 64, unknownScanner{NewScannerSpan(64, 64, spanAll, nil)}), 1)
+		if !bytes.Equal(span.Pix, spanAll.Pix) {
+			t.Error(tc.name, "culling with a span scanner should not change what is drawn")
+		}
+	}
+
+	// A target rectangle away from the origin, as for tiles, still draws there
+	tile, err := ReadIconStream(strings.NewReader(`<svg viewBox="0 0 200 200"><rect x="150" y="150" width="40" height="40" fill="red"/>
+		<rect x="10" y="10" width="40" height="40" fill="blue"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile.SetTarget(0, 0, 200, 200)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	tile.Draw(NewDasher(200, 200, NewScannerGV(200, 200, img, image.Rect(100, 100, 200, 200))), 1)
+	all := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	tile.Draw(NewDasher(200, 200, unknownScanner{NewScannerGV(200, 200, all, image.Rect(100, 100, 200, 200))}), 1)
+	if img.RGBAAt(170, 170) != (color.RGBA{0xFF, 0, 0, 0xFF}) || !bytes.Equal(img.Pix, all.Pix) {
+		t.Error("culling with an offset target should not change what is drawn")
+	}
+}
+
+func TestSpatialIndex(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1000 1000">`)
+	for i := 0; i < 2500; i++ {
+		x, y := (i%50)*20, (i/50)*20
+		if i%7 == 0 {
+			fmt.Fprintf(&sb, `<circle cx="%d" cy="%d" r="6" fill="none" stroke="red" stroke-width="3"/>`, x+10, y+10)
+			continue
+		}
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="12" height="12" transform="rotate(%d %d %d)"/>`, x, y, i%90, x+6, y+6)
+	}
+	sb.WriteString(`</svg>`)
+	icon, err := ReadIconStream(strings.NewReader(sb.String()), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := icon.SpatialIndex()
+	if index.Len() != len(icon.SVGPaths) {
+		t.Fatal("every path should be indexed", index.Len())
+	}
+	brute := func(r ViewBox) []int {
+		var found []int
+		for i := 0; i < index.Len(); i++ {
+			b := index.Bounds(i)
+			if b.X <= r.X+r.W && r.X <= b.X+b.W && b.Y <= r.Y+r.H && r.Y <= b.Y+b.H {
+				found = append(found, i)
+			}
+		}
+		return found
+	}
+	for _, r := range []ViewBox{{0, 0, 1000, 1000}, {103, 207, 55, 31}, {-50, -50, 60, 60}, {990, 500, 100, 1}, {2000, 0, 5, 5}} {
+		if got, want := index.Search(r), brute(r); !reflect.DeepEqual(got, want) {
+			t.Error("search", r, "found", len(got), "paths, want", len(want))
+		}
+	}
+	// The rect at 206, 206 is the 10th of the 10th row
+	if hits := index.At(206, 206); len(hits) == 0 || hits[len(hits)-1] != 10*50+10 {
+		t.Error("point should hit the rect under it", hits)
+	}
+	if b := index.Bounds(0); b.X > 10-6-1.5 || b.X+b.W < 10+6+1.5 {
+		t.Error("bounds should include the stroke", b)
+	}
+
+	// Drawing only the visible paths draws the same as drawing all of them
+	icon.SetTarget(-1500, -700, 4000, 4000)
+	visible := index.Visible(icon.Transform, 64, 64)
+	if len(visible) == 0 || len(visible) > 50 {
+		t.Error("a small part of the icon should be visible", len(visible))
+	}
+	all := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	icon.Draw(NewDasher(64, 64, NewScannerGV(64, 64, all, all.Bounds())), 1)
+	some := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	r := NewDasher(64, 64, NewScannerGV(64, 64, some, some.Bounds()))
+	for _, i := range visible {
+		icon.SVGPaths[i].DrawTransformed(r, 1, icon.Transform)
+	}
+	if !bytes.Equal(all.Pix, some.Pix) {
+		t.Error("visible paths should draw the whole image")
+	}
+
+	if empty := (&SvgIcon{}).SpatialIndex(); len(empty.Search(ViewBox{0, 0, 10, 10})) != 0 {
+		t.Error("empty index should find nothing")
+	}
+}
+
+func TestPages(t *testing.T) {
+	pixel := func(icon *SvgIcon, x, y int) color.NRGBA {
+		img, err := icon.Rasterize(20, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	}
+	red, blue, green := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 128, 0, 255}
+
+	inkscape := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"
+		xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd" width="10mm" height="10mm" viewBox="0 0 10 10">
+	<sodipodi:namedview id="view">
+		<inkscape:page x="0" y="0" width="10" height="10" id="p1" inkscape:label="Cover"/>
+		<inkscape:page x="20" y="0" width="10" height="10" id="p2"/>
+	</sodipodi:namedview>
+	<rect width="10" height="10" fill="red"/>
+	<rect x="20" width="10" height="10" fill="blue"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(inkscape), IgnoreErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := icon.Pages()
+	if len(pages) != 2 || pages[0].ID != "p1" || pages[0].Label != "Cover" || pages[1].ID != "p2" {
+		t.Fatal("pages", pages)
+	}
+	if got := pixel(pages[0].Icon, 10, 10); got != red {
+		t.Error("first page", got)
+	}
+	if got := pixel(pages[1].Icon, 10, 10); got != blue {
+		t.Error("second page", got)
+	}
+	if w, h, _ := pages[1].Icon.PhysicalSize(); w != 10 || h != 10 {
+		t.Error("physical size of page", w, h)
+	}
+
+	pageSet := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+	<rect width="10" height="2" fill="green"/>
+	<pageSet>
+		<page id="one"><rect y="5" width="10" height="5" fill="red"/></page>
+		<page id="two"><rect y="5" width="10" height="5" fill="blue"/></page>
+	</pageSet>
+	</svg>`
+	icon, err = ReadIconStream(strings.NewReader(pageSet), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages = icon.Pages()
+	if len(pages) != 2 || pages[0].ID != "one" || pages[1].ID != "two" {
+		t.Fatal("pages", pages)
+	}
+	for i, want := range []color.NRGBA{red, blue} {
+		if got := pixel(pages[i].Icon, 10, 15); got != want {
+			t.Error("page", i, got, "want", want)
+		}
+		if got := pixel(pages[i].Icon, 10, 1); got != green {
+			t.Error("elements outside of the pageSet should be on page", i, got)
+		}
+	}
+	if got := pixel(icon, 10, 15); got != blue {
+		t.Error("the whole icon should draw every page", got)
+	}
+
+	icon, err = ReadIconStream(strings.NewReader(`<svg viewBox="0 0 10 10"><rect width="10" height="10" fill="red"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages = icon.Pages(); len(pages) != 1 || pixel(pages[0].Icon, 10, 10) != red {
+		t.Error("an icon without pages should have one page", pages)
+	}
+}
+
+func TestTessellate(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 32 32">
+	<linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient>
+	<path d="M16 2 L24 28 L3 11 H29 L8 28 Z" fill-rule="evenodd" fill="url(#g)"/>
+	<path d="M2 2 H12 V12 H2 Z M4 4 V10 H10 V4 Z" fill="green"/>
+	<circle cx="24" cy="8" r="5" fill="none" stroke="black" stroke-width="2" stroke-dasharray="3 2" transform="rotate(10 24 8)"/>
+	</svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const scale = 4
+	meshes := icon.Tessellate(TessellateOptions{Scale: scale})
+	if len(meshes) != 3 {
+		t.Fatal("meshes", len(meshes))
+	}
+	if m := meshes[0]; m.Path != 0 || m.Stroke || m.Paint.Gradient == nil || m.Paint.Color != nil {
+		t.Error("the star should be filled with the gradient", m.Paint)
+	}
+	if m := meshes[1]; m.Path != 1 || m.Paint.Color != (color.NRGBA{0, 128, 0, 255}) || m.Paint.Opacity != 1 {
+		t.Error("the square should be green", m.Paint)
+	}
+	if m := meshes[2]; m.Path != 2 || !m.Stroke {
+		t.Error("the circle should be stroked", m.Path, m.Stroke)
+	}
+
+	// Every pixel inside a path is covered by one triangle of its mesh, and no
+	// pixel outside of it is
+	cross := func(ax, ay, bx, by, px, py float32) float32 { return (bx-ax)*(py-ay) - (by-ay)*(px-ax) }
+	// covers returns the number of triangles of m with the point inside, and
+	// with the point inside or on their edges
+	covers := func(m Mesh, px, py float32) (in, on int) {
+		for i := 0; i < len(m.Indices); i += 3 {
+			a, b, c := m.Indices[i], m.Indices[i+1], m.Indices[i+2]
+			ax, ay := m.Vertices[2*a], m.Vertices[2*a+1]
+			bx, by := m.Vertices[2*b], m.Vertices[2*b+1]
+			cx, cy := m.Vertices[2*c], m.Vertices[2*c+1]
+			if cross(ax, ay, bx, by, cx, cy) <= 0 {
+				t.Fatal("triangles should be clockwise", ax, ay, bx, by, cx, cy)
+			}
+			d0, d1, d2 := cross(ax, ay, bx, by, px, py), cross(bx, by, cx, cy, px, py), cross(cx, cy, ax, ay, px, py)
+			if d0 > 0 && d1 > 0 && d2 > 0 {
+				in++
+			}
+			if d0 >= 0 && d1 >= 0 && d2 >= 0 {
+				on++
+			}
+		}
+		return in, on
+	}
+	for i, m := range meshes {
+		path := *icon
+		path.SVGPaths = icon.SVGPaths[i : i+1]
+		img, err := path.Rasterize(32*scale, 32*scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrong := 0
+		for y := 0; y < 32*scale; y++ {
+			for x := 0; x < 32*scale; x++ {
+				a := img.RGBAAt(x, y).A
+				in, on := covers(m, (float32(x)+0.5)/scale, (float32(y)+0.5)/scale)
+				if in > 1 || (a > 250 && on == 0) || (a < 5 && in > 0) {
+					wrong++
+				}
+			}
+		}
+		if wrong > 0 {
+			t.Error("mesh", i, "differs from the path in", wrong, "pixels")
+		}
+	}
+}
+
+func TestPaintValues(t *testing.T) {
+	red, none, black := color.RGBA{255, 0, 0, 255}, color.RGBA{}, color.RGBA{0, 0, 0, 255}
+	for _, tc := range []struct {
+		svg  string
+		want color.RGBA
+	}{
+		{`<g fill="none"><rect width="10" height="10"/></g>`, none},
+		{`<g fill="red"><rect fill="" width="10" height="10"/></g>`, red},
+		{`<g fill="none"><rect fill="inherit" width="10" height="10"/></g>`, none},
+		{`<g fill="red"><rect fill="blue" style="fill:inherit" width="10" height="10"/></g>`, red},
+		{`<g fill="none"><rect style="fill:unset" width="10" height="10"/></g>`, none},
+		{`<g fill="red"><rect fill="initial" width="10" height="10"/></g>`, black},
+		{`<g stroke="red"><rect stroke="initial" stroke-width="4" width="10" height="10" fill="none"/></g>`, none},
+		{`<g stroke="red"><rect stroke="inherit" stroke-width="20" width="10" height="10" fill="none"/></g>`, red},
+		{`<g color="red"><rect color="blue" style="color:inherit" fill="currentColor" width="10" height="10"/></g>`, red},
+		{`<rect fill="url(#missing) red" width="10" height="10"/>`, red},
+		{`<rect fill="url(#missing) none" width="10" height="10"/>`, none},
+		{`<rect color="red" fill="url(#missing) currentColor" width="10" height="10"/>`, red},
+		{`<linearGradient id="g"><stop stop-color="red"/></linearGradient><rect fill="url(#g) blue" width="10" height="10"/>`, red},
+		{`<pattern id="p" width="10" height="10" patternUnits="userSpaceOnUse"><rect width="10" height="10" fill="red"/></pattern>
+			<rect fill="url(#p) blue" width="10" height="10"/>`, red},
+	} {
+		icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">`+tc.svg+`</svg>`), StrictErrorMode)
+		if err != nil {
+			t.Error(tc.svg, err)
+			continue
+		}
+		img, err := icon.Rasterize(10, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBAAt(5, 5); got != tc.want {
+			t.Error(tc.svg, "drew", got, "want", tc.want)
+		}
+	}
+}
+
+func TestBlurHash(t *testing.T) {
+	read := func(svg string) *SvgIcon {
+		icon, err := ReadIconStream(strings.NewReader(svg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return icon
+	}
+	red := read(`<svg viewBox="0 0 20 10"><rect width="20" height="10" fill="red"/></svg>`)
+	hash, err := red.BlurHash(4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The size flag is 3 + 2*9, and the DC component is the average color, ff0000
+	if len(hash) != 1+1+4+2*11 || hash[0] != 'L' || hash[2:6] != "TI:j" {
+		t.Error("hash of red is", hash)
+	}
+
+	// Transparent parts are drawn on white, or on the background of the options
+	half := read(`<svg viewBox="0 0 10 10"><rect width="5" height="10" fill="blue"/></svg>`)
+	onWhite, err := half.BlurHash(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onBlack, err := half.BlurHash(2, 1, WithBackground(color.Black))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onWhite) != 4+2+2 || onWhite == onBlack {
+		t.Error("hashes on white and black", onWhite, onBlack)
+	}
+	// An AC component of 9*19*19 + 9*19 + 9, encoded as fQ, is zero in every channel
+	if onWhite[6:] == "fQ" {
+		t.Error("half blue hash should have a horizontal component", onWhite)
+	}
+
+	if _, err := red.BlurHash(0, 3); err == nil {
+		t.Error("zero components should be an error")
+	}
+	if _, err := (&SvgIcon{}).BlurHash(4, 3); err == nil {
+		t.Error("an empty icon should be an error")
+	}
+}
+
+func TestDashArray(t *testing.T) {
+	for _, tc := range []struct {
+		style string
+		want  []float64
+	}{
+		{`stroke-dasharray="4,2"`, []float64{4, 2}},
+		{`stroke-dasharray="4 2"`, []float64{4, 2}},
+		{"stroke-dasharray=\"4,\t2\n\"", []float64{4, 2}},
+		{`stroke-dasharray="5"`, []float64{5, 5}},
+		{`stroke-dasharray="1 2 3"`, []float64{1, 2, 3, 1, 2, 3}},
+		{`stroke-dasharray="10% 1mm"`, []float64{50 / math.Sqrt2 / 10, 96 / 25.4}},
+		{`stroke-dasharray="0 0"`, nil},
+		{`class="solid"`, nil},
+		{`style="stroke-dasharray:none"`, nil},
+		{`stroke-dasharray="1" style="stroke-dasharray:inherit"`, []float64{3, 1}},
+	} {
+		icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 30 40">
+			<style>.solid { stroke-dasharray: none }</style>
+			<g stroke-dasharray="3 1"><path d="M0 0H30" stroke="black" `+tc.style+`/></g></svg>`), StrictErrorMode)
+		if err != nil {
+			t.Error(tc.style, err)
+			continue
+		}
+		got := icon.SVGPaths[0].StrokeStyle().Dash
+		if len(got) != len(tc.want) {
+			t.Error(tc.style, "dashes", got, "want", tc.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Error(tc.style, "dashes", got, "want", tc.want)
+				break
+			}
+		}
+	}
+
+	negative := `<svg viewBox="0 0 10 10"><g stroke-dasharray="3 1"><path d="M0 0H10" stroke="black" stroke-dasharray="2 -1"/></g></svg>`
+	if _, err := ReadIconStream(strings.NewReader(negative), StrictErrorMode); err == nil {
+		t.Error("a negative dash should be an error")
+	}
+	icon, err := ReadIconStream(strings.NewReader(negative), IgnoreErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := icon.SVGPaths[0].StrokeStyle().Dash; len(got) != 2 || got[0] != 3 || len(icon.Diagnostics) != 1 {
+		t.Error("a negative dash array should be ignored", got, icon.Diagnostics)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<linearGradient id="g"><stop offset="0" stop-color="#00f"/><stop offset="1" stop-color="#ff0"/></linearGradient>
+	<rect width="20" height="10" fill="red"/>
+	<rect y="10" width="10" height="10" fill="url(#g)"/>
+	<rect x="10" y="10" width="10" height="10" fill="#f00" fill-opacity="0.5"/>
+	<rect x="12" y="12" width="4" height="4" fill="#f40"/>
+	</svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	palette := icon.Palette(10)
+	// Red paints 200 + 100/2, blue and yellow 50 each and orange 16, of 366
+	want := []PaletteColor{
+		{color.NRGBA{255, 0, 0, 255}, 250.0 / 366},
+		{color.NRGBA{0, 0, 255, 255}, 50.0 / 366},
+		{color.NRGBA{255, 255, 0, 255}, 50.0 / 366},
+		{color.NRGBA{255, 68, 0, 255}, 16.0 / 366},
+	}
+	if len(palette) != len(want) {
+		t.Fatal("palette", palette)
+	}
+	for i, c := range palette {
+		if c.Color != want[i].Color || math.Abs(c.Weight-want[i].Weight) > 1e-3 {
+			t.Error("color", i, c, "want", want[i])
+		}
+	}
+	// Orange is merged into red, the closest color
+	palette = icon.Palette(3)
+	if len(palette) != 3 || math.Abs(palette[0].Weight-266.0/366) > 1e-3 || palette[0].Color.G == 0 || palette[0].Color.R != 255 {
+		t.Error("orange should merge into red", palette)
+	}
+	if icon.Palette(0) != nil {
+		t.Error("no colors should be nil")
+	}
+}
+
+// errStreamLimit is returned by a streamHandler given more paths than its limit.
+var errStreamLimit = fmt.Errorf("too many paths")
+
+// streamHandler collects what ParseSVG reads into an icon.
+type streamHandler struct {
+	icon   *SvgIcon
+	starts int
+	limit  int // the most paths accepted, if not zero
+}
+
+func (h *streamHandler) Start(icon *SvgIcon) error {
+	cp := *icon // ParseSVG keeps reading into icon
+	h.icon = &cp
+	h.starts++
+	return nil
+}
+
+func (h *streamHandler) Path(path SvgPath) error {
+	if h.limit > 0 && len(h.icon.SVGPaths) == h.limit {
+		return errStreamLimit
+	}
+	h.icon.SVGPaths = append(h.icon.SVGPaths, path)
+	return nil
+}
+
+func TestParseSVG(t *testing.T) {
+	const doc = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 40 40">
+<defs>
+  <linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient>
+  <clipPath id="c"><circle cx="20" cy="20" r="15"/></clipPath>
+  <pattern id="p" width="4" height="4" patternUnits="userSpaceOnUse"><rect width="2" height="2" fill="green"/></pattern>
+  <rect id="r" width="6" height="6"/>
+</defs>
+<g transform="translate(2 3)" fill="orange">
+  <rect width="10" height="10"/>
+  <use xlink:href="#r" x="20" fill="purple"/>
+</g>
+<rect x="5" y="20" width="30" height="15" fill="url(#g)" clip-path="url(#c)"/>
+<circle cx="30" cy="10" r="6" fill="url(#p)" stroke="black" transform="rotate(10)"/>
+</svg>`
+	want, err := ReadIconStream(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h streamHandler
+	if err := ParseSVG(strings.NewReader(doc), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.starts != 1 || h.icon.ViewBox != want.ViewBox {
+		t.Fatalf("Start called %d times with ViewBox %v, want once with %v", h.starts, h.icon.ViewBox, want.ViewBox)
+	}
+	if len(h.icon.SVGPaths) != len(want.SVGPaths) {
+		t.Fatalf("got %d paths, want %d", len(h.icon.SVGPaths), len(want.SVGPaths))
+	}
+	for i := range want.SVGPaths {
+		got, w := &h.icon.SVGPaths[i], &want.SVGPaths[i]
+		if got.Transform() != w.Transform() || !reflect.DeepEqual(got.Path, w.Path) || got.GetFillColor() != w.GetFillColor() {
+			t.Errorf("path %d differs from ReadIconStream", i)
+		}
+	}
+	got, err := h.icon.Rasterize(40, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := want.Rasterize(40, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Pix, img.Pix) {
+		t.Error("streamed paths draw differently from ReadIconStream")
+	}
+
+	h = streamHandler{limit: 1}
+	if err := ParseSVG(strings.NewReader(doc), &h); err == nil || err != errStreamLimit {
+		t.Errorf("got error %v, want the error of the handler", err)
+	}
+	h = streamHandler{}
+	if err := ParseSVG(strings.NewReader(`<svg viewBox="0 0 5 5"/>`), &h); err != nil || h.starts != 1 || len(h.icon.SVGPaths) != 0 {
+		t.Errorf("empty document: got error %v, %d starts and %d paths", err, h.starts, len(h.icon.SVGPaths))
+	}
+}
+
+func TestStrokeOnlyUnfilled(t *testing.T) {
+	for _, tc := range []struct {
+		name, body   string
+		spec, compat bool // whether the shape is filled by default and with StrokeOnlyUnfilled
+	}{
+		{"stroke only", `<rect width="10" height="10" stroke="red"/>`, true, false},
+		{"neither", `<rect width="10" height="10"/>`, true, true},
+		{"fill none", `<rect width="10" height="10" stroke="red" fill="none"/>`, false, false},
+		{"fill set", `<rect width="10" height="10" stroke="red" fill="blue"/>`, true, true},
+		{"fill on ancestor", `<g fill="blue"><rect width="10" height="10" stroke="red"/></g>`, true, true},
+		{"stroke on ancestor", `<g stroke="red"><rect width="10" height="10"/></g>`, true, false},
+		{"fill in style sheet", `<style>.f { fill: blue }</style><rect class="f" width="10" height="10" stroke="red"/>`, true, true},
+		{"fill in style attribute", `<rect width="10" height="10" style="stroke: red; fill: currentColor"/>`, true, true},
+		{"used", `<defs><rect id="r" width="10" height="10"/></defs><use href="#r" stroke="red"/>`, true, false},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">` + tc.body + `</svg>`
+		for _, compat := range []bool{false, true} {
+			icon, err := ReadIconStreamOptions(strings.NewReader(svg), ParseOptions{StrokeOnlyUnfilled: compat})
+			if err != nil {
+				t.Fatal(tc.name, err)
+			}
+			if len(icon.SVGPaths) != 1 {
+				t.Fatalf("%s: got %d paths", tc.name, len(icon.SVGPaths))
+			}
+			want := tc.spec
+			if compat {
+				want = tc.compat
+			}
+			img, err := icon.Rasterize(20, 20)
+			if err != nil {
+				t.Fatal(tc.name, err)
+			}
+			if filled := img.RGBAAt(5, 5).A != 0; filled != want {
+				t.Errorf("%s with StrokeOnlyUnfilled %v: filled is %v, want %v", tc.name, compat, filled, want)
+			}
+		}
+	}
+}
+
+func TestColorFallback(t *testing.T) {
+	const badColorSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 10">
+	<linearGradient id="g"><stop offset="0" stop-color="bogus"/><stop offset="1" stop-color="bogus"/></linearGradient>
+	<rect width="10" height="10" fill="#12"/>
+	<rect x="10" width="10" height="10" fill="url(#missing)"/>
+	<rect x="20" width="10" height="10" fill="url(#g)"/>
+	<rect x="30" width="10" height="10" fill="lime" stroke="notacolor"/>
+	</svg>`
+	magenta := color.NRGBA{255, 0, 255, 255}
+	icon, err := ReadIconStreamOptions(strings.NewReader(badColorSVG),
+		ParseOptions{ErrorPolicy: IgnoreErrorMode.Policy(), ColorFallback: magenta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != 4 {
+		t.Fatal("every rect should be read", len(icon.SVGPaths))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	icon.Draw(NewDasher(40, 10, NewScannerGV(40, 10, img, img.Bounds())), 1)
+	for _, x := range []int{5, 15, 25} {
+		if c := img.RGBAAt(x, 5); c != (color.RGBA{255, 0, 255, 255}) {
+			t.Error("bad color should be painted with the fallback at", x, c)
+		}
+	}
+	if c := icon.SVGPaths[3].GetLineColor(); c != color.Color(magenta) {
+		t.Error("bad stroke should be the fallback", c)
+	}
+	if len(icon.Diagnostics) != 5 {
+		t.Error("each bad color should be recorded", icon.Diagnostics)
+	}
+	for _, d := range icon.Diagnostics {
+		if d.Pos.Line < 2 || d.Err == nil {
+			t.Error("diagnostic should have a position and error", d)
+		}
+	}
+
+	// Without a fallback, a bad fill fails the reading
+	if _, err := ReadIconStreamOptions(strings.NewReader(badColorSVG),
+		ParseOptions{ErrorPolicy: IgnoreErrorMode.Policy()}); err == nil {
+		t.Error("bad fill should fail without a fallback")
+	}
+	if _, err := ReadIconStreamOptions(strings.NewReader(badColorSVG),
+		ParseOptions{ErrorPolicy: StrictErrorMode.Policy(), ColorFallback: magenta}); err == nil {
+		t.Error("strict policy should still fail on bad colors")
+	}
+}
+
+func TestQualityProfiles(t *testing.T) {
+	icon, err := ReadIcon("testdata/landscapeIcons/beach.svg", StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	best, err := icon.Rasterize(128, 128, WithQuality(BestQuality))
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, _ := icon.Rasterize(128, 128)
+	if !bytes.Equal(def.Pix, best.Pix) {
+		t.Error("best quality should draw as the default does")
+	}
+	// The mean difference of the channels from the best rendering, out of 255
+	for q, limit := range map[Quality]float64{FastQuality: 2, BalancedQuality: 0.5} {
+		img, err := icon.Rasterize(128, 128, WithQuality(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		for i := range img.Pix {
+			sum += math.Abs(float64(img.Pix[i]) - float64(best.Pix[i]))
+		}
+		if mean := sum / float64(len(img.Pix)); mean > limit {
+			t.Error("quality", q, "differs from the best by", mean, "on average")
+		}
+	}
+	if b := FastQuality.Budget(); b.Tolerance <= BalancedQuality.Budget().Tolerance {
+		t.Error("fast quality should flatten more coarsely", b)
+	}
+}
+
+func TestRecolor(t *testing.T) {
+	const recolorSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 30 10">
+	<linearGradient id="g"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="red"/></linearGradient>
+	<mask id="m"><rect width="30" height="10" fill="white"/></mask>
+	<rect width="10" height="10" fill="red" mask="url(#m)"/>
+	<rect x="10" width="10" height="10" fill="url(#g)"/>
+	<rect x="20" width="10" height="10" fill="lime" fill-opacity="0.5"/>
+	</svg>`
+	read := func() *SvgIcon {
+		icon, err := ReadIconStream(strings.NewReader(recolorSVG), StrictErrorMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return icon
+	}
+	draw := func(icon *SvgIcon) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 30, 10))
+		icon.Draw(NewDasher(30, 10, NewScannerGV(30, 10, img, img.Bounds())), 1)
+		return img
+	}
+	blue, drawnBlue := color.NRGBA{0, 0, 255, 255}, color.RGBA{0, 0, 255, 255}
 
-	// Test error handling in parseClasses and parseAttrs
-	_, errSvg := ReadIcon("testdata/TestClasses_bad1.svg", WarnErrorMode)
-	if errSvg == nil {
-		t.Error("failed to catch class defs error")
+	icon := read()
+	icon.ReplaceColor(color.RGBA{255, 0, 0, 255}, blue)
+	img := draw(icon)
+	for _, x := range []int{5, 15} {
+		if c := img.RGBAAt(x, 5); c != drawnBlue {
+			t.Error("red should be replaced at", x, c)
+		}
 	}
-	_, errSvg = ReadIcon("testdata/TestClasses_bad2.svg", WarnErrorMode)
-	if errSvg == nil {
-		t.Error("failed to catch attribute format error")
+	if c := img.RGBAAt(25, 5); c.G == 0 || c.B != 0 {
+		t.Error("other colors should be kept", c)
+	}
+	if g, ok := icon.SVGPaths[1].FillGradient(); !ok || g.Stops[0].StopColor != color.Color(blue) {
+		t.Error("gradient stop should be replaced")
+	}
+	if g := icon.Grads["g"]; g.Stops[1].StopColor != color.Color(blue) {
+		t.Error("gradient definition should be replaced")
+	}
+	if fresh := read(); fresh.Grads["g"].Stops[0].StopColor != color.Color(color.NRGBA{255, 0, 0, 255}) {
+		t.Error("stops of other icons should not change")
+	}
+
+	icon = read()
+	icon.SetColor(blue)
+	img = draw(icon)
+	for _, x := range []int{5, 15} {
+		if c := img.RGBAAt(x, 5); c != drawnBlue {
+			t.Error("SetColor should paint every path at", x, c)
+		}
+	}
+	if c := img.RGBAAt(25, 5); c.B < 120 || c.B > 135 || c.A < 120 || c.A > 135 {
+		t.Error("SetColor should keep the fill opacity", c)
 	}
 
+	icon.SVGPaths[0].SetStrokeColor(blue)
+	if icon.SVGPaths[0].GetLineColor() != color.Color(blue) {
+		t.Error("SetStrokeColor should set the line color")
+	}
 }
 
-func TestHSL(t *testing.T) {
-	c, err := ParseSVGColor("hsl(198, 47%, 65%)")
+func TestReplaceElement(t *testing.T) {
+	const editedSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 30 10">
+	<linearGradient id="grad"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="red"/></linearGradient>
+	<g id="group" transform="translate(10 0)" fill="lime">
+		<rect id="inner" width="5" height="10"/>
+		<rect x="5" width="5" height="10" fill="red"/>
+	</g>
+	<rect id="last" width="10" height="10" fill="url(#grad)"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(editedSVG), StrictErrorMode)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
+	at := func(x int) color.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 30, 10))
+		icon.Draw(NewDasher(30, 10, NewScannerGV(30, 10, img, img.Bounds())), 1)
+		return img.RGBAAt(x, 5)
+	}
+	red, lime, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
 
-	rgb := c.(color.NRGBA)
-	if rgb.R != 124 || rgb.G != 183 || rgb.B != 208 || rgb.A != 255 {
-		t.Errorf("Invalid conversion: rgba(%d, %d, %d, %d)", rgb.R, rgb.G, rgb.B, rgb.A)
-		return
+	// The new rect inherits the transform and fill of the group
+	if err = icon.ReplaceElement("inner", `<rect id="inner" width="2" height="10"/><rect x="2" width="3" height="10" fill="blue"/>`); err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != 4 {
+		t.Fatal("expected 4 paths, got", len(icon.SVGPaths))
+	}
+	if c := at(11); c != lime {
+		t.Error("replaced element should inherit the style of its parent", c)
+	}
+	if c := at(13); c != blue {
+		t.Error("second element of the fragment not drawn", c)
+	}
+	if c := at(17); c != red {
+		t.Error("sibling of the replaced element should be kept", c)
+	}
+
+	// Replacing the group drops the elements within it
+	if err = icon.ReplaceElement("group", `<rect id="group" x="20" width="10" height="10" fill="blue"/>`); err != nil {
+		t.Fatal(err)
+	}
+	if err = icon.ReplaceElement("inner", `<rect/>`); err == nil {
+		t.Error("elements of a replaced group should no longer be found")
+	}
+	if c := at(25); c != blue || len(icon.SVGPaths) != 2 {
+		t.Error("group not replaced", c, len(icon.SVGPaths))
+	}
+
+	// A replaced definition is used by the elements read afterwards
+	if err = icon.ReplaceElement("grad", `<linearGradient id="grad"><stop offset="0" stop-color="blue"/><stop offset="1" stop-color="blue"/></linearGradient>`); err != nil {
+		t.Fatal(err)
+	}
+	if c := at(5); c != red {
+		t.Error("paths drawn with a replaced definition should keep it", c)
+	}
+	if err = icon.ReplaceElement("last", `<rect id="last" width="10" height="10" fill="url(#grad)"/>`); err != nil {
+		t.Fatal(err)
+	}
+	if c := at(5); c != blue {
+		t.Error("replaced element should use the replaced definition", c)
+	}
+
+	if err = icon.ReplaceElement("last", `<rect id="last" fill="lime"`); err == nil {
+		t.Error("expected an error for a malformed fragment")
+	}
+	if c := at(5); c != blue || len(icon.SVGPaths) != 2 {
+		t.Error("a malformed fragment should not change the icon", c, len(icon.SVGPaths))
+	}
+	if err = icon.ReplaceElement("missing", `<rect/>`); err == nil {
+		t.Error("expected an error for a missing id")
+	}
+
+	// The texts of a replaced element are those of the fragment
+	icon, err = ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+		<title>Root</title><g id="a"><title>A</title><rect id="b" width="5" height="5"><desc>B</desc></rect></g>
+		<rect id="c" width="5" height="5"><title>C</title></rect></svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = icon.ReplaceElement("a", `<g id="a"><title>A</title><rect width="5" height="5"/></g>`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = icon.ReplaceElement("c", `<rect id="c" width="5" height="5"><title>D</title></rect>`); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(icon.Titles, []string{"Root", "A", "D"}) || len(icon.Descriptions) != 0 {
+		t.Errorf("texts after replacing are %q and %q", icon.Titles, icon.Descriptions)
+	}
+	if icon.TitleFor("a") != "A" || icon.DescriptionFor("b") != "" || icon.TitleFor("c") != "D" {
+		t.Error("texts by id not replaced", icon.TitleFor("a"), icon.DescriptionFor("b"), icon.TitleFor("c"))
+	}
+}
+
+func TestPathToSVG(t *testing.T) {
+	read := func(d string) SvgPath {
+		icon, err := ReadIconStream(strings.NewReader(`<svg viewBox="0 0 40 40"><path d="`+d+`"/></svg>`), StrictErrorMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(icon.SVGPaths) != 1 {
+			t.Fatalf("%q: got %d paths", d, len(icon.SVGPaths))
+		}
+		return icon.SVGPaths[0]
+	}
+	svgp := read("m1 2.5 h3 v-1 z")
+	if got, want := svgp.String(), "M 1 2.5 L 4 2.5 L 4 1.5 Z"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, d := range []string{
+		"M1 2L3 4Q5 6 7 8C9 10 11 12 13 14Z",
+		"M5 5A10 6 30 0 1 25 20a5 5 0 1 0-10 0",
+		"M0 0S10 0 10 10T20 20M30 30H35V35Z",
+	} {
+		svgp := read(d)
+		if back := read(PathToSVG(svgp.Path)); !reflect.DeepEqual(back.Path, svgp.Path) {
+			t.Errorf("%q: %q reads back as %q", d, svgp.String(), back.String())
+		}
+	}
+	if got := PathToSVG(nil); got != "" {
+		t.Errorf("got %q for an empty path", got)
+	}
+}
+
+func TestMarshalSVG(t *testing.T) {
+	const richSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40" width="10mm" height="10mm">
+	<title>Rich &amp; round</title>
+	<defs>
+		<linearGradient id="lg" x1="0" x2="1" spreadMethod="reflect"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue" stop-opacity="0.5"/></linearGradient>
+		<radialGradient id="rg" gradientUnits="userSpaceOnUse" cx="30" cy="10" r="8" gradientTransform="rotate(10)"><stop offset="0" stop-color="yellow"/><stop offset="1" stop-color="green"/></radialGradient>
+		<clipPath id="cp"><circle cx="10" cy="30" r="8"/></clipPath>
+		<mask id="mk"><rect x="20" y="20" width="10" height="20" fill="white"/></mask>
+		<pattern id="pt" width="4" height="4" patternUnits="userSpaceOnUse"><rect width="2" height="2" fill="purple"/></pattern>
+	</defs>
+	<rect width="20" height="20" fill="url(#lg)" stroke="black" stroke-width="2" stroke-dasharray="3 1" stroke-linejoin="round"/>
+	<g transform="translate(1 1) scale(0.9)"><circle cx="30" cy="10" r="8" fill="url(#rg)" fill-opacity="0.8"/></g>
+	<rect y="20" width="20" height="20" fill="orange" clip-path="url(#cp)"/>
+	<path d="M20 20 H40 V40 H20 Z M24 24 H36 V36 H24 Z" fill-rule="evenodd" fill="url(#pt)" mask="url(#mk)"/>
+	<path d="M2 38 Q10 22 18 38" fill="none" stroke="teal" stroke-linecap="round" stroke-width="3"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(richSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = icon.MarshalSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadIconStream(bytes.NewReader(buf.Bytes()), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if again.ViewBox != icon.ViewBox || len(again.SVGPaths) != len(icon.SVGPaths) {
+		t.Fatal("view box or paths not kept", again.ViewBox, len(again.SVGPaths))
+	}
+	if w, h, ok := again.PhysicalSize(); !ok || math.Abs(w-10) > 1e-9 || math.Abs(h-10) > 1e-9 {
+		t.Error("physical size not kept", w, h)
+	}
+	if len(again.Titles) != 1 || again.Titles[0] != "Rich & round" {
+		t.Error("title not kept", again.Titles)
+	}
+	render := func(icon *SvgIcon) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+		icon.SetTarget(0, 0, 80, 80)
+		icon.Draw(NewDasher(80, 80, NewScannerGV(80, 80, img, img.Bounds())), 1)
+		return img
+	}
+	want, got := render(icon), render(again)
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 80; x++ {
+			w, g := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA), color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			if !nearColor(w, g, 2) {
+				t.Fatalf("pixel %d,%d differs after a round trip: %v, want %v\n%s", x, y, g, w, buf.String())
+			}
+		}
+	}
+
+	// The coordinates of icons with small view boxes are scaled while reading
+	tiny, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1 1">
+	<linearGradient id="g" gradientUnits="userSpaceOnUse" x2="1"><stop offset="0" stop-color="red"/><stop offset="1" stop-color="blue"/></linearGradient>
+	<rect width="0.5" height="0.5" fill="url(#g)"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = tiny.MarshalSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `d="M 0 0 L 0.5 0 L 0.5 0.5 L 0 0.5 Z"`) {
+		t.Error("path data should be in user units", buf.String())
+	}
+	tinyAgain, err := ReadIconStream(bytes.NewReader(buf.Bytes()), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, got = render(tiny), render(tinyAgain)
+	if !reflect.DeepEqual(want.Pix, got.Pix) {
+		t.Error("scaled icon differs after a round trip", buf.String())
+	}
+}
+
+// chanWatcher is a Watcher driven by the test.
+type chanWatcher struct {
+	added   []string
+	changes chan string
+}
+
+func (w *chanWatcher) Add(name string) error  { w.added = append(w.added, name); return nil }
+func (w *chanWatcher) Changes() <-chan string { return w.changes }
+
+func TestWatchIcon(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "icon.svg")
+	write := func(body string) {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">` + body + `</svg>`
+		if err := os.WriteFile(name, []byte(svg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`<linearGradient id="g"><stop stop-color="red"/></linearGradient>
+	<g id="group"><rect id="a" width="5" height="5"/><rect id="b" x="5" width="5" height="5"/></g>`)
+	w := &chanWatcher{changes: make(chan string)}
+	updates := make(chan IconUpdate)
+	done := make(chan error)
+	go func() {
+		done <- WatchIcon(context.Background(), w, name, ParseOptions{}, func(u IconUpdate) { updates <- u })
+	}()
+	u := <-updates
+	if u.Err != nil || u.Icon == nil || len(u.Icon.SVGPaths) != 2 {
+		t.Fatal("first update wrong", u)
+	}
+	if want := []string{"a", "b", "g", "group"}; !reflect.DeepEqual(u.Changed, want) {
+		t.Error("first update should hold every id", u.Changed)
+	}
+
+	// Moving an element down a line changes nothing it draws
+	write(`
+	<linearGradient id="g"><stop stop-color="red"/></linearGradient>
+	<g id="group"><rect id="a" width="5" height="5"/><rect id="b" x="5" width="5" height="5" fill="blue"/><rect id="c"/></g>`)
+	w.changes <- name
+	u = <-updates
+	if want := []string{"b", "c", "group"}; u.Err != nil || !reflect.DeepEqual(u.Changed, want) {
+		t.Error("expected the changed element, the added one and their group", u.Changed, u.Err)
+	}
+
+	write(`<g id="group"`)
+	w.changes <- name
+	if u = <-updates; u.Err == nil || u.Icon != nil {
+		t.Error("expected an error for a malformed file", u)
+	}
+	write(`<g id="group"><rect id="a" width="5" height="5"/><rect id="b" x="5" width="5" height="5" fill="blue"/><rect id="c"/></g>`)
+	w.changes <- "other.svg" // ignored
+	w.changes <- name
+	u = <-updates
+	if want := []string{"g"}; !reflect.DeepEqual(u.Changed, want) {
+		t.Error("changes should be from the last icon read", u.Changed)
+	}
+
+	close(w.changes)
+	if err := <-done; err != nil || !reflect.DeepEqual(w.added, []string{name}) {
+		t.Error("WatchIcon should end when the changes are closed", err, w.added)
+	}
+
+	p := NewPollWatcher(time.Millisecond)
+	defer p.Close()
+	if err := p.Add(name); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-p.Changes():
+		if got != name {
+			t.Error("PollWatcher reported the wrong file", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("PollWatcher did not report the change")
+	}
+	p.Close()
+	for range p.Changes() {
+	}
+}
+
+func TestTitleFor(t *testing.T) {
+	const titledSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<title>Icon</title>
+	<g id="group"><title>Group</title>
+		<rect id="box" width="10" height="10"><title>Box</title><desc>A square</desc></rect>
+		<circle cx="15" cy="15" r="5"><title>Anonymous</title><desc>Without an id</desc></circle>
+	</g>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(titledSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.Titles) != 4 || len(icon.Descriptions) != 2 {
+		t.Error("titles and descriptions not collected", icon.Titles, icon.Descriptions)
+	}
+	for id, want := range map[string]string{"": "Icon", "group": "Group", "box": "Box", "none": ""} {
+		if got := icon.TitleFor(id); got != want {
+			t.Errorf("title for %q: got %q, want %q", id, got, want)
+		}
+	}
+	if got := icon.DescriptionFor("box"); got != "A square" {
+		t.Error("wrong description for box", got)
+	}
+	if got := icon.DescriptionFor(""); got != "" {
+		t.Error("description of an element without an id given to the root", got)
+	}
+	icon.PrefixIDs("p_")
+	if icon.TitleFor("p_box") != "Box" || icon.TitleFor("box") != "" || icon.TitleFor("") != "Icon" {
+		t.Error("titles not renamed with ids")
+	}
+}
+
+func TestDefinitionMetadata(t *testing.T) {
+	const spriteSVG = "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 20 20\">\n" +
+		"<defs>\n" +
+		"  <linearGradient id=\"sky\"><title>Sky</title><stop offset=\"0\" stop-color=\"blue\"/></linearGradient>\n" +
+		"  <symbol id=\"star\"><title>Star</title><desc>From the shapes kit</desc>\n" +
+		"    <rect width=\"5\" height=\"5\"/></symbol>\n" +
+		"</defs>\n" +
+		"<use href=\"#star\"/>\n" +
+		"</svg>\n"
+	icon, err := ReadIconStream(strings.NewReader(spriteSVG), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != 1 {
+		t.Error("expected 1 path, got", len(icon.SVGPaths))
+	}
+	if icon.TitleFor("sky") != "Sky" || icon.TitleFor("star") != "Star" || icon.DescriptionFor("star") != "From the shapes kit" {
+		t.Error("texts of definitions not kept", icon.TitleFor("sky"), icon.TitleFor("star"), icon.DescriptionFor("star"))
+	}
+	for id, want := range map[string]SourcePos{"sky": {Line: 3, Column: 3}, "star": {Line: 4, Column: 3}} {
+		d, ok := icon.LookupDefinition(id)
+		if !ok {
+			t.Fatal("no definition", id)
+		}
+		var got SourcePos
+		switch d := d.(type) {
+		case GradientDef:
+			got = d.Source
+		case SymbolDef:
+			got = d.Source
+		}
+		if got.Line != want.Line || got.Column != want.Column {
+			t.Errorf("source of %s: got %v, want %v", id, got, want)
+		}
+	}
+	icon.PrefixIDs("p_")
+	if d, _ := icon.LookupDefinition("p_sky"); d == nil || d.(GradientDef).Source.Line != 3 {
+		t.Error("source of gradient not renamed with its id")
+	}
+}
+
+func TestSourcePos(t *testing.T) {
+	const posSVG = "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 20 20\">\n" +
+		"  <rect width=\"10\" height=\"10\"/>\n" +
+		"\t<g><circle cx=\"15\" cy=\"15\" r=\"5\"/></g>\n" +
+		"</svg>\n"
+	icon, err := ReadIconStream(strings.NewReader(posSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(icon.SVGPaths) != 2 {
+		t.Fatal("expected 2 paths, got", len(icon.SVGPaths))
+	}
+	rectOff := int64(strings.Index(posSVG, "<rect"))
+	if p := icon.SVGPaths[0].Source; p != (SourcePos{Offset: rectOff, Line: 2, Column: 3}) {
+		t.Error("wrong rect source position", p)
+	}
+	if p := icon.SVGPaths[1].Source; p.Line != 3 || p.Column != 5 || p.String() != "3:5" {
+		t.Error("wrong circle source position", p)
+	}
+}
+
+func TestRasterizeGlyph(t *testing.T) {
+	const glyphSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<rect x="0" y="0" width="10" height="20" fill="red" fill-opacity="0.6"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(glyphSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := RasterizeGlyph(icon, GlyphOptions{Size: 16, Descent: 4})
+	if g.Advance != 16 {
+		t.Error("wrong advance", g.Advance)
+	}
+	if g.Image.Bounds() != image.Rect(0, -12, 8, 4) {
+		t.Error("wrong ink box", g.Image.Bounds())
+	}
+	if w, h, xoff, yoff := g.BDFBox(); w != 8 || h != 16 || xoff != 0 || yoff != -4 {
+		t.Error("wrong BDF box", w, h, xoff, yoff)
+	}
+	rows := g.Rows()
+	if len(rows) != 16 || len(rows[0]) != 1 || rows[0][0] != 0xFF {
+		t.Error("wrong rows", rows)
+	}
+	if g = RasterizeGlyph(icon, GlyphOptions{Size: 16, Threshold: 0xF0}); !g.Image.Bounds().Empty() {
+		t.Error("translucent fill should be below threshold", g.Image.Bounds())
+	}
+}
+
+func TestGlyphOutline(t *testing.T) {
+	const outlineSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<rect x="0" y="0" width="10" height="20"/>
+	<circle cx="15" cy="10" r="4"/>
+	<path d="M0,0 L20,20" fill="none" stroke="black"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(outlineSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contours := GlyphOutline(icon, OutlineOptions{UnitsPerEm: 1000, Descent: 200})
+	if len(contours) != 2 {
+		t.Fatal("expected rect and circle contours, got", len(contours))
+	}
+	for _, p := range contours[0] {
+		if !p.OnCurve || (p.X != 0 && p.X != 500) || (p.Y != 800 && p.Y != -200) {
+			t.Error("wrong rect contour", contours[0])
+			break
+		}
+	}
+	var offCurve int
+	for _, p := range contours[1] {
+		if !p.OnCurve {
+			offCurve++
+			continue
+		}
+		if d := math.Hypot(p.X-750, p.Y-300); math.Abs(d-200) > 1 {
+			t.Error("circle contour point off the circle", p, d)
+		}
+	}
+	if offCurve == 0 || !contours[1][0].OnCurve {
+		t.Error("circle contour should be quadratic and start on the curve", contours[1])
+	}
+}
+
+func TestDither(t *testing.T) {
+	const graySVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 32 32">
+	<rect x="0" y="0" width="16" height="32" fill="#808080"/>
+	<rect x="16" y="0" width="8" height="32" fill="black"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(graySVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []DitherMode{DitherThreshold, DitherFloydSteinberg, DitherBayer} {
+		img := DrawMonochrome(icon, 32, 32, mode)
+		var gray int
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 16; x++ {
+				gray += int(img.ColorIndexAt(x, y))
+			}
+			if img.ColorIndexAt(20, y) != 1 || img.ColorIndexAt(28, y) != 0 {
+				t.Fatal("black and white areas should not be dithered, mode", mode)
+			}
+		}
+		if mode == DitherThreshold {
+			if gray != 0 {
+				t.Error("mid gray should threshold to white, got black pixels", gray)
+			}
+		} else if gray < 200 || gray > 312 {
+			t.Error("mid gray should dither to about half black, mode", mode, "black pixels", gray)
+		}
+	}
+}
+
+func TestChangeTracker(t *testing.T) {
+	const boxesSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">
+	<rect x="0" y="0" width="10" height="10" fill="red"/>
+	<rect x="20" y="20" width="10" height="10" fill="blue" stroke="black" stroke-width="2"/>
+	</svg>`
+	icon, err := ReadIconStream(strings.NewReader(boxesSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := NewChangeTracker(40, 40)
+	if r := ct.Update(icon); r != image.Rect(0, 0, 40, 40) {
+		t.Error("first update should cover the whole image", r)
+	}
+	if r := ct.Update(icon); !r.Empty() {
+		t.Error("unchanged icon should need no refresh", r)
+	}
+	if r := ct.PathBounds(1); r != image.Rect(19, 19, 31, 31) {
+		t.Error("stroked path bounds wrong", r)
+	}
+	icon.SVGPaths[1].SetFillColor(color.NRGBA{0, 255, 0, 255})
+	if r := ct.Update(icon); r != image.Rect(19, 19, 31, 31) {
+		t.Error("recolored path should need refresh of its bounds only", r)
+	}
+	icon.SVGPaths = icon.SVGPaths[:1]
+	if r := ct.Update(icon); r != image.Rect(19, 19, 31, 31) {
+		t.Error("removed path should need refresh of its old bounds", r)
+	}
+}
+
+func TestQuantizeGray(t *testing.T) {
+	src := image.NewUniform(color.Gray{0x80})
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+	q := QuantizeGray(img, 16, DitherThreshold)
+	if len(q.Palette) != 16 || q.ColorIndexAt(3, 3) != 8 {
+		t.Error("mid gray should quantize to level 8", q.ColorIndexAt(3, 3))
+	}
+	q = QuantizeGray(img, 16, DitherBayer)
+	levels := map[uint8]int{}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			levels[q.ColorIndexAt(x, y)]++
+		}
+	}
+	if len(levels) != 2 || levels[7] == 0 || levels[8] == 0 {
+		t.Error("ordered dither should mix neighbouring levels", levels)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	// Paths are in 26.6 fixed point, and arcs are approximated by curves
+	near := func(a, b float64) bool { return math.Abs(a-b) < 0.02 }
+	for _, tc := range []struct {
+		name, body             string
+		minX, minY, maxX, maxY float64
+	}{
+		{"rect", `<rect x="2" y="3" width="10" height="5"/>`, 2, 3, 12, 8},
+		{"circle", `<circle cx="20" cy="20" r="5"/>`, 15, 15, 25, 25},
+		{"quadratic", `<path d="M0 0Q10 20 20 0"/>`, 0, 0, 20, 10},
+		{"cubic", `<path d="M0 0C0 30 30 30 30 0"/>`, 0, 0, 30, 22.5},
+		{"cubic in both axes", `<path d="M10 0C30 0 30 20 10 20C-10 20 -10 0 10 0"/>`, -5, 0, 25, 20},
+		{"transformed", `<g transform="translate(10 10)"><rect width="10" height="10" transform="rotate(45)"/></g>`,
+			10 - 5*math.Sqrt2, 10, 10 + 5*math.Sqrt2, 10 + 10*math.Sqrt2},
+		{"scaled curve", `<path d="M0 0Q10 20 20 0" transform="scale(2 0.5)"/>`, 0, 0, 40, 5},
+	} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40">` + tc.body + `</svg>`
+		icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		x0, y0, x1, y1 := icon.SVGPaths[0].Bounds()
+		if !near(x0, tc.minX) || !near(y0, tc.minY) || !near(x1, tc.maxX) || !near(y1, tc.maxY) {
+			t.Errorf("%s: got bounds %v %v %v %v, want %v %v %v %v", tc.name, x0, y0, x1, y1, tc.minX, tc.minY, tc.maxX, tc.maxY)
+		}
+	}
+	icon, err := ReadIconStream(strings.NewReader(`<svg viewBox="0 0 40 40"><rect x="2" y="3" width="5" height="5"/>
+		<circle cx="30" cy="20" r="5"/></svg>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x0, y0, x1, y1 := icon.Bounds(); x0 != 2 || y0 != 3 || !near(x1, 35) || !near(y1, 25) {
+		t.Errorf("got icon bounds %v %v %v %v, want 2 3 35 25", x0, y0, x1, y1)
+	}
+	if x0, y0, x1, y1 := (&SvgIcon{}).Bounds(); x0 != 0 || y0 != 0 || x1 != 0 || y1 != 0 {
+		t.Errorf("got bounds %v %v %v %v for an empty icon", x0, y0, x1, y1)
+	}
+}
+
+func TestWriteTerminal(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 3))
+	for x := 0; x < 8; x++ {
+		img.Set(x, 0, color.NRGBA{255, 0, 0, 255})
+		img.Set(x, 1, color.NRGBA{0, 0, 255, 255})
+	}
+	var buf bytes.Buffer
+	if err := WriteTerminal(&buf, img, TerminalANSI); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); len(lines) != 2 ||
+		!strings.Contains(lines[0], "\x1b[38;2;255;0;0;48;2;0;0;255m\u2580") ||
+		strings.Contains(lines[1], "\u2580") {
+		t.Errorf("unexpected ANSI output %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteTerminal(&buf, img, TerminalKitty); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b_Ga=T,f=100,m=0;") {
+		t.Errorf("unexpected kitty output %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteTerminal(&buf, img, TerminalSixel); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "\x1bP0;1;0q\"1;1;8;3") || !strings.HasSuffix(out, "\x1b\\\n") {
+		t.Errorf("unexpected sixel framing %q", out)
+	}
+	// The red row is the first bit of the band, the blue row the second
+	if !strings.Contains(out, "!8@$") || !strings.Contains(out, "!8A$") {
+		t.Errorf("unexpected sixel data %q", out)
+	}
+}
+
+func TestContinueDash(t *testing.T) {
+	// Two 7 unit subpaths dashed 4 on, 4 off. Restarted, the second starts with a
+	// dash over 0 to 4; continued from 7, it has a gap to 1 and a dash to 5.
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<path d="M0,5 H7 M0,15 H7" fill="none" stroke="black" stroke-width="2" stroke-dasharray="4 4"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cont := range []bool{false, true} {
+		icon.SVGPaths[0].ContinueDash = cont
+		img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+		icon.Draw(NewDasher(20, 20, NewScannerGV(20, 20, img, img.Bounds())), 1)
+		if a := img.RGBAAt(2, 4).A; a != 0xFF {
+			t.Errorf("continue %v: first dash not drawn, alpha %d", cont, a)
+		}
+		start, end := img.RGBAAt(0, 14).A != 0, img.RGBAAt(4, 14).A != 0
+		if start == cont || end != cont {
+			t.Errorf("continue %v: second subpath dash at 0 %v, at 4 %v", cont, start, end)
+		}
+	}
+}
+
+func TestStrokeOpacityOverlap(t *testing.T) {
+	// Each stroke overlaps itself at the pixel; the whole stroke must be covered
+	// once and composited once, so the overlap is no darker than the rest.
+	for _, tc := range []struct {
+		d string
+		p image.Point
+	}{
+		{"M2,2 L18,18 L2,18", image.Point{17, 17}},
+		{"M2,2 L18,2 L18,18 L2,18 Z", image.Point{2, 2}},
+		{"M2,10 H18 M10,2 V18", image.Point{9, 9}},
+		{"M4,10 H16 H8", image.Point{10, 9}},
+		{"M2,10 C30,0 -10,0 18,10", image.Point{10, 6}},
+	} {
+		for _, join := range []string{"miter", "round", "bevel"} {
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20"><path d="` + tc.d +
+				`" fill="none" stroke="black" stroke-width="4" stroke-linecap="round" stroke-linejoin="` +
+				join + `" stroke-opacity="0.5"/></svg>`
+			icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gv := image.NewRGBA(image.Rect(0, 0, 20, 20))
+			icon.Draw(NewDasher(20, 20, NewScannerGV(20, 20, gv, gv.Bounds())), 1)
+			span := image.NewRGBA(image.Rect(0, 0, 20, 20))
+			icon.Draw(NewDasher(20, 20, NewScannerSpan(20, 20, span, nil)), 1)
+			for _, img := range []*image.RGBA{gv, span} {
+				var maxA uint8
+				for i := 3; i < len(img.Pix); i += 4 {
+					if img.Pix[i] > maxA {
+						maxA = img.Pix[i]
+					}
+				}
+				if a := img.RGBAAt(tc.p.X, tc.p.Y).A; a < 0x7F || maxA > 0x80 {
+					t.Errorf("%s %s: alpha at overlap %d, maximum %d", tc.d, join, a, maxA)
+				}
+			}
+		}
+	}
+}
+
+func TestDrawWithStroke(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 20">
+		<path d="M10,10 H30" fill="none" stroke="#000000"/></svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svgp := &icon.SVGPaths[0]
+	stroke := func(ss StrokeStyle) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		svgp.DrawWithStroke(NewDasher(40, 20, NewScannerGV(40, 20, img, img.Bounds())), 1, Identity, ss)
+		return img
+	}
+	wide := svgp.StrokeStyle()
+	wide.LineWidth, wide.LineCap, wide.LeadLineCap = 6, SquareCap, SquareCap
+	img := stroke(wide)
+	if img.RGBAAt(20, 12).A != 0xFF || img.RGBAAt(32, 10).A != 0xFF || img.RGBAAt(7, 10).A != 0xFF {
+		t.Error("stroke not drawn with the given width and caps", img.RGBAAt(20, 12), img.RGBAAt(32, 10), img.RGBAAt(7, 10))
+	}
+	if img.RGBAAt(20, 14).A != 0 {
+		t.Error("stroke wider than the given width", img.RGBAAt(20, 14))
+	}
+	// Without cap functions the ends are butt
+	img = stroke(StrokeStyle{LineWidth: 6, MiterLimit: 4})
+	if img.RGBAAt(20, 12).A != 0xFF || img.RGBAAt(31, 10).A != 0 || img.RGBAAt(8, 10).A != 0 {
+		t.Error("default caps not butt", img.RGBAAt(20, 12), img.RGBAAt(31, 10), img.RGBAAt(8, 10))
+	}
+	// A lead cap only caps the end of the path
+	lead := wide
+	lead.LeadLineCap, lead.LineCap = SquareCap, ButtCap
+	img = stroke(lead)
+	if img.RGBAAt(32, 10).A != 0xFF || img.RGBAAt(8, 10).A != 0 {
+		t.Error("lead cap not drawn at the end only", img.RGBAAt(32, 10), img.RGBAAt(8, 10))
+	}
+}
+
+func TestIsolateOpacity(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 20">
+	<rect x="4" y="4" width="12" height="12" fill="#FF0000" stroke="#0000FF" stroke-width="4" opacity="0.5"/></svg>`
+	icon, err := ReadIconStream(strings.NewReader(svg), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, isolate := range []bool{false, true} {
+		icon.IsolateOpacity = isolate
+		img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+		icon.Draw(NewDasher(20, 20, NewScannerGV(20, 20, img, img.Bounds())), 1)
+		// The inner half of the stroke overlaps the fill, which only shows
+		// through when the opacity is applied to the fill and stroke separately
+		over, fill := img.RGBAAt(4, 10), img.RGBAAt(10, 10)
+		if fill.R < 0x7E || fill.R > 0x80 {
+			t.Errorf("isolate %v: fill %v", isolate, fill)
+		}
+		if (over.R != 0) == isolate || over.B < 0x7E || over.B > 0x80 {
+			t.Errorf("isolate %v: stroke over fill %v", isolate, over)
+		}
+	}
+	// Zoomed far into the fill, the layer is only as large as the image
+	icon.SetTarget(-5e5, -5e5, 2e6, 2e6)
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	icon.Draw(NewDasher(20, 20, NewScannerGV(20, 20, img, img.Bounds())), 1)
+	if fill := img.RGBAAt(10, 10); fill.R < 0x7E || fill.R > 0x80 {
+		t.Errorf("zoomed fill %v", fill)
+	}
+}
+
+func TestScannerSpan(t *testing.T) {
+	for _, icon := range ReadIconSet("testdata/landscapeIcons/", []string{"beach", "iceberg", "village"}) {
+		w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+		gv := image.NewRGBA(image.Rect(0, 0, w, h))
+		icon.Draw(NewDasher(w, h, NewScannerGV(w, h, gv, gv.Bounds())), 1)
+		for _, filler := range []SpanFiller{nil, GenericSpanFiller} {
+			span := image.NewRGBA(image.Rect(0, 0, w, h))
+			icon.Draw(NewDasher(w, h, NewScannerSpan(w, h, span, filler)), 1)
+			var maxDiff int
+			for i := range gv.Pix {
+				d := int(gv.Pix[i]) - int(span.Pix[i])
+				if d < 0 {
+					d = -d
+				}
+				if d > maxDiff {
+					maxDiff = d
+				}
+			}
+			// Coverage is quantized to 8 bits before compositing, so overlapping
+			// anti-aliased edges may differ slightly
+			if maxDiff > 4 {
+				t.Error("span scanner output differs from ScannerGV by", maxDiff)
+			}
+		}
+	}
+}
+
+func TestScannerSpanExtent(t *testing.T) {
+	// Paths partly outside the image and the clip are rasterized over their
+	// visible extent only, which must not change their coverage
+	icon, err := ReadIcon("testdata/landscapeIcons/beach.svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, h := int(icon.ViewBox.W), int(icon.ViewBox.H)
+	icon.Transform = Identity.Translate(-float64(w)/2, -float64(h)/3).Scale(1.5, 1.5)
+	clip := image.Rect(w/4, h/4, w, h)
+	gv := image.NewRGBA(image.Rect(0, 0, w, h))
+	scannerGV := NewScannerGV(w, h, gv, gv.Bounds())
+	scannerGV.SetClip(clip)
+	icon.Draw(NewDasher(w, h, scannerGV), 1)
+	span := image.NewRGBA(image.Rect(0, 0, w, h))
+	scannerSpan := NewScannerSpan(w, h, span, nil)
+	scannerSpan.SetClip(clip)
+	icon.Draw(NewDasher(w, h, scannerSpan), 1)
+	for i := range gv.Pix {
+		if d := int(gv.Pix[i]) - int(span.Pix[i]); d > 4 || d < -4 {
+			t.Fatalf("span scanner output differs from ScannerGV by %d at pixel %d", d, i/4)
+		}
+	}
+}
+
+func BenchmarkScannerSpan(b *testing.B) {
+	icon, err := ReadIcon("testdata/landscapeIcons/beach.svg")
+	if err != nil {
+		b.Fatal(err)
+	}
+	w, h := int(icon.ViewBox.W)*4, int(icon.ViewBox.H)*4
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	raster := NewDasher(w, h, NewScannerSpan(w, h, img, nil))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		icon.Draw(raster, 1)
 	}
 }
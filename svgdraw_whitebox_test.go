@@ -2,7 +2,16 @@
 // created: 2018 by S.R.Wiley
 package oksvg
 
-import "testing"
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
 
 func TestReadFloat(t *testing.T) {
 	c := new(PathCursor)
@@ -44,3 +53,262 @@ func TestReadFloat(t *testing.T) {
 	}
 
 }
+
+func TestGetPoints(t *testing.T) {
+	c := new(PathCursor)
+	pStr := "1.5.5-2e1,3E+1 +4 1e-1px"
+	if err := c.GetPoints(pStr); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1.5, 0.5, -20, 30, 4, 0.1}
+	if len(c.points) != len(want) {
+		t.Fatal("get points failed", pStr, c.points)
+	}
+	for i := range want {
+		if c.points[i] != want[i] {
+			t.Error("get points failed", pStr, c.points)
+		}
+	}
+
+	if err := c.CompilePath("M10-20L30.5.5h1e1z"); err != nil {
+		t.Fatal(err)
+	}
+	compact := append(rasterx.Path{}, c.Path...)
+	c.Path.Clear()
+	if err := c.CompilePath("M 10 -20 L 30.5 0.5 H 40.5 Z"); err != nil {
+		t.Fatal(err)
+	}
+	if len(compact) != len(c.Path) {
+		t.Fatal("compact path differs", compact, c.Path)
+	}
+	for i := range compact {
+		if compact[i] != c.Path[i] {
+			t.Fatal("compact path differs", compact, c.Path)
+		}
+	}
+}
+
+func TestRotatedEllipseAt(t *testing.T) {
+	c := new(PathCursor)
+	c.RotatedEllipseAt(50, 50, 20, 10, 90)
+	// A quarter turn moves the start of the ellipse from the x axis to the y axis
+	if len(c.Path) < 3 || c.Path[1] != 50*64 || c.Path[2] != 70*64 {
+		t.Error("rotated ellipse start point failed", c.Path[:3])
+	}
+	rotPath := append(rasterx.Path{}, c.Path...)
+	c.Path.Clear()
+	c.ElipseAt(50, 50, 20, 10)
+	if len(c.Path) != len(rotPath) || c.Path[1] != 70*64 || c.Path[2] != 50*64 {
+		t.Error("ellipse start point failed", c.Path[:3])
+	}
+}
+
+// countAdder counts the path commands added to it.
+type countAdder struct {
+	lines, curves int
+}
+
+func (c *countAdder) Start(a fixed.Point26_6)            {}
+func (c *countAdder) Line(b fixed.Point26_6)             { c.lines++ }
+func (c *countAdder) QuadBezier(b, d fixed.Point26_6)    { c.curves++ }
+func (c *countAdder) CubeBezier(b, d, e fixed.Point26_6) { c.curves++ }
+func (c *countAdder) Stop(closeLoop bool)                {}
+
+func TestTessellationBudget(t *testing.T) {
+	ca := &countAdder{}
+	b := &budgetAdder{Adder: ca, TessellationBudget: TessellationBudget{
+		MaxCurveSegments: 10, MaxPathSegments: 15}}
+	b.Start(rasterx.ToFixedP(0, 0))
+	b.QuadBezier(rasterx.ToFixedP(0.1, 0.1), rasterx.ToFixedP(0.2, 0))
+	if ca.curves != 1 || ca.lines != 0 {
+		t.Error("small curve should be passed through", ca)
+	}
+	b.spent = 0
+	huge := rasterx.ToFixedP(3e7, 3e7)
+	b.CubeBezier(huge, huge, rasterx.ToFixedP(100, 0))
+	if ca.lines != 10 {
+		t.Error("curve should be limited to 10 segments, got", ca.lines)
+	}
+	b.CubeBezier(huge, huge, rasterx.ToFixedP(0, 0))
+	if ca.lines != 15 {
+		t.Error("path should be limited to 15 segments, got", ca.lines)
+	}
+	b.QuadBezier(rasterx.ToFixedP(0.1, 0.1), rasterx.ToFixedP(0.2, 0))
+	if ca.lines != 16 || ca.curves != 1 || b.a != rasterx.ToFixedP(0.2, 0) {
+		t.Error("spent budget should draw curves as lines", ca, b.a)
+	}
+}
+
+// pointsAdder records the points of the lines added to it.
+type pointsAdder struct {
+	countAdder
+	points []fixed.Point26_6
+}
+
+func (p *pointsAdder) Line(b fixed.Point26_6) { p.points = append(p.points, b) }
+
+func TestCurveTolerance(t *testing.T) {
+	// A quarter circle of radius 100 as a cubic, drawn at 20 times
+	a, c1, c2, r := rasterx.ToFixedP(2000, 0), rasterx.ToFixedP(2000, 1104.6),
+		rasterx.ToFixedP(1104.6, 2000), rasterx.ToFixedP(0, 2000)
+	segments := func(tol float64) []fixed.Point26_6 {
+		pa := &pointsAdder{}
+		b := &budgetAdder{Adder: pa, TessellationBudget: TessellationBudget{Tolerance: tol}}
+		b.Start(a)
+		b.CubeBezier(c1, c2, r)
+		if pa.curves != 0 || len(pa.points) == 0 || pa.points[len(pa.points)-1] != r {
+			t.Fatal("curve should be flattened into lines ending at its end", pa.curves, len(pa.points))
+		}
+		return pa.points
+	}
+	fine, coarse := segments(0.05), segments(2)
+	if len(fine) <= 2*len(coarse) {
+		t.Error("smaller tolerances should give more segments", len(fine), len(coarse))
+	}
+	for _, tol := range []float64{0.05, 2} {
+		pts := append([]fixed.Point26_6{a}, segments(tol)...)
+		for i := 1; i < len(pts); i++ {
+			// The middle of each segment is within the tolerance of the circle
+			mx, my := float64(pts[i-1].X+pts[i].X)/128, float64(pts[i-1].Y+pts[i].Y)/128
+			if d := 2000 - math.Hypot(mx, my); d > tol+0.05 {
+				t.Errorf("segment %d is %.3f pixels from the curve, tolerance %v", i, d, tol)
+			}
+		}
+	}
+
+	// Sharply bent parts get more segments
+	pa := &pointsAdder{}
+	b := &budgetAdder{Adder: pa, TessellationBudget: TessellationBudget{Tolerance: 0.25}}
+	b.Start(rasterx.ToFixedP(0, 0))
+	b.CubeBezier(rasterx.ToFixedP(1000, 0), rasterx.ToFixedP(1000, 0), rasterx.ToFixedP(1000, 1000))
+	var left, right int
+	for _, p := range pa.points {
+		if p.X < 500*64 {
+			left++
+		} else {
+			right++
+		}
+	}
+	if right <= left {
+		t.Error("the bend of the curve should get more segments", left, right)
+	}
+
+	// The budget still limits the segments
+	pa = &pointsAdder{}
+	b = &budgetAdder{Adder: pa, TessellationBudget: TessellationBudget{Tolerance: 0.01, MaxCurveSegments: 8}}
+	b.Start(a)
+	b.QuadBezier(rasterx.ToFixedP(2000, 2000), r)
+	if len(pa.points) > 8 || pa.points[len(pa.points)-1] != r {
+		t.Error("curve should be limited to 8 segments, got", len(pa.points))
+	}
+}
+
+func TestPathVertices(t *testing.T) {
+	c := &PathCursor{}
+	if err := c.CompilePath("M0 0H10V10Z"); err != nil {
+		t.Fatal(err)
+	}
+	vs := pathVertices(c.Path, 0)
+	want := []markerVertex{
+		{0, 0, -3 * math.Pi / 4, 0}, // the start is entered from the closing segment
+		{10, 0, 0, math.Pi / 2},
+		{10, 10, math.Pi / 2, -3 * math.Pi / 4},
+		{0, 0, -3 * math.Pi / 4, 0}, // the closing vertex leaves along the first segment
+	}
+	if len(vs) != len(want) {
+		t.Fatal("closed triangle should have 4 vertices", vs)
+	}
+	for i, v := range vs {
+		w := want[i]
+		if v.x != w.x || v.y != w.y || math.Abs(math.Remainder(v.in-w.in, 2*math.Pi)) > 1e-6 ||
+			math.Abs(v.out-w.out) > 1e-6 {
+			t.Error("vertex", i, "want", w, "got", v)
+		}
+	}
+	if a := bisector(0, math.Pi/2); math.Abs(a-math.Pi/4) > 1e-9 {
+		t.Error("bisector of right angle", a)
+	}
+	if a := bisector(math.NaN(), 1); a != 1 {
+		t.Error("bisector without an incoming direction", a)
+	}
+}
+
+func TestMayDraw(t *testing.T) {
+	icon, err := ReadIconStream(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">
+	<rect x="10" y="10" width="10" height="10"/>
+	<rect x="-30" y="10" width="10" height="10" stroke="black" stroke-width="4"/>
+	<rect x="-30" y="10" width="10" height="10" stroke="black" stroke-width="40"/>
+	</svg>`), StrictErrorMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	r := rasterx.NewDasher(50, 50, rasterx.NewScannerGV(50, 50, img, img.Bounds()))
+	for i, want := range []bool{true, false, true} {
+		svgp := &icon.SVGPaths[i]
+		if got := svgp.mayDraw(r, rasterx.Identity, svgp.StrokeStyle()); got != want {
+			t.Error("path", i, "may draw", got, "want", want)
+		}
+	}
+	// Moved into view by the transform
+	if svgp := &icon.SVGPaths[1]; !svgp.mayDraw(r, rasterx.Identity.Translate(40, 0), svgp.StrokeStyle()) {
+		t.Error("translated path should be drawn")
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	src := strings.Repeat("<g>\n  <rect/>\n</g>\n", 1000)
+	l := &lineReader{r: strings.NewReader(src)}
+	buf := make([]byte, 100)
+	var read int64
+	for i := 0; i < 1000; i++ {
+		offset := int64(i*len("<g>\n  <rect/>\n</g>\n") + len("<g>\n  "))
+		for read < offset+1 {
+			n, _ := l.Read(buf)
+			read += int64(n)
+		}
+		if p := l.pos(offset); p.Line != 3*i+2 || p.Column != 3 {
+			t.Fatalf("rect %d at %v, want %d:3", i, p, 3*i+2)
+		}
+		if len(l.breaks) > 20 {
+			t.Fatalf("%d line breaks kept after rect %d", len(l.breaks), i)
+		}
+	}
+}
+
+func TestLowMemoryRead(t *testing.T) {
+	const svg = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><title>T</title>
+		<g id="g"><desc>D</desc><rect id="r" width="5" height="5"/></g></svg>`
+	icon, err := readIcon(strings.NewReader(svg), ParseOptions{LowMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Titles != nil || icon.Descriptions != nil || icon.titles != nil || icon.elements != nil {
+		t.Error("texts or elements recorded while reading with LowMemory")
+	}
+	if icon, _ = readIcon(strings.NewReader(svg), ParseOptions{}); len(icon.Titles) != 1 || len(icon.elements) != 2 {
+		t.Error("texts or elements not recorded", icon.Titles, len(icon.elements))
+	}
+}
+
+// BenchmarkFillSpan compares fillSpan with fillSpanGeneric on a span of 1024
+// pixels that is fully covered but for an anti-aliased pixel at each end.
+func BenchmarkFillSpan(b *testing.B) {
+	pix, cov := make([]uint8, 4*1024), make([]uint8, 1024)
+	for i := range cov {
+		cov[i] = 0xff
+	}
+	cov[0], cov[len(cov)-1] = 0x40, 0xc0
+	c := color.RGBA{0x20, 0x40, 0x80, 0xff}
+	for _, f := range []struct {
+		name string
+		fill func(pix, cov []uint8, c color.RGBA)
+	}{{"Word", fillSpan}, {"Generic", fillSpanGeneric}} {
+		b.Run(f.name, func(b *testing.B) {
+			b.SetBytes(int64(len(pix)))
+			for i := 0; i < b.N; i++ {
+				f.fill(pix, cov, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// terminal.go implements encoders for drawing icons in terminals.
+
+package oksvg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/srwiley/rasterx"
+)
+
+// TerminalProtocol selects how images are written to a terminal.
+type TerminalProtocol int
+
+// TerminalProtocol constants
+const (
+	// TerminalANSI draws two pixels per character cell using half block
+	// characters and 24-bit ANSI colors. It works in most modern terminals.
+	TerminalANSI TerminalProtocol = iota
+	// TerminalSixel uses the DEC sixel graphics protocol with a 256 color palette.
+	TerminalSixel
+	// TerminalKitty uses the kitty terminal graphics protocol.
+	TerminalKitty
+)
+
+// DrawTerminal draws the icon, using its Transform, into an image of width w and
+// height h in pixels and writes it to the terminal out with the protocol.
+func DrawTerminal(out io.Writer, icon *SvgIcon, w, h int, proto TerminalProtocol) error {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+	return WriteTerminal(out, img, proto)
+}
+
+// WriteTerminal writes img to the terminal out with the protocol. Transparent
+// pixels show the background of the terminal.
+func WriteTerminal(out io.Writer, img image.Image, proto TerminalProtocol) error {
+	bw := bufio.NewWriter(out)
+	switch proto {
+	case TerminalSixel:
+		writeSixel(bw, img)
+	case TerminalKitty:
+		if err := writeKitty(bw, img); err != nil {
+			return err
+		}
+	default:
+		writeANSI(bw, img)
+	}
+	return bw.Flush()
+}
+
+// writeANSI writes img as rows of upper half blocks, with the foreground color set
+// to the upper pixel and the background color to the lower pixel.
+func writeANSI(w *bufio.Writer, img image.Image) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			var bottom color.NRGBA
+			if y+1 < b.Max.Y {
+				bottom = color.NRGBAModel.Convert(img.At(x, y+1)).(color.NRGBA)
+			}
+			switch {
+			case top.A < 0x80 && bottom.A < 0x80:
+				w.WriteString("\x1b[0m ")
+			case bottom.A < 0x80:
+				fmt.Fprintf(w, "\x1b[0;38;2;%d;%d;%dm▀", top.R, top.G, top.B)
+			case top.A < 0x80:
+				fmt.Fprintf(w, "\x1b[0;38;2;%d;%d;%dm▄", bottom.R, bottom.G, bottom.B)
+			default:
+				fmt.Fprintf(w, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀",
+					top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			}
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
+
+// writeKitty writes img as a PNG transmitted with the kitty graphics protocol,
+// in chunks of at most 4096 bytes of base64 data.
+func writeKitty(w *bufio.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	const chunk = 4096
+	for i := 0; i < len(data); i += chunk {
+		end, more := i+chunk, 1
+		if end >= len(data) {
+			end, more = len(data), 0
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, data[i:end])
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, data[i:end])
+		}
+	}
+	w.WriteString("\n")
+	return nil
+}
+
+// writeSixel writes img in the sixel protocol, dithered to the 256 color Plan 9 palette.
+func writeSixel(w *bufio.Writer, img image.Image) {
+	b := img.Bounds()
+	pal := image.NewPaletted(b, palette.Plan9)
+	draw.FloydSteinberg.Draw(pal, b, img, b.Min)
+	// P2 = 1 leaves pixels that are not drawn, here the transparent ones, unchanged
+	fmt.Fprintf(w, "\x1bP0;1;0q\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range palette.Plan9 {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, r*100/0xFFFF, g*100/0xFFFF, bl*100/0xFFFF)
+	}
+	sixels := make([]byte, b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y += 6 {
+		var used [256]bool
+		for dy := 0; dy < 6 && y+dy < b.Max.Y; dy++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if opaque(img, x, y+dy) {
+					used[pal.ColorIndexAt(x, y+dy)] = true
+				}
+			}
+		}
+		for ci := range used {
+			if !used[ci] {
+				continue
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && y+dy < b.Max.Y; dy++ {
+					if opaque(img, x, y+dy) && int(pal.ColorIndexAt(x, y+dy)) == ci {
+						bits |= 1 << uint(dy)
+					}
+				}
+				sixels[x-b.Min.X] = '?' + bits
+			}
+			fmt.Fprintf(w, "#%d", ci)
+			writeSixelRuns(w, sixels)
+			w.WriteByte('$')
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\\n")
+}
+
+// writeSixelRuns writes the sixel characters, compressing runs with the repeat introducer.
+func writeSixelRuns(w *bufio.Writer, sixels []byte) {
+	for i := 0; i < len(sixels); {
+		j := i + 1
+		for j < len(sixels) && sixels[j] == sixels[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, sixels[i])
+		} else {
+			w.Write(sixels[i:j])
+		}
+		i = j
+	}
+}
+
+// opaque reports whether the pixel at x, y of img is at least half opaque.
+func opaque(img image.Image, x, y int) bool {
+	_, _, _, a := img.At(x, y).RGBA()
+	return a >= 0x8000
+}
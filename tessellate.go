@@ -0,0 +1,301 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// tessellate.go implements export of icons as triangle meshes with paints, for
+// game and UI engines that draw them on the GPU.
+
+package oksvg
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// TessellateOptions configures Tessellate.
+type TessellateOptions struct {
+	// Scale is the number of pixels per user unit the icon is tessellated for,
+	// as its largest size on screen. Curves are flattened within the Budget of
+	// the icon at that scale, and strokes are as wide as Draw draws them at that
+	// scale. Zero means 1.
+	Scale float64
+}
+
+// Mesh is the triangles covering the fill or the stroke of a path.
+type Mesh struct {
+	Path   int  // the index of the path in SVGPaths
+	Stroke bool // whether the mesh covers the stroke of the path rather than its fill
+	// Vertices holds the x and y of each vertex in turn, in the user units of the
+	// ViewBox.
+	Vertices []float32
+	// Indices holds the indices of the three vertices of each triangle. The
+	// triangles do not overlap, and are clockwise with y growing downwards.
+	Indices []uint32
+	Paint   Paint
+}
+
+// Paint describes the color of a Mesh. Paths painted with patterns or images
+// have neither a Color nor a Gradient.
+type Paint struct {
+	Color    color.Color       // the solid color, nil for other paints
+	Gradient *rasterx.Gradient // the gradient, nil for other paints
+	// Transform maps the coordinates the Gradient is defined in to those of the
+	// vertices. ObjectBoundingBox gradients have the bounding box of the path,
+	// in the coordinates of the vertices, as their Bounds and are not transformed.
+	Transform rasterx.Matrix2D
+	Opacity   float64 // the fill or stroke opacity, including that of the element
+}
+
+// Tessellate converts the fills and strokes of the paths of the icon into
+// meshes of triangles, in drawing order, so that they can be drawn on the GPU
+// and scaled without rasterizing the icon again. Fills are split into
+// trapezoids under their fill rule, so self intersecting paths and holes are
+// covered as Draw covers them, and strokes are outlined with their dashes,
+// joins and caps as Draw outlines them. Clip paths, masks and the Transform of
+// the icon are ignored.
+func (s *SvgIcon) Tessellate(opts TessellateOptions) []Mesh {
+	k := opts.Scale
+	if k <= 0 {
+		k = 1
+	}
+	tb := s.budget()
+	scale := rasterx.Identity.Scale(k, k)
+	var ms meshScanner
+	r := rasterx.NewDasher(1, 1, &ms)
+	unscale := func(b ViewBox) ViewBox { return ViewBox{b.X / k, b.Y / k, b.W / k, b.H / k} }
+	var meshes []Mesh
+	for i := range s.SVGPaths {
+		svgp := &s.SVGPaths[i]
+		m := svgp.mAdder.M
+		svgp.mAdder.M = scale.Mult(m)
+		if svgp.fillerColor != nil {
+			ms.Clear()
+			svgp.mAdder.Adder = &budgetAdder{Adder: &rasterx.Filler{Scanner: &ms}, TessellationBudget: tb}
+			svgp.Path.AddTo(&svgp.mAdder)
+			mesh := ms.mesh(svgp.UseNonZeroWinding, 1/k)
+			mesh.Path = i
+			mesh.Paint = newPaint(svgp.fillerColor, svgp.FillOpacity, m, unscale(objectBounds(&ms)))
+			if len(mesh.Indices) > 0 {
+				meshes = append(meshes, mesh)
+			}
+		}
+		if svgp.linerColor != nil {
+			// The bounding box is that of the dashes, without the stroke width
+			ss := svgp.StrokeStyle()
+			bbox := svgp.dashBounds(ss, tb)
+			svgp.addStroke(r, ss, tb)
+			mesh := ms.mesh(true, 1/k)
+			mesh.Path, mesh.Stroke = i, true
+			mesh.Paint = newPaint(svgp.linerColor, svgp.LineOpacity, m, unscale(bbox))
+			if len(mesh.Indices) > 0 {
+				meshes = append(meshes, mesh)
+			}
+		}
+		svgp.mAdder.M = m
+	}
+	return meshes
+}
+
+// newPaint returns the Paint of the fill or stroke paint p of a path drawn with
+// transform m, with opacity, whose bounding box is bbox.
+func newPaint(p interface{}, opacity float64, m rasterx.Matrix2D, bbox ViewBox) Paint {
+	paint := Paint{Transform: rasterx.Identity, Opacity: opacity}
+	switch p := p.(type) {
+	case color.Color:
+		paint.Color = p
+	case rasterx.Gradient:
+		p.Stops = append([]rasterx.GradStop(nil), p.Stops...)
+		if p.Units == rasterx.ObjectBoundingBox {
+			p.Bounds = bbox
+		}
+		paint.Gradient, paint.Transform = &p, userMatrix(&p, m)
+	}
+	return paint
+}
+
+// meshEdge is an edge of a flattened path from its top at y0 to its bottom at
+// y1, with x0 and x1 its x at each end. dir is 1 if the path goes down along
+// it and -1 if it goes up.
+type meshEdge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+// xAt returns the x of the edge at y.
+func (e meshEdge) xAt(y float64) float64 {
+	if y <= e.y0 {
+		return e.x0
+	}
+	if y >= e.y1 {
+		return e.x1
+	}
+	return e.x0 + (e.x1-e.x0)*(y-e.y0)/(e.y1-e.y0)
+}
+
+// meshScanner is a rasterx Scanner that records the edges of the paths added to
+// it, which it splits into triangles.
+type meshScanner struct {
+	edges                  []meshEdge
+	last                   fixed.Point26_6
+	minX, minY, maxX, maxY fixed.Int26_6
+}
+
+// Start starts a new subpath at a. As with the rasterx scanners, subpaths are
+// not closed; the Filler closes those of fills, and the subpaths of the
+// outlines of strokes enclose their inside together.
+func (s *meshScanner) Start(a fixed.Point26_6) {
+	s.last = a
+	s.set(a)
+}
+
+// Line adds an edge from the current point to b.
+func (s *meshScanner) Line(b fixed.Point26_6) {
+	s.addEdge(s.last, b)
+	s.last = b
+	s.set(b)
+}
+
+func (s *meshScanner) set(a fixed.Point26_6) {
+	s.minX, s.minY = minFixed(s.minX, a.X), minFixed(s.minY, a.Y)
+	s.maxX, s.maxY = maxFixed(s.maxX, a.X), maxFixed(s.maxY, a.Y)
+}
+
+// addEdge adds the edge from a to b, unless it is horizontal, as horizontal
+// edges do not change the winding number of any point.
+func (s *meshScanner) addEdge(a, b fixed.Point26_6) {
+	if a.Y == b.Y {
+		return
+	}
+	e := meshEdge{float64(a.X), float64(a.Y), float64(b.X), float64(b.Y), 1}
+	if a.Y > b.Y {
+		e = meshEdge{e.x1, e.y1, e.x0, e.y0, -1}
+	}
+	s.edges = append(s.edges, e)
+}
+
+// Draw does nothing, as the edges are split into triangles by mesh.
+func (s *meshScanner) Draw() {}
+
+// GetPathExtent returns the extent of the path
+func (s *meshScanner) GetPathExtent() fixed.Rectangle26_6 {
+	return fixed.Rectangle26_6{Min: fixed.Point26_6{X: s.minX, Y: s.minY}, Max: fixed.Point26_6{X: s.maxX, Y: s.maxY}}
+}
+
+func (s *meshScanner) SetBounds(w, h int)                {}
+func (s *meshScanner) SetColor(color interface{})        {}
+func (s *meshScanner) SetWinding(useNonZeroWinding bool) {}
+func (s *meshScanner) SetClip(rect image.Rectangle)      {}
+
+// Clear drops the recorded edges.
+func (s *meshScanner) Clear() {
+	s.edges = s.edges[:0]
+	s.last = fixed.Point26_6{}
+	const mxfi = fixed.Int26_6(math.MaxInt32)
+	s.minX, s.minY, s.maxX, s.maxY = mxfi, mxfi, -mxfi, -mxfi
+}
+
+// mesh returns the triangles covering the inside of the recorded edges under
+// the nonzero fill rule, or the evenodd rule if nonZero is false, with their
+// vertices scaled by unscale. The plane is cut into horizontal slabs at the
+// ends and crossings of the edges, so that no edges cross within a slab, and
+// the inside of each slab is covered by trapezoids between its edges.
+func (s *meshScanner) mesh(nonZero bool, unscale float64) (mesh Mesh) {
+	edges := s.edges
+	sort.Slice(edges, func(i, j int) bool { return edges[i].y0 < edges[j].y0 })
+	ys := make([]float64, 0, 2*len(edges))
+	var active []meshEdge
+	for _, e := range edges {
+		ys = append(ys, e.y0, e.y1)
+		n := 0
+		for _, a := range active {
+			if a.y1 <= e.y0 {
+				continue
+			}
+			active[n] = a
+			n++
+			if y, ok := crossing(a, e); ok {
+				ys = append(ys, y)
+			}
+		}
+		active = append(active[:n], e)
+	}
+	sort.Float64s(ys)
+
+	index := make(map[[2]float32]uint32)
+	vertex := func(x, y float64) uint32 {
+		p := [2]float32{float32(x * unscale / 64), float32(y * unscale / 64)}
+		i, ok := index[p]
+		if !ok {
+			i = uint32(len(mesh.Vertices) / 2)
+			index[p] = i
+			mesh.Vertices = append(mesh.Vertices, p[0], p[1])
+		}
+		return i
+	}
+	type slabEdge struct {
+		x0, xm, x1 float64 // x at the top, the middle and the bottom of the slab
+		dir        int
+	}
+	var slab []slabEdge
+	active, next := active[:0], 0
+	for i := 0; i+1 < len(ys); i++ {
+		y0, y1 := ys[i], ys[i+1]
+		if y1-y0 < 1e-9 {
+			continue
+		}
+		n := 0
+		for _, a := range active {
+			if a.y1 > y0 {
+				active[n] = a
+				n++
+			}
+		}
+		active = active[:n]
+		for ; next < len(edges) && edges[next].y0 <= y0; next++ {
+			if edges[next].y1 > y0 {
+				active = append(active, edges[next])
+			}
+		}
+		slab = slab[:0]
+		ym := (y0 + y1) / 2
+		for _, a := range active {
+			slab = append(slab, slabEdge{a.xAt(y0), a.xAt(ym), a.xAt(y1), a.dir})
+		}
+		sort.Slice(slab, func(i, j int) bool { return slab[i].xm < slab[j].xm })
+		winding := 0
+		for j := 0; j+1 < len(slab); j++ {
+			winding += slab[j].dir
+			if (nonZero && winding == 0) || (!nonZero && winding%2 == 0) {
+				continue
+			}
+			l, r := slab[j], slab[j+1]
+			a, b := vertex(l.x0, y0), vertex(r.x0, y0)
+			c, d := vertex(r.x1, y1), vertex(l.x1, y1)
+			if a != b {
+				mesh.Indices = append(mesh.Indices, a, b, c)
+			}
+			if c != d {
+				mesh.Indices = append(mesh.Indices, a, c, d)
+			}
+		}
+	}
+	return mesh
+}
+
+// crossing returns the y at which the edges a and b cross, strictly within
+// both of them.
+func crossing(a, b meshEdge) (float64, bool) {
+	top, bottom := math.Max(a.y0, b.y0), math.Min(a.y1, b.y1)
+	if top >= bottom {
+		return 0, false
+	}
+	// The difference of the x of the edges changes sign where they cross
+	dTop, dBottom := a.xAt(top)-b.xAt(top), a.xAt(bottom)-b.xAt(bottom)
+	if dTop == 0 || dBottom == 0 || (dTop < 0) == (dBottom < 0) {
+		return 0, false
+	}
+	return top + (bottom-top)*dTop/(dTop-dBottom), true
+}
@@ -8,8 +8,10 @@ package oksvg
 import (
 	"errors"
 	"image/color"
+	"math"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/srwiley/rasterx"
 	"golang.org/x/image/colornames"
@@ -17,21 +19,20 @@ import (
 
 // unitSuffixes are suffixes sometimes applied to the width and height attributes
 // of the svg element.
-var unitSuffixes = []string{"cm", "mm", "px", "pt"}
+var unitSuffixes = []string{"cm", "mm", "px", "pt", "pc", "in"}
 
+// parseColorValue parses an rgb component, a number from 0 to 255 or a
+// percentage. Out of range values are clamped.
 func parseColorValue(v string) (uint8, error) {
-	if v[len(v)-1] == '%' {
-		n, err := strconv.Atoi(strings.TrimSpace(v[:len(v)-1]))
-		if err != nil {
-			return 0, err
-		}
-		return uint8(n * 0xFF / 100), nil
+	max := 255.0
+	if strings.HasSuffix(v, "%") {
+		v, max = v[:len(v)-1], 100
 	}
-	n, err := strconv.Atoi(strings.TrimSpace(v))
-	if n > 255 {
-		n = 255
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, err
 	}
-	return uint8(n), err
+	return uint8(math.Round(clamp01(f/max) * 0xFF)), nil
 }
 
 // trimSuffixes removes unitSuffixes from any number that is not just numeric
@@ -46,72 +47,302 @@ func trimSuffixes(a string) (b string) {
 	return
 }
 
+// mmPerUnit is the length in millimeters of each absolute unit of CSS.
+var mmPerUnit = map[string]float64{"": 25.4 / 96, "px": 25.4 / 96, "pt": 25.4 / 72, "pc": 25.4 / 6,
+	"in": 25.4, "cm": 10, "mm": 1, "Q": 0.25}
+
+// unitsPerInch is the number of each absolute unit of CSS other than px in an inch.
+var unitsPerInch = map[string]float64{"in": 1, "cm": 2.54, "mm": 25.4, "Q": 101.6, "pt": 72, "pc": 6}
+
+// physicalLength returns the length v in millimeters, or zero if it is not a
+// positive absolute length.
+func physicalLength(v string) float64 {
+	v = strings.TrimSpace(v)
+	i := len(v)
+	for i > 0 && (v[i-1] < '0' || v[i-1] > '9') && v[i-1] != '.' {
+		i--
+	}
+	scale, ok := mmPerUnit[v[i:]]
+	if !ok {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v[:i], 64)
+	if err != nil || !(f > 0) {
+		return 0
+	}
+	return f * scale
+}
+
 // parseFloat is a helper function that strips suffixes before passing to strconv.ParseFloat
 func parseFloat(s string, bitSize int) (float64, error) {
 	val := trimSuffixes(s)
 	return strconv.ParseFloat(val, bitSize)
 }
 
-// splitOnCommaOrSpace returns a list of strings after splitting the input on comma and space delimiters
+// fontSizeKeywords are the absolute font-size keywords in pixels.
+var fontSizeKeywords = map[string]float64{"xx-small": 9, "x-small": 10, "small": 13,
+	"medium": 16, "large": 18, "x-large": 24, "xx-large": 32}
+
+// LengthContext holds what the units of a length are relative to.
+type LengthContext struct {
+	FontSize     float64 // the size of em units
+	RootFontSize float64 // the size of rem units
+	Percent      float64 // the length that 100% is
+	DPI          float64 // user units per inch of absolute units, 96 as in CSS if zero
+}
+
+// ParseLength parses the length v, with or without a unit, into user units. The
+// absolute units in, cm, mm, Q, pt and pc are converted at ctx.DPI, while px are
+// user units.
+func ParseLength(v string, ctx LengthContext) (float64, error) {
+	v = strings.TrimSpace(v)
+	i := numberLen(v)
+	f, err := strconv.ParseFloat(v[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	dpi := ctx.DPI
+	if dpi <= 0 {
+		dpi = 96
+	}
+	switch unit := v[i:]; unit {
+	case "", "px":
+		return f, nil
+	case "em":
+		return f * ctx.FontSize, nil
+	case "rem":
+		return f * ctx.RootFontSize, nil
+	case "%":
+		return f * ctx.Percent / 100, nil
+	default:
+		n, ok := unitsPerInch[unit]
+		if !ok {
+			return 0, errors.New("unknown unit " + unit + " in length " + v)
+		}
+		return f * dpi / n, nil
+	}
+}
+
+// numberLen returns the length of the number at the start of v, so that an e
+// starting a unit, as in 1em, is not taken for an exponent.
+func numberLen(v string) int {
+	digits := func(i int) int {
+		for i < len(v) && v[i] >= '0' && v[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(v) && (v[i] == '-' || v[i] == '+') {
+		i++
+	}
+	i = digits(i)
+	if i < len(v) && v[i] == '.' {
+		i = digits(i + 1)
+	}
+	if i < len(v) && (v[i] == 'e' || v[i] == 'E') {
+		j := i + 1
+		if j < len(v) && (v[j] == '-' || v[j] == '+') {
+			j++
+		}
+		if k := digits(j); k > j {
+			i = k
+		}
+	}
+	return i
+}
+
+// parseAspectRatio returns the alignment of the preserveAspectRatio value par,
+// "none" if it is empty, and whether the viewBox slices rather than meets.
+func parseAspectRatio(par string) (align string, slice bool) {
+	fields := strings.Fields(par)
+	if len(fields) > 0 && fields[0] == "defer" { // only meaningful for images
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "none", false
+	}
+	return fields[0], len(fields) > 1 && fields[1] == "slice"
+}
+
+// splitOnCommaOrSpace returns a list of strings after splitting the input on comma and white space delimiters
 func splitOnCommaOrSpace(s string) []string {
 	return strings.FieldsFunc(s,
 		func(r rune) bool {
-			return r == ',' || r == ' '
+			return r == ',' || unicode.IsSpace(r)
 		})
 }
 
-func parseClasses(data string) (map[string]styleAttribute, error) {
-	res := map[string]styleAttribute{}
-	arr := strings.Split(data, "}")
-	for _, v := range arr {
+// selectMedia replaces the @media blocks of the style sheet css by the rules
+// they contain if their query matches, and removes them otherwise. Queries
+// match the color scheme, and the all and screen media types.
+func selectMedia(css string, scheme ColorScheme) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(css, "@media")
+		if i < 0 {
+			b.WriteString(css)
+			return b.String()
+		}
+		b.WriteString(css[:i])
+		open := strings.IndexByte(css[i:], '{')
+		if open < 0 {
+			return b.String()
+		}
+		open += i
+		end, depth := open+1, 1
+		for ; end < len(css) && depth > 0; end++ {
+			switch css[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+		}
+		if mediaMatches(css[i+len("@media"):open], scheme) {
+			b.WriteString(css[open+1 : end-1])
+		}
+		css = css[end:]
+	}
+}
+
+// mediaMatches reports whether the media query matches the color scheme.
+func mediaMatches(query string, scheme ColorScheme) bool {
+	q := strings.ToLower(strings.Join(strings.Fields(query), ""))
+	switch {
+	case strings.Contains(q, "prefers-color-scheme:dark"):
+		return scheme == DarkColorScheme
+	case strings.Contains(q, "prefers-color-scheme:light"):
+		return scheme == LightColorScheme
+	}
+	return q == "" || q == "all" || q == "screen"
+}
+
+// cssRule is a rule of a style sheet. Its selector is a compound of an optional
+// element name, id and classes, all of which an element must match.
+type cssRule struct {
+	tag, id     string
+	classes     []string
+	specificity int
+	decls       []string // property:value pairs, in order
+	important   []string // the pairs marked !important
+}
+
+// matches reports whether the rule applies to the element tag with the id and
+// classes.
+func (r *cssRule) matches(tag, id string, classes []string) bool {
+	if (r.tag != "" && r.tag != tag) || (r.id != "" && r.id != id) {
+		return false
+	}
+	for _, rc := range r.classes {
+		found := false
+		for _, c := range classes {
+			if c == rc {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStyleSheet returns the rules of the style sheet data. Selectors that are
+// not a compound of an element name, id and classes, such as those with
+// combinators, pseudo-classes or attributes, are skipped.
+func parseStyleSheet(data string) ([]cssRule, error) {
+	var rules []cssRule
+	for {
+		i := strings.Index(data, "/*")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(data[i+2:], "*/")
+		if j < 0 {
+			data = data[:i]
+			break
+		}
+		data = data[:i] + " " + data[i+2+j+2:]
+	}
+	for _, v := range strings.Split(data, "}") {
 		v = strings.TrimSpace(v)
 		if v == "" {
 			continue
 		}
 		valueIndex := strings.Index(v, "{")
 		if valueIndex == -1 || valueIndex == len(v)-1 {
-			return res, errors.New(v + "}: invalid map format in class definitions")
+			return rules, errors.New(v + "}: invalid map format in class definitions")
 		}
-		classesStr := v[:valueIndex]
-		attrStr := v[valueIndex+1:]
-		attrMap, err := parseAttrs(attrStr)
+		decls, important, err := parseDeclarations(v[valueIndex+1:])
 		if err != nil {
-			return res, err
+			return rules, err
 		}
-		classes := strings.Split(classesStr, ",")
-		for _, class := range classes {
-			class = strings.TrimSpace(class)
-			if len(class) > 0 && class[0] == '.' {
-				class = class[1:]
+		for _, sel := range strings.Split(v[:valueIndex], ",") {
+			if r, ok := parseSelector(strings.TrimSpace(sel)); ok {
+				r.decls, r.important = decls, important
+				rules = append(rules, r)
 			}
-			for attrKey, attrVal := range attrMap {
-				if res[class] == nil {
-					res[class] = make(styleAttribute, len(attrMap))
-				}
-				res[class][attrKey] = attrVal
+		}
+	}
+	return rules, nil
+}
+
+// parseSelector reads a compound selector such as rect, .a, #b or path.a.c, and
+// returns false for any other selector.
+func parseSelector(sel string) (r cssRule, ok bool) {
+	if sel == "" || strings.ContainsAny(sel, " \t\n>+~:[") {
+		return r, false
+	}
+	if sel == "*" {
+		return r, true
+	}
+	for i := 0; i < len(sel); {
+		j := i + 1
+		for j < len(sel) && sel[j] != '.' && sel[j] != '#' {
+			j++
+		}
+		switch name := sel[i:j]; name[0] {
+		case '.':
+			r.classes = append(r.classes, name[1:])
+			r.specificity += 10
+		case '#':
+			r.id = name[1:]
+			r.specificity += 100
+		default:
+			if i != 0 {
+				return r, false
 			}
+			r.tag = name
+			r.specificity++
 		}
+		i = j
 	}
-	return res, nil
+	return r, true
 }
 
-func parseAttrs(attrStr string) (styleAttribute, error) {
-	arr := strings.Split(attrStr, ";")
-	res := make(styleAttribute, len(arr))
-	for _, kv := range arr {
+// parseDeclarations returns the property:value pairs of a declaration block,
+// with those marked !important, without the mark, returned separately.
+func parseDeclarations(block string) (decls, important []string, err error) {
+	for _, kv := range strings.Split(block, ";") {
 		kv = strings.TrimSpace(kv)
 		if kv == "" {
 			continue
 		}
 		tmp := strings.SplitN(kv, ":", 2)
 		if len(tmp) != 2 {
-			return res, errors.New(kv + ": invalid attribute format")
+			return nil, nil, errors.New(kv + ": invalid attribute format")
 		}
 		k := strings.TrimSpace(tmp[0])
 		v := strings.TrimSpace(tmp[1])
-		res[k] = v
+		if i := strings.Index(v, "!"); i >= 0 && strings.TrimSpace(v[i+1:]) == "important" {
+			important = append(important, k+":"+strings.TrimSpace(v[:i]))
+			continue
+		}
+		decls = append(decls, k+":"+v)
 	}
-	return res, nil
+	return decls, important, nil
 }
 
 func readFraction(v string) (f float64, err error) {
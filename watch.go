@@ -0,0 +1,223 @@
+// Copyright 2017 The oksvg Authors. All rights reserved.
+//
+// watch.go implements reading an icon file again whenever it changes, for
+// icon design and preview tools.
+
+package oksvg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watcher reports changes to files. It can wrap fsnotify or a similar package,
+// which oksvg does not depend on, be a PollWatcher, or be driven by a test.
+type Watcher interface {
+	// Add starts watching the file name.
+	Add(name string) error
+	// Changes returns the channel the names of changed files are sent on, as
+	// they were added. It is closed when the Watcher is closed.
+	Changes() <-chan string
+}
+
+// IconUpdate is an icon read by WatchIcon.
+type IconUpdate struct {
+	Icon *SvgIcon // nil if the file could not be read
+	// Changed holds the sorted ids of the elements, gradients and definitions
+	// that were added, removed or changed since the last icon that was read. An
+	// element changes when anything it draws does, so the groups around a
+	// changed element change with it. On the first update, it holds every id.
+	Changed []string
+	Err     error // the error reading the file, such as a syntax error while it is edited
+}
+
+// WatchIcon reads the icon file name with the options opts, and again each time
+// w reports that it changed, calling fn with each result, until ctx is done or
+// the changes of w are closed. An update whose file cannot be read has an Err,
+// and the next icon is compared with the last one that was read. WatchIcon
+// returns the error of ctx, nil if the changes were closed, or the error adding
+// name to w.
+func WatchIcon(ctx context.Context, w Watcher, name string, opts ParseOptions, fn func(IconUpdate)) error {
+	if err := w.Add(name); err != nil {
+		return err
+	}
+	var last map[string]string
+	read := func() {
+		icon, err := readIconFile(name, opts)
+		if err != nil {
+			fn(IconUpdate{Err: err})
+			return
+		}
+		prints := icon.fingerprints()
+		fn(IconUpdate{Icon: icon, Changed: changedIDs(last, prints)})
+		last = prints
+	}
+	read()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case changed, ok := <-w.Changes():
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(changed) == filepath.Clean(name) {
+				read()
+			}
+		}
+	}
+}
+
+// readIconFile reads the icon file name with the options opts.
+func readIconFile(name string, opts ParseOptions) (*SvgIcon, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadIconStreamOptions(f, opts)
+}
+
+// fingerprints returns, by id, a description of what each element with an id
+// outside defs draws, and of each gradient and definition, written as
+// MarshalSVG writes them.
+func (s *SvgIcon) fingerprints() map[string]string {
+	cs := coordScaleFor(s.ViewBox)
+	prints := make(map[string]string, len(s.elements)+len(s.Grads)+len(s.Defs))
+	for _, e := range s.elements {
+		if _, ok := prints[e.id]; ok {
+			continue // only the first element with an id can be replaced
+		}
+		m := &svgMarshaler{ids: make(map[interface{}]string)}
+		var b bytes.Buffer
+		for i := e.first; i < e.end; i++ {
+			m.element(&b, &s.SVGPaths[i], cs)
+		}
+		prints[e.id] = m.defs.String() + b.String()
+	}
+	for id, g := range s.Grads {
+		m := &svgMarshaler{ids: make(map[interface{}]string)}
+		m.gradient(*g, cs)
+		prints[id] += m.defs.String()
+	}
+	for id, defs := range s.Defs {
+		var b strings.Builder
+		for _, def := range defs {
+			b.WriteString("<" + def.Tag)
+			for _, attr := range def.Attrs {
+				b.WriteString(" " + attr.Name.Local + "=" + attr.Value)
+			}
+			b.WriteString(">")
+		}
+		prints[id] += b.String()
+	}
+	return prints
+}
+
+// changedIDs returns the sorted ids that are in only one of old and new, or
+// differ between them.
+func changedIDs(old, new map[string]string) []string {
+	var ids []string
+	for id, p := range new {
+		if q, ok := old[id]; !ok || p != q {
+			ids = append(ids, id)
+		}
+	}
+	for id := range old {
+		if _, ok := new[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// PollWatcher is a Watcher that checks the size and modification time of its
+// files at an interval, for systems or file systems without notifications.
+type PollWatcher struct {
+	interval time.Duration
+	changes  chan string
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	files    map[string]fileStamp
+}
+
+// fileStamp is what a PollWatcher compares to find that a file changed.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	exists  bool
+}
+
+// NewPollWatcher returns a PollWatcher checking its files every interval.
+func NewPollWatcher(interval time.Duration) *PollWatcher {
+	p := &PollWatcher{interval: interval, changes: make(chan string), done: make(chan struct{}),
+		files: make(map[string]fileStamp)}
+	go p.poll()
+	return p
+}
+
+// Add starts watching the file name, which need not exist yet.
+func (p *PollWatcher) Add(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files[name] = stampFile(name)
+	return nil
+}
+
+// Changes returns the channel the names of changed files are sent on.
+func (p *PollWatcher) Changes() <-chan string {
+	return p.changes
+}
+
+// Close stops watching and closes the changes channel.
+func (p *PollWatcher) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// poll checks the files every interval until the PollWatcher is closed.
+func (p *PollWatcher) poll() {
+	defer close(p.changes)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		var changed []string
+		p.mu.Lock()
+		for name, stamp := range p.files {
+			if now := stampFile(name); now != stamp {
+				p.files[name] = now
+				changed = append(changed, name)
+			}
+		}
+		p.mu.Unlock()
+		for _, name := range changed {
+			select {
+			case p.changes <- name:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// stampFile returns the fileStamp of the file name.
+func stampFile(name string) fileStamp {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: fi.Size(), modTime: fi.ModTime(), exists: true}
+}